@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/skr1ms/PaymentAlphaBank.git/internal/apikey"
+)
+
+const apiKeyUsage = `Использование:
+  apikey issue -name <название> -role <admin|support|finance|merchant> [-scopes a,b] [-merchant <id>] [-ttl 720h]
+  apikey revoke -id <id>
+  apikey list`
+
+// Управление API-ключами из командной строки
+func runAPIKeyCommand(ctx context.Context, service *apikey.APIKeyService, args []string) error {
+	if len(args) == 0 {
+		return errors.New(apiKeyUsage)
+	}
+
+	switch args[0] {
+	case "issue":
+		flags := flag.NewFlagSet("apikey issue", flag.ContinueOnError)
+		name := flags.String("name", "", "название ключа")
+		role := flags.String("role", "", "роль: admin, support, finance, merchant")
+		scopes := flags.String("scopes", "", "области доступа через запятую; по умолчанию все области роли")
+		merchantID := flags.Int64("merchant", 0, "ID партнера для роли merchant")
+		ttl := flags.Duration("ttl", 0, "срок действия; 0 — бессрочный")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+
+		req := &apikey.IssueRequest{
+			Name:       *name,
+			Role:       *role,
+			MerchantID: *merchantID,
+			TTL:        *ttl,
+		}
+		if *scopes != "" {
+			for _, scope := range strings.Split(*scopes, ",") {
+				req.Scopes = append(req.Scopes, strings.TrimSpace(scope))
+			}
+		}
+
+		key, secret, err := service.Issue(ctx, req)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Выпущен ключ %d (%s), роль %s, области: %s\n", key.ID, key.Name, key.Role, strings.Join(key.Scopes, ","))
+		fmt.Println("Сохраните ключ, повторно он не показывается:")
+		fmt.Println(secret)
+		return nil
+
+	case "revoke":
+		flags := flag.NewFlagSet("apikey revoke", flag.ContinueOnError)
+		id := flags.Int64("id", 0, "ID ключа")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		if *id <= 0 {
+			return errors.New(apiKeyUsage)
+		}
+
+		if err := service.Revoke(ctx, *id); err != nil {
+			return err
+		}
+		fmt.Printf("Ключ %d отозван\n", *id)
+		return nil
+
+	case "list":
+		keys, err := service.List(ctx)
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tНАЗВАНИЕ\tПРЕФИКС\tРОЛЬ\tОБЛАСТИ\tСТАТУС")
+		now := time.Now()
+		for _, key := range keys {
+			status := "активен"
+			switch {
+			case !key.RevokedAt.IsZero():
+				status = "отозван"
+			case !key.IsActive(now):
+				status = "истек"
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\n", key.ID, key.Name, key.Prefix, key.Role, strings.Join(key.Scopes, ","), status)
+		}
+		return w.Flush()
+	}
+
+	return errors.New(apiKeyUsage)
+}
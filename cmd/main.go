@@ -1,20 +1,41 @@
 package main
 
 import (
+	"context"
 	"log"
+	"net"
+	"os"
+	"strings"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
 	"github.com/gofiber/fiber/v2/middleware/recover"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
 	_ "github.com/lib/pq"
 	"github.com/skr1ms/PaymentAlphaBank.git/config"
+	"github.com/skr1ms/PaymentAlphaBank.git/internal/apikey"
+	"github.com/skr1ms/PaymentAlphaBank.git/internal/outbox"
 	"github.com/skr1ms/PaymentAlphaBank.git/internal/payment"
+	"github.com/skr1ms/PaymentAlphaBank.git/internal/promo"
+	"github.com/skr1ms/PaymentAlphaBank.git/internal/webhook"
 	"github.com/skr1ms/PaymentAlphaBank.git/migration"
+	"github.com/skr1ms/PaymentAlphaBank.git/pkg/auth"
 	"github.com/skr1ms/PaymentAlphaBank.git/pkg/db"
+	"github.com/skr1ms/PaymentAlphaBank.git/pkg/httpapi"
+	"github.com/skr1ms/PaymentAlphaBank.git/pkg/openapi"
+	paymentv1 "github.com/skr1ms/PaymentAlphaBank.git/pkg/paymentpb/payment/v1"
+	"github.com/skr1ms/PaymentAlphaBank.git/pkg/secret"
+	"github.com/skr1ms/PaymentAlphaBank.git/pkg/storage"
+	"google.golang.org/grpc"
 )
 
 func main() {
-	config := config.NewTestConfig()
+	// Настройки: окружение, затем YAML-файл, затем флаги; режим — из APP_MODE
+	config, args, err := config.Load(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Режим работы: %s", config.Mode)
 
 	db, err := db.NewDb(config)
 	if err != nil {
@@ -22,34 +43,183 @@ func main() {
 	}
 	defer db.Close()
 
+	// Подкоманда управления схемой: migrate up|down|status
+	if len(args) > 0 && args[0] == "migrate" {
+		if err := runMigrateCommand(context.Background(), db.DB, args[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	migration.Init(db, config)
 
-	app := fiber.New()
+	apiKeyService := apikey.NewAPIKeyService(apikey.NewAPIKeyRepository(db.DB))
+
+	// Подкоманда управления API-ключами: apikey issue|revoke|list
+	if len(args) > 0 && args[0] == "apikey" {
+		if err := runAPIKeyCommand(context.Background(), apiKeyService, args[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// Конвертное шифрование секретов и данных оплаты в базе
+	keys, err := secret.NewKeyProvider(config.SecretsKey, config.SecretsKeyFile)
+	if err != nil {
+		log.Fatalf("Ошибка инициализации шифрования: %v", err)
+	}
+	envelope := secret.NewEnvelope(keys)
+
+	// Ошибки, не обработанные в обработчиках, отдаются в общем JSON-формате
+	app := fiber.New(fiber.Config{
+		ErrorHandler: httpapi.ErrorHandler,
+		ReadTimeout:  config.ReadTimeout,
+		WriteTimeout: config.WriteTimeout,
+		IdleTimeout:  config.IdleTimeout,
+	})
 	app.Use(cors.New(
 		cors.Config{
-			AllowOrigins: "*",
-			AllowHeaders: "Origin, Content-Type, Accept",
-			AllowMethods: "GET, POST, PUT, DELETE, OPTIONS",
+			AllowOrigins:  strings.Join(config.CORSOrigins, ","),
+			AllowHeaders:  "Origin, Content-Type, Accept, Authorization, X-API-Key, X-Merchant-Key, X-Request-ID",
+			AllowMethods:  "GET, POST, PUT, DELETE, OPTIONS",
+			ExposeHeaders: "X-Request-ID, X-Next-Cursor, Deprecation, Link",
 		},
 	))
 	app.Use(recover.New())
+	app.Use(requestid.New())
+
+	// Загруженные изображения купонов
+	app.Static("/uploads", config.UploadDir)
+
+	// Документ OpenAPI и Swagger UI; маршруты добавляются обработчиками
+	spec := openapi.New("PaymentAlphaBank API", "1.0.0", "/api/v1")
+	app.Get("/api/openapi.json", spec.Handler())
+	app.Get("/api/docs", openapi.UIHandler("PaymentAlphaBank API", "/api/openapi.json"))
 
+	// Версионированный API; старые пути без версии перенаправляются на /api/v1
 	api := app.Group("/api")
+	api.Use(httpapi.Legacy("/api", "v1"))
+	v1 := api.Group("/v1")
 
 	// repository
 	couponRepo := payment.NewCouponRepository(db.DB)
 	orderRepo := payment.NewOrderRepository(db.DB)
 	userCouponRepo := payment.NewUserCouponRepository(db.DB)
+	orderChangeRepo := payment.NewOrderChangeRepository(db.DB)
+	merchantRepo := payment.NewMerchantRepository(db.DB)
+	giftRepo := payment.NewGiftRepository(db.DB)
+	promoRepo := promo.NewPromoRepository(db.DB)
+	cartRepo := payment.NewCartRepository(db.DB)
+	webhookRepo := webhook.NewWebhookRepository(db.DB)
+	outboxRepo := outbox.NewOutboxRepository(db.DB)
+
+	// storage
+	imageStorage, err := storage.NewLocalStorage(config.UploadDir, "/uploads")
+	if err != nil {
+		log.Fatalf("Ошибка инициализации хранилища: %v", err)
+	}
+
+	// auth
+	verifier, err := auth.NewVerifier(config)
+	if err != nil {
+		log.Fatalf("Ошибка инициализации проверки JWT: %v", err)
+	}
+	guard := auth.NewGuard(verifier, apiKeyService)
+	if config.IsTest {
+		v1.Get("/test/token", verifier.TestTokenHandler())
+	}
 
 	// service
 	alfaClient := payment.NewAlfaBankClient(config)
-	couponService := payment.NewCouponService(couponRepo, orderRepo, userCouponRepo, alfaClient)
+	catalogService := payment.NewCatalogService(couponRepo, imageStorage)
+	codeSigner := payment.NewCodeSigner(config)
+	promoService := promo.NewPromoService(promoRepo)
+	webhookService := webhook.NewWebhookService(webhookRepo, envelope)
+	outboxService := outbox.NewOutboxService(outboxRepo)
+	merchantService := payment.NewMerchantService(merchantRepo, envelope)
+	orderNumbers, err := payment.NewOrderNumberGenerator(config.OrderNumber, orderRepo)
+	if err != nil {
+		log.Fatalf("Ошибка настройки номеров заказов: %v", err)
+	}
+	couponService := payment.NewCouponService(couponRepo, orderRepo, userCouponRepo, orderChangeRepo, db.TxRunner(), orderNumbers, merchantService, alfaClient, envelope, codeSigner, promoService, webhookService, outboxService)
+	cartService := payment.NewCartService(cartRepo, couponRepo, couponService)
+
+	// Секреты подписок на вебхуки, созданных до шифрования
+	encryptedSecrets, err := webhookService.EncryptPlaintextSecrets(context.Background())
+	if err != nil {
+		log.Fatalf("Ошибка шифрования секретов подписок: %v", err)
+	}
+	if encryptedSecrets > 0 {
+		log.Printf("Зашифрованы секреты %d подписок на вебхуки", encryptedSecrets)
+	}
+
+	// Подкоманда перешифрования после ротации ключей: secrets reencrypt
+	if len(args) > 0 && args[0] == "secrets" {
+		if err := runSecretsCommand(context.Background(), merchantService, couponService, webhookService, args[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	giftService := payment.NewGiftService(userCouponRepo, giftRepo, orderChangeRepo, db.TxRunner())
 
 	// handler
-	payment.NewPaymentHandler(api, &payment.PaymentHandlerDeps{
-		CouponService: couponService,
+	payment.NewPaymentHandler(v1, &payment.PaymentHandlerDeps{
+		CouponService:   couponService,
+		CatalogService:  catalogService,
+		CartService:     cartService,
+		MerchantService: merchantService,
+		GiftService:     giftService,
+		Verifier:        verifier,
+		Guard:           guard,
+		OpenAPI:         spec,
+	})
+	promo.NewPromoHandler(v1, &promo.PromoHandlerDeps{
+		PromoService: promoService,
+		Guard:        guard,
+		OpenAPI:      spec,
 	})
+	webhook.NewWebhookHandler(v1, &webhook.WebhookHandlerDeps{
+		WebhookService: webhookService,
+		Guard:          guard,
+		OpenAPI:        spec,
+	})
+
+	// Сверка зависших заказов и незавершенных возвратов с банком; изменения
+	// попадают в потоки событий
+	go payment.NewReconciler(orderRepo, couponService).Run(context.Background())
+
+	// Доставка вебхуков внешним системам
+	go webhook.NewDeliveryWorker(webhookRepo, envelope).Run(context.Background())
+
+	// Публикация событий outbox во внешний приемник
+	outboxSink, err := outbox.NewSink(config.OutboxConfig)
+	if err != nil {
+		log.Fatalf("Ошибка инициализации приемника outbox: %v", err)
+	}
+	go outbox.NewRelay(outboxRepo, outboxSink, config.OutboxConfig.Retention).Run(context.Background())
+
+	// gRPC API на отдельном порту
+	grpcServer := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(guard.UnaryServerInterceptor()),
+		grpc.ChainStreamInterceptor(guard.StreamServerInterceptor()),
+	)
+	paymentv1.RegisterPaymentServiceServer(grpcServer, payment.NewGRPCServer(&payment.GRPCServerDeps{
+		CouponService:   couponService,
+		CatalogService:  catalogService,
+		MerchantService: merchantService,
+	}))
+	grpcListener, err := net.Listen("tcp", ":"+config.GRPCPort)
+	if err != nil {
+		log.Fatalf("Ошибка запуска gRPC-сервера: %v", err)
+	}
+	go func() {
+		if err := grpcServer.Serve(grpcListener); err != nil {
+			log.Fatalf("Ошибка gRPC-сервера: %v", err)
+		}
+	}()
+	log.Printf("gRPC API: :%s", config.GRPCPort)
 
-	log.Printf("Тестовая страница: http://localhost:%s/api/test", config.Port)
+	log.Printf("Тестовая страница: http://localhost:%s/api/v1/test", config.Port)
+	log.Printf("Документация API: http://localhost:%s/api/docs", config.Port)
 	log.Fatal(app.Listen(":" + config.Port))
 }
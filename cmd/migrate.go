@@ -0,0 +1,87 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/skr1ms/PaymentAlphaBank.git/migration"
+	"github.com/uptrace/bun"
+)
+
+const migrateUsage = `Использование:
+  migrate up
+  migrate down [-steps 1]
+  migrate status`
+
+// Управление схемой базы из командной строки; при обычном запуске
+// ожидающие миграции применяются автоматически
+func runMigrateCommand(ctx context.Context, db *bun.DB, args []string) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+
+	migrator, err := migration.NewMigrator(db)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up(ctx)
+		for _, m := range applied {
+			fmt.Printf("Применена миграция %d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			return err
+		}
+		if len(applied) == 0 {
+			fmt.Println("Ожидающих миграций нет")
+		}
+		return nil
+
+	case "down":
+		flags := flag.NewFlagSet("migrate down", flag.ContinueOnError)
+		steps := flags.Int("steps", 1, "сколько последних миграций откатить")
+		if err := flags.Parse(args[1:]); err != nil {
+			return err
+		}
+		if *steps <= 0 {
+			return errors.New(migrateUsage)
+		}
+
+		reverted, err := migrator.Down(ctx, *steps)
+		for _, m := range reverted {
+			fmt.Printf("Откачена миграция %d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			return err
+		}
+		if len(reverted) == 0 {
+			fmt.Println("Примененных миграций нет")
+		}
+		return nil
+
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ВЕРСИЯ\tНАЗВАНИЕ\tПРИМЕНЕНА")
+		for _, status := range statuses {
+			applied := "нет"
+			if !status.AppliedAt.IsZero() {
+				applied = status.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\n", status.Version, status.Name, applied)
+		}
+		return w.Flush()
+	}
+
+	return errors.New(migrateUsage)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+
+	"github.com/skr1ms/PaymentAlphaBank.git/internal/payment"
+	"github.com/skr1ms/PaymentAlphaBank.git/internal/webhook"
+)
+
+const secretsUsage = `Использование:
+  secrets reencrypt [-batch 100]`
+
+// Перешифрование секретов основным ключом после ротации: сначала новый ключ
+// становится основным в файле ключей, затем запускается эта команда, и только
+// после нее старый ключ удаляется из файла
+func runSecretsCommand(ctx context.Context, merchantService *payment.MerchantService, couponService *payment.CouponService, webhookService *webhook.WebhookService, args []string) error {
+	if len(args) == 0 || args[0] != "reencrypt" {
+		return errors.New(secretsUsage)
+	}
+
+	flags := flag.NewFlagSet("secrets reencrypt", flag.ContinueOnError)
+	batch := flags.Int("batch", 100, "записей за один запрос")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+	if *batch <= 0 {
+		return errors.New(secretsUsage)
+	}
+
+	merchants, err := merchantService.ReencryptSecrets(ctx, *batch)
+	if err != nil {
+		return fmt.Errorf("пароли шлюза партнеров: %w", err)
+	}
+	fmt.Printf("Перешифрованы пароли шлюза партнеров: %d\n", merchants)
+
+	orders, err := couponService.ReencryptPaymentDetails(ctx, *batch)
+	if err != nil {
+		return fmt.Errorf("данные оплаты заказов: %w", err)
+	}
+	fmt.Printf("Перешифрованы данные оплаты заказов: %d\n", orders)
+
+	subscriptions, err := webhookService.ReencryptSecrets(ctx, *batch)
+	if err != nil {
+		return fmt.Errorf("секреты подписок на вебхуки: %w", err)
+	}
+	fmt.Printf("Перешифрованы секреты подписок на вебхуки: %d\n", subscriptions)
+	return nil
+}
@@ -1,51 +1,65 @@
 package config
 
-import (
-	"os"
+import "time"
 
-	"github.com/joho/godotenv"
+// Режимы работы из APP_MODE
+const (
+	ModeTest       = "test"
+	ModeProduction = "production"
 )
 
 type Config struct {
-	BaseURL  string
-	Username string
-	Password string
-	IsTest   bool
-	Port     string
-	DbConfig DbConfig
+	Mode             string
+	IsTest           bool
+	BaseURL          string // адрес платежного шлюза Альфа-Банка
+	Username         string
+	Password         string
+	GatewayTimeout   time.Duration
+	Port             string
+	GRPCPort         string
+	ReadTimeout      time.Duration
+	WriteTimeout     time.Duration // 0 — без ограничения, нужно для потоков SSE
+	IdleTimeout      time.Duration
+	CORSOrigins      []string
+	CouponSigningKey string
+	SecretsKey       string // base64-ключ AES-256 для секретов в базе
+	SecretsKeyFile   string // файл набора ключей с ротацией; важнее SecretsKey
+	UploadDir        string
+	JWTConfig        JWTConfig
+	DbConfig         DbConfig
+	OutboxConfig     OutboxConfig
+	OrderNumber      OrderNumberConfig
 }
 
-type DbConfig struct {
-	URL string
+// Проверка токенов пользователей: HS256 с секретом и/или RS256 с ключами из JWKS-файла
+type JWTConfig struct {
+	Secret   string
+	JWKSFile string
+	Issuer   string
+	Audience string
 }
 
-// Создание конфигурации для тестовой среды
-func NewTestConfig() *Config {
-	godotenv.Load()
-	return &Config{
-		BaseURL:  "https://alfa.rbsuat.com",
-		Username: os.Getenv("ALFA_BANK_USERNAME_TEST"),
-		Password: os.Getenv("ALFA_BANK_PASSWORD_TEST"),
-		IsTest:   true,
-		Port:     "3000",	
-		DbConfig: DbConfig{
-			URL: os.Getenv("DB_URL"),
-		},
-	}
+type DbConfig struct {
+	URL             string
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
 }
 
-// Создание конфигурации для продакшена
-func NewProdConfig() *Config {
-	godotenv.Load()
-	return &Config{
-		BaseURL:  os.Getenv("ALFA_BANK_PROD_URL"),
-		Username: os.Getenv("ALFA_BANK_USERNAME"),
-		Password: os.Getenv("ALFA_BANK_PASSWORD"),
-		IsTest:   false,
-		Port:     "3000",
-		DbConfig: DbConfig{
-			URL: os.Getenv("DB_URL"),
-		},
-	}
+// Публикация событий outbox во внешний приемник
+type OutboxConfig struct {
+	Sink      string        // log, http, nats или kafka
+	URL       string        // адрес приемника; для log не нужен
+	Topic     string        // subject NATS или топик Kafka
+	Secret    string        // секрет подписи запросов http
+	Retention time.Duration // срок хранения опубликованных событий; 0 — не удалять
 }
 
+// Формат номеров заказов: <префикс>-<дата>-<номер><контрольный символ>
+type OrderNumberConfig struct {
+	Prefix     string // латинские буквы и цифры
+	DateFormat string // YYMMDD, YYYYMMDD или none
+	Source     string // sequence — последовательность Postgres, ulid — ULID
+	CheckDigit bool
+}
@@ -0,0 +1,536 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"github.com/skr1ms/PaymentAlphaBank.git/pkg/secret"
+	"gopkg.in/yaml.v3"
+)
+
+// Путь к необязательному YAML-файлу настроек
+const configFileEnv = "CONFIG_FILE"
+
+var orderNumberPrefixPattern = regexp.MustCompile(`^[A-Za-z0-9]{1,8}$`)
+
+// Настройка и ее источники. Значение берется из первого источника, где оно
+// задано: переменные окружения, YAML-файл, флаги, значение режима по умолчанию
+type setting struct {
+	key      string   // ключ YAML, вложенность через точку
+	env      []string // переменные окружения; первая непустая побеждает
+	testEnv  []string // переменные окружения в режиме test, если отличаются
+	flag     string
+	usage    string
+	defaults map[string]string // значение по умолчанию для режима
+	apply    func(c *Config, value string) error
+}
+
+func (s *setting) envNames(mode string) []string {
+	if mode == ModeTest && s.testEnv != nil {
+		return s.testEnv
+	}
+	return s.env
+}
+
+func (s *setting) source(mode string) string {
+	return s.key + " (" + strings.Join(s.envNames(mode), ", ") + ")"
+}
+
+// Режим читается первым: от него зависят значения по умолчанию. Значения
+// по умолчанию нет: в режиме test действуют известные всем секреты и выдача
+// тестовых токенов, поэтому он включается только явно
+var modeSetting = &setting{
+	key: "mode", env: []string{"APP_MODE"}, flag: "mode",
+	usage: "режим работы: test или production (обязательно)",
+}
+
+var settings = []*setting{
+	{
+		key: "server.port", env: []string{"PORT"}, flag: "port",
+		usage:    "порт HTTP API",
+		defaults: map[string]string{ModeTest: "3000", ModeProduction: "3000"},
+		apply:    func(c *Config, v string) (err error) { c.Port, err = parsePort(v); return },
+	},
+	{
+		key: "server.grpc_port", env: []string{"GRPC_PORT"}, flag: "grpc-port",
+		usage:    "порт gRPC API",
+		defaults: map[string]string{ModeTest: "50051", ModeProduction: "50051"},
+		apply:    func(c *Config, v string) (err error) { c.GRPCPort, err = parsePort(v); return },
+	},
+	{
+		key: "server.read_timeout", env: []string{"READ_TIMEOUT"}, flag: "read-timeout",
+		usage:    "таймаут чтения запроса",
+		defaults: map[string]string{ModeTest: "15s", ModeProduction: "15s"},
+		apply:    func(c *Config, v string) (err error) { c.ReadTimeout, err = parseDuration(v); return },
+	},
+	{
+		key: "server.write_timeout", env: []string{"WRITE_TIMEOUT"}, flag: "write-timeout",
+		usage:    "таймаут записи ответа; 0 — без ограничения (потоки SSE)",
+		defaults: map[string]string{ModeTest: "0s", ModeProduction: "0s"},
+		apply:    func(c *Config, v string) (err error) { c.WriteTimeout, err = parseDuration(v); return },
+	},
+	{
+		key: "server.idle_timeout", env: []string{"IDLE_TIMEOUT"}, flag: "idle-timeout",
+		usage:    "таймаут простаивающего keep-alive соединения",
+		defaults: map[string]string{ModeTest: "2m", ModeProduction: "2m"},
+		apply:    func(c *Config, v string) (err error) { c.IdleTimeout, err = parseDuration(v); return },
+	},
+	{
+		key: "server.upload_dir", env: []string{"UPLOAD_DIR"}, flag: "upload-dir",
+		usage:    "директория загружаемых изображений купонов",
+		defaults: map[string]string{ModeTest: "./uploads", ModeProduction: "./uploads"},
+		apply:    func(c *Config, v string) error { c.UploadDir = v; return nil },
+	},
+	{
+		key: "cors.allow_origins", env: []string{"CORS_ALLOW_ORIGINS"}, flag: "cors-allow-origins",
+		usage:    "разрешенные источники CORS через запятую; * — любые",
+		defaults: map[string]string{ModeTest: "*"},
+		apply:    func(c *Config, v string) error { c.CORSOrigins = splitList(v); return nil },
+	},
+	{
+		key: "gateway.url", env: []string{"ALFA_BANK_URL", "ALFA_BANK_PROD_URL"}, testEnv: []string{"ALFA_BANK_URL"},
+		flag:     "gateway-url",
+		usage:    "адрес платежного шлюза Альфа-Банка",
+		defaults: map[string]string{ModeTest: "https://alfa.rbsuat.com"},
+		apply: func(c *Config, v string) error {
+			u, err := url.Parse(v)
+			if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+				return errors.New("нужен абсолютный http(s) URL")
+			}
+			c.BaseURL = strings.TrimRight(v, "/")
+			return nil
+		},
+	},
+	{
+		key: "gateway.username", env: []string{"ALFA_BANK_USERNAME"}, testEnv: []string{"ALFA_BANK_USERNAME_TEST"},
+		flag: "gateway-username", usage: "логин API шлюза",
+		apply: func(c *Config, v string) error { c.Username = v; return nil },
+	},
+	{
+		key: "gateway.password", env: []string{"ALFA_BANK_PASSWORD"}, testEnv: []string{"ALFA_BANK_PASSWORD_TEST"},
+		flag: "gateway-password", usage: "пароль API шлюза",
+		apply: func(c *Config, v string) error { c.Password = v; return nil },
+	},
+	{
+		key: "gateway.timeout", env: []string{"ALFA_BANK_TIMEOUT"}, flag: "gateway-timeout",
+		usage:    "таймаут запросов к шлюзу",
+		defaults: map[string]string{ModeTest: "30s", ModeProduction: "30s"},
+		apply:    func(c *Config, v string) (err error) { c.GatewayTimeout, err = parsePositiveDuration(v); return },
+	},
+	{
+		key: "db.url", env: []string{"DB_URL"}, flag: "db-url",
+		usage: "строка подключения к Postgres",
+		apply: func(c *Config, v string) error { c.DbConfig.URL = v; return nil },
+	},
+	{
+		key: "db.max_open_conns", env: []string{"DB_MAX_OPEN_CONNS"}, flag: "db-max-open-conns",
+		usage:    "максимум открытых соединений с базой; 0 — без ограничения",
+		defaults: map[string]string{ModeTest: "25", ModeProduction: "25"},
+		apply:    func(c *Config, v string) (err error) { c.DbConfig.MaxOpenConns, err = parseCount(v); return },
+	},
+	{
+		key: "db.max_idle_conns", env: []string{"DB_MAX_IDLE_CONNS"}, flag: "db-max-idle-conns",
+		usage:    "максимум простаивающих соединений с базой",
+		defaults: map[string]string{ModeTest: "5", ModeProduction: "5"},
+		apply:    func(c *Config, v string) (err error) { c.DbConfig.MaxIdleConns, err = parseCount(v); return },
+	},
+	{
+		key: "db.conn_max_lifetime", env: []string{"DB_CONN_MAX_LIFETIME"}, flag: "db-conn-max-lifetime",
+		usage:    "время жизни соединения с базой; 0 — без ограничения",
+		defaults: map[string]string{ModeTest: "30m", ModeProduction: "30m"},
+		apply:    func(c *Config, v string) (err error) { c.DbConfig.ConnMaxLifetime, err = parseDuration(v); return },
+	},
+	{
+		key: "db.conn_max_idle_time", env: []string{"DB_CONN_MAX_IDLE_TIME"}, flag: "db-conn-max-idle-time",
+		usage:    "время простоя соединения с базой до закрытия; 0 — без ограничения",
+		defaults: map[string]string{ModeTest: "5m", ModeProduction: "5m"},
+		apply:    func(c *Config, v string) (err error) { c.DbConfig.ConnMaxIdleTime, err = parseDuration(v); return },
+	},
+	{
+		key: "coupon_signing_key", env: []string{"COUPON_SIGNING_KEY"}, flag: "coupon-signing-key",
+		usage:    "ключ подписи QR-кодов и штрихкодов купонов",
+		defaults: map[string]string{ModeTest: "test-coupon-signing-key"},
+		apply:    func(c *Config, v string) error { c.CouponSigningKey = v; return nil },
+	},
+	{
+		key: "secrets.key", env: []string{"SECRETS_KEY"}, flag: "secrets-key",
+		usage:    "ключ шифрования секретов в базе: 32 байта в base64",
+		defaults: map[string]string{ModeTest: "dGVzdC1zZWNyZXRzLWtleS0zMi1ieXRlcy1sb25nISE="},
+		apply: func(c *Config, v string) error {
+			if _, err := secret.ParseKey(v); err != nil {
+				return err
+			}
+			c.SecretsKey = v
+			return nil
+		},
+	},
+	{
+		key: "secrets.key_file", env: []string{"SECRETS_KEY_FILE"}, flag: "secrets-key-file",
+		usage: "JSON-файл набора ключей шифрования секретов с основным ключом для ротации",
+		apply: func(c *Config, v string) error {
+			if _, err := secret.LoadKeyFile(v); err != nil {
+				return err
+			}
+			c.SecretsKeyFile = v
+			return nil
+		},
+	},
+	{
+		key: "jwt.secret", env: []string{"JWT_SECRET"}, flag: "jwt-secret",
+		usage:    "секрет HS256 для JWT пользователей",
+		defaults: map[string]string{ModeTest: "test-jwt-secret"},
+		apply:    func(c *Config, v string) error { c.JWTConfig.Secret = v; return nil },
+	},
+	{
+		key: "jwt.jwks_file", env: []string{"JWT_JWKS_FILE"}, flag: "jwt-jwks-file",
+		usage: "JWKS-файл с ключами RS256",
+		apply: func(c *Config, v string) error { c.JWTConfig.JWKSFile = v; return nil },
+	},
+	{
+		key: "jwt.issuer", env: []string{"JWT_ISSUER"}, flag: "jwt-issuer",
+		usage: "ожидаемый издатель JWT",
+		apply: func(c *Config, v string) error { c.JWTConfig.Issuer = v; return nil },
+	},
+	{
+		key: "jwt.audience", env: []string{"JWT_AUDIENCE"}, flag: "jwt-audience",
+		usage: "ожидаемая аудитория JWT",
+		apply: func(c *Config, v string) error { c.JWTConfig.Audience = v; return nil },
+	},
+	{
+		key: "order_number.prefix", env: []string{"ORDER_NUMBER_PREFIX"}, flag: "order-number-prefix",
+		usage:    "префикс номеров заказов: латинские буквы и цифры",
+		defaults: map[string]string{ModeTest: "TST", ModeProduction: "CP"},
+		apply: func(c *Config, v string) error {
+			if !orderNumberPrefixPattern.MatchString(v) {
+				return fmt.Errorf("неверный префикс %q: до 8 латинских букв и цифр", v)
+			}
+			c.OrderNumber.Prefix = strings.ToUpper(v)
+			return nil
+		},
+	},
+	{
+		key: "order_number.date", env: []string{"ORDER_NUMBER_DATE"}, flag: "order-number-date",
+		usage:    "дата в номере заказа: YYMMDD, YYYYMMDD или none",
+		defaults: map[string]string{ModeTest: "YYMMDD", ModeProduction: "YYMMDD"},
+		apply: func(c *Config, v string) error {
+			switch v {
+			case "YYMMDD", "YYYYMMDD", "none":
+				c.OrderNumber.DateFormat = v
+				return nil
+			}
+			return fmt.Errorf("неизвестный формат даты %q, допустимы YYMMDD, YYYYMMDD и none", v)
+		},
+	},
+	{
+		key: "order_number.source", env: []string{"ORDER_NUMBER_SOURCE"}, flag: "order-number-source",
+		usage:    "источник номера заказа: sequence — последовательность Postgres, ulid — ULID",
+		defaults: map[string]string{ModeTest: "sequence", ModeProduction: "sequence"},
+		apply: func(c *Config, v string) error {
+			switch v {
+			case "sequence", "ulid":
+				c.OrderNumber.Source = v
+				return nil
+			}
+			return fmt.Errorf("неизвестный источник %q, допустимы sequence и ulid", v)
+		},
+	},
+	{
+		key: "order_number.check_digit", env: []string{"ORDER_NUMBER_CHECK_DIGIT"}, flag: "order-number-check-digit",
+		usage:    "добавлять контрольный символ в конец номера заказа",
+		defaults: map[string]string{ModeTest: "true", ModeProduction: "true"},
+		apply:    func(c *Config, v string) (err error) { c.OrderNumber.CheckDigit, err = parseBool(v); return },
+	},
+	{
+		key: "outbox.sink", env: []string{"OUTBOX_SINK"}, flag: "outbox-sink",
+		usage:    "приемник событий outbox: log, http, nats или kafka",
+		defaults: map[string]string{ModeTest: "log", ModeProduction: "log"},
+		apply: func(c *Config, v string) error {
+			switch v {
+			case "log", "http", "nats", "kafka":
+				c.OutboxConfig.Sink = v
+				return nil
+			}
+			return fmt.Errorf("неизвестный приемник %q, допустимы log, http, nats и kafka", v)
+		},
+	},
+	{
+		key: "outbox.url", env: []string{"OUTBOX_URL"}, flag: "outbox-url",
+		usage: "адрес приемника outbox: URL вебхука, nats://host:4222 или URL Kafka REST Proxy",
+		apply: func(c *Config, v string) error { c.OutboxConfig.URL = v; return nil },
+	},
+	{
+		key: "outbox.topic", env: []string{"OUTBOX_TOPIC"}, flag: "outbox-topic",
+		usage:    "subject NATS или топик Kafka для событий outbox",
+		defaults: map[string]string{ModeTest: "payments", ModeProduction: "payments"},
+		apply:    func(c *Config, v string) error { c.OutboxConfig.Topic = v; return nil },
+	},
+	{
+		key: "outbox.secret", env: []string{"OUTBOX_SECRET"}, flag: "outbox-secret",
+		usage: "секрет HMAC-подписи событий для приемника http",
+		apply: func(c *Config, v string) error { c.OutboxConfig.Secret = v; return nil },
+	},
+	{
+		key: "outbox.retention", env: []string{"OUTBOX_RETENTION"}, flag: "outbox-retention",
+		usage:    "срок хранения опубликованных событий outbox; 0 — не удалять",
+		defaults: map[string]string{ModeTest: "168h", ModeProduction: "168h"},
+		apply:    func(c *Config, v string) (err error) { c.OutboxConfig.Retention, err = parseDuration(v); return },
+	},
+}
+
+// Настройки, без которых нельзя запускаться в режиме production
+var productionRequired = []string{
+	"gateway.url", "gateway.username", "gateway.password",
+	"coupon_signing_key", "cors.allow_origins",
+}
+
+// Ошибка конфигурации со списком всех неверных и недостающих настроек
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return "неверная конфигурация:\n  - " + strings.Join(e.Problems, "\n  - ")
+}
+
+// Загрузка конфигурации из .env, переменных окружения, YAML-файла
+// (CONFIG_FILE или -config) и флагов args. Возвращает аргументы после
+// флагов, например подкоманду
+func Load(args []string) (*Config, []string, error) {
+	godotenv.Load()
+
+	flags := flag.NewFlagSet("PaymentAlphaBank", flag.ContinueOnError)
+	configFile := flags.String("config", "", "YAML-файл настроек; переменная "+configFileEnv+" важнее")
+	flagValues := make(map[string]*string, len(settings)+1)
+	for _, s := range append([]*setting{modeSetting}, settings...) {
+		flagValues[s.flag] = flags.String(s.flag, "", s.usage)
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, nil, err
+	}
+
+	// Учитываются только явно переданные флаги
+	explicit := make(map[string]string)
+	flags.Visit(func(f *flag.Flag) {
+		if value, ok := flagValues[f.Name]; ok {
+			explicit[f.Name] = *value
+		}
+	})
+
+	var problems []string
+
+	path := os.Getenv(configFileEnv)
+	if path == "" {
+		path = *configFile
+	}
+	file := map[string]string{}
+	if path != "" {
+		var err error
+		if file, err = readYAML(path); err != nil {
+			return nil, nil, &ValidationError{Problems: []string{err.Error()}}
+		}
+		problems = append(problems, unknownKeys(file)...)
+	}
+
+	lookup := func(s *setting, mode string) (string, bool) {
+		for _, name := range s.envNames(mode) {
+			if value := strings.TrimSpace(os.Getenv(name)); value != "" {
+				return value, true
+			}
+		}
+		if value, ok := file[s.key]; ok && value != "" {
+			return value, true
+		}
+		if value, ok := explicit[s.flag]; ok && value != "" {
+			return value, true
+		}
+		if value, ok := s.defaults[mode]; ok {
+			return value, true
+		}
+		return "", false
+	}
+
+	mode, ok := lookup(modeSetting, "")
+	if !ok {
+		problems = append(problems, modeSetting.source("")+": не задан режим работы, укажите test или production")
+		return nil, nil, &ValidationError{Problems: problems}
+	}
+	if mode != ModeTest && mode != ModeProduction {
+		problems = append(problems, fmt.Sprintf("%s: неизвестный режим %q, допустимы test и production", modeSetting.source(""), mode))
+		return nil, nil, &ValidationError{Problems: problems}
+	}
+
+	config := &Config{Mode: mode, IsTest: mode == ModeTest}
+	present := make(map[string]bool, len(settings))
+	for _, s := range settings {
+		value, ok := lookup(s, mode)
+		if !ok {
+			continue
+		}
+		present[s.key] = true
+		if err := s.apply(config, value); err != nil {
+			problems = append(problems, fmt.Sprintf("%s: %v", s.source(mode), err))
+		}
+	}
+
+	problems = append(problems, validate(config, present)...)
+	if len(problems) > 0 {
+		return nil, nil, &ValidationError{Problems: problems}
+	}
+	return config, flags.Args(), nil
+}
+
+// Проверки, зависящие от нескольких настроек или от режима
+func validate(config *Config, present map[string]bool) []string {
+	var problems []string
+	missing := func(key string) {
+		for _, s := range settings {
+			if s.key == key {
+				problems = append(problems, s.source(config.Mode)+": не задана")
+				return
+			}
+		}
+	}
+
+	if !present["db.url"] {
+		missing("db.url")
+	}
+	if config.Port != "" && config.Port == config.GRPCPort {
+		problems = append(problems, "server.port и server.grpc_port совпадают")
+	}
+	if config.DbConfig.MaxOpenConns > 0 && config.DbConfig.MaxIdleConns > config.DbConfig.MaxOpenConns {
+		problems = append(problems, "db.max_idle_conns больше db.max_open_conns")
+	}
+	if config.JWTConfig.Secret == "" && config.JWTConfig.JWKSFile == "" {
+		problems = append(problems, "jwt.secret (JWT_SECRET) или jwt.jwks_file (JWT_JWKS_FILE): нужен хотя бы один")
+	}
+	if config.OutboxConfig.Sink != "" && config.OutboxConfig.Sink != "log" && !present["outbox.url"] {
+		problems = append(problems, "outbox.url (OUTBOX_URL): не задана, нужна для приемника "+config.OutboxConfig.Sink)
+	}
+
+	if config.Mode == ModeProduction {
+		for _, key := range productionRequired {
+			if !present[key] {
+				missing(key)
+			}
+		}
+		if !present["secrets.key"] && !present["secrets.key_file"] {
+			problems = append(problems, "secrets.key (SECRETS_KEY) или secrets.key_file (SECRETS_KEY_FILE): нужен хотя бы один")
+		}
+	}
+	return problems
+}
+
+// Плоское представление YAML: ключи через точку, списки через запятую
+func readYAML(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("файл настроек: %w", err)
+	}
+
+	var tree map[string]any
+	if err := yaml.Unmarshal(data, &tree); err != nil {
+		return nil, fmt.Errorf("файл настроек %s: %w", path, err)
+	}
+
+	flat := make(map[string]string)
+	flatten("", tree, flat)
+	return flat, nil
+}
+
+func flatten(prefix string, tree map[string]any, flat map[string]string) {
+	for key, value := range tree {
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		switch v := value.(type) {
+		case map[string]any:
+			flatten(key, v, flat)
+		case []any:
+			items := make([]string, 0, len(v))
+			for _, item := range v {
+				items = append(items, fmt.Sprint(item))
+			}
+			flat[key] = strings.Join(items, ",")
+		case nil:
+			flat[key] = ""
+		default:
+			flat[key] = fmt.Sprint(v)
+		}
+	}
+}
+
+// Опечатки в файле настроек иначе молча игнорировались бы
+func unknownKeys(file map[string]string) []string {
+	known := map[string]bool{modeSetting.key: true}
+	for _, s := range settings {
+		known[s.key] = true
+	}
+
+	var problems []string
+	for key := range file {
+		if !known[key] {
+			problems = append(problems, fmt.Sprintf("%s: неизвестная настройка в файле", key))
+		}
+	}
+	sort.Strings(problems)
+	return problems
+}
+
+func parsePort(value string) (string, error) {
+	port, err := strconv.Atoi(value)
+	if err != nil || port < 1 || port > 65535 {
+		return "", fmt.Errorf("неверный порт %q", value)
+	}
+	return value, nil
+}
+
+func parseDuration(value string) (time.Duration, error) {
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("неверная длительность %q, пример: 30s, 5m", value)
+	}
+	return d, nil
+}
+
+func parsePositiveDuration(value string) (time.Duration, error) {
+	d, err := parseDuration(value)
+	if err == nil && d == 0 {
+		return 0, fmt.Errorf("длительность должна быть больше нуля")
+	}
+	return d, err
+}
+
+func parseBool(value string) (bool, error) {
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("нужно true или false, получено %q", value)
+	}
+	return b, nil
+}
+
+func parseCount(value string) (int, error) {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("нужно целое неотрицательное число, получено %q", value)
+	}
+	return n, nil
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
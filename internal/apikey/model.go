@@ -0,0 +1,29 @@
+package apikey
+
+import (
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+// Модель API-ключа сотрудника или партнера; сам ключ не хранится, только хеш
+type APIKey struct {
+	bun.BaseModel `bun:"table:api_keys"`
+
+	ID         int64     `bun:"id,pk,autoincrement" json:"id"`
+	Name       string    `bun:"name,notnull" json:"name"`
+	Prefix     string    `bun:"prefix,notnull" json:"prefix"` // начало ключа, чтобы отличать ключи в списке
+	KeyHash    string    `bun:"key_hash,notnull,unique" json:"-"`
+	Role       string    `bun:"role,notnull" json:"role"`
+	Scopes     []string  `bun:"scopes,array" json:"scopes"`
+	MerchantID int64     `bun:"merchant_id,nullzero" json:"merchant_id,omitempty"` // только для роли merchant
+	ExpiresAt  time.Time `bun:"expires_at,nullzero" json:"expires_at,omitempty"`
+	LastUsedAt time.Time `bun:"last_used_at,nullzero" json:"last_used_at,omitempty"`
+	RevokedAt  time.Time `bun:"revoked_at,nullzero" json:"revoked_at,omitempty"`
+	CreatedAt  time.Time `bun:"created_at,nullzero,notnull,default:current_timestamp" json:"created_at"`
+}
+
+// Действует ли ключ на момент now
+func (k *APIKey) IsActive(now time.Time) bool {
+	return k.RevokedAt.IsZero() && (k.ExpiresAt.IsZero() || now.Before(k.ExpiresAt))
+}
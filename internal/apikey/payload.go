@@ -0,0 +1,11 @@
+package apikey
+
+import "time"
+
+type IssueRequest struct {
+	Name       string
+	Role       string
+	Scopes     []string
+	MerchantID int64
+	TTL        time.Duration // 0 — бессрочный
+}
@@ -0,0 +1,70 @@
+package apikey
+
+import (
+	"context"
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+type APIKeyRepository struct {
+	db *bun.DB
+}
+
+func NewAPIKeyRepository(db *bun.DB) *APIKeyRepository {
+	return &APIKeyRepository{db: db}
+}
+
+func (r *APIKeyRepository) Create(ctx context.Context, key *APIKey) error {
+	key.CreatedAt = time.Now()
+	_, err := r.db.NewInsert().Model(key).Exec(ctx)
+	return err
+}
+
+func (r *APIKeyRepository) GetByHash(ctx context.Context, keyHash string) (*APIKey, error) {
+	key := &APIKey{}
+	err := r.db.NewSelect().
+		Model(key).
+		Where("key_hash = ?", keyHash).
+		Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func (r *APIKeyRepository) List(ctx context.Context) ([]APIKey, error) {
+	var keys []APIKey
+	err := r.db.NewSelect().
+		Model(&keys).
+		Order("created_at DESC").
+		Scan(ctx)
+	return keys, err
+}
+
+// Отзыв ключа; false, если ключ не найден или уже отозван
+func (r *APIKeyRepository) Revoke(ctx context.Context, id int64, revokedAt time.Time) (bool, error) {
+	res, err := r.db.NewUpdate().
+		Model((*APIKey)(nil)).
+		Set("revoked_at = ?", revokedAt).
+		Where("id = ? AND revoked_at IS NULL", id).
+		Exec(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected == 1, nil
+}
+
+func (r *APIKeyRepository) TouchLastUsed(ctx context.Context, id int64, usedAt time.Time) error {
+	_, err := r.db.NewUpdate().
+		Model((*APIKey)(nil)).
+		Set("last_used_at = ?", usedAt).
+		Where("id = ?", id).
+		Exec(ctx)
+	return err
+}
@@ -0,0 +1,132 @@
+package apikey
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/skr1ms/PaymentAlphaBank.git/pkg/auth"
+)
+
+const (
+	keyPrefix = "ak_"
+
+	// Время последнего использования обновляем не чаще раза в минуту
+	lastUsedInterval = time.Minute
+)
+
+var (
+	ErrInvalidRole      = errors.New("неизвестная роль")
+	ErrNameRequired     = errors.New("не указано название ключа")
+	ErrMerchantRequired = errors.New("ключу партнера нужен ID партнера")
+	ErrKeyNotFound      = errors.New("API-ключ не найден или уже отозван")
+)
+
+type APIKeyService struct {
+	keyRepo *APIKeyRepository
+}
+
+func NewAPIKeyService(keyRepo *APIKeyRepository) *APIKeyService {
+	return &APIKeyService{keyRepo: keyRepo}
+}
+
+// Выпуск ключа; без явных областей доступа ключ получает все области роли.
+// Ключ возвращается один раз, в базе хранится только хеш
+func (s *APIKeyService) Issue(ctx context.Context, req *IssueRequest) (*APIKey, string, error) {
+	if req.Name == "" {
+		return nil, "", ErrNameRequired
+	}
+	if !auth.IsRole(req.Role) {
+		return nil, "", ErrInvalidRole
+	}
+	if (req.Role == auth.RoleMerchant) != (req.MerchantID > 0) {
+		return nil, "", ErrMerchantRequired
+	}
+
+	scopes := req.Scopes
+	if len(scopes) == 0 {
+		scopes = auth.RoleScopes(req.Role)
+	}
+	if err := auth.ValidateScopes(req.Role, scopes); err != nil {
+		return nil, "", err
+	}
+
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return nil, "", fmt.Errorf("ошибка генерации API-ключа: %w", err)
+	}
+	secret := keyPrefix + hex.EncodeToString(raw)
+
+	key := &APIKey{
+		Name:       req.Name,
+		Prefix:     secret[:len(keyPrefix)+8],
+		KeyHash:    hashKey(secret),
+		Role:       req.Role,
+		Scopes:     scopes,
+		MerchantID: req.MerchantID,
+	}
+	if req.TTL > 0 {
+		key.ExpiresAt = time.Now().Add(req.TTL)
+	}
+
+	if err := s.keyRepo.Create(ctx, key); err != nil {
+		return nil, "", err
+	}
+
+	return key, secret, nil
+}
+
+func (s *APIKeyService) Revoke(ctx context.Context, id int64) error {
+	revoked, err := s.keyRepo.Revoke(ctx, id, time.Now())
+	if err != nil {
+		return err
+	}
+	if !revoked {
+		return ErrKeyNotFound
+	}
+	return nil
+}
+
+func (s *APIKeyService) List(ctx context.Context) ([]APIKey, error) {
+	return s.keyRepo.List(ctx)
+}
+
+// Проверка ключа из заголовка запроса; реализует auth.KeyAuthenticator
+func (s *APIKeyService) AuthenticateKey(ctx context.Context, secret string) (*auth.Principal, error) {
+	key, err := s.keyRepo.GetByHash(ctx, hashKey(secret))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	now := time.Now()
+	if !key.IsActive(now) {
+		return nil, nil
+	}
+
+	if now.Sub(key.LastUsedAt) > lastUsedInterval {
+		if err := s.keyRepo.TouchLastUsed(ctx, key.ID, now); err != nil {
+			log.Printf("Ошибка обновления времени использования API-ключа %d: %v", key.ID, err)
+		}
+	}
+
+	return &auth.Principal{
+		KeyID:      key.ID,
+		Roles:      []string{key.Role},
+		Scopes:     key.Scopes,
+		MerchantID: key.MerchantID,
+	}, nil
+}
+
+func hashKey(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,52 @@
+package outbox
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+// События для внешних потребителей
+const (
+	EventOrderPaid       = "order.paid"
+	EventCouponActivated = "coupon.activated"
+)
+
+// Событие outbox. Записывается в транзакции изменения, которое описывает,
+// и публикуется ретранслятором после фиксации. События с одним ключом
+// публикуются строго в порядке ID
+type Event struct {
+	bun.BaseModel `bun:"table:outbox_events"`
+
+	ID            int64           `bun:"id,pk,autoincrement" json:"id"`
+	EventID       string          `bun:"event_id,notnull,unique" json:"event_id"` // по нему потребитель отбрасывает повторы
+	EventType     string          `bun:"event_type,notnull" json:"event_type"`
+	Key           string          `bun:"aggregate_key,notnull" json:"key"` // номер заказа
+	Payload       json.RawMessage `bun:"payload,type:jsonb,notnull" json:"payload"`
+	Attempts      int             `bun:"attempts,notnull,default:0" json:"attempts"`
+	NextAttemptAt time.Time       `bun:"next_attempt_at,notnull,default:current_timestamp" json:"next_attempt_at"`
+	LastError     string          `bun:"last_error" json:"last_error,omitempty"`
+	CreatedAt     time.Time       `bun:"created_at,nullzero,notnull,default:current_timestamp" json:"created_at"`
+	PublishedAt   time.Time       `bun:"published_at,nullzero" json:"published_at,omitempty"`
+}
+
+// Сообщение, которое получает приемник. Одно и то же событие может прийти
+// повторно, ID при этом не меняется
+type Message struct {
+	ID        string          `json:"id"`
+	Type      string          `json:"type"`
+	Key       string          `json:"key"`
+	CreatedAt time.Time       `json:"created_at"`
+	Data      json.RawMessage `json:"data"`
+}
+
+func (e *Event) Message() *Message {
+	return &Message{
+		ID:        e.EventID,
+		Type:      e.EventType,
+		Key:       e.Key,
+		CreatedAt: e.CreatedAt,
+		Data:      e.Payload,
+	}
+}
@@ -0,0 +1,76 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// Публикация в поток JetStream. Сообщение считается принятым только по
+// PubAck: поток на subject сохранил его. Если потока нет, публикация
+// завершается ошибкой и ретранслятор повторит ее. Заголовок Nats-Msg-Id
+// позволяет потоку отбрасывать повторы в пределах окна дедупликации
+type NATSSink struct {
+	subject string
+	js      jetstream.JetStream
+}
+
+func NewNATSSink(rawURL, subject string) (*NATSSink, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("неверный адрес NATS: %w", err)
+	}
+	if u.Scheme != "nats" && u.Scheme != "tls" {
+		return nil, fmt.Errorf("неверный адрес NATS %q: нужна схема nats:// или tls://", rawURL)
+	}
+	if subject == "" || strings.ContainsAny(subject, " \t\r\n") {
+		return nil, fmt.Errorf("неверный subject NATS %q", subject)
+	}
+
+	// Сервер может быть недоступен при запуске: клиент подключится позже,
+	// а до тех пор публикации завершаются ошибкой и повторяются
+	conn, err := nats.Connect(rawURL,
+		nats.Name("PaymentAlphaBank-outbox"),
+		nats.Timeout(sinkTimeout),
+		nats.RetryOnFailedConnect(true),
+		nats.MaxReconnects(-1),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка подключения к NATS: %w", err)
+	}
+	js, err := jetstream.New(conn)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("ошибка подключения к JetStream: %w", err)
+	}
+	return &NATSSink{subject: subject, js: js}, nil
+}
+
+func (s *NATSSink) Publish(ctx context.Context, msg *Message) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, sinkTimeout)
+		defer cancel()
+	}
+
+	natsMsg := nats.NewMsg(s.subject)
+	natsMsg.Header.Set("Outbox-Event", msg.Type)
+	natsMsg.Header.Set("Outbox-Key", msg.Key)
+	natsMsg.Data = body
+
+	// Повтор с тем же ID поток подтверждает с Duplicate: сообщение уже сохранено
+	if _, err := s.js.PublishMsg(ctx, natsMsg, jetstream.WithMsgID(msg.ID)); err != nil {
+		return fmt.Errorf("ошибка публикации в JetStream: %w", err)
+	}
+	return nil
+}
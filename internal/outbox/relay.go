@@ -0,0 +1,132 @@
+package outbox
+
+import (
+	"context"
+	"log"
+	"time"
+)
+
+// Параметры ретрансляции
+const (
+	relayInterval   = 2 * time.Second
+	relayBatch      = 100
+	cleanupInterval = time.Hour
+	retryBaseDelay  = 5 * time.Second
+	retryMaxDelay   = 10 * time.Minute
+)
+
+// Публикация событий outbox в приемник. Событие отмечается опубликованным
+// только после ответа приемника, поэтому доставка — не менее одного раза:
+// при сбое между публикацией и отметкой событие уйдет повторно. Неудача
+// задерживает все последующие события того же ключа до успешного повтора.
+// Опубликованные события удаляются через retention
+type Relay struct {
+	outboxRepo  *OutboxRepository
+	sink        Sink
+	retention   time.Duration
+	lastCleanup time.Time
+}
+
+func NewRelay(outboxRepo *OutboxRepository, sink Sink, retention time.Duration) *Relay {
+	return &Relay{
+		outboxRepo: outboxRepo,
+		sink:       sink,
+		retention:  retention,
+	}
+}
+
+// Ретрансляция до отмены ctx
+func (r *Relay) Run(ctx context.Context) {
+	ticker := time.NewTicker(relayInterval)
+	defer ticker.Stop()
+
+	for {
+		_, err := r.outboxRepo.WithRelayLock(ctx, func(ctx context.Context) error {
+			// Полная пачка — вероятно, в очереди есть еще, берем сразу
+			for r.publishReady(ctx) == relayBatch {
+			}
+			r.cleanup(ctx)
+			return nil
+		})
+		if err != nil {
+			log.Printf("Ошибка ретрансляции outbox: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Публикация одной пачки; 0 при ошибке базы, чтобы не повторять пачку сразу
+func (r *Relay) publishReady(ctx context.Context) int {
+	events, err := r.outboxRepo.ListReady(ctx, time.Now(), relayBatch)
+	if err != nil {
+		log.Printf("Ошибка получения событий outbox: %v", err)
+		return 0
+	}
+
+	// Ключи, событие которых не ушло: остальные их события ждут следующего раза
+	blocked := make(map[string]bool)
+	for i := range events {
+		event := &events[i]
+		if ctx.Err() != nil {
+			return 0
+		}
+		if blocked[event.Key] {
+			continue
+		}
+
+		if err := r.sink.Publish(ctx, event.Message()); err != nil {
+			blocked[event.Key] = true
+			event.Attempts++
+			event.NextAttemptAt = time.Now().Add(retryDelay(event.Attempts))
+			event.LastError = err.Error()
+			log.Printf("Событие outbox %d (%s, %s) не опубликовано, попытка %d: %v",
+				event.ID, event.EventType, event.Key, event.Attempts, err)
+			if err := r.outboxRepo.MarkFailed(ctx, event); err != nil {
+				log.Printf("Ошибка сохранения попытки события outbox %d: %v", event.ID, err)
+				return 0
+			}
+			continue
+		}
+
+		if err := r.outboxRepo.MarkPublished(ctx, event.ID, time.Now()); err != nil {
+			// Событие уйдет повторно; потребитель отбросит его по ID
+			log.Printf("Ошибка отметки события outbox %d: %v", event.ID, err)
+			return 0
+		}
+	}
+	return len(events)
+}
+
+func (r *Relay) cleanup(ctx context.Context) {
+	if r.retention <= 0 || time.Since(r.lastCleanup) < cleanupInterval {
+		return
+	}
+	r.lastCleanup = time.Now()
+
+	deleted, err := r.outboxRepo.DeletePublished(ctx, time.Now().Add(-r.retention))
+	if err != nil {
+		log.Printf("Ошибка очистки outbox: %v", err)
+		return
+	}
+	if deleted > 0 {
+		log.Printf("Из outbox удалено опубликованных событий: %d", deleted)
+	}
+}
+
+// Задержка перед попыткой attempts+1: 5 с, 10 с, 20 с ... не более 10 мин.
+// Меньше, чем у вебхуков: задержка события держит очередь всего заказа
+func retryDelay(attempts int) time.Duration {
+	delay := retryBaseDelay
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= retryMaxDelay {
+			return retryMaxDelay
+		}
+	}
+	return delay
+}
@@ -0,0 +1,109 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/skr1ms/PaymentAlphaBank.git/pkg/db"
+	"github.com/uptrace/bun"
+)
+
+// Ключ рекомендательной блокировки ретранслятора: публикует одна реплика,
+// иначе события одного заказа могли бы уйти не по порядку
+const relayLockKey = 7_301_845_520_105
+
+type OutboxRepository struct {
+	db *bun.DB
+}
+
+func NewOutboxRepository(db *bun.DB) *OutboxRepository {
+	return &OutboxRepository{db: db}
+}
+
+// Запись событий в транзакции вызывающего кода, если она открыта
+func (r *OutboxRepository) Add(ctx context.Context, events ...*Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+	_, err := db.Conn(ctx, r.db).NewInsert().Model(&events).Exec(ctx)
+	return err
+}
+
+// Неопубликованные события по порядку ID. Событие пропускается, если у его
+// ключа есть не опубликованное событие, время повтора которого еще не пришло:
+// более поздние события ключа ждут, пока не уйдет первое
+func (r *OutboxRepository) ListReady(ctx context.Context, now time.Time, limit int) ([]Event, error) {
+	var events []Event
+	err := r.db.NewSelect().
+		Model(&events).
+		Where("event.published_at IS NULL").
+		Where(`NOT EXISTS (
+			SELECT 1 FROM outbox_events AS waiting
+			WHERE waiting.aggregate_key = event.aggregate_key
+			  AND waiting.published_at IS NULL
+			  AND waiting.id <= event.id
+			  AND waiting.next_attempt_at > ?)`, now).
+		Order("event.id").
+		Limit(limit).
+		Scan(ctx)
+	return events, err
+}
+
+func (r *OutboxRepository) MarkPublished(ctx context.Context, id int64, publishedAt time.Time) error {
+	_, err := r.db.NewUpdate().
+		Model((*Event)(nil)).
+		Set("published_at = ?", publishedAt).
+		Set("last_error = NULL").
+		Where("id = ?", id).
+		Exec(ctx)
+	return err
+}
+
+func (r *OutboxRepository) MarkFailed(ctx context.Context, event *Event) error {
+	_, err := r.db.NewUpdate().
+		Model(event).
+		Column("attempts", "next_attempt_at", "last_error").
+		WherePK().
+		Exec(ctx)
+	return err
+}
+
+// Удаление опубликованных событий старше before; возвращает число удаленных
+func (r *OutboxRepository) DeletePublished(ctx context.Context, before time.Time) (int, error) {
+	res, err := r.db.NewDelete().
+		Model((*Event)(nil)).
+		Where("published_at < ?", before).
+		Exec(ctx)
+	if err != nil {
+		return 0, err
+	}
+	deleted, err := res.RowsAffected()
+	return int(deleted), err
+}
+
+// Выполнение fn, если блокировку ретранслятора удалось взять; false — ее
+// держит другая реплика
+func (r *OutboxRepository) WithRelayLock(ctx context.Context, fn func(ctx context.Context) error) (bool, error) {
+	conn, err := r.db.Conn(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	var locked bool
+	if err := conn.QueryRowContext(ctx, "SELECT pg_try_advisory_lock(?)", relayLockKey).Scan(&locked); err != nil {
+		return false, fmt.Errorf("ошибка получения блокировки outbox: %w", err)
+	}
+	if !locked {
+		return false, nil
+	}
+	defer func() {
+		if _, err := conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock(?)", relayLockKey); err != nil {
+			log.Printf("Ошибка снятия блокировки outbox: %v", err)
+		}
+	}()
+
+	return true, fn(ctx)
+}
@@ -0,0 +1,49 @@
+package outbox
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+type OutboxService struct {
+	outboxRepo *OutboxRepository
+}
+
+func NewOutboxService(outboxRepo *OutboxRepository) *OutboxService {
+	return &OutboxService{outboxRepo: outboxRepo}
+}
+
+// Запись события для публикации. Вызывается внутри транзакции изменения:
+// событие сохранится, только если изменение зафиксировано
+func (s *OutboxService) Add(ctx context.Context, eventType, key string, data any) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("ошибка сериализации события %s: %w", eventType, err)
+	}
+	eventID, err := newEventID()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	return s.outboxRepo.Add(ctx, &Event{
+		EventID:       eventID,
+		EventType:     eventType,
+		Key:           key,
+		Payload:       payload,
+		NextAttemptAt: now,
+		CreatedAt:     now,
+	})
+}
+
+func newEventID() (string, error) {
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("ошибка генерации ID события: %w", err)
+	}
+	return "out_" + hex.EncodeToString(raw), nil
+}
@@ -0,0 +1,173 @@
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/skr1ms/PaymentAlphaBank.git/config"
+	"github.com/skr1ms/PaymentAlphaBank.git/internal/webhook"
+)
+
+// Заголовки запросов приемника http
+const (
+	HeaderSignature = "X-Outbox-Signature" // как у вебхуков: t=<unix-время>,v1=<HMAC-SHA256>
+	HeaderEvent     = "X-Outbox-Event"
+	HeaderEventID   = "X-Outbox-ID"
+	HeaderKey       = "X-Outbox-Key"
+)
+
+const sinkTimeout = 10 * time.Second
+
+// Приемник событий. nil означает, что приемник принял сообщение и оно не
+// потеряется; при ошибке сообщение будет отправлено повторно
+type Sink interface {
+	Publish(ctx context.Context, msg *Message) error
+}
+
+// Приемник по настройкам outbox
+func NewSink(cfg config.OutboxConfig) (Sink, error) {
+	switch cfg.Sink {
+	case "", "log":
+		return LogSink{}, nil
+	case "http":
+		return NewHTTPSink(cfg.URL, cfg.Secret), nil
+	case "nats":
+		return NewNATSSink(cfg.URL, cfg.Topic)
+	case "kafka":
+		return NewKafkaSink(cfg.URL, cfg.Topic), nil
+	}
+	return nil, fmt.Errorf("неизвестный приемник outbox %q", cfg.Sink)
+}
+
+// Запись событий в журнал, для разработки
+type LogSink struct{}
+
+func (LogSink) Publish(ctx context.Context, msg *Message) error {
+	log.Printf("Событие %s %s (%s): %s", msg.Type, msg.ID, msg.Key, msg.Data)
+	return nil
+}
+
+// POST сообщения на URL. Ответ 2xx подтверждает прием
+type HTTPSink struct {
+	url    string
+	secret string
+	client *http.Client
+}
+
+func NewHTTPSink(url, secret string) *HTTPSink {
+	return &HTTPSink{
+		url:    url,
+		secret: secret,
+		client: &http.Client{Timeout: sinkTimeout},
+	}
+}
+
+func (s *HTTPSink) Publish(ctx context.Context, msg *Message) error {
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "PaymentAlphaBank-Outbox/1.0")
+	req.Header.Set(HeaderEvent, msg.Type)
+	req.Header.Set(HeaderEventID, msg.ID)
+	req.Header.Set(HeaderKey, msg.Key)
+	if s.secret != "" {
+		req.Header.Set(HeaderSignature, webhook.Sign(s.secret, time.Now(), body))
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("приемник ответил %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// Kafka через Kafka REST Proxy (API v2). Ключ записи — номер заказа, поэтому
+// события заказа попадают в одну партицию и читаются по порядку
+type KafkaSink struct {
+	url    string
+	client *http.Client
+}
+
+func NewKafkaSink(proxyURL, topic string) *KafkaSink {
+	return &KafkaSink{
+		url:    strings.TrimRight(proxyURL, "/") + "/topics/" + topic,
+		client: &http.Client{Timeout: sinkTimeout},
+	}
+}
+
+type kafkaRecords struct {
+	Records []kafkaRecord `json:"records"`
+}
+
+type kafkaRecord struct {
+	Key   string   `json:"key"`
+	Value *Message `json:"value"`
+}
+
+type kafkaResponse struct {
+	Offsets []struct {
+		Partition int     `json:"partition"`
+		Offset    int64   `json:"offset"`
+		ErrorCode *int    `json:"error_code"`
+		Error     *string `json:"error"`
+	} `json:"offsets"`
+	Message string `json:"message"`
+}
+
+func (s *KafkaSink) Publish(ctx context.Context, msg *Message) error {
+	body, err := json.Marshal(kafkaRecords{Records: []kafkaRecord{{Key: msg.Key, Value: msg}}})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/vnd.kafka.json.v2+json")
+	req.Header.Set("Accept", "application/vnd.kafka.v2+json")
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var result kafkaResponse
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&result); err != nil && resp.StatusCode == http.StatusOK {
+		return fmt.Errorf("ошибка разбора ответа Kafka REST Proxy: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Kafka REST Proxy ответил %d: %s", resp.StatusCode, result.Message)
+	}
+	// Прокси отвечает 200 и при ошибке записи в партицию
+	for _, offset := range result.Offsets {
+		if offset.Error != nil {
+			return fmt.Errorf("ошибка записи в Kafka: %s", *offset.Error)
+		}
+		if offset.ErrorCode != nil {
+			return fmt.Errorf("ошибка записи в Kafka: код %d", *offset.ErrorCode)
+		}
+	}
+	return nil
+}
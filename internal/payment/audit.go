@@ -0,0 +1,130 @@
+package payment
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/skr1ms/PaymentAlphaBank.git/pkg/auth"
+)
+
+// Источники изменений заказа в журнале
+const (
+	ChangeSourceAPI        = "api"
+	ChangeSourceWebhook    = "webhook"     // уведомление банка
+	ChangeSourceReturnPage = "return_page" // возврат покупателя с платежной страницы
+	ChangeSourceReconciler = "reconciler"
+	ChangeSourceAdmin      = "admin"
+)
+
+// Исполнители изменений без учетной записи
+const (
+	ActorBank   = "bank"
+	ActorSystem = "system"
+)
+
+type changeSourceKey struct{}
+
+type changeSource struct {
+	source string
+	actor  string
+}
+
+// Контекст с источником и исполнителем изменений для журнала заказа.
+// Без него изменения записываются с источником api
+func WithChangeSource(ctx context.Context, source, actor string) context.Context {
+	return context.WithValue(ctx, changeSourceKey{}, changeSource{source: source, actor: actor})
+}
+
+func changeSourceFrom(ctx context.Context) changeSource {
+	if source, ok := ctx.Value(changeSourceKey{}).(changeSource); ok {
+		return source
+	}
+	return changeSource{source: ChangeSourceAPI}
+}
+
+// Исполнитель для журнала: API-ключ, магазин или пользователь; пусто для
+// запроса без учетных данных
+func PrincipalActor(principal *auth.Principal) string {
+	switch {
+	case principal == nil:
+		return ""
+	case principal.KeyID != 0:
+		return "key:" + strconv.FormatInt(principal.KeyID, 10)
+	case principal.MerchantID != 0:
+		return MerchantActor(principal.MerchantID)
+	case principal.UserID != "":
+		return "user:" + principal.UserID
+	}
+	return ""
+}
+
+func MerchantActor(merchantID int64) string {
+	return "merchant:" + strconv.FormatInt(merchantID, 10)
+}
+
+// Запись в журнал заказа с источником из контекста. Вызывается в транзакции
+// изменения, поэтому запись и изменение фиксируются вместе
+func (s *CouponService) recordChange(ctx context.Context, order *Order, change *OrderChange) error {
+	source := changeSourceFrom(ctx)
+	change.OrderID = order.ID
+	change.Source = source.source
+	change.Actor = source.actor
+	if change.BankOrderID == "" {
+		change.BankOrderID = order.AlfaBankOrderID
+	}
+	return s.changeRepo.Append(ctx, change)
+}
+
+// Смена статуса для журнала; bank — ответ банка, по которому статус изменен
+func statusChange(previous, status string, bank *AlfaBankStatusResponse) *OrderChange {
+	change := &OrderChange{
+		Kind:      OrderChangeStatus,
+		OldStatus: previous,
+		NewStatus: status,
+	}
+	if bank != nil {
+		change.BankStatus = &bank.OrderStatus
+		change.BankActionCode = &bank.ActionCode
+		if bank.ActionCodeDescription != "" {
+			change.Details = map[string]any{"action_code_description": bank.ActionCodeDescription}
+		}
+	}
+	return change
+}
+
+// Возврат для журнала: сумма в копейках и возвращенные купоны; статус
+// указывается, только если возврат его изменил
+func refundChange(previous, status string, refund *Refund) *OrderChange {
+	change := &OrderChange{
+		Kind: OrderChangeRefunded,
+		Details: map[string]any{
+			"refund_id":       refund.ID,
+			"amount":          refund.Amount,
+			"user_coupon_ids": refund.UserCouponIDs(),
+		},
+	}
+	if status != previous {
+		change.OldStatus = previous
+		change.NewStatus = status
+	}
+	return change
+}
+
+// Передача купона в подарок для журнала заказа, по которому купон куплен
+func couponTransferChange(ctx context.Context, userCoupon *UserCoupon, fromUserID, toUserID string) *OrderChange {
+	change := &OrderChange{
+		OrderID:      userCoupon.OrderID,
+		Kind:         OrderChangeCouponTransferred,
+		UserCouponID: userCoupon.ID,
+		Source:       changeSourceFrom(ctx).source,
+		Actor:        "user:" + toUserID,
+		Details: map[string]any{
+			"from_user_id": fromUserID,
+			"to_user_id":   toUserID,
+		},
+	}
+	if userCoupon.Order != nil {
+		change.BankOrderID = userCoupon.Order.AlfaBankOrderID
+	}
+	return change
+}
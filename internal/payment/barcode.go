@@ -0,0 +1,125 @@
+package payment
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image/color"
+	"image/png"
+
+	"github.com/boombuler/barcode"
+	"github.com/boombuler/barcode/code128"
+	"github.com/boombuler/barcode/qr"
+)
+
+// Виды кодов для отображения купона
+const (
+	BarcodeKindQR      = "qr"
+	BarcodeKindCode128 = "code128"
+)
+
+// Форматы изображений
+const (
+	ImageFormatPNG = "png"
+	ImageFormatSVG = "svg"
+)
+
+const (
+	qrImageSize      = 320
+	code128Width     = 480
+	code128Height    = 120
+	barcodeQuietZone = 4 // пустое поле вокруг кода в модулях
+)
+
+var ErrUnsupportedBarcode = errors.New("неподдерживаемый вид или формат кода")
+
+// Отрисовка подписанного кода купона в PNG или SVG
+func RenderBarcode(payload, kind, format string) ([]byte, string, error) {
+	var (
+		bc  barcode.Barcode
+		err error
+	)
+
+	switch kind {
+	case BarcodeKindQR:
+		bc, err = qr.Encode(payload, qr.M, qr.Auto)
+	case BarcodeKindCode128:
+		bc, err = code128.Encode(payload)
+	default:
+		return nil, "", ErrUnsupportedBarcode
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("ошибка кодирования: %w", err)
+	}
+
+	switch format {
+	case ImageFormatPNG:
+		data, err := renderPNG(bc, kind)
+		return data, "image/png", err
+	case ImageFormatSVG:
+		return renderSVG(bc), "image/svg+xml", nil
+	default:
+		return nil, "", ErrUnsupportedBarcode
+	}
+}
+
+func renderPNG(bc barcode.Barcode, kind string) ([]byte, error) {
+	width, height := qrImageSize, qrImageSize
+	if kind == BarcodeKindCode128 {
+		width, height = code128Width, code128Height
+	}
+
+	scaled, err := barcode.Scale(bc, width, height)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка масштабирования: %w", err)
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, scaled); err != nil {
+		return nil, fmt.Errorf("ошибка кодирования PNG: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// SVG строится по модулям кода, соседние темные модули строки объединяются
+func renderSVG(bc barcode.Barcode) []byte {
+	bounds := bc.Bounds()
+	cols := bounds.Dx()
+	rows := bounds.Dy()
+
+	// Одномерные коды растягиваем по высоте
+	rowHeight := 1
+	if bc.Metadata().Dimensions == 1 {
+		rowHeight = cols / 4
+	}
+
+	width := cols + 2*barcodeQuietZone
+	height := rows*rowHeight + 2*barcodeQuietZone
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" shape-rendering="crispEdges">`, width, height)
+	fmt.Fprintf(&buf, `<rect width="%d" height="%d" fill="#fff"/><path fill="#000" d="`, width, height)
+
+	for y := 0; y < rows; y++ {
+		for x := 0; x < cols; {
+			if !isDark(bc.At(bounds.Min.X+x, bounds.Min.Y+y)) {
+				x++
+				continue
+			}
+			start := x
+			for x < cols && isDark(bc.At(bounds.Min.X+x, bounds.Min.Y+y)) {
+				x++
+			}
+			fmt.Fprintf(&buf, "M%d %dh%dv%dh-%dz",
+				start+barcodeQuietZone, y*rowHeight+barcodeQuietZone, x-start, rowHeight, x-start)
+		}
+	}
+
+	buf.WriteString(`"/></svg>`)
+	return buf.Bytes()
+}
+
+func isDark(c color.Color) bool {
+	r, g, b, _ := c.RGBA()
+	return r+g+b < 3*0x8000
+}
@@ -0,0 +1,135 @@
+package payment
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// Варианты сортировки каталога
+const (
+	SortCreatedDesc = "created_desc"
+	SortPriceAsc    = "price_asc"
+	SortPriceDesc   = "price_desc"
+	SortNameAsc     = "name_asc"
+	SortRelevance   = "relevance"
+)
+
+const (
+	defaultCatalogLimit = 50
+	maxCatalogLimit     = 100
+)
+
+var ErrInvalidCatalogFilter = errors.New("неверные параметры фильтрации каталога")
+
+// Фильтр каталога купонов
+type CouponFilter struct {
+	Query      string
+	Category   string
+	Tag        string
+	MerchantID int64
+	MinPrice   *float64
+	MaxPrice   *float64
+	Sort       string
+	Cursor     *CatalogCursor
+	Limit      int
+	Locale     string
+}
+
+// Курсор keyset-пагинации: значение ключа сортировки и ID последнего купона
+type CatalogCursor struct {
+	Sort  string `json:"s"`
+	Value string `json:"v"`
+	ID    int64  `json:"id"`
+}
+
+// Проверка фильтра и подстановка значений по умолчанию
+func (f *CouponFilter) Normalize() error {
+	if f.Sort == "" {
+		f.Sort = SortCreatedDesc
+		if f.Query != "" {
+			f.Sort = SortRelevance
+		}
+	}
+
+	switch f.Sort {
+	case SortCreatedDesc, SortPriceAsc, SortPriceDesc, SortNameAsc:
+	case SortRelevance:
+		if f.Query == "" {
+			return ErrInvalidCatalogFilter
+		}
+	default:
+		return ErrInvalidCatalogFilter
+	}
+
+	if f.Limit <= 0 {
+		f.Limit = defaultCatalogLimit
+	}
+	if f.Limit > maxCatalogLimit {
+		f.Limit = maxCatalogLimit
+	}
+
+	if f.MinPrice != nil && f.MaxPrice != nil && *f.MinPrice > *f.MaxPrice {
+		return ErrInvalidCatalogFilter
+	}
+
+	// Курсор действителен только для той же сортировки
+	if f.Cursor != nil && f.Cursor.Sort != f.Sort {
+		return ErrInvalidCatalogFilter
+	}
+
+	return nil
+}
+
+// Курсор, указывающий на следующую страницу после купона
+func (f *CouponFilter) NextCursor(last *Coupon) string {
+	cursor := CatalogCursor{Sort: f.Sort, ID: last.ID}
+	switch f.Sort {
+	case SortPriceAsc, SortPriceDesc:
+		cursor.Value = strconv.FormatFloat(last.Price, 'f', -1, 64)
+	case SortNameAsc:
+		cursor.Value = last.Name
+	case SortRelevance:
+		cursor.Value = strconv.FormatFloat(last.Rank, 'g', -1, 64)
+	default:
+		cursor.Value = last.CreatedAt.UTC().Format(time.RFC3339Nano)
+	}
+
+	data, _ := json.Marshal(cursor)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func ParseCatalogCursor(value string) (*CatalogCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return nil, ErrInvalidCatalogFilter
+	}
+
+	var cursor CatalogCursor
+	if err := json.Unmarshal(data, &cursor); err != nil {
+		return nil, ErrInvalidCatalogFilter
+	}
+	return &cursor, nil
+}
+
+// Значение ключа сортировки из курсора в типе, пригодном для сравнения в SQL
+func (c *CatalogCursor) sortValue() (interface{}, error) {
+	switch c.Sort {
+	case SortPriceAsc, SortPriceDesc, SortRelevance:
+		v, err := strconv.ParseFloat(c.Value, 64)
+		if err != nil {
+			return nil, ErrInvalidCatalogFilter
+		}
+		return v, nil
+	case SortNameAsc:
+		return c.Value, nil
+	default:
+		v, err := time.Parse(time.RFC3339Nano, c.Value)
+		if err != nil {
+			return nil, ErrInvalidCatalogFilter
+		}
+		return v, nil
+	}
+}
@@ -0,0 +1,82 @@
+package payment
+
+import (
+	"crypto/rand"
+	"errors"
+	"math/big"
+	"strings"
+)
+
+// Алфавит кодов купонов: без 0, 1, I и O, которые легко перепутать на кассе
+const codeAlphabet = "23456789ABCDEFGHJKLMNPQRSTUVWXYZ"
+
+const (
+	codeRandomLength = 11 // случайные символы, ~55 бит энтропии
+	codeGroupSize    = 4
+)
+
+var (
+	ErrInvalidCouponCode  = errors.New("неверный формат кода купона")
+	ErrCouponCodeNotFound = errors.New("купон с таким кодом не найден")
+	ErrCouponOwnerMissing = errors.New("не указан владелец купона")
+	ErrCouponNotOwned     = errors.New("купон принадлежит другому пользователю")
+	ErrCouponNotPaid      = errors.New("заказ купона не оплачен")
+	ErrCouponExpired      = errors.New("срок действия купона истек")
+	ErrCouponAlreadyUsed  = errors.New("купон уже использован")
+	ErrCouponRefunded     = errors.New("купон возвращен")
+)
+
+// Генерация кода купона вида XXXX-XXXX-XXXX, последний символ — контрольный
+func GenerateCouponCode() (string, error) {
+	max := big.NewInt(int64(len(codeAlphabet)))
+	raw := make([]byte, codeRandomLength, codeRandomLength+1)
+	for i := range raw {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		raw[i] = codeAlphabet[n.Int64()]
+	}
+	raw = append(raw, codeCheckChar(string(raw)))
+	return formatCouponCode(string(raw)), nil
+}
+
+// Приведение введенного кода к каноническому виду с проверкой контрольного символа
+func NormalizeCouponCode(input string) (string, error) {
+	raw := strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+		return r
+	}, strings.ToUpper(strings.TrimSpace(input)))
+
+	if len(raw) != codeRandomLength+1 {
+		return "", ErrInvalidCouponCode
+	}
+	for i := 0; i < len(raw); i++ {
+		if strings.IndexByte(codeAlphabet, raw[i]) < 0 {
+			return "", ErrInvalidCouponCode
+		}
+	}
+	if codeCheckChar(raw[:codeRandomLength]) != raw[codeRandomLength] {
+		return "", ErrInvalidCouponCode
+	}
+
+	return formatCouponCode(raw), nil
+}
+
+// Контрольный символ по алгоритму Луна для основания 32
+func codeCheckChar(s string) byte {
+	return luhnCheckChar(codeAlphabet, s)
+}
+
+func formatCouponCode(raw string) string {
+	var b strings.Builder
+	for i := 0; i < len(raw); i++ {
+		if i > 0 && i%codeGroupSize == 0 {
+			b.WriteByte('-')
+		}
+		b.WriteByte(raw[i])
+	}
+	return b.String()
+}
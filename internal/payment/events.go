@@ -0,0 +1,120 @@
+package payment
+
+import (
+	"sync"
+	"time"
+)
+
+// Типы событий заказа
+const (
+	OrderEventSnapshot      = "order.snapshot"
+	OrderEventStatusChanged = "order.status_changed"
+	OrderEventRefunded      = "order.refunded"
+)
+
+// Размер очереди подписчика. Медленный подписчик теряет события сверх
+// очереди, но не задерживает публикацию
+const orderEventBuffer = 16
+
+// Изменение заказа для подписчиков. Суммы в рублях, как в ответах API
+type OrderEvent struct {
+	Type           string    `json:"type"`
+	OrderID        int64     `json:"order_id"`
+	OrderNumber    string    `json:"order_number"`
+	UserID         string    `json:"user_id"`
+	Status         string    `json:"status"`
+	PreviousStatus string    `json:"previous_status,omitempty"`
+	Amount         float64   `json:"amount"`
+	Refunded       float64   `json:"refunded"`
+	Currency       string    `json:"currency"`
+	OccurredAt     time.Time `json:"occurred_at"`
+}
+
+// Фильтр подписки: по номеру заказа, по пользователю или оба условия сразу
+type OrderEventFilter struct {
+	OrderNumber string
+	UserID      string
+}
+
+func (f OrderEventFilter) matches(event *OrderEvent) bool {
+	if f.OrderNumber != "" && f.OrderNumber != event.OrderNumber {
+		return false
+	}
+	if f.UserID != "" && f.UserID != event.UserID {
+		return false
+	}
+	return true
+}
+
+type orderSubscription struct {
+	filter OrderEventFilter
+	events chan OrderEvent
+}
+
+// Шина событий заказов внутри процесса. Источники — обработка уведомлений
+// банка, сверка зависших заказов и возвраты; подписчики получают изменения
+// без собственных запросов в банк
+type OrderEventBus struct {
+	mu            sync.RWMutex
+	subscriptions map[*orderSubscription]struct{}
+}
+
+func NewOrderEventBus() *OrderEventBus {
+	return &OrderEventBus{subscriptions: make(map[*orderSubscription]struct{})}
+}
+
+// Подписка на события. Канал закрывается вызовом cancel
+func (b *OrderEventBus) Subscribe(filter OrderEventFilter) (<-chan OrderEvent, func()) {
+	sub := &orderSubscription{
+		filter: filter,
+		events: make(chan OrderEvent, orderEventBuffer),
+	}
+
+	b.mu.Lock()
+	b.subscriptions[sub] = struct{}{}
+	b.mu.Unlock()
+
+	var once sync.Once
+	cancel := func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subscriptions, sub)
+			b.mu.Unlock()
+			close(sub.events)
+		})
+	}
+	return sub.events, cancel
+}
+
+func (b *OrderEventBus) Publish(event OrderEvent) {
+	if event.OccurredAt.IsZero() {
+		event.OccurredAt = time.Now()
+	}
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for sub := range b.subscriptions {
+		if !sub.filter.matches(&event) {
+			continue
+		}
+		select {
+		case sub.events <- event:
+		default:
+		}
+	}
+}
+
+func newOrderEvent(eventType string, order *Order, previousStatus string) OrderEvent {
+	return OrderEvent{
+		Type:           eventType,
+		OrderID:        order.ID,
+		OrderNumber:    order.OrderNumber,
+		UserID:         order.UserID,
+		Status:         order.Status,
+		PreviousStatus: previousStatus,
+		Amount:         float64(order.Amount) / 100,
+		Refunded:       float64(order.RefundedAmount) / 100,
+		Currency:       order.Currency,
+		OccurredAt:     time.Now(),
+	}
+}
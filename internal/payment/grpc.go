@@ -0,0 +1,434 @@
+package payment
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math"
+	"net/url"
+	"time"
+
+	"github.com/skr1ms/PaymentAlphaBank.git/internal/promo"
+	"github.com/skr1ms/PaymentAlphaBank.git/pkg/auth"
+	"github.com/skr1ms/PaymentAlphaBank.git/pkg/i18n"
+	paymentv1 "github.com/skr1ms/PaymentAlphaBank.git/pkg/paymentpb/payment/v1"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Метаданные с ключом партнера для RedeemCoupon, как заголовок X-Merchant-Key в REST
+const merchantKeyMetadata = "x-merchant-key"
+
+type GRPCServerDeps struct {
+	CouponService   *CouponService
+	CatalogService  *CatalogService
+	MerchantService *MerchantService
+}
+
+// gRPC API поверх тех же сервисов, что и REST. Учетные данные проверяет
+// интерцептор auth.Guard, права — каждый метод
+type GRPCServer struct {
+	paymentv1.UnimplementedPaymentServiceServer
+	deps *GRPCServerDeps
+}
+
+func NewGRPCServer(deps *GRPCServerDeps) *GRPCServer {
+	return &GRPCServer{deps: deps}
+}
+
+func (s *GRPCServer) ListCoupons(ctx context.Context, req *paymentv1.ListCouponsRequest) (*paymentv1.ListCouponsResponse, error) {
+	filter := &CouponFilter{
+		Query:    req.Query,
+		Category: req.Category,
+		Tag:      req.Tag,
+		Sort:     req.Sort,
+		Limit:    int(req.Limit),
+		Locale:   grpcLocale(req.Locale),
+	}
+	if req.Cursor != "" {
+		cursor, err := ParseCatalogCursor(req.Cursor)
+		if err != nil {
+			return nil, grpcError(err, "ошибка получения купонов")
+		}
+		filter.Cursor = cursor
+	}
+
+	coupons, nextCursor, err := s.deps.CatalogService.GetCoupons(ctx, filter)
+	if err != nil {
+		return nil, grpcError(err, "ошибка получения купонов")
+	}
+
+	resp := &paymentv1.ListCouponsResponse{NextCursor: nextCursor}
+	for i := range coupons {
+		resp.Coupons = append(resp.Coupons, couponToProto(&coupons[i]))
+	}
+	return resp, nil
+}
+
+func (s *GRPCServer) CreateOrder(ctx context.Context, req *paymentv1.CreateOrderRequest) (*paymentv1.CreateOrderResponse, error) {
+	// Сервисы с orders:write оформляют заказы от имени пользователей
+	userID, err := auth.ActingUser(ctx, req.UserId, auth.ScopeOrdersWrite)
+	if err != nil {
+		return nil, err
+	}
+	if !isAbsoluteURL(req.ReturnUrl) || (req.FailUrl != "" && !isAbsoluteURL(req.FailUrl)) {
+		return nil, status.Error(codes.InvalidArgument, "return_url и fail_url должны быть абсолютными URL")
+	}
+
+	orderReq := &CreateOrderRequest{
+		CouponID:  req.CouponId,
+		UserID:    userID,
+		ReturnURL: req.ReturnUrl,
+		FailURL:   req.FailUrl,
+		Language:  grpcLocale(req.Language),
+		PromoCode: req.PromoCode,
+	}
+	for _, item := range req.Items {
+		orderReq.Items = append(orderReq.Items, OrderItemRequest{
+			CouponID: item.CouponId,
+			Quantity: int(item.Quantity),
+		})
+	}
+
+	ctx = WithChangeSource(ctx, ChangeSourceAPI, PrincipalActor(auth.PrincipalFromContext(ctx)))
+	response, err := s.deps.CouponService.CreateOrder(ctx, orderReq)
+	if err != nil {
+		return nil, grpcError(err, "ошибка создания заказа")
+	}
+
+	return &paymentv1.CreateOrderResponse{
+		OrderId:     response.OrderID,
+		OrderNumber: response.OrderNumber,
+		PaymentUrl:  response.PaymentURL,
+		Amount:      toMinor(response.Amount),
+		Discount:    toMinor(response.Discount),
+	}, nil
+}
+
+func (s *GRPCServer) GetOrderStatus(ctx context.Context, req *paymentv1.GetOrderStatusRequest) (*paymentv1.GetOrderStatusResponse, error) {
+	if err := s.authorizeOrder(ctx, req.OrderNumber); err != nil {
+		return nil, err
+	}
+	order, err := s.orderStatus(ctx, req.OrderNumber, req.Locale)
+	if err != nil {
+		return nil, err
+	}
+	return &paymentv1.GetOrderStatusResponse{Order: order}, nil
+}
+
+// Текущий статус сразу, затем каждое изменение из шины событий заказов.
+// Поток завершается, когда оплата заказа завершена, или по отмене клиентом
+func (s *GRPCServer) WatchOrder(req *paymentv1.WatchOrderRequest, stream paymentv1.PaymentService_WatchOrderServer) error {
+	if req.OrderNumber == "" {
+		return status.Error(codes.InvalidArgument, "не указан номер заказа")
+	}
+
+	ctx := stream.Context()
+	if err := s.authorizeOrder(ctx, req.OrderNumber); err != nil {
+		return err
+	}
+
+	events, unsubscribe := s.deps.CouponService.SubscribeOrderEvents(OrderEventFilter{OrderNumber: req.OrderNumber})
+	defer unsubscribe()
+
+	var last *paymentv1.OrderStatus
+	for {
+		locale := grpcLocale(req.Locale)
+		response, err := s.deps.CouponService.GetOrderStatus(ctx, req.OrderNumber, locale)
+		if err != nil {
+			return grpcError(err, "ошибка проверки статуса заказа")
+		}
+		order := orderStatusToProto(req.OrderNumber, locale, response)
+
+		if last == nil || order.Status != last.Status || order.Refunded != last.Refunded {
+			if err := stream.Send(&paymentv1.WatchOrderResponse{Order: order}); err != nil {
+				return err
+			}
+			last = order
+		}
+		if isFinalOrderStatus(order.Status) {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return status.FromContextError(ctx.Err()).Err()
+		case <-events:
+		}
+	}
+}
+
+// Заказ доступен владельцу и вызывающему с областью orders:read. Чужой
+// заказ неотличим от несуществующего, как в REST
+func (s *GRPCServer) authorizeOrder(ctx context.Context, orderNumber string) error {
+	if orderNumber == "" {
+		return status.Error(codes.InvalidArgument, "не указан номер заказа")
+	}
+	principal := auth.PrincipalFromContext(ctx)
+	if principal == nil {
+		return status.Error(codes.Unauthenticated, "требуется авторизация")
+	}
+
+	userID := principal.UserID
+	if principal.HasScope(auth.ScopeOrdersRead) {
+		userID = ""
+	} else if userID == "" {
+		// Ключ без пользователя и без доступа к заказам
+		return status.Error(codes.PermissionDenied, "недостаточно прав")
+	}
+
+	if err := s.deps.CouponService.CheckOrderAccess(ctx, orderNumber, userID); err != nil {
+		return grpcError(err, "ошибка проверки статуса заказа")
+	}
+	return nil
+}
+
+func (s *GRPCServer) orderStatus(ctx context.Context, orderNumber, locale string) (*paymentv1.OrderStatus, error) {
+	if orderNumber == "" {
+		return nil, status.Error(codes.InvalidArgument, "не указан номер заказа")
+	}
+
+	locale = grpcLocale(locale)
+	ctx = WithChangeSource(ctx, ChangeSourceAPI, PrincipalActor(auth.PrincipalFromContext(ctx)))
+	response, err := s.deps.CouponService.CheckOrderStatus(ctx, orderNumber, locale)
+	if err != nil {
+		return nil, grpcError(err, "ошибка проверки статуса заказа")
+	}
+
+	return orderStatusToProto(orderNumber, locale, response), nil
+}
+
+func orderStatusToProto(orderNumber, locale string, response *OrderStatusResponse) *paymentv1.OrderStatus {
+	order := &paymentv1.OrderStatus{
+		OrderId:     response.OrderID,
+		OrderNumber: orderNumber,
+		Status:      response.Status,
+		CouponName:  response.CouponName,
+		Amount:      toMinor(response.Amount),
+		Discount:    toMinor(response.Discount),
+		Refunded:    toMinor(response.Refunded),
+		Currency:    response.Currency,
+		Message:     i18n.Translate(locale, response.Message),
+	}
+	for _, item := range response.Items {
+		order.Items = append(order.Items, orderItemToProto(item))
+	}
+	return order
+}
+
+func (s *GRPCServer) ListUserCoupons(ctx context.Context, req *paymentv1.ListUserCouponsRequest) (*paymentv1.ListUserCouponsResponse, error) {
+	userID, err := auth.ActingUser(ctx, req.UserId, auth.ScopeOrdersRead)
+	if err != nil {
+		return nil, err
+	}
+
+	coupons, err := s.deps.CouponService.GetUserCoupons(ctx, userID, grpcLocale(req.Locale))
+	if err != nil {
+		return nil, grpcError(err, "ошибка получения купонов")
+	}
+
+	resp := &paymentv1.ListUserCouponsResponse{}
+	for i := range coupons {
+		resp.Coupons = append(resp.Coupons, userCouponToProto(&coupons[i]))
+	}
+	return resp, nil
+}
+
+func (s *GRPCServer) ListUserOrders(ctx context.Context, req *paymentv1.ListUserOrdersRequest) (*paymentv1.ListUserOrdersResponse, error) {
+	userID, err := auth.ActingUser(ctx, req.UserId, auth.ScopeOrdersRead)
+	if err != nil {
+		return nil, err
+	}
+
+	orders, err := s.deps.CouponService.GetUserOrders(ctx, userID, grpcLocale(req.Locale))
+	if err != nil {
+		return nil, grpcError(err, "ошибка получения заказов")
+	}
+
+	resp := &paymentv1.ListUserOrdersResponse{}
+	for i := range orders {
+		resp.Orders = append(resp.Orders, orderToProto(&orders[i]))
+	}
+	return resp, nil
+}
+
+func (s *GRPCServer) RedeemCoupon(ctx context.Context, req *paymentv1.RedeemCouponRequest) (*paymentv1.RedeemCouponResponse, error) {
+	merchant, err := s.merchant(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx = WithChangeSource(ctx, ChangeSourceAPI, MerchantActor(merchant.ID))
+	response, err := s.deps.CouponService.RedeemCoupon(ctx, merchant, &RedeemCouponRequest{
+		Code:       req.Code,
+		Payload:    req.Payload,
+		UserID:     req.UserId,
+		TerminalID: req.TerminalId,
+	})
+	if err != nil {
+		return nil, grpcError(err, "ошибка погашения купона")
+	}
+
+	return &paymentv1.RedeemCouponResponse{
+		Code:       response.Code,
+		CouponName: response.CouponName,
+		UserId:     response.UserID,
+		MerchantId: response.MerchantID,
+		TerminalId: response.TerminalID,
+		RedeemedAt: timestamppb.New(response.RedeemedAt),
+	}, nil
+}
+
+// Партнер по ключу x-merchant-key или по API-ключу с ролью merchant
+func (s *GRPCServer) merchant(ctx context.Context) (*Merchant, error) {
+	var (
+		merchant *Merchant
+		err      error
+	)
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	if keys := md.Get(merchantKeyMetadata); len(keys) > 0 {
+		merchant, err = s.deps.MerchantService.Authenticate(ctx, keys[0])
+	} else {
+		if err := auth.CheckScope(ctx, auth.ScopeRedemptionsWrite); err != nil {
+			return nil, err
+		}
+		if principal := auth.PrincipalFromContext(ctx); principal.MerchantID > 0 {
+			merchant, err = s.deps.MerchantService.GetMerchant(ctx, principal.MerchantID)
+		}
+	}
+
+	if err != nil {
+		log.Printf("Ошибка аутентификации партнера: %v", err)
+		return nil, status.Error(codes.Internal, "ошибка проверки доступа")
+	}
+	if merchant == nil {
+		return nil, status.Error(codes.Unauthenticated, "неверный API-ключ партнера")
+	}
+	return merchant, nil
+}
+
+// Ошибки сервисов в коды gRPC; неизвестные ошибки логируются и скрываются
+func grpcError(err error, message string) error {
+	switch {
+	case errors.Is(err, ErrInvalidCatalogFilter), errors.Is(err, ErrInvalidOrderItems),
+		errors.Is(err, ErrInvalidCouponCode), errors.Is(err, ErrInvalidCodeSignature),
+		errors.Is(err, ErrCouponOwnerMissing):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, ErrOrderNotFound), errors.Is(err, ErrCouponNotFound),
+		errors.Is(err, ErrCouponCodeNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	case errors.Is(err, ErrCouponNotOwned):
+		return status.Error(codes.PermissionDenied, err.Error())
+	case errors.Is(err, ErrCouponNotPaid), errors.Is(err, ErrCouponExpired),
+		errors.Is(err, ErrCouponAlreadyUsed), errors.Is(err, ErrCouponRefunded),
+		errors.Is(err, promo.ErrInvalidPromoCode):
+		return status.Error(codes.FailedPrecondition, err.Error())
+	}
+
+	log.Printf("%s: %v", message, err)
+	return status.Error(codes.Internal, message)
+}
+
+// Оплата завершена, статус больше не меняется без действий сотрудников
+func isFinalOrderStatus(status string) bool {
+	switch status {
+	case OrderStatusPaid, OrderStatusFailed, OrderStatusCancelled, OrderStatusRefunded:
+		return true
+	}
+	return false
+}
+
+func grpcLocale(locale string) string {
+	if i18n.IsSupported(locale) {
+		return locale
+	}
+	return i18n.DefaultLocale
+}
+
+func isAbsoluteURL(value string) bool {
+	u, err := url.Parse(value)
+	return err == nil && u.IsAbs() && u.Host != ""
+}
+
+// Рубли в копейки
+func toMinor(amount float64) int64 {
+	return int64(math.Round(amount * 100))
+}
+
+func timestampOrNil(t time.Time) *timestamppb.Timestamp {
+	if t.IsZero() {
+		return nil
+	}
+	return timestamppb.New(t)
+}
+
+func couponToProto(coupon *Coupon) *paymentv1.Coupon {
+	pb := &paymentv1.Coupon{
+		Id:          coupon.ID,
+		Name:        coupon.Name,
+		Description: coupon.Description,
+		Price:       coupon.Price,
+		Currency:    coupon.Currency,
+		ValidDays:   int32(coupon.ValidDays),
+		Tags:        coupon.Tags,
+		ImageUrl:    coupon.ImageURL,
+	}
+	if coupon.Category != nil {
+		pb.Category = coupon.Category.Slug
+	}
+	return pb
+}
+
+func orderItemToProto(item *OrderItem) *paymentv1.OrderItem {
+	return &paymentv1.OrderItem{
+		Id:               item.ID,
+		CouponId:         item.CouponID,
+		Name:             item.Name,
+		Quantity:         int32(item.Quantity),
+		UnitPrice:        item.UnitPrice,
+		DiscountAmount:   item.DiscountAmount,
+		Amount:           item.Amount,
+		RefundedQuantity: int32(item.RefundedQuantity),
+		RefundedAmount:   item.RefundedAmount,
+	}
+}
+
+func orderToProto(order *Order) *paymentv1.Order {
+	pb := &paymentv1.Order{
+		Id:             order.ID,
+		OrderNumber:    order.OrderNumber,
+		UserId:         order.UserID,
+		Status:         order.Status,
+		Amount:         order.Amount,
+		DiscountAmount: order.DiscountAmount,
+		RefundedAmount: order.RefundedAmount,
+		Currency:       order.Currency,
+		Description:    order.Description,
+		CreatedAt:      timestampOrNil(order.CreatedAt),
+	}
+	for _, item := range order.Items {
+		pb.Items = append(pb.Items, orderItemToProto(item))
+	}
+	return pb
+}
+
+func userCouponToProto(uc *UserCoupon) *paymentv1.UserCoupon {
+	pb := &paymentv1.UserCoupon{
+		Id:          uc.ID,
+		CouponId:    uc.CouponID,
+		OrderId:     uc.OrderID,
+		Code:        uc.Code,
+		IsUsed:      uc.IsUsed,
+		ActivatedAt: timestampOrNil(uc.ActivatedAt),
+		ExpiresAt:   timestampOrNil(uc.ExpiresAt),
+		UsedAt:      timestampOrNil(uc.UsedAt),
+		RefundedAt:  timestampOrNil(uc.RefundedAt),
+	}
+	if uc.Coupon != nil {
+		pb.CouponName = uc.Coupon.Name
+	}
+	return pb
+}
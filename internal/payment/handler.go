@@ -1,14 +1,41 @@
 package payment
 
 import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	htmlpkg "html"
 	"log"
 	"strconv"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/keyauth"
+	"github.com/gofiber/fiber/v2/middleware/limiter"
+	"github.com/skr1ms/PaymentAlphaBank.git/internal/promo"
+	"github.com/skr1ms/PaymentAlphaBank.git/pkg/auth"
+	"github.com/skr1ms/PaymentAlphaBank.git/pkg/httpapi"
+	"github.com/skr1ms/PaymentAlphaBank.git/pkg/i18n"
+	"github.com/skr1ms/PaymentAlphaBank.git/pkg/openapi"
+)
+
+// Ограничение неудачных проверок кода купона с одного IP
+const (
+	codeLookupMaxFailures = 10
+	codeLookupWindow      = 15 * time.Minute
 )
 
 type PaymentHandlerDeps struct {
-	CouponService *CouponService
+	CouponService   *CouponService
+	CatalogService  *CatalogService
+	CartService     *CartService
+	MerchantService *MerchantService
+	GiftService     *GiftService
+	Verifier        *auth.Verifier
+	Guard           *auth.Guard
+	OpenAPI         *openapi.Spec
 }
 
 type PaymentHandler struct {
@@ -23,12 +50,61 @@ func NewPaymentHandler(router fiber.Router, deps *PaymentHandlerDeps) {
 		deps:   deps,
 	}
 
+	// Язык ответа: ?lang или Accept-Language
+	router.Use(i18n.Middleware)
+
+	// Проверка JWT; к данным пользователя из пути допускаются он сам и администратор
+	authn := deps.Verifier.Middleware()
+	self := auth.RequireUser("userID")
+
+	// Служебные маршруты: API-ключ или JWT с ролью, дающей нужную область доступа
+	scope := deps.Guard.RequireScope
+
+	// Тела запросов проверяются по схемам из документа OpenAPI
+	describeRoutes(deps.OpenAPI)
+	validate := deps.OpenAPI.ValidateBody
+
 	// API маршруты
 	router.Get("/coupons", handler.GetCoupons)
-	router.Post("/orders", handler.CreateOrder)
-	router.Get("/orders/{orderNumber}/status", handler.GetOrderStatus)
-	router.Get("/users/{userID}/coupons", handler.GetUserCoupons)
-	router.Get("/users/{userID}/orders", handler.GetUserOrders)
+	router.Get("/categories", handler.GetCategories)
+	router.Post("/coupons/:couponID/image", scope(auth.ScopeCouponsWrite), handler.UploadCouponImage)
+	router.Put("/coupons/:couponID/translations/:locale", scope(auth.ScopeCouponsWrite), validate(CouponTranslationRequest{}), handler.SaveCouponTranslation)
+	router.Put("/coupons/:couponID/merchant", scope(auth.ScopeCouponsWrite), validate(CouponMerchantRequest{}), handler.SetCouponMerchant)
+	router.Post("/orders", authn, validate(CreateOrderRequest{}), handler.CreateOrder)
+	router.Post("/promo-codes/check", authn, validate(promo.CheckPromoCodeRequest{}), handler.CheckPromoCode)
+	router.Get("/orders/:orderNumber/status", authn, handler.GetOrderStatus)
+	router.Get("/orders/:orderNumber/events", auth.TokenFromQuery("access_token"), authn, handler.OrderEvents)
+	router.Post("/orders/:orderNumber/refunds", scope(auth.ScopeRefundsWrite), validate(RefundRequest{}), handler.RefundOrder)
+	router.Post("/admin/orders/:orderNumber/cancel", scope(auth.ScopeOrdersWrite), handler.CancelOrder)
+	router.Get("/admin/orders", scope(auth.ScopeOrdersRead), handler.SearchOrders)
+	router.Get("/admin/orders/:orderNumber/payment-details", scope(auth.ScopeOrdersRead), handler.GetPaymentDetails)
+	router.Get("/admin/orders/:orderNumber/timeline", scope(auth.ScopeOrdersRead), handler.GetOrderTimeline)
+	router.Get("/admin/merchants", scope(auth.ScopeMerchantsRead), handler.ListMerchants)
+	router.Post("/admin/merchants", scope(auth.ScopeMerchantsWrite), validate(MerchantRequest{}), handler.CreateMerchant)
+	router.Put("/admin/merchants/:merchantID", scope(auth.ScopeMerchantsWrite), validate(MerchantRequest{}), handler.UpdateMerchant)
+	router.Get("/users/:userID/coupons", authn, self, handler.GetUserCoupons)
+	router.Get("/users/:userID/orders", authn, self, handler.GetUserOrders)
+	router.Post("/users/:userID/orders/:orderNumber/cancel", authn, self, handler.CancelOrder)
+	router.Get("/users/:userID/events", auth.TokenFromQuery("access_token"), authn, self, handler.UserOrderEvents)
+	router.Get("/users/:userID/coupons/:userCouponID/barcode", authn, self, handler.GetUserCouponBarcode)
+	router.Get("/coupons/code/:code", newCodeLookupLimiter(), handler.GetCouponByCode)
+
+	// Корзина
+	router.Get("/users/:userID/cart", authn, self, handler.GetCart)
+	router.Put("/users/:userID/cart/items/:couponID", authn, self, validate(CartItemRequest{}), handler.SetCartItem)
+	router.Delete("/users/:userID/cart/items/:couponID", authn, self, handler.RemoveCartItem)
+	router.Delete("/users/:userID/cart", authn, self, handler.ClearCart)
+	router.Post("/users/:userID/cart/checkout", authn, self, validate(CheckoutRequest{}), handler.Checkout)
+
+	// Подарки
+	router.Post("/users/:userID/coupons/:userCouponID/gifts", authn, self, validate(CreateGiftRequest{}), handler.CreateGift)
+	router.Delete("/users/:userID/gifts/:giftID", authn, self, handler.CancelGift)
+	router.Get("/gifts/:token", handler.GetGift)
+	router.Post("/gifts/:token/accept", authn, validate(AcceptGiftRequest{}), handler.AcceptGift)
+
+	// Маршруты партнеров
+	merchant := router.Group("/merchant", handler.merchantAuth())
+	merchant.Post("/redemptions", validate(RedeemCouponRequest{}), handler.RedeemCoupon)
 
 	// Платежные маршруты
 	router.Get("/payment/return", handler.PaymentReturn)
@@ -44,71 +120,587 @@ func NewPaymentHandler(router fiber.Router, deps *PaymentHandlerDeps) {
 }
 
 func (h *PaymentHandler) GetCoupons(c *fiber.Ctx) error {
-	coupons, err := h.deps.CouponService.GetCoupons(c.Context())
+	filter, err := parseCouponFilter(c)
+	if err != nil {
+		return httpapi.Respond(c, fiber.StatusBadRequest, httpapi.CodeBadRequest, "Неверные параметры фильтрации")
+	}
+
+	coupons, nextCursor, err := h.deps.CatalogService.GetCoupons(c.Context(), filter)
 	if err != nil {
+		if errors.Is(err, ErrInvalidCatalogFilter) {
+			return httpapi.Respond(c, fiber.StatusBadRequest, httpapi.CodeBadRequest, "Неверные параметры фильтрации")
+		}
 		log.Printf("Ошибка получения купонов: %v", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Ошибка получения купонов",
-		})
+		return httpapi.Respond(c, fiber.StatusInternalServerError, httpapi.CodeInternal, "Ошибка получения купонов")
+	}
+
+	// Тело остается массивом купонов, курсор следующей страницы — в заголовке
+	if nextCursor != "" {
+		c.Set("X-Next-Cursor", nextCursor)
 	}
 
 	return c.JSON(coupons)
 }
 
+// Разбор параметров каталога: q, category, tag, min_price, max_price, sort, cursor, limit
+func parseCouponFilter(c *fiber.Ctx) (*CouponFilter, error) {
+	filter := &CouponFilter{
+		Query:    c.Query("q"),
+		Category: c.Query("category"),
+		Tag:      c.Query("tag"),
+		Sort:     c.Query("sort"),
+		Locale:   i18n.Locale(c),
+	}
+
+	for param, target := range map[string]**float64{
+		"min_price": &filter.MinPrice,
+		"max_price": &filter.MaxPrice,
+	} {
+		if value := c.Query(param); value != "" {
+			price, err := strconv.ParseFloat(value, 64)
+			if err != nil || price < 0 {
+				return nil, ErrInvalidCatalogFilter
+			}
+			*target = &price
+		}
+	}
+
+	if value := c.Query("merchant_id"); value != "" {
+		merchantID, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || merchantID <= 0 {
+			return nil, ErrInvalidCatalogFilter
+		}
+		filter.MerchantID = merchantID
+	}
+
+	if value := c.Query("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			return nil, ErrInvalidCatalogFilter
+		}
+		filter.Limit = limit
+	}
+
+	if value := c.Query("cursor"); value != "" {
+		cursor, err := ParseCatalogCursor(value)
+		if err != nil {
+			return nil, err
+		}
+		filter.Cursor = cursor
+	}
+
+	return filter, nil
+}
+
+func (h *PaymentHandler) SaveCouponTranslation(c *fiber.Ctx) error {
+	couponID, err := c.ParamsInt("couponID")
+	if err != nil || couponID <= 0 {
+		return httpapi.Respond(c, fiber.StatusBadRequest, httpapi.CodeBadRequest, "Неверный ID купона")
+	}
+
+	var req CouponTranslationRequest
+	if err := c.BodyParser(&req); err != nil {
+		return httpapi.Respond(c, fiber.StatusBadRequest, httpapi.CodeBadRequest, "Неверный формат запроса")
+	}
+
+	translation, err := h.deps.CatalogService.SaveTranslation(c.Context(), int64(couponID), c.Params("locale"), &req)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrCouponNotFound):
+			return httpapi.Respond(c, fiber.StatusNotFound, httpapi.CodeCouponNotFound, "Купон не найден")
+		case errors.Is(err, ErrUnsupportedLocale), errors.Is(err, ErrTranslationNameRequired):
+			return httpapi.Respond(c, fiber.StatusBadRequest, httpapi.CodeBadRequest, err.Error())
+		}
+		log.Printf("Ошибка сохранения перевода купона: %v", err)
+		return httpapi.Respond(c, fiber.StatusInternalServerError, httpapi.CodeInternal, "Ошибка сохранения перевода")
+	}
+
+	return c.JSON(translation)
+}
+
+func (h *PaymentHandler) SetCouponMerchant(c *fiber.Ctx) error {
+	couponID, err := c.ParamsInt("couponID")
+	if err != nil || couponID <= 0 {
+		return httpapi.Respond(c, fiber.StatusBadRequest, httpapi.CodeBadRequest, "Неверный ID купона")
+	}
+
+	var req CouponMerchantRequest
+	if err := c.BodyParser(&req); err != nil {
+		return httpapi.Respond(c, fiber.StatusBadRequest, httpapi.CodeBadRequest, "Неверный формат запроса")
+	}
+
+	if req.MerchantID != 0 {
+		merchant, err := h.deps.MerchantService.GetMerchant(c.Context(), req.MerchantID)
+		if err != nil {
+			log.Printf("Ошибка получения партнера: %v", err)
+			return httpapi.Respond(c, fiber.StatusInternalServerError, httpapi.CodeInternal, "Ошибка изменения купона")
+		}
+		if merchant == nil {
+			return httpapi.Respond(c, fiber.StatusNotFound, httpapi.CodeNotFound, "Партнер не найден")
+		}
+	}
+
+	coupon, err := h.deps.CatalogService.SetCouponMerchant(c.Context(), int64(couponID), req.MerchantID)
+	if err != nil {
+		if errors.Is(err, ErrCouponNotFound) {
+			return httpapi.Respond(c, fiber.StatusNotFound, httpapi.CodeCouponNotFound, "Купон не найден")
+		}
+		log.Printf("Ошибка привязки купона к партнеру: %v", err)
+		return httpapi.Respond(c, fiber.StatusInternalServerError, httpapi.CodeInternal, "Ошибка изменения купона")
+	}
+
+	return c.JSON(coupon)
+}
+
+func (h *PaymentHandler) GetCategories(c *fiber.Ctx) error {
+	categories, err := h.deps.CatalogService.GetCategories(c.Context())
+	if err != nil {
+		log.Printf("Ошибка получения категорий: %v", err)
+		return httpapi.Respond(c, fiber.StatusInternalServerError, httpapi.CodeInternal, "Ошибка получения категорий")
+	}
+
+	return c.JSON(categories)
+}
+
+func (h *PaymentHandler) UploadCouponImage(c *fiber.Ctx) error {
+	couponID, err := c.ParamsInt("couponID")
+	if err != nil || couponID <= 0 {
+		return httpapi.Respond(c, fiber.StatusBadRequest, httpapi.CodeBadRequest, "Неверный ID купона")
+	}
+
+	fileHeader, err := c.FormFile("image")
+	if err != nil {
+		return httpapi.Respond(c, fiber.StatusBadRequest, httpapi.CodeBadRequest, "Не передано изображение")
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		log.Printf("Ошибка открытия загруженного файла: %v", err)
+		return httpapi.Respond(c, fiber.StatusInternalServerError, httpapi.CodeInternal, "Ошибка загрузки изображения")
+	}
+	defer file.Close()
+
+	coupon, err := h.deps.CatalogService.UploadCouponImage(c.Context(), int64(couponID), file)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrCouponNotFound):
+			return httpapi.Respond(c, fiber.StatusNotFound, httpapi.CodeCouponNotFound, "Купон не найден")
+		case errors.Is(err, ErrUnsupportedImageType), errors.Is(err, ErrImageTooLarge):
+			return httpapi.Respond(c, fiber.StatusBadRequest, httpapi.CodeBadRequest, err.Error())
+		}
+		log.Printf("Ошибка загрузки изображения купона: %v", err)
+		return httpapi.Respond(c, fiber.StatusInternalServerError, httpapi.CodeInternal, "Ошибка загрузки изображения")
+	}
+
+	return c.JSON(coupon)
+}
+
 func (h *PaymentHandler) CreateOrder(c *fiber.Ctx) error {
 	var req CreateOrderRequest
 	if err := c.BodyParser(&req); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Неверный формат запроса",
-		})
+		return httpapi.Respond(c, fiber.StatusBadRequest, httpapi.CodeBadRequest, "Неверный формат запроса")
+	}
+
+	// Заказ оформляется на владельца токена; администратор может указать другого пользователя
+	userID, ok := auth.ActingUserID(c, req.UserID)
+	if !ok {
+		return httpapi.Respond(c, fiber.StatusForbidden, httpapi.CodeForbidden, "Недостаточно прав")
+	}
+	req.UserID = userID
+
+	if req.Language == "" {
+		req.Language = i18n.Locale(c)
+	}
+
+	response, err := h.deps.CouponService.CreateOrder(changeContext(c, ChangeSourceAPI), &req)
+	if err != nil {
+		return orderError(c, err)
+	}
+
+	response.Message = i18n.T(c, response.Message)
+	return c.JSON(response)
+}
+
+func orderError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, ErrInvalidOrderItems), errors.Is(err, ErrCartEmpty):
+		return httpapi.Respond(c, fiber.StatusBadRequest, httpapi.CodeBadRequest, err.Error())
+	case errors.Is(err, ErrReturnURLRequired):
+		return httpapi.Respond(c, fiber.StatusBadRequest, httpapi.CodeBadRequest, "Не указан адрес возврата")
+	case errors.Is(err, ErrCouponNotFound):
+		return httpapi.Respond(c, fiber.StatusNotFound, httpapi.CodeCouponNotFound, "Купон не найден")
+	case errors.Is(err, promo.ErrInvalidPromoCode):
+		return httpapi.Respond(c, fiber.StatusUnprocessableEntity, httpapi.CodeUnprocessable, err.Error())
+	}
+
+	log.Printf("Ошибка создания заказа: %v", err)
+	return httpapi.Respond(c, fiber.StatusInternalServerError, httpapi.CodeInternal, "Ошибка создания заказа")
+}
+
+func (h *PaymentHandler) SearchOrders(c *fiber.Ctx) error {
+	var filter OrderSearchRequest
+	if err := c.QueryParser(&filter); err != nil {
+		return httpapi.Respond(c, fiber.StatusBadRequest, httpapi.CodeBadRequest, "Неверные параметры поиска заказов")
+	}
+
+	orders, err := h.deps.CouponService.SearchOrders(c.Context(), &filter)
+	if err != nil {
+		if errors.Is(err, ErrInvalidOrderSearch) {
+			return httpapi.Respond(c, fiber.StatusBadRequest, httpapi.CodeBadRequest, "Неверные параметры поиска заказов")
+		}
+		log.Printf("Ошибка поиска заказов: %v", err)
+		return httpapi.Respond(c, fiber.StatusInternalServerError, httpapi.CodeInternal, "Ошибка получения заказов")
+	}
+
+	return c.JSON(orders)
+}
+
+func (h *PaymentHandler) GetPaymentDetails(c *fiber.Ctx) error {
+	details, err := h.deps.CouponService.GetPaymentDetails(c.Context(), c.Params("orderNumber"))
+	if err != nil {
+		if errors.Is(err, ErrOrderNotFound) {
+			return httpapi.Respond(c, fiber.StatusNotFound, httpapi.CodeOrderNotFound, "Заказ не найден")
+		}
+		log.Printf("Ошибка получения данных оплаты: %v", err)
+		return httpapi.Respond(c, fiber.StatusInternalServerError, httpapi.CodeInternal, "Ошибка получения данных оплаты")
+	}
+	if details == nil {
+		return httpapi.Respond(c, fiber.StatusNotFound, httpapi.CodeNotFound, "Данные оплаты не найдены")
+	}
+
+	return c.JSON(details)
+}
+
+func (h *PaymentHandler) GetOrderTimeline(c *fiber.Ctx) error {
+	timeline, err := h.deps.CouponService.GetOrderTimeline(c.Context(), c.Params("orderNumber"))
+	if err != nil {
+		if errors.Is(err, ErrOrderNotFound) {
+			return httpapi.Respond(c, fiber.StatusNotFound, httpapi.CodeOrderNotFound, "Заказ не найден")
+		}
+		log.Printf("Ошибка получения журнала заказа: %v", err)
+		return httpapi.Respond(c, fiber.StatusInternalServerError, httpapi.CodeInternal, "Ошибка получения журнала заказа")
+	}
+
+	return c.JSON(timeline)
+}
+
+func (h *PaymentHandler) RefundOrder(c *fiber.Ctx) error {
+	var req RefundRequest
+	if err := c.BodyParser(&req); err != nil {
+		return httpapi.Respond(c, fiber.StatusBadRequest, httpapi.CodeBadRequest, "Неверный формат запроса")
+	}
+
+	response, err := h.deps.CouponService.RefundOrder(changeContext(c, ChangeSourceAdmin), c.Params("orderNumber"), &req)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrOrderNotFound):
+			return httpapi.Respond(c, fiber.StatusNotFound, httpapi.CodeOrderNotFound, "Заказ не найден")
+		case errors.Is(err, ErrInvalidRefundItems):
+			return httpapi.Respond(c, fiber.StatusBadRequest, httpapi.CodeBadRequest, err.Error())
+		case errors.Is(err, ErrOrderNotRefundable), errors.Is(err, ErrRefundUnavailable):
+			return httpapi.Respond(c, fiber.StatusConflict, httpapi.CodeConflict, err.Error())
+		case errors.Is(err, ErrRefundRejected):
+			log.Printf("Ошибка возврата по заказу: %v", err)
+			return httpapi.Respond(c, fiber.StatusBadGateway, httpapi.CodeBadGateway, "Банк отклонил возврат")
+		case errors.Is(err, ErrRefundUnconfirmed):
+			log.Printf("Ошибка возврата по заказу: %v", err)
+			return httpapi.Respond(c, fiber.StatusBadGateway, httpapi.CodeBadGateway, ErrRefundUnconfirmed.Error())
+		}
+		log.Printf("Ошибка возврата по заказу: %v", err)
+		return httpapi.Respond(c, fiber.StatusInternalServerError, httpapi.CodeInternal, "Ошибка возврата")
 	}
 
-	response, err := h.deps.CouponService.CreateOrder(c.Context(), &req)
+	return c.JSON(response)
+}
+
+// Отмена заказа пользователем по своему маршруту или сотрудником по
+// административному; на маршруте пользователя отменяются только его заказы
+func (h *PaymentHandler) CancelOrder(c *fiber.Ctx) error {
+	userID := c.Params("userID")
+	ctx := changeContext(c, ChangeSourceAPI)
+	if userID == "" {
+		ctx = changeContext(c, ChangeSourceAdmin)
+	}
+
+	response, err := h.deps.CouponService.CancelOrder(ctx, c.Params("orderNumber"), userID, i18n.Locale(c))
 	if err != nil {
-		log.Printf("Ошибка создания заказа: %v", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Ошибка создания заказа",
-		})
+		switch {
+		case errors.Is(err, ErrOrderNotFound):
+			return httpapi.Respond(c, fiber.StatusNotFound, httpapi.CodeOrderNotFound, "Заказ не найден")
+		case errors.Is(err, ErrOrderNotCancellable), errors.Is(err, ErrOrderAlreadyPaid), errors.Is(err, ErrPaymentInProgress):
+			return httpapi.Respond(c, fiber.StatusConflict, httpapi.CodeConflict, err.Error())
+		case errors.Is(err, ErrCancelRejected):
+			log.Printf("Ошибка отмены заказа: %v", err)
+			return httpapi.Respond(c, fiber.StatusBadGateway, httpapi.CodeBadGateway, "Банк отклонил отмену заказа")
+		}
+		log.Printf("Ошибка отмены заказа: %v", err)
+		return httpapi.Respond(c, fiber.StatusInternalServerError, httpapi.CodeInternal, "Ошибка отмены заказа")
 	}
 
 	return c.JSON(response)
 }
 
+func (h *PaymentHandler) GetCart(c *fiber.Ctx) error {
+	cart, err := h.deps.CartService.GetCart(c.Context(), c.Params("userID"), i18n.Locale(c))
+	if err != nil {
+		log.Printf("Ошибка получения корзины: %v", err)
+		return httpapi.Respond(c, fiber.StatusInternalServerError, httpapi.CodeInternal, "Ошибка получения корзины")
+	}
+
+	return c.JSON(cart)
+}
+
+func (h *PaymentHandler) SetCartItem(c *fiber.Ctx) error {
+	couponID, err := c.ParamsInt("couponID")
+	if err != nil || couponID <= 0 {
+		return httpapi.Respond(c, fiber.StatusBadRequest, httpapi.CodeBadRequest, "Неверный ID купона")
+	}
+
+	var req CartItemRequest
+	if err := c.BodyParser(&req); err != nil {
+		return httpapi.Respond(c, fiber.StatusBadRequest, httpapi.CodeBadRequest, "Неверный формат запроса")
+	}
+
+	cart, err := h.deps.CartService.SetItem(c.Context(), c.Params("userID"), int64(couponID), req.Quantity, i18n.Locale(c))
+	if err != nil {
+		return cartError(c, err)
+	}
+
+	return c.JSON(cart)
+}
+
+func (h *PaymentHandler) RemoveCartItem(c *fiber.Ctx) error {
+	couponID, err := c.ParamsInt("couponID")
+	if err != nil || couponID <= 0 {
+		return httpapi.Respond(c, fiber.StatusBadRequest, httpapi.CodeBadRequest, "Неверный ID купона")
+	}
+
+	cart, err := h.deps.CartService.RemoveItem(c.Context(), c.Params("userID"), int64(couponID), i18n.Locale(c))
+	if err != nil {
+		return cartError(c, err)
+	}
+
+	return c.JSON(cart)
+}
+
+func (h *PaymentHandler) ClearCart(c *fiber.Ctx) error {
+	if err := h.deps.CartService.Clear(c.Context(), c.Params("userID")); err != nil {
+		return cartError(c, err)
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func (h *PaymentHandler) Checkout(c *fiber.Ctx) error {
+	var req CheckoutRequest
+	if err := c.BodyParser(&req); err != nil {
+		return httpapi.Respond(c, fiber.StatusBadRequest, httpapi.CodeBadRequest, "Неверный формат запроса")
+	}
+
+	if req.Language == "" {
+		req.Language = i18n.Locale(c)
+	}
+
+	response, err := h.deps.CartService.Checkout(changeContext(c, ChangeSourceAPI), c.Params("userID"), &req)
+	if err != nil {
+		return orderError(c, err)
+	}
+
+	response.Message = i18n.T(c, response.Message)
+	return c.JSON(response)
+}
+
+func cartError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, ErrCouponNotFound):
+		return httpapi.Respond(c, fiber.StatusNotFound, httpapi.CodeCouponNotFound, "Купон не найден")
+	case errors.Is(err, ErrInvalidCartQuantity), errors.Is(err, ErrCartFull):
+		return httpapi.Respond(c, fiber.StatusBadRequest, httpapi.CodeBadRequest, err.Error())
+	}
+
+	log.Printf("Ошибка изменения корзины: %v", err)
+	return httpapi.Respond(c, fiber.StatusInternalServerError, httpapi.CodeInternal, "Ошибка изменения корзины")
+}
+
+func (h *PaymentHandler) CheckPromoCode(c *fiber.Ctx) error {
+	var req promo.CheckPromoCodeRequest
+	if err := c.BodyParser(&req); err != nil {
+		return httpapi.Respond(c, fiber.StatusBadRequest, httpapi.CodeBadRequest, "Неверный формат запроса")
+	}
+
+	userID, ok := auth.ActingUserID(c, req.UserID)
+	if !ok {
+		return httpapi.Respond(c, fiber.StatusForbidden, httpapi.CodeForbidden, "Недостаточно прав")
+	}
+	req.UserID = userID
+
+	quote, err := h.deps.CouponService.CheckPromoCode(c.Context(), &req)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrCouponNotFound):
+			return httpapi.Respond(c, fiber.StatusNotFound, httpapi.CodeCouponNotFound, "Купон не найден")
+		case errors.Is(err, promo.ErrInvalidPromoCode):
+			return httpapi.Respond(c, fiber.StatusUnprocessableEntity, httpapi.CodeUnprocessable, err.Error())
+		}
+		log.Printf("Ошибка проверки промокода: %v", err)
+		return httpapi.Respond(c, fiber.StatusInternalServerError, httpapi.CodeInternal, "Ошибка проверки промокода")
+	}
+
+	return c.JSON(quote)
+}
+
 func (h *PaymentHandler) GetOrderStatus(c *fiber.Ctx) error {
 	orderNumber := c.Params("orderNumber")
 
 	if orderNumber == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Не указан номер заказа",
-		})
+		return httpapi.Respond(c, fiber.StatusBadRequest, httpapi.CodeBadRequest, "Не указан номер заказа")
+	}
+
+	if err := h.authorizeOrder(c, orderNumber); err != nil {
+		if errors.Is(err, ErrOrderNotFound) {
+			return httpapi.Respond(c, fiber.StatusNotFound, httpapi.CodeOrderNotFound, "Заказ не найден")
+		}
+		log.Printf("Ошибка проверки статуса заказа: %v", err)
+		return httpapi.Respond(c, fiber.StatusInternalServerError, httpapi.CodeInternal, "Ошибка проверки статуса заказа")
 	}
 
-	response, err := h.deps.CouponService.CheckOrderStatus(c.Context(), orderNumber)
+	response, err := h.deps.CouponService.CheckOrderStatus(changeContext(c, ChangeSourceAPI), orderNumber, i18n.Locale(c))
 	if err != nil {
+		if errors.Is(err, ErrOrderNotFound) {
+			return httpapi.Respond(c, fiber.StatusNotFound, httpapi.CodeOrderNotFound, "Заказ не найден")
+		}
 		log.Printf("Ошибка проверки статуса заказа: %v", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Ошибка проверки статуса заказа",
-		})
+		return httpapi.Respond(c, fiber.StatusInternalServerError, httpapi.CodeInternal, "Ошибка проверки статуса заказа")
 	}
 
+	response.Message = i18n.T(c, response.Message)
 	return c.JSON(response)
 }
 
+// Заказ доступен владельцу и сотрудникам с доступом к заказам (администратор,
+// поддержка). Чужой заказ неотличим от несуществующего, чтобы по ответу
+// нельзя было перебирать номера
+func (h *PaymentHandler) authorizeOrder(c *fiber.Ctx, orderNumber string) error {
+	userID := auth.UserID(c)
+	if auth.HasScope(c, auth.ScopeOrdersRead) {
+		userID = ""
+	}
+	return h.deps.CouponService.CheckOrderAccess(c.Context(), orderNumber, userID)
+}
+
+// Поток SSE с изменениями заказа: сначала текущее состояние из базы,
+// затем события шины. Банк на каждого подписчика не опрашивается
+func (h *PaymentHandler) OrderEvents(c *fiber.Ctx) error {
+	orderNumber := c.Params("orderNumber")
+
+	if orderNumber == "" {
+		return httpapi.Respond(c, fiber.StatusBadRequest, httpapi.CodeBadRequest, "Не указан номер заказа")
+	}
+
+	if err := h.authorizeOrder(c, orderNumber); err != nil {
+		if errors.Is(err, ErrOrderNotFound) {
+			return httpapi.Respond(c, fiber.StatusNotFound, httpapi.CodeOrderNotFound, "Заказ не найден")
+		}
+		log.Printf("Ошибка получения заказа: %v", err)
+		return httpapi.Respond(c, fiber.StatusInternalServerError, httpapi.CodeInternal, "Ошибка проверки статуса заказа")
+	}
+
+	// Подписка до чтения снимка, чтобы не потерять изменение между ними
+	events, unsubscribe := h.deps.CouponService.SubscribeOrderEvents(OrderEventFilter{OrderNumber: orderNumber})
+
+	snapshot, err := h.deps.CouponService.OrderSnapshot(c.Context(), orderNumber)
+	if err != nil {
+		unsubscribe()
+		if errors.Is(err, ErrOrderNotFound) {
+			return httpapi.Respond(c, fiber.StatusNotFound, httpapi.CodeOrderNotFound, "Заказ не найден")
+		}
+		log.Printf("Ошибка получения заказа: %v", err)
+		return httpapi.Respond(c, fiber.StatusInternalServerError, httpapi.CodeInternal, "Ошибка проверки статуса заказа")
+	}
+
+	return streamOrderEvents(c, []OrderEvent{*snapshot}, events, unsubscribe)
+}
+
+// Поток SSE с изменениями всех заказов пользователя
+func (h *PaymentHandler) UserOrderEvents(c *fiber.Ctx) error {
+	userID := c.Params("userID")
+
+	if userID == "" {
+		return httpapi.Respond(c, fiber.StatusBadRequest, httpapi.CodeBadRequest, "Не указан ID пользователя")
+	}
+
+	events, unsubscribe := h.deps.CouponService.SubscribeOrderEvents(OrderEventFilter{UserID: userID})
+	return streamOrderEvents(c, nil, events, unsubscribe)
+}
+
+// Интервал комментариев-пингов, чтобы прокси не закрывали простаивающий поток
+const sseHeartbeatInterval = 15 * time.Second
+
+// Запись событий в формате text/event-stream до ошибки записи, то есть
+// до отключения клиента
+func streamOrderEvents(c *fiber.Ctx, initial []OrderEvent, events <-chan OrderEvent, unsubscribe func()) error {
+	c.Set(fiber.HeaderContentType, "text/event-stream")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	c.Set(fiber.HeaderConnection, "keep-alive")
+	c.Set("X-Accel-Buffering", "no")
+
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer unsubscribe()
+
+		heartbeat := time.NewTicker(sseHeartbeatInterval)
+		defer heartbeat.Stop()
+
+		for _, event := range initial {
+			if err := writeOrderEvent(w, event); err != nil {
+				return
+			}
+		}
+		// Пустой комментарий сразу отправляет заголовки, даже без снимка
+		if _, err := w.WriteString(": connected\n\n"); err != nil || w.Flush() != nil {
+			return
+		}
+
+		for {
+			select {
+			case event, ok := <-events:
+				if !ok {
+					return
+				}
+				if err := writeOrderEvent(w, event); err != nil {
+					return
+				}
+			case <-heartbeat.C:
+				if _, err := w.WriteString(": ping\n\n"); err != nil || w.Flush() != nil {
+					return
+				}
+			}
+		}
+	})
+	return nil
+}
+
+func writeOrderEvent(w *bufio.Writer, event OrderEvent) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event.Type, data); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
 func (h *PaymentHandler) GetUserCoupons(c *fiber.Ctx) error {
 	userID := c.Params("userID")
 
 	if userID == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Не указан ID пользователя",
-		})
+		return httpapi.Respond(c, fiber.StatusBadRequest, httpapi.CodeBadRequest, "Не указан ID пользователя")
 	}
 
-	coupons, err := h.deps.CouponService.GetUserCoupons(c.Context(), userID)
+	coupons, err := h.deps.CouponService.GetUserCoupons(c.Context(), userID, i18n.Locale(c))
 	if err != nil {
 		log.Printf("Ошибка получения купонов пользователя: %v", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Ошибка получения купонов",
-		})
+		return httpapi.Respond(c, fiber.StatusInternalServerError, httpapi.CodeInternal, "Ошибка получения купонов")
 	}
 
 	return c.JSON(coupons)
@@ -118,22 +710,312 @@ func (h *PaymentHandler) GetUserOrders(c *fiber.Ctx) error {
 	userID := c.Params("userID")
 
 	if userID == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Не указан ID пользователя",
-		})
+		return httpapi.Respond(c, fiber.StatusBadRequest, httpapi.CodeBadRequest, "Не указан ID пользователя")
 	}
 
-	orders, err := h.deps.CouponService.GetUserOrders(c.Context(), userID)
+	orders, err := h.deps.CouponService.GetUserOrders(c.Context(), userID, i18n.Locale(c))
 	if err != nil {
 		log.Printf("Ошибка получения заказов пользователя: %v", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Ошибка получения заказов",
-		})
+		return httpapi.Respond(c, fiber.StatusInternalServerError, httpapi.CodeInternal, "Ошибка получения заказов")
 	}
 
 	return c.JSON(orders)
 }
 
+func (h *PaymentHandler) GetCouponByCode(c *fiber.Ctx) error {
+	coupon, err := h.deps.CouponService.GetUserCouponByCode(c.Context(), c.Params("code"), i18n.Locale(c))
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrInvalidCouponCode):
+			return httpapi.Respond(c, fiber.StatusBadRequest, httpapi.CodeBadRequest, "Неверный код купона")
+		case errors.Is(err, ErrCouponCodeNotFound):
+			return httpapi.Respond(c, fiber.StatusNotFound, httpapi.CodeCouponNotFound, "Купон не найден")
+		}
+		log.Printf("Ошибка поиска купона по коду: %v", err)
+		return httpapi.Respond(c, fiber.StatusInternalServerError, httpapi.CodeInternal, "Ошибка поиска купона")
+	}
+
+	return c.JSON(coupon)
+}
+
+func (h *PaymentHandler) GetUserCouponBarcode(c *fiber.Ctx) error {
+	userID := c.Params("userID")
+	userCouponID, err := c.ParamsInt("userCouponID")
+	if err != nil || userCouponID <= 0 {
+		return httpapi.Respond(c, fiber.StatusBadRequest, httpapi.CodeBadRequest, "Неверный ID купона")
+	}
+
+	kind := c.Query("type", BarcodeKindQR)
+	format := c.Query("format", ImageFormatPNG)
+
+	image, contentType, err := h.deps.CouponService.GetUserCouponBarcode(c.Context(), userID, int64(userCouponID), kind, format)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrUnsupportedBarcode):
+			return httpapi.Respond(c, fiber.StatusBadRequest, httpapi.CodeBadRequest, "Неподдерживаемый вид или формат кода")
+		case errors.Is(err, ErrCouponCodeNotFound):
+			return httpapi.Respond(c, fiber.StatusNotFound, httpapi.CodeCouponNotFound, "Купон не найден")
+		}
+		log.Printf("Ошибка формирования кода купона: %v", err)
+		return httpapi.Respond(c, fiber.StatusInternalServerError, httpapi.CodeInternal, "Ошибка формирования кода купона")
+	}
+
+	c.Set(fiber.HeaderCacheControl, "private, no-store")
+	c.Set(fiber.HeaderContentType, contentType)
+	return c.Send(image)
+}
+
+func (h *PaymentHandler) CreateGift(c *fiber.Ctx) error {
+	userID := c.Params("userID")
+	userCouponID, err := c.ParamsInt("userCouponID")
+	if err != nil || userCouponID <= 0 {
+		return httpapi.Respond(c, fiber.StatusBadRequest, httpapi.CodeBadRequest, "Неверный ID купона")
+	}
+
+	var req CreateGiftRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return httpapi.Respond(c, fiber.StatusBadRequest, httpapi.CodeBadRequest, "Неверный формат запроса")
+		}
+	}
+
+	response, err := h.deps.GiftService.CreateGift(c.Context(), userID, int64(userCouponID), &req)
+	if err != nil {
+		return giftError(c, err, "Ошибка создания подарка")
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(response)
+}
+
+func (h *PaymentHandler) CancelGift(c *fiber.Ctx) error {
+	userID := c.Params("userID")
+	giftID, err := c.ParamsInt("giftID")
+	if err != nil || giftID <= 0 {
+		return httpapi.Respond(c, fiber.StatusBadRequest, httpapi.CodeBadRequest, "Неверный ID подарка")
+	}
+
+	if err := h.deps.GiftService.CancelGift(c.Context(), userID, int64(giftID)); err != nil {
+		return giftError(c, err, "Ошибка отмены подарка")
+	}
+
+	return c.JSON(fiber.Map{
+		"success": true,
+	})
+}
+
+func (h *PaymentHandler) GetGift(c *fiber.Ctx) error {
+	gift, err := h.deps.GiftService.GetGift(c.Context(), c.Params("token"))
+	if err != nil {
+		return giftError(c, err, "Ошибка получения подарка")
+	}
+
+	return c.JSON(gift)
+}
+
+func (h *PaymentHandler) AcceptGift(c *fiber.Ctx) error {
+	var req AcceptGiftRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return httpapi.Respond(c, fiber.StatusBadRequest, httpapi.CodeBadRequest, "Неверный формат запроса")
+		}
+	}
+
+	// Подарок принимает владелец токена
+	userID, ok := auth.ActingUserID(c, req.UserID)
+	if !ok {
+		return httpapi.Respond(c, fiber.StatusForbidden, httpapi.CodeForbidden, "Недостаточно прав")
+	}
+
+	userCoupon, err := h.deps.GiftService.AcceptGift(c.Context(), c.Params("token"), userID)
+	if err != nil {
+		return giftError(c, err, "Ошибка принятия подарка")
+	}
+
+	return c.JSON(userCoupon)
+}
+
+func giftError(c *fiber.Ctx, err error, message string) error {
+	switch {
+	case errors.Is(err, ErrGiftNotFound):
+		return httpapi.Respond(c, fiber.StatusNotFound, httpapi.CodeGiftNotFound, err.Error())
+	case errors.Is(err, ErrCouponCodeNotFound):
+		return httpapi.Respond(c, fiber.StatusNotFound, httpapi.CodeCouponNotFound, err.Error())
+	case errors.Is(err, ErrGiftWrongRecipient):
+		return httpapi.Respond(c, fiber.StatusForbidden, httpapi.CodeForbidden, err.Error())
+	case errors.Is(err, ErrGiftToSelf):
+		return httpapi.Respond(c, fiber.StatusBadRequest, httpapi.CodeBadRequest, err.Error())
+	case errors.Is(err, ErrGiftNotPending), errors.Is(err, ErrGiftExpired),
+		errors.Is(err, ErrGiftAlreadyPending), errors.Is(err, ErrCouponNotTransferable):
+		return httpapi.Respond(c, fiber.StatusConflict, httpapi.CodeConflict, err.Error())
+	}
+
+	log.Printf("%s: %v", message, err)
+	return httpapi.Respond(c, fiber.StatusInternalServerError, httpapi.CodeInternal, message)
+}
+
+func (h *PaymentHandler) RedeemCoupon(c *fiber.Ctx) error {
+	var req RedeemCouponRequest
+	if err := c.BodyParser(&req); err != nil {
+		return httpapi.Respond(c, fiber.StatusBadRequest, httpapi.CodeBadRequest, "Неверный формат запроса")
+	}
+
+	merchant := c.Locals(merchantLocalsKey).(*Merchant)
+	ctx := WithChangeSource(c.Context(), ChangeSourceAPI, MerchantActor(merchant.ID))
+	response, err := h.deps.CouponService.RedeemCoupon(ctx, merchant, &req)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrInvalidCouponCode):
+			return httpapi.Respond(c, fiber.StatusBadRequest, httpapi.CodeBadRequest, "Неверный код купона")
+		case errors.Is(err, ErrCouponOwnerMissing):
+			return httpapi.Respond(c, fiber.StatusBadRequest, httpapi.CodeBadRequest, "Не указан владелец купона")
+		case errors.Is(err, ErrInvalidCodeSignature):
+			return httpapi.Respond(c, fiber.StatusBadRequest, httpapi.CodeBadRequest, "Подпись кода купона недействительна")
+		case errors.Is(err, ErrCouponCodeNotFound):
+			return httpapi.Respond(c, fiber.StatusNotFound, httpapi.CodeCouponNotFound, "Купон не найден")
+		case errors.Is(err, ErrCouponNotOwned):
+			return httpapi.Respond(c, fiber.StatusForbidden, httpapi.CodeForbidden, "Купон принадлежит другому пользователю")
+		case errors.Is(err, ErrCouponNotPaid), errors.Is(err, ErrCouponExpired),
+			errors.Is(err, ErrCouponAlreadyUsed), errors.Is(err, ErrCouponRefunded):
+			return httpapi.Respond(c, fiber.StatusConflict, httpapi.CodeConflict, err.Error())
+		}
+		log.Printf("Ошибка погашения купона: %v", err)
+		return httpapi.Respond(c, fiber.StatusInternalServerError, httpapi.CodeInternal, "Ошибка погашения купона")
+	}
+
+	return c.JSON(response)
+}
+
+func (h *PaymentHandler) ListMerchants(c *fiber.Ctx) error {
+	merchants, err := h.deps.MerchantService.ListMerchants(c.Context())
+	if err != nil {
+		log.Printf("Ошибка получения партнеров: %v", err)
+		return httpapi.Respond(c, fiber.StatusInternalServerError, httpapi.CodeInternal, "Ошибка получения партнеров")
+	}
+
+	return c.JSON(merchants)
+}
+
+func (h *PaymentHandler) CreateMerchant(c *fiber.Ctx) error {
+	var req MerchantRequest
+	if err := c.BodyParser(&req); err != nil {
+		return httpapi.Respond(c, fiber.StatusBadRequest, httpapi.CodeBadRequest, "Неверный формат запроса")
+	}
+
+	merchant, apiKey, err := h.deps.MerchantService.CreateMerchant(c.Context(), &req)
+	if err != nil {
+		return merchantError(c, err, "Ошибка создания партнера")
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(CreateMerchantResponse{
+		Merchant: *merchant,
+		APIKey:   apiKey,
+	})
+}
+
+func (h *PaymentHandler) UpdateMerchant(c *fiber.Ctx) error {
+	merchantID, err := c.ParamsInt("merchantID")
+	if err != nil || merchantID <= 0 {
+		return httpapi.Respond(c, fiber.StatusBadRequest, httpapi.CodeBadRequest, "Неверный ID партнера")
+	}
+
+	var req MerchantRequest
+	if err := c.BodyParser(&req); err != nil {
+		return httpapi.Respond(c, fiber.StatusBadRequest, httpapi.CodeBadRequest, "Неверный формат запроса")
+	}
+
+	merchant, err := h.deps.MerchantService.UpdateMerchant(c.Context(), int64(merchantID), &req)
+	if err != nil {
+		return merchantError(c, err, "Ошибка изменения партнера")
+	}
+
+	return c.JSON(merchant)
+}
+
+func merchantError(c *fiber.Ctx, err error, message string) error {
+	switch {
+	case errors.Is(err, ErrInvalidMerchant):
+		return httpapi.Respond(c, fiber.StatusBadRequest, httpapi.CodeBadRequest, err.Error())
+	case errors.Is(err, ErrMerchantNotFound):
+		return httpapi.Respond(c, fiber.StatusNotFound, httpapi.CodeNotFound, "Партнер не найден")
+	}
+
+	log.Printf("%s: %v", message, err)
+	return httpapi.Respond(c, fiber.StatusInternalServerError, httpapi.CodeInternal, message)
+}
+
+// Контекст запроса с источником и исполнителем изменений для журнала заказа
+func changeContext(c *fiber.Ctx, source string) context.Context {
+	actor := PrincipalActor(auth.GetPrincipal(c))
+	if actor == "" && auth.UserID(c) != "" {
+		actor = "user:" + auth.UserID(c)
+	}
+	return WithChangeSource(c.Context(), source, actor)
+}
+
+const merchantLocalsKey = "merchant"
+
+// Аутентификация партнера по ключу из заголовка X-Merchant-Key или по
+// API-ключу с ролью merchant, привязанному к партнеру
+func (h *PaymentHandler) merchantAuth() fiber.Handler {
+	merchantKey := keyauth.New(keyauth.Config{
+		KeyLookup: "header:X-Merchant-Key",
+		Validator: func(c *fiber.Ctx, key string) (bool, error) {
+			merchant, err := h.deps.MerchantService.Authenticate(c.Context(), key)
+			if err != nil {
+				log.Printf("Ошибка аутентификации партнера: %v", err)
+				return false, err
+			}
+			if merchant == nil {
+				return false, keyauth.ErrMissingOrMalformedAPIKey
+			}
+			c.Locals(merchantLocalsKey, merchant)
+			return true, nil
+		},
+		ErrorHandler: func(c *fiber.Ctx, err error) error {
+			return httpapi.Respond(c, fiber.StatusUnauthorized, httpapi.CodeUnauthorized, "Неверный API-ключ партнера")
+		},
+	})
+
+	return func(c *fiber.Ctx) error {
+		if c.Get(auth.APIKeyHeader) == "" {
+			return merchantKey(c)
+		}
+
+		principal, err := h.deps.Guard.Authenticate(c)
+		if err != nil {
+			log.Printf("Ошибка аутентификации партнера: %v", err)
+			return httpapi.Respond(c, fiber.StatusInternalServerError, httpapi.CodeInternal, "Ошибка проверки доступа")
+		}
+		if principal == nil || principal.MerchantID == 0 || !principal.HasScope(auth.ScopeRedemptionsWrite) {
+			return httpapi.Respond(c, fiber.StatusUnauthorized, httpapi.CodeUnauthorized, "Неверный API-ключ партнера")
+		}
+
+		merchant, err := h.deps.MerchantService.GetMerchant(c.Context(), principal.MerchantID)
+		if err != nil {
+			log.Printf("Ошибка аутентификации партнера: %v", err)
+			return httpapi.Respond(c, fiber.StatusInternalServerError, httpapi.CodeInternal, "Ошибка проверки доступа")
+		}
+		if merchant == nil {
+			return httpapi.Respond(c, fiber.StatusUnauthorized, httpapi.CodeUnauthorized, "Неверный API-ключ партнера")
+		}
+
+		c.Locals(merchantLocalsKey, merchant)
+		return c.Next()
+	}
+}
+
+// Считаются только неудачные попытки, чтобы коды нельзя было перебрать
+func newCodeLookupLimiter() fiber.Handler {
+	return limiter.New(limiter.Config{
+		Max:                    codeLookupMaxFailures,
+		Expiration:             codeLookupWindow,
+		SkipSuccessfulRequests: true,
+		LimitReached: func(c *fiber.Ctx) error {
+			return httpapi.Respond(c, fiber.StatusTooManyRequests, httpapi.CodeTooManyRequests, "Слишком много попыток, попробуйте позже")
+		},
+	})
+}
+
 func (h *PaymentHandler) PaymentReturn(c *fiber.Ctx) error {
 	orderNumber := c.Query("orderNumber")
 	if orderNumber == "" {
@@ -143,60 +1025,86 @@ func (h *PaymentHandler) PaymentReturn(c *fiber.Ctx) error {
 			// Можно добавить логику поиска по alfaBankOrderId
 			log.Printf("Возврат с платежной страницы для orderId: %s", orderId)
 		}
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Не указан номер заказа",
-		})
+		return httpapi.Respond(c, fiber.StatusBadRequest, httpapi.CodeBadRequest, "Не указан номер заказа")
 	}
 
-	status, err := h.deps.CouponService.CheckOrderStatus(c.Context(), orderNumber)
+	status, err := h.deps.CouponService.CheckOrderStatus(changeContext(c, ChangeSourceReturnPage), orderNumber, i18n.Locale(c))
 	if err != nil {
+		if errors.Is(err, ErrOrderNotFound) {
+			return httpapi.Respond(c, fiber.StatusNotFound, httpapi.CodeOrderNotFound, "Заказ не найден")
+		}
 		log.Printf("Ошибка проверки статуса при возврате: %v", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Ошибка проверки статуса платежа",
-		})
+		return httpapi.Respond(c, fiber.StatusInternalServerError, httpapi.CodeInternal, "Ошибка проверки статуса платежа")
+	}
+
+	// Страница оформляется в стиле магазина, если он задал оформление
+	title, header, style := i18n.T(c, "Результат платежа"), "", ""
+	if status.MerchantID != 0 {
+		merchant, err := h.deps.MerchantService.GetMerchant(c.Context(), status.MerchantID)
+		if err != nil {
+			log.Printf("Ошибка получения оформления партнера: %v", err)
+		} else if merchant != nil {
+			branding := merchant.Branding
+			if branding.DisplayName != "" {
+				title = htmlpkg.EscapeString(branding.DisplayName) + " — " + title
+			}
+			if branding.LogoURL != "" {
+				header = `<img class="logo" src="` + htmlpkg.EscapeString(branding.LogoURL) + `" alt="` + htmlpkg.EscapeString(branding.DisplayName) + `">`
+			}
+			if brandColorPattern.MatchString(branding.PrimaryColor) {
+				style = "        h1, a { color: " + branding.PrimaryColor + "; }\n"
+			}
+		}
 	}
 
 	html := `
 <!DOCTYPE html>
-<html>
+<html lang="` + i18n.Locale(c) + `">
 <head>
     <meta charset="UTF-8">
-    <title>Результат платежа</title>
+    <title>` + title + `</title>
     <style>
         body { font-family: Arial, sans-serif; margin: 20px; text-align: center; }
+        .logo { max-height: 64px; }
         .success { color: green; }
         .error { color: red; }
         .pending { color: orange; }
-    </style>
+` + style + `    </style>
 </head>
 <body>
-    <h1>Результат платежа</h1>
+    ` + header + `
+    <h1>` + title + `</h1>
 `
 
 	switch status.Status {
 	case OrderStatusPaid:
+		// У заказа из корзины нет единственного купона
+		activated := i18n.T(c, "Купоны активированы в вашем аккаунте.")
+		if status.CouponName != "" {
+			activated = fmt.Sprintf(i18n.T(c, "Купон \"%s\" активирован в вашем аккаунте."), htmlpkg.EscapeString(status.CouponName))
+		}
 		html += `<div class="success">
-            <h2>✓ Платеж успешно завершен!</h2>
-            <p>Купон "` + status.CouponName + `" активирован в вашем аккаунте.</p>
+            <h2>✓ ` + i18n.T(c, "Платеж успешно завершен!") + `</h2>
+            <p>` + activated + `</p>
         </div>`
 	case OrderStatusFailed:
 		html += `<div class="error">
-            <h2>✗ Платеж отклонен</h2>
-            <p>Попробуйте еще раз или выберите другой способ оплаты.</p>
+            <h2>✗ ` + i18n.T(c, "Платеж отклонен") + `</h2>
+            <p>` + i18n.T(c, "Попробуйте еще раз или выберите другой способ оплаты.") + `</p>
         </div>`
 	case OrderStatusPending:
 		html += `<div class="pending">
-            <h2>⏳ Платеж обрабатывается</h2>
-            <p>Статус платежа будет обновлен в ближайшее время.</p>
+            <h2>⏳ ` + i18n.T(c, "Платеж обрабатывается") + `</h2>
+            <p>` + i18n.T(c, "Статус платежа будет обновлен в ближайшее время.") + `</p>
         </div>`
 	default:
 		html += `<div class="error">
-            <h2>? Неизвестный статус платежа</h2>
+            <h2>? ` + i18n.T(c, "Неизвестный статус платежа") + `</h2>
         </div>`
 	}
 
 	html += `
-    <p><a href="/">Вернуться на главную</a></p>
+    <p><a href="/">` + i18n.T(c, "Вернуться на главную") + `</a></p>
 </body>
 </html>`
 
@@ -211,12 +1119,14 @@ func (h *PaymentHandler) PaymentNotification(c *fiber.Ctx) error {
 	log.Printf("Получено уведомление о платеже: orderNumber=%s, orderId=%s", orderNumber, orderId)
 
 	if orderNumber != "" {
-		_, err := h.deps.CouponService.CheckOrderStatus(c.Context(), orderNumber)
+		ctx := WithChangeSource(c.Context(), ChangeSourceWebhook, ActorBank)
+		_, err := h.deps.CouponService.CheckOrderStatus(ctx, orderNumber, i18n.DefaultLocale)
 		if err != nil {
 			log.Printf("Ошибка обработки уведомления: %v", err)
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-				"error": "Error",
-			})
+			if errors.Is(err, ErrOrderNotFound) {
+				return httpapi.Respond(c, fiber.StatusNotFound, httpapi.CodeOrderNotFound, "Заказ не найден")
+			}
+			return httpapi.Respond(c, fiber.StatusInternalServerError, httpapi.CodeInternal, "Ошибка обработки уведомления")
 		}
 	}
 
@@ -255,7 +1165,7 @@ func (h *PaymentHandler) TestPage(c *fiber.Ctx) error {
 
     <script>
         function loadCoupons() {
-            fetch('/api/coupons')
+            fetch('/api/v1/coupons')
                 .then(response => response.json())
                 .then(data => {
                     const div = document.getElementById('coupons-result');
@@ -266,19 +1176,30 @@ func (h *PaymentHandler) TestPage(c *fiber.Ctx) error {
                 });
         }
 
+        // Токен тестовой среды для запросов от имени пользователя
+        function getToken(userId) {
+            return fetch('/api/v1/test/token?user_id=' + encodeURIComponent(userId))
+                .then(response => response.json())
+                .then(data => data.token);
+        }
+
         function createTestOrder() {
             const orderData = {
                 coupon_id: 1,
                 user_id: 'test_user_123',
-                return_url: window.location.origin + '/payment/return',
-                fail_url: window.location.origin + '/payment/return'
+                return_url: window.location.origin + '/api/v1/payment/return',
+                fail_url: window.location.origin + '/api/v1/payment/return'
             };
 
-            fetch('/api/orders', {
+            getToken(orderData.user_id)
+            .then(token => fetch('/api/v1/orders', {
                 method: 'POST',
-                headers: { 'Content-Type': 'application/json' },
+                headers: {
+                    'Content-Type': 'application/json',
+                    'Authorization': 'Bearer ' + token
+                },
                 body: JSON.stringify(orderData)
-            })
+            }))
             .then(response => response.json())
             .then(data => {
                 const div = document.getElementById('order-result');
@@ -340,30 +1261,24 @@ func (h *PaymentHandler) TestPayment(c *fiber.Ctx) error {
 
 	couponID, err := strconv.ParseInt(couponIDStr, 10, 64)
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
-			"error": "Неверный ID купона",
-		})
+		return httpapi.Respond(c, fiber.StatusBadRequest, httpapi.CodeBadRequest, "Неверный ID купона")
 	}
 
 	req := &CreateOrderRequest{
 		CouponID:  couponID,
 		UserID:    userID,
-		ReturnURL: "http://" + c.Hostname() + "/payment/return",
-		FailURL:   "http://" + c.Hostname() + "/payment/return",
+		ReturnURL: "http://" + c.Hostname() + "/api/v1/payment/return",
+		FailURL:   "http://" + c.Hostname() + "/api/v1/payment/return",
 	}
 
 	response, err := h.deps.CouponService.CreateOrder(c.Context(), req)
 	if err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": err.Error(),
-		})
+		return httpapi.Respond(c, fiber.StatusInternalServerError, httpapi.CodeInternal, err.Error())
 	}
 
 	if response.Success {
 		return c.Redirect(response.PaymentURL, fiber.StatusSeeOther)
 	} else {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": response.Message,
-		})
+		return httpapi.Respond(c, fiber.StatusInternalServerError, httpapi.CodeInternal, response.Message)
 	}
 }
@@ -0,0 +1,800 @@
+// Хранилища payment в памяти с той же семантикой, что и репозитории
+// на Postgres: уникальные номера заказов и коды купонов, сортировка по времени
+// создания, транзакции с откатом. Для тестов и запуска без базы
+package memstore
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/skr1ms/PaymentAlphaBank.git/internal/payment"
+	"github.com/skr1ms/PaymentAlphaBank.git/pkg/db"
+	"github.com/skr1ms/PaymentAlphaBank.git/pkg/i18n"
+	"github.com/skr1ms/PaymentAlphaBank.git/pkg/secret"
+)
+
+// Количество попыток сгенерировать уникальный код купона, как в Postgres
+const couponCodeAttempts = 5
+
+type translationKey struct {
+	couponID int64
+	locale   string
+}
+
+// Строки таблиц. Связи (Coupon, Items, Order) не хранятся и собираются при чтении
+type state struct {
+	lastID       int64
+	coupons      map[int64]payment.Coupon
+	translations map[translationKey]payment.CouponTranslation
+	orders       map[int64]payment.Order
+	orderNumbers map[string]int64
+	items        map[int64]payment.OrderItem
+	userCoupons  map[int64]payment.UserCoupon
+	codes        map[string]int64
+	refunds      map[int64]payment.Refund
+	changes      []payment.OrderChange // журнал только дописывается
+}
+
+func newState() *state {
+	return &state{
+		coupons:      make(map[int64]payment.Coupon),
+		translations: make(map[translationKey]payment.CouponTranslation),
+		orders:       make(map[int64]payment.Order),
+		orderNumbers: make(map[string]int64),
+		items:        make(map[int64]payment.OrderItem),
+		userCoupons:  make(map[int64]payment.UserCoupon),
+		codes:        make(map[string]int64),
+		refunds:      make(map[int64]payment.Refund),
+	}
+}
+
+// Копия для отката транзакции. Строки хранятся по значению, поэтому
+// достаточно скопировать карты; срезы тегов купонов не изменяются на месте
+func (st *state) clone() *state {
+	c := &state{
+		lastID:       st.lastID,
+		coupons:      make(map[int64]payment.Coupon, len(st.coupons)),
+		translations: make(map[translationKey]payment.CouponTranslation, len(st.translations)),
+		orders:       make(map[int64]payment.Order, len(st.orders)),
+		orderNumbers: make(map[string]int64, len(st.orderNumbers)),
+		items:        make(map[int64]payment.OrderItem, len(st.items)),
+		userCoupons:  make(map[int64]payment.UserCoupon, len(st.userCoupons)),
+		codes:        make(map[string]int64, len(st.codes)),
+		refunds:      make(map[int64]payment.Refund, len(st.refunds)),
+	}
+	for k, v := range st.coupons {
+		c.coupons[k] = v
+	}
+	for k, v := range st.translations {
+		c.translations[k] = v
+	}
+	for k, v := range st.orders {
+		c.orders[k] = v
+	}
+	for k, v := range st.orderNumbers {
+		c.orderNumbers[k] = v
+	}
+	for k, v := range st.items {
+		c.items[k] = v
+	}
+	for k, v := range st.userCoupons {
+		c.userCoupons[k] = v
+	}
+	for k, v := range st.codes {
+		c.codes[k] = v
+	}
+	for k, v := range st.refunds {
+		c.refunds[k] = v
+	}
+	c.changes = st.changes[:len(st.changes):len(st.changes)]
+	return c
+}
+
+// Общий ID для всех таблиц: уникальности в пределах таблицы достаточно
+func (st *state) nextID() int64 {
+	st.lastID++
+	return st.lastID
+}
+
+// Общее состояние хранилищ. Транзакции выполняются по одной: RunInTx держит
+// блокировку до конца fn, поэтому изоляция строже, чем в Postgres
+type Store struct {
+	mu     sync.Mutex
+	state  *state
+	serial atomic.Int64 // как последовательность Postgres: вне транзакций
+}
+
+func New() *Store {
+	return &Store{state: newState()}
+}
+
+type txKey struct{}
+
+// Блокировка на время операции; внутри транзакции этого же хранилища она уже взята
+func (s *Store) lock(ctx context.Context) func() {
+	if tx, ok := ctx.Value(txKey{}).(*Store); ok && tx == s {
+		return func() {}
+	}
+	s.mu.Lock()
+	return s.mu.Unlock
+}
+
+func (s *Store) RunInTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	if tx, ok := ctx.Value(txKey{}).(*Store); ok && tx == s {
+		return fn(ctx)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	snapshot := s.state.clone()
+	if err := fn(context.WithValue(ctx, txKey{}, s)); err != nil {
+		s.state = snapshot
+		return err
+	}
+	return nil
+}
+
+func (s *Store) Coupons() *CouponRepository {
+	return &CouponRepository{s: s}
+}
+
+func (s *Store) Orders() *OrderRepository {
+	return &OrderRepository{s: s}
+}
+
+func (s *Store) UserCoupons() *UserCouponRepository {
+	return &UserCouponRepository{s: s}
+}
+
+func (s *Store) OrderChanges() *OrderChangeRepository {
+	return &OrderChangeRepository{s: s}
+}
+
+var (
+	_ payment.TxRunner         = (*Store)(nil)
+	_ payment.CouponStore      = (*CouponRepository)(nil)
+	_ payment.OrderStore       = (*OrderRepository)(nil)
+	_ payment.UserCouponStore  = (*UserCouponRepository)(nil)
+	_ payment.OrderChangeStore = (*OrderChangeRepository)(nil)
+)
+
+type CouponRepository struct {
+	s *Store
+}
+
+// Добавление купона в каталог
+func (r *CouponRepository) Create(ctx context.Context, coupon *payment.Coupon) error {
+	defer r.s.lock(ctx)()
+	st := r.s.state
+
+	now := time.Now()
+	coupon.ID = st.nextID()
+	if coupon.Currency == "" {
+		coupon.Currency = "RUB"
+	}
+	coupon.CreatedAt = now
+	coupon.UpdatedAt = now
+
+	row := *coupon
+	row.Category = nil
+	row.Tags = append([]string(nil), coupon.Tags...)
+	st.coupons[row.ID] = row
+	return nil
+}
+
+func (r *CouponRepository) GetByID(ctx context.Context, id int64) (*payment.Coupon, error) {
+	defer r.s.lock(ctx)()
+
+	coupon, ok := r.s.state.coupons[id]
+	if !ok || !coupon.IsActive {
+		return nil, sql.ErrNoRows
+	}
+	return &coupon, nil
+}
+
+func (r *CouponRepository) SaveTranslation(ctx context.Context, translation *payment.CouponTranslation) error {
+	defer r.s.lock(ctx)()
+	st := r.s.state
+
+	key := translationKey{couponID: translation.CouponID, locale: translation.Locale}
+	translation.UpdatedAt = time.Now()
+	if existing, ok := st.translations[key]; ok {
+		translation.ID = existing.ID
+	} else {
+		translation.ID = st.nextID()
+	}
+	st.translations[key] = *translation
+	return nil
+}
+
+func (r *CouponRepository) Localize(ctx context.Context, locale string, coupons ...*payment.Coupon) error {
+	if locale == i18n.DefaultLocale {
+		return nil
+	}
+	defer r.s.lock(ctx)()
+
+	for _, coupon := range coupons {
+		if coupon == nil {
+			continue
+		}
+		if translation, ok := r.s.state.translations[translationKey{couponID: coupon.ID, locale: locale}]; ok {
+			coupon.Name = translation.Name
+			coupon.Description = translation.Description
+		}
+	}
+	return nil
+}
+
+type OrderRepository struct {
+	s *Store
+}
+
+func (r *OrderRepository) Create(ctx context.Context, order *payment.Order) error {
+	defer r.s.lock(ctx)()
+	st := r.s.state
+
+	if _, ok := st.orderNumbers[order.OrderNumber]; ok {
+		return db.UniqueViolation("orders_order_number_key")
+	}
+
+	order.CreatedAt = time.Now()
+	order.UpdatedAt = order.CreatedAt
+	order.ID = st.nextID()
+	if order.Status == "" {
+		order.Status = payment.OrderStatusCreated
+	}
+	if order.Currency == "" {
+		order.Currency = "RUB"
+	}
+
+	for _, item := range order.Items {
+		item.ID = st.nextID()
+		item.OrderID = order.ID
+		item.CreatedAt = order.CreatedAt
+		st.items[item.ID] = itemRow(item)
+	}
+	st.orders[order.ID] = orderRow(order)
+	st.orderNumbers[order.OrderNumber] = order.ID
+	return nil
+}
+
+func (r *OrderRepository) NextOrderSerial(ctx context.Context) (int64, error) {
+	return r.s.serial.Add(1), nil
+}
+
+func (r *OrderRepository) GetByOrderNumber(ctx context.Context, orderNumber string) (*payment.Order, error) {
+	defer r.s.lock(ctx)()
+	st := r.s.state
+
+	id, ok := st.orderNumbers[orderNumber]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	order := st.order(id, true)
+	return &order, nil
+}
+
+// Транзакции хранилища выполняются по одной, поэтому отдельная блокировка
+// строки не нужна
+func (r *OrderRepository) LockByOrderNumber(ctx context.Context, orderNumber string) (*payment.Order, error) {
+	return r.GetByOrderNumber(ctx, orderNumber)
+}
+
+func (r *OrderRepository) GetUserOrders(ctx context.Context, userID string) ([]payment.Order, error) {
+	defer r.s.lock(ctx)()
+	st := r.s.state
+
+	var orders []payment.Order
+	for _, id := range st.ordersNewestFirst() {
+		if st.orders[id].UserID == userID {
+			order := st.order(id, false)
+			orders = append(orders, order)
+		}
+	}
+	return orders, nil
+}
+
+func (r *OrderRepository) Search(ctx context.Context, filter *payment.OrderSearchRequest) ([]payment.Order, error) {
+	defer r.s.lock(ctx)()
+	st := r.s.state
+
+	var orders []payment.Order
+	skipped := 0
+	for _, id := range st.ordersNewestFirst() {
+		row := st.orders[id]
+		if filter.Status != "" && row.Status != filter.Status ||
+			filter.UserID != "" && row.UserID != filter.UserID ||
+			filter.OrderNumber != "" && row.OrderNumber != filter.OrderNumber ||
+			filter.AlfaBankOrderID != "" && row.AlfaBankOrderID != filter.AlfaBankOrderID ||
+			filter.MerchantID != 0 && row.MerchantID != filter.MerchantID {
+			continue
+		}
+		if skipped < filter.Offset {
+			skipped++
+			continue
+		}
+		if filter.Limit > 0 && len(orders) >= filter.Limit {
+			break
+		}
+
+		order := st.order(id, false)
+		order.Coupon = nil
+		orders = append(orders, order)
+	}
+	return orders, nil
+}
+
+func (r *OrderRepository) UpdateStatus(ctx context.Context, orderID int64, status string) error {
+	return r.update(ctx, orderID, func(order *payment.Order) { order.Status = status })
+}
+
+func (r *OrderRepository) UpdateAlfaBankOrderID(ctx context.Context, orderID int64, alfaBankOrderID string) error {
+	return r.update(ctx, orderID, func(order *payment.Order) { order.AlfaBankOrderID = alfaBankOrderID })
+}
+
+func (r *OrderRepository) UpdatePaymentDetails(ctx context.Context, orderID int64, paymentDetails string) error {
+	return r.update(ctx, orderID, func(order *payment.Order) { order.PaymentDetails = paymentDetails })
+}
+
+func (r *OrderRepository) UpdateDiscount(ctx context.Context, order *payment.Order) error {
+	defer r.s.lock(ctx)()
+	st := r.s.state
+
+	row, ok := st.orders[order.ID]
+	if !ok {
+		return nil
+	}
+	row.PromoCodeID = order.PromoCodeID
+	row.DiscountAmount = order.DiscountAmount
+	row.Amount = order.Amount
+	row.UpdatedAt = time.Now()
+	st.orders[order.ID] = row
+
+	for _, item := range order.Items {
+		if stored, ok := st.items[item.ID]; ok {
+			stored.DiscountAmount = item.DiscountAmount
+			stored.Amount = item.Amount
+			st.items[item.ID] = stored
+		}
+	}
+	return nil
+}
+
+func (r *OrderRepository) ListStalePaymentDetails(ctx context.Context, prefix string, afterID int64, limit int) ([]secret.Record, error) {
+	defer r.s.lock(ctx)()
+
+	var records []secret.Record
+	for _, id := range r.s.state.ordersByID() {
+		row := r.s.state.orders[id]
+		if id <= afterID || row.PaymentDetails == "" || strings.HasPrefix(row.PaymentDetails, prefix) {
+			continue
+		}
+		records = append(records, secret.Record{ID: id, Value: row.PaymentDetails})
+		if len(records) == limit {
+			break
+		}
+	}
+	return records, nil
+}
+
+func (r *OrderRepository) CreateRefund(
+	ctx context.Context,
+	orderID int64,
+	plan func(order *payment.Order, available map[int64][]int64) ([]payment.RefundLine, error),
+) (*payment.Refund, error) {
+	var refund *payment.Refund
+
+	err := r.s.RunInTx(ctx, func(ctx context.Context) error {
+		st := r.s.state
+		if _, ok := st.orders[orderID]; !ok {
+			return sql.ErrNoRows
+		}
+		order := st.order(orderID, false)
+		order.Coupon = nil
+		for _, item := range order.Items {
+			item.Coupon = nil
+		}
+
+		// Незавершенные возвраты учитываются в позициях как проведенные
+		items := make(map[int64]*payment.OrderItem, len(order.Items))
+		for _, item := range order.Items {
+			items[item.ID] = item
+		}
+		for _, pending := range st.refunds {
+			if pending.OrderID != orderID || pending.Status != payment.RefundStatusPending {
+				continue
+			}
+			for _, refunded := range pending.Items {
+				if item, ok := items[refunded.ItemID]; ok {
+					item.RefundedQuantity += refunded.Quantity
+					item.RefundedAmount += refunded.Amount
+				}
+			}
+		}
+
+		// Подаренный купон принадлежит получателю, поэтому возвращаются
+		// только купоны, оставшиеся у покупателя; новые первыми
+		var userCoupons []payment.UserCoupon
+		for _, uc := range st.userCoupons {
+			if uc.OrderID == orderID && uc.UserID == order.UserID && !uc.IsUsed && uc.RefundedAt.IsZero() {
+				userCoupons = append(userCoupons, uc)
+			}
+		}
+		sort.Slice(userCoupons, func(i, j int) bool { return userCoupons[i].ID > userCoupons[j].ID })
+
+		available := make(map[int64][]int64)
+		for _, uc := range userCoupons {
+			available[uc.OrderItemID] = append(available[uc.OrderItemID], uc.ID)
+		}
+
+		lines, err := plan(&order, available)
+		if err != nil {
+			return err
+		}
+
+		refund = payment.NewRefund(orderID, lines)
+		refund.ID = st.nextID()
+		refund.CreatedAt = time.Now()
+		refund.UpdatedAt = refund.CreatedAt
+		st.refunds[refund.ID] = *refund
+
+		for _, id := range refund.UserCouponIDs() {
+			if uc, ok := st.userCoupons[id]; ok {
+				uc.RefundedAt = refund.CreatedAt
+				st.userCoupons[id] = uc
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return refund, nil
+}
+
+func (st *state) pendingRefund(refundID int64) (payment.Refund, error) {
+	refund, ok := st.refunds[refundID]
+	if !ok {
+		return refund, sql.ErrNoRows
+	}
+	if refund.Status != payment.RefundStatusPending {
+		return refund, payment.ErrRefundNotPending
+	}
+	return refund, nil
+}
+
+func (r *OrderRepository) CompleteRefund(ctx context.Context, refundID int64) (*payment.Order, error) {
+	var completed *payment.Order
+
+	err := r.s.RunInTx(ctx, func(ctx context.Context) error {
+		st := r.s.state
+		refund, err := st.pendingRefund(refundID)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		for _, refunded := range refund.Items {
+			item, ok := st.items[refunded.ItemID]
+			if !ok || item.OrderID != refund.OrderID {
+				return fmt.Errorf("позиция %d возврата %d не найдена", refunded.ItemID, refund.ID)
+			}
+			item.RefundedQuantity += refunded.Quantity
+			item.RefundedAmount += refunded.Amount
+			st.items[item.ID] = item
+		}
+
+		row := st.orders[refund.OrderID]
+		row.RefundedAmount += refund.Amount
+		if row.RefundedAmount >= row.Amount {
+			row.Status = payment.OrderStatusRefunded
+		}
+		row.UpdatedAt = now
+		st.orders[row.ID] = row
+
+		refund.Status = payment.RefundStatusSucceeded
+		refund.UpdatedAt = now
+		st.refunds[refund.ID] = refund
+
+		order := st.order(row.ID, false)
+		order.Coupon = nil
+		completed = &order
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return completed, nil
+}
+
+func (r *OrderRepository) FailRefund(ctx context.Context, refundID int64, reason string) error {
+	return r.s.RunInTx(ctx, func(ctx context.Context) error {
+		st := r.s.state
+		refund, err := st.pendingRefund(refundID)
+		if err != nil {
+			return err
+		}
+
+		for _, id := range refund.UserCouponIDs() {
+			if uc, ok := st.userCoupons[id]; ok {
+				uc.RefundedAt = time.Time{}
+				st.userCoupons[id] = uc
+			}
+		}
+
+		refund.Status = payment.RefundStatusFailed
+		refund.BankError = reason
+		refund.UpdatedAt = time.Now()
+		st.refunds[refund.ID] = refund
+		return nil
+	})
+}
+
+func (r *OrderRepository) ListPendingRefunds(ctx context.Context, createdBefore time.Time, limit int) ([]payment.Refund, error) {
+	defer r.s.lock(ctx)()
+	st := r.s.state
+
+	var refunds []payment.Refund
+	for _, refund := range st.refunds {
+		if refund.Status == payment.RefundStatusPending && refund.CreatedAt.Before(createdBefore) {
+			order := st.orders[refund.OrderID]
+			refund.Order = &order
+			refunds = append(refunds, refund)
+		}
+	}
+	sort.Slice(refunds, func(i, j int) bool {
+		if !refunds[i].CreatedAt.Equal(refunds[j].CreatedAt) {
+			return refunds[i].CreatedAt.Before(refunds[j].CreatedAt)
+		}
+		return refunds[i].ID < refunds[j].ID
+	})
+	if limit > 0 && len(refunds) > limit {
+		refunds = refunds[:limit]
+	}
+	return refunds, nil
+}
+
+func (r *OrderRepository) update(ctx context.Context, orderID int64, apply func(order *payment.Order)) error {
+	defer r.s.lock(ctx)()
+	st := r.s.state
+
+	row, ok := st.orders[orderID]
+	if !ok {
+		return nil
+	}
+	apply(&row)
+	row.UpdatedAt = time.Now()
+	st.orders[orderID] = row
+	return nil
+}
+
+type UserCouponRepository struct {
+	s *Store
+}
+
+func (r *UserCouponRepository) ActivateOrder(ctx context.Context, order *payment.Order, expiresAt func(item *payment.OrderItem) time.Time) (int, error) {
+	defer r.s.lock(ctx)()
+	st := r.s.state
+
+	issued := make(map[int64]int)
+	for _, uc := range st.userCoupons {
+		issued[uc.OrderItemID]++
+	}
+
+	activated := 0
+	for _, item := range order.Items {
+		for i := issued[item.ID]; i < item.Quantity; i++ {
+			code, err := st.uniqueCode()
+			if err != nil {
+				return 0, err
+			}
+			userCoupon := payment.UserCoupon{
+				ID:          st.nextID(),
+				UserID:      order.UserID,
+				CouponID:    item.CouponID,
+				OrderID:     order.ID,
+				OrderItemID: item.ID,
+				Code:        code,
+				ActivatedAt: time.Now(),
+				ExpiresAt:   expiresAt(item),
+			}
+			st.userCoupons[userCoupon.ID] = userCoupon
+			st.codes[code] = userCoupon.ID
+			activated++
+		}
+	}
+	return activated, nil
+}
+
+func (r *UserCouponRepository) GetByCode(ctx context.Context, code string) (*payment.UserCoupon, error) {
+	defer r.s.lock(ctx)()
+	st := r.s.state
+
+	id, ok := st.codes[code]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	userCoupon := st.userCoupon(id, true)
+	return &userCoupon, nil
+}
+
+func (r *UserCouponRepository) GetUserCoupon(ctx context.Context, userID string, userCouponID int64) (*payment.UserCoupon, error) {
+	defer r.s.lock(ctx)()
+	st := r.s.state
+
+	row, ok := st.userCoupons[userCouponID]
+	if !ok || row.UserID != userID {
+		return nil, sql.ErrNoRows
+	}
+	userCoupon := st.userCoupon(userCouponID, false)
+	return &userCoupon, nil
+}
+
+func (r *UserCouponRepository) GetUserCoupons(ctx context.Context, userID string) ([]payment.UserCoupon, error) {
+	defer r.s.lock(ctx)()
+	st := r.s.state
+
+	var userCoupons []payment.UserCoupon
+	for id, row := range st.userCoupons {
+		if row.UserID == userID {
+			userCoupons = append(userCoupons, st.userCoupon(id, true))
+		}
+	}
+	sort.Slice(userCoupons, func(i, j int) bool {
+		a, b := userCoupons[i], userCoupons[j]
+		if !a.ActivatedAt.Equal(b.ActivatedAt) {
+			return a.ActivatedAt.After(b.ActivatedAt)
+		}
+		return a.ID > b.ID
+	})
+	return userCoupons, nil
+}
+
+func (r *UserCouponRepository) UseCoupon(ctx context.Context, userCouponID, merchantID int64, terminalID string, usedAt time.Time) (bool, error) {
+	defer r.s.lock(ctx)()
+	st := r.s.state
+
+	row, ok := st.userCoupons[userCouponID]
+	if !ok || row.IsUsed || !row.RefundedAt.IsZero() || row.IsExpired(usedAt) {
+		return false, nil
+	}
+	row.IsUsed = true
+	row.UsedAt = usedAt
+	row.RedeemedMerchantID = merchantID
+	row.RedeemedTerminalID = terminalID
+	st.userCoupons[userCouponID] = row
+	return true, nil
+}
+
+type OrderChangeRepository struct {
+	s *Store
+}
+
+func (r *OrderChangeRepository) Append(ctx context.Context, change *payment.OrderChange) error {
+	defer r.s.lock(ctx)()
+	st := r.s.state
+
+	// Детали проходят через JSON, как через jsonb: числа читаются как float64
+	row := *change
+	if change.Details != nil {
+		data, err := json.Marshal(change.Details)
+		if err != nil {
+			return err
+		}
+		row.Details = nil
+		if err := json.Unmarshal(data, &row.Details); err != nil {
+			return err
+		}
+	}
+
+	change.ID = st.nextID()
+	change.CreatedAt = time.Now()
+	row.ID, row.CreatedAt = change.ID, change.CreatedAt
+	st.changes = append(st.changes, row)
+	return nil
+}
+
+func (r *OrderChangeRepository) ListByOrder(ctx context.Context, orderID int64) ([]payment.OrderChange, error) {
+	defer r.s.lock(ctx)()
+
+	var changes []payment.OrderChange
+	for _, row := range r.s.state.changes {
+		if row.OrderID == orderID {
+			changes = append(changes, row)
+		}
+	}
+	return changes, nil
+}
+
+// Заказ со связями; withItemCoupons — подставлять ли купоны позиций
+func (st *state) order(id int64, withItemCoupons bool) payment.Order {
+	order := st.orders[id]
+	if coupon, ok := st.coupons[order.CouponID]; ok {
+		order.Coupon = &coupon
+	}
+
+	for _, row := range st.items {
+		if row.OrderID != id {
+			continue
+		}
+		item := row
+		if withItemCoupons {
+			if coupon, ok := st.coupons[item.CouponID]; ok {
+				item.Coupon = &coupon
+			}
+		}
+		order.Items = append(order.Items, &item)
+	}
+	sort.Slice(order.Items, func(i, j int) bool { return order.Items[i].Position < order.Items[j].Position })
+	return order
+}
+
+// Купон пользователя с заказом; withCoupon — подставлять ли купон каталога
+func (st *state) userCoupon(id int64, withCoupon bool) payment.UserCoupon {
+	userCoupon := st.userCoupons[id]
+	if order, ok := st.orders[userCoupon.OrderID]; ok {
+		userCoupon.Order = &order
+	}
+	if withCoupon {
+		if coupon, ok := st.coupons[userCoupon.CouponID]; ok {
+			userCoupon.Coupon = &coupon
+		}
+	}
+	return userCoupon
+}
+
+// ID заказов по убыванию времени создания; при равном времени — по убыванию ID
+func (st *state) ordersNewestFirst() []int64 {
+	ids := st.ordersByID()
+	sort.SliceStable(ids, func(i, j int) bool {
+		a, b := st.orders[ids[i]], st.orders[ids[j]]
+		if !a.CreatedAt.Equal(b.CreatedAt) {
+			return a.CreatedAt.After(b.CreatedAt)
+		}
+		return a.ID > b.ID
+	})
+	return ids
+}
+
+func (st *state) ordersByID() []int64 {
+	ids := make([]int64, 0, len(st.orders))
+	for id := range st.orders {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+func (st *state) uniqueCode() (string, error) {
+	for attempt := 0; attempt < couponCodeAttempts; attempt++ {
+		code, err := payment.GenerateCouponCode()
+		if err != nil {
+			return "", err
+		}
+		if _, taken := st.codes[code]; !taken {
+			return code, nil
+		}
+	}
+	return "", db.UniqueViolation("user_coupons_code_key")
+}
+
+func orderRow(order *payment.Order) payment.Order {
+	row := *order
+	row.Coupon = nil
+	row.Items = nil
+	return row
+}
+
+func itemRow(item *payment.OrderItem) payment.OrderItem {
+	row := *item
+	row.Coupon = nil
+	return row
+}
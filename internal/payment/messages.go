@@ -0,0 +1,124 @@
+package payment
+
+import "github.com/skr1ms/PaymentAlphaBank.git/pkg/i18n"
+
+func init() {
+	i18n.Register(i18n.LocaleEN, map[string]string{
+		// Сообщения обработчиков
+		"Заказ не найден":                         "Order not found",
+		"Заказ успешно создан":                    "Order created successfully",
+		"Купон не найден":                         "Coupon not found",
+		"Купон принадлежит другому пользователю":  "Coupon belongs to another user",
+		"Не передано изображение":                 "Image is missing",
+		"Не указан ID пользователя":               "User ID is required",
+		"Не указан владелец купона":               "Coupon owner is required",
+		"Не указан номер заказа":                  "Order number is required",
+		"Неверные параметры фильтрации":           "Invalid filter parameters",
+		"Неверный API-ключ партнера":              "Invalid merchant API key",
+		"Неверный ID купона":                      "Invalid coupon ID",
+		"Неверный ID подарка":                     "Invalid gift ID",
+		"Неверный код купона":                     "Invalid coupon code",
+		"Неверный формат запроса":                 "Invalid request format",
+		"Неподдерживаемый вид или формат кода":    "Unsupported code type or format",
+		"Ошибка загрузки изображения":             "Failed to upload image",
+		"Ошибка отмены подарка":                   "Failed to cancel gift",
+		"Ошибка погашения купона":                 "Failed to redeem coupon",
+		"Ошибка поиска купона":                    "Failed to look up coupon",
+		"Ошибка получения заказов":                "Failed to get orders",
+		"Ошибка получения категорий":              "Failed to get categories",
+		"Ошибка получения купонов":                "Failed to get coupons",
+		"Ошибка получения подарка":                "Failed to get gift",
+		"Ошибка принятия подарка":                 "Failed to accept gift",
+		"Ошибка проверки статуса в банке":         "Failed to check status with the bank",
+		"Ошибка проверки статуса заказа":          "Failed to check order status",
+		"Ошибка обработки уведомления":            "Failed to process notification",
+		"Ошибка проверки статуса платежа":         "Failed to check payment status",
+		"Ошибка регистрации платежа":              "Failed to register payment",
+		"Ошибка создания заказа":                  "Failed to create order",
+		"Ошибка создания подарка":                 "Failed to create gift",
+		"Ошибка проверки промокода":               "Failed to check promo code",
+		"Ошибка возврата":                         "Refund failed",
+		"Банк отклонил возврат":                   "The bank rejected the refund",
+		"Банк отклонил отмену заказа":             "The bank rejected the order cancellation",
+		"Ошибка получения корзины":                "Failed to get cart",
+		"Ошибка изменения корзины":                "Failed to update cart",
+		"Неверный состав заказа":                  "Invalid order items",
+		"Неверные параметры поиска заказов":       "Invalid order search parameters",
+		"Ошибка сохранения перевода":              "Failed to save translation",
+		"Ошибка формирования кода купона":         "Failed to render coupon code",
+		"Подпись кода купона недействительна":     "Coupon code signature is invalid",
+		"Слишком много попыток, попробуйте позже": "Too many attempts, try again later",
+		"Не указан адрес возврата":                "Return URL is required",
+		"Неверный ID партнера":                    "Invalid merchant ID",
+		"Партнер не найден":                       "Merchant not found",
+		"Ошибка получения партнеров":              "Failed to get merchants",
+		"Ошибка создания партнера":                "Failed to create merchant",
+		"Ошибка изменения партнера":               "Failed to update merchant",
+		"Ошибка изменения купона":                 "Failed to update coupon",
+		"Ошибка получения данных оплаты":          "Failed to get payment details",
+		"Ошибка получения журнала заказа":         "Failed to get order timeline",
+		"Ошибка отмены заказа":                    "Failed to cancel order",
+		"Данные оплаты не найдены":                "Payment details not found",
+
+		// Ошибки сервисов
+		"неподдерживаемый вид или формат кода":                        "unsupported code type or format",
+		"неверные параметры фильтрации каталога":                      "invalid catalog filter parameters",
+		"неверный формат кода купона":                                 "invalid coupon code format",
+		"купон с таким кодом не найден":                               "coupon with this code not found",
+		"не указан владелец купона":                                   "coupon owner is required",
+		"купон принадлежит другому пользователю":                      "coupon belongs to another user",
+		"заказ купона не оплачен":                                     "coupon order is not paid",
+		"срок действия купона истек":                                  "coupon has expired",
+		"купон уже использован":                                       "coupon has already been used",
+		"купон не найден":                                             "coupon not found",
+		"поддерживаются только изображения JPEG, PNG и WebP":          "only JPEG, PNG and WebP images are supported",
+		"изображение слишком большое":                                 "image is too large",
+		"подарок не найден":                                           "gift not found",
+		"подарок уже принят или отменен":                              "gift has already been accepted or cancelled",
+		"срок действия подарка истек":                                 "gift has expired",
+		"подарок предназначен другому пользователю":                   "gift is intended for another user",
+		"нельзя подарить купон самому себе":                           "you cannot gift a coupon to yourself",
+		"для купона уже создан подарок":                               "a gift already exists for this coupon",
+		"купон нельзя передать: он использован, истек или не оплачен": "coupon cannot be transferred: it is used, expired or unpaid",
+		"подпись кода купона недействительна":                         "coupon code signature is invalid",
+		"неподдерживаемый язык":                                       "unsupported language",
+		"не указано название":                                         "name is required",
+		"купон возвращен":                                             "coupon has been refunded",
+		"неверный состав заказа":                                      "invalid order items",
+		"вернуть можно только оплаченный заказ":                       "only paid orders can be refunded",
+		"неверные позиции возврата":                                   "invalid refund items",
+		"недостаточно купонов для возврата: использованные и уже возвращенные купоны не возвращаются": "not enough coupons to refund: used and already refunded coupons cannot be refunded",
+		"банк не подтвердил возврат, результат будет сверен автоматически":                            "the bank did not confirm the refund, it will be reconciled automatically",
+		"возврат уже завершен":              "refund has already been completed",
+		"неверные параметры поиска заказов": "invalid order search parameters",
+		"корзина пуста":                     "cart is empty",
+		"в корзине слишком много позиций":   "too many items in the cart",
+		"неверное количество купонов":       "invalid coupon quantity",
+		"неверный состав заказа: купоны разных магазинов оформляются отдельными заказами": "invalid order items: coupons from different shops must be ordered separately",
+		"не указан адрес возврата":                                     "return URL is required",
+		"неверные параметры партнера":                                  "invalid merchant parameters",
+		"неверные параметры партнера: цвет задается в формате #rrggbb": "invalid merchant parameters: color must be in #rrggbb format",
+		"неверные параметры партнера: не указан пароль шлюза":          "invalid merchant parameters: gateway password is required",
+		"партнер не найден":                                            "merchant not found",
+		"отменить можно только неоплаченный заказ":                     "only unpaid orders can be cancelled",
+		"заказ уже оплачен":                                            "order has already been paid",
+		"покупатель подтверждает оплату, отмена пока невозможна":       "payment is being confirmed by the customer, the order cannot be cancelled yet",
+		"банк отклонил отмену заказа":                                  "the bank rejected the order cancellation",
+
+		// Страница результата платежа
+		"Результат платежа":                                     "Payment result",
+		"Платеж успешно завершен!":                              "Payment completed successfully!",
+		`Купон "%s" активирован в вашем аккаунте.`:              `Coupon "%s" has been activated in your account.`,
+		"Купоны активированы в вашем аккаунте.":                 "Coupons have been activated in your account.",
+		"Платеж отклонен":                                       "Payment declined",
+		"Попробуйте еще раз или выберите другой способ оплаты.": "Please try again or choose another payment method.",
+		"Платеж обрабатывается":                                 "Payment is being processed",
+		"Статус платежа будет обновлен в ближайшее время.":      "Payment status will be updated shortly.",
+		"Неизвестный статус платежа":                            "Unknown payment status",
+		"Вернуться на главную":                                  "Back to home page",
+
+		// Описание заказа для платежной страницы банка
+		"Покупка купона: %s":      "Coupon purchase: %s",
+		"Покупка купонов: %d шт.": "Coupon purchase: %d items",
+	})
+}
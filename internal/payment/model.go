@@ -12,8 +12,19 @@ const (
 	OrderStatusPaid      = "paid"
 	OrderStatusFailed    = "failed"
 	OrderStatusCancelled = "cancelled"
+	OrderStatusRefunded  = "refunded"
 )
 
+// Модель категории купонов
+type Category struct {
+	bun.BaseModel `bun:"table:coupon_categories"`
+
+	ID        int64     `bun:"id,pk,autoincrement" json:"id"`
+	Slug      string    `bun:"slug,notnull,unique" json:"slug"`
+	Name      string    `bun:"name,notnull" json:"name"`
+	CreatedAt time.Time `bun:"created_at,nullzero,notnull,default:current_timestamp" json:"created_at"`
+}
+
 // Модель купона
 type Coupon struct {
 	bun.BaseModel `bun:"table:coupons"`
@@ -24,8 +35,32 @@ type Coupon struct {
 	Price       float64   `bun:"price,notnull" json:"price"`
 	Currency    string    `bun:"currency,notnull,default:'RUB'" json:"currency"`
 	IsActive    bool      `bun:"is_active,notnull,default:true" json:"is_active"`
+	ValidDays   int       `bun:"valid_days,notnull,default:0" json:"valid_days"` // 0 — бессрочный
+	CategoryID  int64     `bun:"category_id,nullzero" json:"category_id,omitempty"`
+	MerchantID  int64     `bun:"merchant_id,nullzero" json:"merchant_id,omitempty"` // магазин; пусто — общий каталог
+	Tags        []string  `bun:"tags,array" json:"tags"`
+	ImageKey    string    `bun:"image_key,nullzero" json:"-"`
+	ImageURL    string    `bun:"image_url,nullzero" json:"image_url,omitempty"`
 	CreatedAt   time.Time `bun:"created_at,nullzero,notnull,default:current_timestamp" json:"created_at"`
 	UpdatedAt   time.Time `bun:"updated_at,nullzero,notnull,default:current_timestamp" json:"updated_at"`
+
+	// Релевантность при полнотекстовом поиске, в таблице не хранится
+	Rank float64 `bun:"rank,scanonly" json:"-"`
+
+	// Связи
+	Category *Category `bun:"rel:belongs-to,join:category_id=id" json:"category,omitempty"`
+}
+
+// Перевод названия и описания купона; исходные тексты на русском хранятся в coupons
+type CouponTranslation struct {
+	bun.BaseModel `bun:"table:coupon_translations"`
+
+	ID          int64     `bun:"id,pk,autoincrement" json:"id"`
+	CouponID    int64     `bun:"coupon_id,notnull,unique:coupon_translations_coupon_locale" json:"coupon_id"`
+	Locale      string    `bun:"locale,notnull,unique:coupon_translations_coupon_locale" json:"locale"`
+	Name        string    `bun:"name,notnull" json:"name"`
+	Description string    `bun:"description" json:"description"`
+	UpdatedAt   time.Time `bun:"updated_at,nullzero,notnull,default:current_timestamp" json:"updated_at"`
 }
 
 // Модель заказа
@@ -35,18 +70,166 @@ type Order struct {
 	ID              int64     `bun:"id,pk,autoincrement" json:"id"`
 	OrderNumber     string    `bun:"order_number,notnull,unique" json:"order_number"`
 	AlfaBankOrderID string    `bun:"alfabank_order_id" json:"alfabank_order_id"`
-	CouponID        int64     `bun:"coupon_id,notnull" json:"coupon_id"`
+	CouponID        int64     `bun:"coupon_id,nullzero" json:"coupon_id,omitempty"`     // только у заказов из одной позиции
+	MerchantID      int64     `bun:"merchant_id,nullzero" json:"merchant_id,omitempty"` // магазин купонов заказа
 	UserID          string    `bun:"user_id,notnull" json:"user_id"`
-	Amount          int64     `bun:"amount,notnull" json:"amount"` // в копейках
+	Amount          int64     `bun:"amount,notnull" json:"amount"`                             // в копейках, с учетом скидки
+	DiscountAmount  int64     `bun:"discount_amount,notnull,default:0" json:"discount_amount"` // в копейках
+	RefundedAmount  int64     `bun:"refunded_amount,notnull,default:0" json:"refunded_amount"` // в копейках
+	PromoCodeID     int64     `bun:"promo_code_id,nullzero" json:"promo_code_id,omitempty"`
 	Currency        string    `bun:"currency,notnull,default:'RUB'" json:"currency"`
 	Status          string    `bun:"status,notnull,default:'created'" json:"status"`
 	PaymentURL      string    `bun:"payment_url" json:"payment_url"`
 	ReturnURL       string    `bun:"return_url" json:"return_url"`
 	FailURL         string    `bun:"fail_url" json:"fail_url"`
 	Description     string    `bun:"description" json:"description"`
+	PaymentDetails  string    `bun:"payment_details" json:"-"` // зашифрованные PaymentDetails
 	CreatedAt       time.Time `bun:"created_at,nullzero,notnull,default:current_timestamp" json:"created_at"`
 	UpdatedAt       time.Time `bun:"updated_at,nullzero,notnull,default:current_timestamp" json:"updated_at"`
 
+	// Связи
+	Coupon *Coupon      `bun:"rel:belongs-to,join:coupon_id=id" json:"coupon,omitempty"`
+	Items  []*OrderItem `bun:"rel:has-many,join:id=order_id" json:"items,omitempty"`
+}
+
+// Данные попытки оплаты из ответа банка: маска карты, держатель и IP
+// плательщика. Хранятся только в зашифрованном виде
+type PaymentDetails struct {
+	Pan            string `json:"pan,omitempty"`
+	Expiration     string `json:"expiration,omitempty"`
+	CardholderName string `json:"cardholder_name,omitempty"`
+	ApprovalCode   string `json:"approval_code,omitempty"`
+	IP             string `json:"ip,omitempty"`
+}
+
+// Позиция заказа: купон, количество и цена за единицу. Суммы в копейках
+type OrderItem struct {
+	bun.BaseModel `bun:"table:order_items"`
+
+	ID               int64     `bun:"id,pk,autoincrement" json:"id"`
+	OrderID          int64     `bun:"order_id,notnull,unique:order_items_order_position" json:"order_id"`
+	Position         int       `bun:"position,notnull,unique:order_items_order_position" json:"position"` // positionId в чеке
+	CouponID         int64     `bun:"coupon_id,notnull" json:"coupon_id"`
+	Name             string    `bun:"name,notnull" json:"name"`
+	Quantity         int       `bun:"quantity,notnull" json:"quantity"`
+	UnitPrice        int64     `bun:"unit_price,notnull" json:"unit_price"`
+	DiscountAmount   int64     `bun:"discount_amount,notnull,default:0" json:"discount_amount"`
+	Amount           int64     `bun:"amount,notnull" json:"amount"` // с учетом скидки
+	RefundedQuantity int       `bun:"refunded_quantity,notnull,default:0" json:"refunded_quantity"`
+	RefundedAmount   int64     `bun:"refunded_amount,notnull,default:0" json:"refunded_amount"`
+	CreatedAt        time.Time `bun:"created_at,nullzero,notnull,default:current_timestamp" json:"created_at"`
+
+	// Связи
+	Coupon *Coupon `bun:"rel:belongs-to,join:coupon_id=id" json:"-"`
+}
+
+// Сумма возврата за quantity единиц позиции. Скидка могла не разделиться
+// на количество нацело, поэтому последние единицы забирают остаток копеек
+func (item *OrderItem) RefundAmount(quantity int) int64 {
+	returned := int64(item.RefundedQuantity)
+	q := int64(item.Quantity)
+	return item.Amount*(returned+int64(quantity))/q - item.Amount*returned/q
+}
+
+// Статусы возврата
+const (
+	RefundStatusPending   = "pending" // сохранен до запроса в банк или банк не ответил
+	RefundStatusSucceeded = "succeeded"
+	RefundStatusFailed    = "failed"
+)
+
+// Возврат по заказу. Сохраняется до запроса в банк, его купоны сразу
+// снимаются с погашения; суммы позиций и заказа меняются только после
+// подтверждения банка. Возврат, оставшийся в pending, завершает сверка
+type Refund struct {
+	bun.BaseModel `bun:"table:refunds"`
+
+	ID        int64        `bun:"id,pk,autoincrement" json:"id"`
+	OrderID   int64        `bun:"order_id,notnull" json:"order_id"`
+	Status    string       `bun:"status,notnull" json:"status"`
+	Amount    int64        `bun:"amount,notnull" json:"amount"` // в копейках
+	Items     []RefundItem `bun:"items,type:jsonb,notnull" json:"items"`
+	BankError string       `bun:"bank_error,nullzero" json:"bank_error,omitempty"`
+	CreatedAt time.Time    `bun:"created_at,nullzero,notnull,default:current_timestamp" json:"created_at"`
+	UpdatedAt time.Time    `bun:"updated_at,nullzero,notnull,default:current_timestamp" json:"updated_at"`
+
+	// Связи
+	Order *Order `bun:"rel:belongs-to,join:order_id=id" json:"-"`
+}
+
+// Позиция возврата: единицы позиции заказа, их сумма и возвращаемые купоны
+type RefundItem struct {
+	ItemID        int64   `json:"item_id"`
+	Quantity      int     `json:"quantity"`
+	Amount        int64   `json:"amount"`
+	UserCouponIDs []int64 `json:"user_coupon_ids"`
+}
+
+func (r *Refund) UserCouponIDs() []int64 {
+	var ids []int64
+	for _, item := range r.Items {
+		ids = append(ids, item.UserCouponIDs...)
+	}
+	return ids
+}
+
+// Незавершенные возвраты в позициях заказа как проведенные: их единицы уже
+// заняты, а суммы следующих возвратов считаются после них
+func addPendingRefunds(order *Order, pending []Refund) {
+	items := make(map[int64]*OrderItem, len(order.Items))
+	for _, item := range order.Items {
+		items[item.ID] = item
+	}
+	for _, refund := range pending {
+		for _, refunded := range refund.Items {
+			if item, ok := items[refunded.ItemID]; ok {
+				item.RefundedQuantity += refunded.Quantity
+				item.RefundedAmount += refunded.Amount
+			}
+		}
+	}
+}
+
+// Виды записей журнала заказа
+const (
+	OrderChangeStatus            = "status_changed"
+	OrderChangeCouponsActivated  = "coupons_activated"
+	OrderChangeCouponRedeemed    = "coupon_redeemed"
+	OrderChangeRefunded          = "refunded"
+	OrderChangeCouponTransferred = "coupon_transferred"
+)
+
+// Запись журнала изменений заказа и его купонов. Записи только добавляются:
+// UPDATE и DELETE таблицы запрещены триггером
+type OrderChange struct {
+	bun.BaseModel `bun:"table:order_events"`
+
+	ID             int64          `bun:"id,pk,autoincrement" json:"id"`
+	OrderID        int64          `bun:"order_id,notnull" json:"order_id"`
+	Kind           string         `bun:"kind,notnull" json:"kind"`
+	OldStatus      string         `bun:"old_status,nullzero" json:"old_status,omitempty"`
+	NewStatus      string         `bun:"new_status,nullzero" json:"new_status,omitempty"`
+	UserCouponID   int64          `bun:"user_coupon_id,nullzero" json:"user_coupon_id,omitempty"`
+	Source         string         `bun:"source,notnull" json:"source"`
+	Actor          string         `bun:"actor,nullzero" json:"actor,omitempty"` // user:<id>, key:<id>, merchant:<id>, bank или system
+	BankOrderID    string         `bun:"bank_order_id,nullzero" json:"bank_order_id,omitempty"`
+	BankStatus     *int           `bun:"bank_status" json:"bank_status,omitempty"` // orderStatus из ответа банка
+	BankActionCode *int           `bun:"bank_action_code" json:"bank_action_code,omitempty"`
+	Details        map[string]any `bun:"details,type:jsonb,nullzero" json:"details,omitempty"`
+	CreatedAt      time.Time      `bun:"created_at,nullzero,notnull,default:current_timestamp" json:"created_at"`
+}
+
+// Позиция корзины пользователя
+type CartItem struct {
+	bun.BaseModel `bun:"table:cart_items"`
+
+	ID        int64     `bun:"id,pk,autoincrement" json:"id"`
+	UserID    string    `bun:"user_id,notnull,unique:cart_items_user_coupon" json:"user_id"`
+	CouponID  int64     `bun:"coupon_id,notnull,unique:cart_items_user_coupon" json:"coupon_id"`
+	Quantity  int       `bun:"quantity,notnull" json:"quantity"`
+	CreatedAt time.Time `bun:"created_at,nullzero,notnull,default:current_timestamp" json:"created_at"`
+	UpdatedAt time.Time `bun:"updated_at,nullzero,notnull,default:current_timestamp" json:"updated_at"`
+
 	// Связи
 	Coupon *Coupon `bun:"rel:belongs-to,join:coupon_id=id" json:"coupon,omitempty"`
 }
@@ -59,11 +242,99 @@ type UserCoupon struct {
 	UserID      string    `bun:"user_id,notnull" json:"user_id"`
 	CouponID    int64     `bun:"coupon_id,notnull" json:"coupon_id"`
 	OrderID     int64     `bun:"order_id,notnull" json:"order_id"`
+	OrderItemID int64     `bun:"order_item_id,nullzero" json:"order_item_id,omitempty"`
+	Code        string    `bun:"code,nullzero,unique" json:"code"`
 	ActivatedAt time.Time `bun:"activated_at,nullzero,notnull,default:current_timestamp" json:"activated_at"`
+	ExpiresAt   time.Time `bun:"expires_at,nullzero" json:"expires_at,omitempty"`
 	IsUsed      bool      `bun:"is_used,notnull,default:false" json:"is_used"`
 	UsedAt      time.Time `bun:"used_at,nullzero" json:"used_at,omitempty"`
+	RefundedAt  time.Time `bun:"refunded_at,nullzero" json:"refunded_at,omitempty"`
+
+	// Кто погасил купон
+	RedeemedMerchantID int64  `bun:"redeemed_merchant_id,nullzero" json:"redeemed_merchant_id,omitempty"`
+	RedeemedTerminalID string `bun:"redeemed_terminal_id,nullzero" json:"redeemed_terminal_id,omitempty"`
 
 	// Связи
 	Coupon *Coupon `bun:"rel:belongs-to,join:coupon_id=id" json:"coupon,omitempty"`
 	Order  *Order  `bun:"rel:belongs-to,join:order_id=id" json:"order,omitempty"`
 }
+
+// Истек ли срок действия купона
+func (uc *UserCoupon) IsExpired(now time.Time) bool {
+	return !uc.ExpiresAt.IsZero() && !now.Before(uc.ExpiresAt)
+}
+
+// Статусы подарков
+const (
+	GiftStatusPending   = "pending"
+	GiftStatusAccepted  = "accepted"
+	GiftStatusCancelled = "cancelled"
+)
+
+// Модель подарка купона другому пользователю
+type CouponGift struct {
+	bun.BaseModel `bun:"table:coupon_gifts"`
+
+	ID           int64     `bun:"id,pk,autoincrement" json:"id"`
+	UserCouponID int64     `bun:"user_coupon_id,notnull" json:"user_coupon_id"`
+	FromUserID   string    `bun:"from_user_id,notnull" json:"from_user_id"`
+	ToUserID     string    `bun:"to_user_id,nullzero" json:"to_user_id,omitempty"` // пусто — подарок по ссылке
+	TokenHash    string    `bun:"token_hash,notnull,unique" json:"-"`
+	Status       string    `bun:"status,notnull,default:'pending'" json:"status"`
+	ExpiresAt    time.Time `bun:"expires_at,notnull" json:"expires_at"`
+	AcceptedBy   string    `bun:"accepted_by,nullzero" json:"accepted_by,omitempty"`
+	AcceptedAt   time.Time `bun:"accepted_at,nullzero" json:"accepted_at,omitempty"`
+	CreatedAt    time.Time `bun:"created_at,nullzero,notnull,default:current_timestamp" json:"created_at"`
+	UpdatedAt    time.Time `bun:"updated_at,nullzero,notnull,default:current_timestamp" json:"updated_at"`
+
+	// Связи
+	UserCoupon *UserCoupon `bun:"rel:belongs-to,join:user_coupon_id=id" json:"user_coupon,omitempty"`
+}
+
+// Журнал смены владельца купона
+type CouponTransfer struct {
+	bun.BaseModel `bun:"table:coupon_transfers"`
+
+	ID            int64     `bun:"id,pk,autoincrement" json:"id"`
+	UserCouponID  int64     `bun:"user_coupon_id,notnull" json:"user_coupon_id"`
+	GiftID        int64     `bun:"gift_id,notnull" json:"gift_id"`
+	FromUserID    string    `bun:"from_user_id,notnull" json:"from_user_id"`
+	ToUserID      string    `bun:"to_user_id,notnull" json:"to_user_id"`
+	TransferredAt time.Time `bun:"transferred_at,nullzero,notnull,default:current_timestamp" json:"transferred_at"`
+}
+
+// Модель партнера: магазин, продающий купоны, и/или точка их погашения на кассе
+type Merchant struct {
+	bun.BaseModel `bun:"table:merchants"`
+
+	ID         int64  `bun:"id,pk,autoincrement" json:"id"`
+	Name       string `bun:"name,notnull" json:"name"`
+	APIKeyHash string `bun:"api_key_hash,notnull,unique" json:"-"`
+	IsActive   bool   `bun:"is_active,notnull,default:true" json:"is_active"`
+
+	// Собственная учетная запись магазина в Альфа-Банке; без логина заказы
+	// регистрируются под общей учетной записью из конфигурации
+	GatewayURL      string `bun:"gateway_url" json:"gateway_url,omitempty"`
+	GatewayUsername string `bun:"gateway_username" json:"gateway_username,omitempty"`
+	GatewayPassword string `bun:"gateway_password" json:"-"` // зашифрован, см. MerchantService
+
+	// Адреса возврата покупателя по умолчанию и оформление страницы результата
+	ReturnURL string           `bun:"return_url" json:"return_url,omitempty"`
+	FailURL   string           `bun:"fail_url" json:"fail_url,omitempty"`
+	Branding  MerchantBranding `bun:"branding,type:jsonb,nullzero" json:"branding"`
+
+	CreatedAt time.Time `bun:"created_at,nullzero,notnull,default:current_timestamp" json:"created_at"`
+	UpdatedAt time.Time `bun:"updated_at,nullzero,notnull,default:current_timestamp" json:"updated_at"`
+}
+
+// Оформление страницы результата оплаты
+type MerchantBranding struct {
+	DisplayName  string `json:"display_name,omitempty"`
+	LogoURL      string `json:"logo_url,omitempty" validate:"url"`
+	PrimaryColor string `json:"primary_color,omitempty"` // #rrggbb
+}
+
+// Есть ли у магазина собственный логин в шлюзе
+func (m *Merchant) HasGatewayAccount() bool {
+	return m.GatewayUsername != ""
+}
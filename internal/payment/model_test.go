@@ -0,0 +1,70 @@
+package payment
+
+import "testing"
+
+func TestOrderItemRefundAmount(t *testing.T) {
+	tests := []struct {
+		name     string
+		quantity int
+		amount   int64
+		refunds  []int // количества последовательных возвратов
+	}{
+		{name: "делится нацело", quantity: 4, amount: 40000, refunds: []int{1, 1, 2}},
+		{name: "остаток копеек", quantity: 3, amount: 10000, refunds: []int{1, 1, 1}},
+		{name: "остаток при возврате частями", quantity: 7, amount: 99999, refunds: []int{2, 1, 3, 1}},
+		{name: "возврат всей позиции сразу", quantity: 5, amount: 12345, refunds: []int{5}},
+		{name: "скидка больше остатка", quantity: 3, amount: 2, refunds: []int{1, 1, 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			item := &OrderItem{Quantity: tt.quantity, Amount: tt.amount}
+			unitFloor := tt.amount / int64(tt.quantity)
+
+			var total int64
+			for _, quantity := range tt.refunds {
+				amount := item.RefundAmount(quantity)
+				// Единица стоит целую часть цены или на копейку больше
+				if amount < unitFloor*int64(quantity) || amount > (unitFloor+1)*int64(quantity) {
+					t.Fatalf("возврат %d ед. на %d коп. вне цены единицы %d коп.", quantity, amount, unitFloor)
+				}
+				item.RefundedQuantity += quantity
+				item.RefundedAmount += amount
+				total += amount
+			}
+
+			if item.RefundedQuantity != tt.quantity {
+				t.Fatalf("возвращено %d ед. из %d", item.RefundedQuantity, tt.quantity)
+			}
+			if total != tt.amount {
+				t.Fatalf("сумма возвратов %d коп., сумма позиции %d коп.", total, tt.amount)
+			}
+		})
+	}
+}
+
+func TestAddPendingRefunds(t *testing.T) {
+	order := &Order{Items: []*OrderItem{
+		{ID: 1, Quantity: 3, Amount: 10000},
+		{ID: 2, Quantity: 2, Amount: 5000, RefundedQuantity: 1, RefundedAmount: 2500},
+	}}
+	pending := NewRefund(1, []RefundLine{
+		{Item: order.Items[0], Quantity: 1, Amount: order.Items[0].RefundAmount(1), UserCouponIDs: []int64{11}},
+	})
+
+	addPendingRefunds(order, []Refund{*pending})
+
+	first := order.Items[0]
+	if first.RefundedQuantity != 1 || first.RefundedAmount != 3333 {
+		t.Fatalf("позиция 1: возвращено %d ед. на %d коп.", first.RefundedQuantity, first.RefundedAmount)
+	}
+	// Следующие возвраты считаются после незавершенного и в сумме дают позицию
+	if rest := first.RefundAmount(2); first.RefundedAmount+rest != first.Amount {
+		t.Fatalf("остаток позиции %d коп., всего %d коп. из %d", rest, first.RefundedAmount+rest, first.Amount)
+	}
+
+	second := order.Items[1]
+	if second.RefundedQuantity != 1 || second.RefundedAmount != 2500 {
+		t.Fatalf("позиция 2 изменилась: %d ед. на %d коп.", second.RefundedQuantity, second.RefundedAmount)
+	}
+}
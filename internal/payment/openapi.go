@@ -0,0 +1,319 @@
+package payment
+
+import (
+	"net/http"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/gofiber/fiber/v2"
+	"github.com/skr1ms/PaymentAlphaBank.git/internal/promo"
+	"github.com/skr1ms/PaymentAlphaBank.git/pkg/openapi"
+)
+
+// Схемы доступа маршрутов
+var (
+	userSecurity     = []string{openapi.SecurityBearer}
+	staffSecurity    = []string{openapi.SecurityAPIKey, openapi.SecurityBearer}
+	merchantSecurity = []string{openapi.SecurityMerchantKey, openapi.SecurityAPIKey}
+)
+
+// Тип ответа потоков событий заказов
+const eventStream = "text/event-stream"
+
+// Описание маршрутов PaymentHandler в документе OpenAPI. Пути и тела
+// должны совпадать с регистрацией в NewPaymentHandler
+func describeRoutes(spec *openapi.Spec) {
+	couponID := map[string]string{"couponID": "integer"}
+	userCouponID := map[string]string{"userCouponID": "integer"}
+
+	// Каталог
+	spec.Add(openapi.Operation{
+		Method: http.MethodGet, Path: "/coupons", Tags: []string{"catalog"},
+		Summary: "Каталог купонов; курсор следующей страницы — в заголовке X-Next-Cursor",
+		Query: []*openapi3.Parameter{
+			queryParam("q", "Полнотекстовый поиск", openapi3.NewStringSchema()),
+			queryParam("category", "Slug категории", openapi3.NewStringSchema()),
+			queryParam("tag", "Тег", openapi3.NewStringSchema()),
+			queryParam("merchant_id", "ID магазина", openapi3.NewIntegerSchema().WithMin(1)),
+			queryParam("min_price", "Минимальная цена", openapi3.NewFloat64Schema().WithMin(0)),
+			queryParam("max_price", "Максимальная цена", openapi3.NewFloat64Schema().WithMin(0)),
+			queryParam("sort", "Сортировка", openapi3.NewStringSchema().WithEnum(SortCreatedDesc, SortPriceAsc, SortPriceDesc, SortNameAsc, SortRelevance)),
+			queryParam("cursor", "Курсор страницы", openapi3.NewStringSchema()),
+			queryParam("limit", "Размер страницы", openapi3.NewIntegerSchema().WithMin(1)),
+		},
+		Response: []Coupon{},
+	})
+	spec.Add(openapi.Operation{
+		Method: http.MethodGet, Path: "/categories", Tags: []string{"catalog"},
+		Summary:  "Категории каталога",
+		Response: []Category{},
+	})
+	spec.Add(openapi.Operation{
+		Method: http.MethodPost, Path: "/coupons/:couponID/image", Tags: []string{"catalog"},
+		Summary:   "Загрузка изображения купона (multipart, поле image)",
+		Security:  staffSecurity,
+		PathTypes: couponID,
+		Response:  Coupon{},
+	})
+	spec.Add(openapi.Operation{
+		Method: http.MethodPut, Path: "/coupons/:couponID/translations/:locale", Tags: []string{"catalog"},
+		Summary:   "Перевод названия и описания купона",
+		Security:  staffSecurity,
+		PathTypes: couponID,
+		Request:   CouponTranslationRequest{},
+		Response:  CouponTranslation{},
+	})
+	spec.Add(openapi.Operation{
+		Method: http.MethodPut, Path: "/coupons/:couponID/merchant", Tags: []string{"catalog"},
+		Summary:   "Привязка купона к магазину; 0 — общий каталог",
+		Security:  staffSecurity,
+		PathTypes: couponID,
+		Request:   CouponMerchantRequest{},
+		Response:  Coupon{},
+	})
+
+	// Заказы
+	spec.Add(openapi.Operation{
+		Method: http.MethodPost, Path: "/orders", Tags: []string{"orders"},
+		Summary:  "Создание заказа из одного купона или нескольких позиций",
+		Security: userSecurity,
+		Request:  CreateOrderRequest{},
+		Response: CreateOrderResponse{},
+	})
+	spec.Add(openapi.Operation{
+		Method: http.MethodPost, Path: "/promo-codes/check", Tags: []string{"orders"},
+		Summary:  "Расчет скидки по промокоду",
+		Security: userSecurity,
+		Request:  promo.CheckPromoCodeRequest{},
+		Response: promo.Quote{},
+	})
+	spec.Add(openapi.Operation{
+		Method: http.MethodGet, Path: "/orders/:orderNumber/status", Tags: []string{"orders"},
+		Summary:  "Статус заказа с проверкой в банке; доступен владельцу и сотрудникам",
+		Security: userSecurity,
+		Response: OrderStatusResponse{},
+	})
+	spec.Add(openapi.Operation{
+		Method: http.MethodGet, Path: "/orders/:orderNumber/events", Tags: []string{"orders"},
+		Summary:  "Поток SSE: текущее состояние заказа (order.snapshot), затем его изменения; для EventSource токен передается в access_token",
+		Security: userSecurity,
+		Query: []*openapi3.Parameter{
+			queryParam("access_token", "JWT, если нельзя передать заголовок Authorization", openapi3.NewStringSchema()),
+		},
+		Response:    OrderEvent{},
+		ContentType: eventStream,
+	})
+	spec.Add(openapi.Operation{
+		Method: http.MethodPost, Path: "/orders/:orderNumber/refunds", Tags: []string{"orders"},
+		Summary:  "Возврат части единиц по позициям заказа",
+		Security: staffSecurity,
+		Request:  RefundRequest{},
+		Response: RefundResponse{},
+	})
+	spec.Add(openapi.Operation{
+		Method: http.MethodPost, Path: "/admin/orders/:orderNumber/cancel", Tags: []string{"orders"},
+		Summary:  "Отмена неоплаченного заказа: снятие удержания в банке и освобождение промокода; 409, если банк уже провел оплату",
+		Security: staffSecurity,
+		Response: OrderStatusResponse{},
+	})
+	spec.Add(openapi.Operation{
+		Method: http.MethodGet, Path: "/admin/orders", Tags: []string{"orders"},
+		Summary:  "Поиск заказов",
+		Security: staffSecurity,
+		Query: []*openapi3.Parameter{
+			queryParam("status", "Статус заказа", openapi3.NewStringSchema()),
+			queryParam("user_id", "ID пользователя", openapi3.NewStringSchema()),
+			queryParam("order_number", "Номер заказа", openapi3.NewStringSchema()),
+			queryParam("alfabank_order_id", "ID заказа в банке", openapi3.NewStringSchema()),
+			queryParam("merchant_id", "ID магазина", openapi3.NewIntegerSchema().WithMin(1)),
+			queryParam("limit", "Размер страницы", openapi3.NewIntegerSchema().WithMin(0).WithMax(maxOrderSearchLimit)),
+			queryParam("offset", "Смещение", openapi3.NewIntegerSchema().WithMin(0)),
+		},
+		Response: []Order{},
+	})
+	spec.Add(openapi.Operation{
+		Method: http.MethodGet, Path: "/admin/orders/:orderNumber/payment-details", Tags: []string{"orders"},
+		Summary:  "Данные попытки оплаты: маска карты, держатель, IP плательщика",
+		Security: staffSecurity,
+		Response: PaymentDetails{},
+	})
+	spec.Add(openapi.Operation{
+		Method: http.MethodGet, Path: "/admin/orders/:orderNumber/timeline", Tags: []string{"orders"},
+		Summary:  "Журнал заказа: смены статуса, выдача, погашение и возврат купонов с источником и исполнителем",
+		Security: staffSecurity,
+		Response: OrderTimelineResponse{},
+	})
+
+	// Купоны пользователя
+	spec.Add(openapi.Operation{
+		Method: http.MethodGet, Path: "/users/:userID/coupons", Tags: []string{"users"},
+		Summary:  "Купоны пользователя",
+		Security: userSecurity,
+		Response: []UserCoupon{},
+	})
+	spec.Add(openapi.Operation{
+		Method: http.MethodGet, Path: "/users/:userID/orders", Tags: []string{"users"},
+		Summary:  "Заказы пользователя",
+		Security: userSecurity,
+		Response: []Order{},
+	})
+	spec.Add(openapi.Operation{
+		Method: http.MethodPost, Path: "/users/:userID/orders/:orderNumber/cancel", Tags: []string{"users"},
+		Summary:  "Отмена своего неоплаченного заказа; 409, если банк уже провел оплату",
+		Security: userSecurity,
+		Response: OrderStatusResponse{},
+	})
+	spec.Add(openapi.Operation{
+		Method: http.MethodGet, Path: "/users/:userID/events", Tags: []string{"users"},
+		Summary:  "Поток SSE с изменениями заказов пользователя; для EventSource токен передается в access_token",
+		Security: userSecurity,
+		Query: []*openapi3.Parameter{
+			queryParam("access_token", "JWT, если нельзя передать заголовок Authorization", openapi3.NewStringSchema()),
+		},
+		Response:    OrderEvent{},
+		ContentType: eventStream,
+	})
+	spec.Add(openapi.Operation{
+		Method: http.MethodGet, Path: "/users/:userID/coupons/:userCouponID/barcode", Tags: []string{"users"},
+		Summary:   "QR-код или штрихкод купона",
+		Security:  userSecurity,
+		PathTypes: userCouponID,
+		Query: []*openapi3.Parameter{
+			queryParam("type", "Вид кода", openapi3.NewStringSchema().WithEnum(BarcodeKindQR, BarcodeKindCode128)),
+			queryParam("format", "Формат изображения", openapi3.NewStringSchema().WithEnum(ImageFormatPNG, ImageFormatSVG)),
+		},
+		ContentType: "image/png",
+	})
+	spec.Add(openapi.Operation{
+		Method: http.MethodGet, Path: "/coupons/code/:code", Tags: []string{"users"},
+		Summary:  "Купон по коду",
+		Response: CouponCodeResponse{},
+	})
+
+	// Корзина
+	spec.Add(openapi.Operation{
+		Method: http.MethodGet, Path: "/users/:userID/cart", Tags: []string{"cart"},
+		Summary:  "Корзина пользователя",
+		Security: userSecurity,
+		Response: CartResponse{},
+	})
+	spec.Add(openapi.Operation{
+		Method: http.MethodPut, Path: "/users/:userID/cart/items/:couponID", Tags: []string{"cart"},
+		Summary:   "Количество купона в корзине; 0 удаляет позицию",
+		Security:  userSecurity,
+		PathTypes: couponID,
+		Request:   CartItemRequest{},
+		Response:  CartResponse{},
+	})
+	spec.Add(openapi.Operation{
+		Method: http.MethodDelete, Path: "/users/:userID/cart/items/:couponID", Tags: []string{"cart"},
+		Summary:   "Удаление позиции из корзины",
+		Security:  userSecurity,
+		PathTypes: couponID,
+		Response:  CartResponse{},
+	})
+	spec.Add(openapi.Operation{
+		Method: http.MethodDelete, Path: "/users/:userID/cart", Tags: []string{"cart"},
+		Summary:  "Очистка корзины",
+		Security: userSecurity,
+		Status:   http.StatusNoContent,
+	})
+	spec.Add(openapi.Operation{
+		Method: http.MethodPost, Path: "/users/:userID/cart/checkout", Tags: []string{"cart"},
+		Summary:  "Оформление заказа из корзины",
+		Security: userSecurity,
+		Request:  CheckoutRequest{},
+		Response: CreateOrderResponse{},
+	})
+
+	// Подарки
+	spec.Add(openapi.Operation{
+		Method: http.MethodPost, Path: "/users/:userID/coupons/:userCouponID/gifts", Tags: []string{"gifts"},
+		Summary:   "Подарок купона другому пользователю",
+		Security:  userSecurity,
+		PathTypes: userCouponID,
+		Request:   CreateGiftRequest{},
+		Response:  CreateGiftResponse{},
+		Status:    http.StatusCreated,
+	})
+	spec.Add(openapi.Operation{
+		Method: http.MethodDelete, Path: "/users/:userID/gifts/:giftID", Tags: []string{"gifts"},
+		Summary:   "Отмена подарка",
+		Security:  userSecurity,
+		PathTypes: map[string]string{"giftID": "integer"},
+		Response:  map[string]bool{},
+	})
+	spec.Add(openapi.Operation{
+		Method: http.MethodGet, Path: "/gifts/:token", Tags: []string{"gifts"},
+		Summary:  "Подарок по ссылке",
+		Response: GiftResponse{},
+	})
+	spec.Add(openapi.Operation{
+		Method: http.MethodPost, Path: "/gifts/:token/accept", Tags: []string{"gifts"},
+		Summary:  "Принятие подарка",
+		Security: userSecurity,
+		Request:  AcceptGiftRequest{},
+		Response: UserCoupon{},
+	})
+
+	// Партнеры
+	spec.Add(openapi.Operation{
+		Method: http.MethodGet, Path: "/admin/merchants", Tags: []string{"merchant"},
+		Summary:  "Список партнеров",
+		Security: staffSecurity,
+		Response: []Merchant{},
+	})
+	spec.Add(openapi.Operation{
+		Method: http.MethodPost, Path: "/admin/merchants", Tags: []string{"merchant"},
+		Summary:  "Создание партнера; API-ключ возвращается один раз",
+		Security: staffSecurity,
+		Request:  MerchantRequest{},
+		Response: CreateMerchantResponse{},
+		Status:   http.StatusCreated,
+	})
+	spec.Add(openapi.Operation{
+		Method: http.MethodPut, Path: "/admin/merchants/:merchantID", Tags: []string{"merchant"},
+		Summary:   "Изменение партнера, его учетной записи в шлюзе и оформления",
+		Security:  staffSecurity,
+		PathTypes: map[string]string{"merchantID": "integer"},
+		Request:   MerchantRequest{},
+		Response:  Merchant{},
+	})
+	spec.Add(openapi.Operation{
+		Method: http.MethodPost, Path: "/merchant/redemptions", Tags: []string{"merchant"},
+		Summary:  "Погашение купона партнером",
+		Security: merchantSecurity,
+		Request:  RedeemCouponRequest{},
+		Response: RedeemCouponResponse{},
+	})
+
+	// Платежи
+	spec.Add(openapi.Operation{
+		Method: http.MethodGet, Path: "/payment/return", Tags: []string{"payment"},
+		Summary: "Возврат покупателя с платежной страницы банка",
+		Query: []*openapi3.Parameter{
+			queryParam("orderNumber", "Номер заказа", openapi3.NewStringSchema()),
+		},
+		ContentType: fiber.MIMETextHTML,
+	})
+	spec.Add(openapi.Operation{
+		Method: http.MethodPost, Path: "/payment/notification", Tags: []string{"payment"},
+		Summary:     "Уведомление банка о платеже (форма: orderNumber, orderId)",
+		ContentType: fiber.MIMETextPlain,
+	})
+
+	// Тестовые страницы
+	spec.Add(openapi.Operation{
+		Method: http.MethodGet, Path: "/test", Tags: []string{"test"},
+		Summary:     "Тестовая страница",
+		ContentType: fiber.MIMETextHTML,
+	})
+	spec.Add(openapi.Operation{
+		Method: http.MethodGet, Path: "/test/payment", Tags: []string{"test"},
+		Summary:     "Форма тестового платежа",
+		ContentType: fiber.MIMETextHTML,
+	})
+}
+
+func queryParam(name, description string, schema *openapi3.Schema) *openapi3.Parameter {
+	return openapi3.NewQueryParameter(name).WithDescription(description).WithSchema(schema)
+}
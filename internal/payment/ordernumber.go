@@ -0,0 +1,180 @@
+package payment
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+
+	"github.com/skr1ms/PaymentAlphaBank.git/config"
+)
+
+// Ограничения номера заказа в шлюзе: orderNumber register.do — до 32
+// символов; используются только латинские буквы, цифры и дефис
+const alfaOrderNumberMaxLength = 32
+
+const (
+	orderSerialWidth     = 8  // номера из последовательности дополняются нулями до 8 цифр
+	orderSerialMaxLength = 12 // длина, под которую проверяется формат: до триллиона заказов
+	orderNumberAttempts  = 3  // попытки создать заказ при занятом номере
+)
+
+const (
+	decimalAlphabet = "0123456789"
+	ulidAlphabet    = "0123456789ABCDEFGHJKMNPQRSTVWXYZ" // base32 Крокфорда
+	ulidLength      = 26
+)
+
+// Источник порядковых номеров заказов. Номер, выданный в отмененной
+// транзакции, повторно не выдается
+type OrderSequence interface {
+	NextOrderSerial(ctx context.Context) (int64, error)
+}
+
+// Генератор номеров заказов вида CP-251019-000000427: префикс, дата,
+// номер из последовательности или ULID и контрольный символ. В номере нет
+// данных покупателя, он уходит в банк как orderNumber
+type OrderNumberGenerator struct {
+	prefix     string
+	dateLayout string
+	ulid       bool
+	checkDigit bool
+	sequence   OrderSequence
+	now        func() time.Time
+}
+
+func NewOrderNumberGenerator(format config.OrderNumberConfig, sequence OrderSequence) (*OrderNumberGenerator, error) {
+	g := &OrderNumberGenerator{
+		prefix:     format.Prefix,
+		checkDigit: format.CheckDigit,
+		sequence:   sequence,
+		now:        time.Now,
+	}
+
+	switch format.DateFormat {
+	case "YYMMDD":
+		g.dateLayout = "060102"
+	case "YYYYMMDD":
+		g.dateLayout = "20060102"
+	case "", "none":
+	default:
+		return nil, fmt.Errorf("неизвестный формат даты номера заказа %q", format.DateFormat)
+	}
+
+	switch format.Source {
+	case "", "sequence":
+	case "ulid":
+		g.ulid = true
+	default:
+		return nil, fmt.Errorf("неизвестный источник номера заказа %q", format.Source)
+	}
+
+	for i := 0; i < len(g.prefix); i++ {
+		if strings.IndexByte(decimalAlphabet+"ABCDEFGHIJKLMNOPQRSTUVWXYZ", g.prefix[i]) < 0 {
+			return nil, fmt.Errorf("неверный префикс номера заказа %q: только заглавные латинские буквы и цифры", g.prefix)
+		}
+	}
+
+	if n := g.maxLength(); n > alfaOrderNumberMaxLength {
+		return nil, fmt.Errorf("номер заказа в этом формате занимает до %d символов, банк принимает не более %d: сократите префикс или уберите дату",
+			n, alfaOrderNumberMaxLength)
+	}
+	return g, nil
+}
+
+// Наибольшая длина номера в формате генератора
+func (g *OrderNumberGenerator) maxLength() int {
+	n := orderSerialMaxLength
+	if g.ulid {
+		n = ulidLength
+	}
+	if g.checkDigit {
+		n++
+	}
+	if g.prefix != "" {
+		n += len(g.prefix) + 1
+	}
+	if g.dateLayout != "" {
+		n += len(g.dateLayout) + 1
+	}
+	return n
+}
+
+func (g *OrderNumberGenerator) Next(ctx context.Context) (string, error) {
+	now := g.now()
+
+	var serial, alphabet string
+	if g.ulid {
+		id, err := newULID(now)
+		if err != nil {
+			return "", err
+		}
+		serial, alphabet = id, ulidAlphabet
+	} else {
+		n, err := g.sequence.NextOrderSerial(ctx)
+		if err != nil {
+			return "", fmt.Errorf("ошибка получения номера заказа: %w", err)
+		}
+		serial, alphabet = fmt.Sprintf("%0*d", orderSerialWidth, n), decimalAlphabet
+	}
+
+	var parts []string
+	if g.prefix != "" {
+		parts = append(parts, g.prefix)
+	}
+	date := ""
+	if g.dateLayout != "" {
+		date = now.Format(g.dateLayout)
+		parts = append(parts, date)
+	}
+	// Контрольный символ защищает дату и номер от опечаток при ручном вводе
+	if g.checkDigit {
+		serial += string(luhnCheckChar(alphabet, date+serial))
+	}
+	number := strings.Join(append(parts, serial), "-")
+
+	if len(number) > alfaOrderNumberMaxLength {
+		return "", fmt.Errorf("номер заказа %s длиннее %d символов", number, alfaOrderNumberMaxLength)
+	}
+	return number, nil
+}
+
+// Контрольный символ по алгоритму Луна для основания алфавита; цифры даты
+// входят в оба алфавита
+func luhnCheckChar(alphabet, s string) byte {
+	n := len(alphabet)
+	factor := 2
+	sum := 0
+	for i := len(s) - 1; i >= 0; i-- {
+		addend := factor * strings.IndexByte(alphabet, s[i])
+		factor = 3 - factor
+		sum += addend/n + addend%n
+	}
+	return alphabet[(n-sum%n)%n]
+}
+
+// ULID: 48 бит времени в миллисекундах и 80 случайных бит, 26 символов
+// base32 Крокфорда. Номера сортируются по времени создания
+func newULID(now time.Time) (string, error) {
+	raw := make([]byte, 16)
+	ms := uint64(now.UnixMilli())
+	for i := 5; i >= 0; i-- {
+		raw[i] = byte(ms)
+		ms >>= 8
+	}
+	if _, err := rand.Read(raw[6:]); err != nil {
+		return "", fmt.Errorf("ошибка генерации номера заказа: %w", err)
+	}
+
+	value := new(big.Int).SetBytes(raw)
+	base := big.NewInt(int64(len(ulidAlphabet)))
+	digit := new(big.Int)
+	id := make([]byte, ulidLength)
+	for i := ulidLength - 1; i >= 0; i-- {
+		value.DivMod(value, base, digit)
+		id[i] = ulidAlphabet[digit.Int64()]
+	}
+	return string(id), nil
+}
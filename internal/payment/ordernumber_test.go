@@ -0,0 +1,111 @@
+package payment
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/skr1ms/PaymentAlphaBank.git/config"
+)
+
+type fixedSequence int64
+
+func (s fixedSequence) NextOrderSerial(ctx context.Context) (int64, error) {
+	return int64(s), nil
+}
+
+var alfaOrderNumberPattern = regexp.MustCompile(`^[A-Z0-9-]+$`)
+
+// Самые длинные допустимые форматы: номер из 12 цифр и ULID с контрольным
+// символом укладываются в ограничения банка, а формат на символ длиннее
+// отклоняется при запуске
+func TestOrderNumberLongestFormat(t *testing.T) {
+	tests := []struct {
+		name   string
+		format config.OrderNumberConfig
+	}{
+		{"sequence", config.OrderNumberConfig{Prefix: "ABCDEFGH9", DateFormat: "YYYYMMDD", Source: "sequence", CheckDigit: true}},
+		{"ulid", config.OrderNumberConfig{Prefix: "ABC9", DateFormat: "none", Source: "ulid", CheckDigit: true}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g, err := NewOrderNumberGenerator(tt.format, fixedSequence(999_999_999_999))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n := g.maxLength(); n != alfaOrderNumberMaxLength {
+				t.Fatalf("наибольшая длина формата %d, ожидалось %d", n, alfaOrderNumberMaxLength)
+			}
+
+			number, err := g.Next(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(number) > alfaOrderNumberMaxLength {
+				t.Fatalf("номер %s длиннее %d символов", number, alfaOrderNumberMaxLength)
+			}
+			if !alfaOrderNumberPattern.MatchString(number) {
+				t.Fatalf("номер %s содержит символы кроме A-Z, 0-9 и дефиса", number)
+			}
+
+			longer := tt.format
+			longer.Prefix += "X"
+			if _, err := NewOrderNumberGenerator(longer, fixedSequence(1)); err == nil {
+				t.Fatalf("формат с префиксом %s длиннее %d символов принят", longer.Prefix, alfaOrderNumberMaxLength)
+			}
+		})
+	}
+}
+
+// Любая замена одного символа даты, номера или контрольного символа
+// обнаруживается проверкой по алгоритму Луна
+func TestOrderNumberCheckDigitDetectsTypo(t *testing.T) {
+	tests := []struct {
+		name     string
+		format   config.OrderNumberConfig
+		alphabet string
+	}{
+		{"sequence", config.OrderNumberConfig{Prefix: "CP", DateFormat: "YYMMDD", Source: "sequence", CheckDigit: true}, decimalAlphabet},
+		{"ulid", config.OrderNumberConfig{Prefix: "CP", DateFormat: "none", Source: "ulid", CheckDigit: true}, ulidAlphabet},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g, err := NewOrderNumberGenerator(tt.format, fixedSequence(427))
+			if err != nil {
+				t.Fatal(err)
+			}
+			g.now = func() time.Time { return time.Date(2025, 10, 19, 12, 0, 0, 0, time.UTC) }
+
+			number, err := g.Next(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			// Префикс контрольным символом не защищен
+			payload := strings.ReplaceAll(strings.TrimPrefix(number, tt.format.Prefix+"-"), "-", "")
+			if !luhnValid(tt.alphabet, payload) {
+				t.Fatalf("номер %s не проходит проверку контрольного символа", number)
+			}
+
+			for i := 0; i < len(payload); i++ {
+				for j := 0; j < len(tt.alphabet); j++ {
+					if tt.alphabet[j] == payload[i] {
+						continue
+					}
+					typo := payload[:i] + string(tt.alphabet[j]) + payload[i+1:]
+					if luhnValid(tt.alphabet, typo) {
+						t.Fatalf("опечатка %s в номере %s прошла проверку", typo, number)
+					}
+				}
+			}
+		})
+	}
+}
+
+func luhnValid(alphabet, s string) bool {
+	last := len(s) - 1
+	return luhnCheckChar(alphabet, s[:last]) == s[last]
+}
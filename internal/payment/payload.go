@@ -1,27 +1,206 @@
 package payment
 
+import "time"
+
 type CreateOrderRequest struct {
-	CouponID  int64  `json:"coupon_id"`
-	UserID    string `json:"user_id"`
-	ReturnURL string `json:"return_url"`
-	FailURL   string `json:"fail_url,omitempty"`
+	CouponID  int64              `json:"coupon_id,omitempty" validate:"min=1"` // заказ из одного купона
+	Items     []OrderItemRequest `json:"items,omitempty"`                      // или несколько позиций
+	UserID    string             `json:"user_id,omitempty" validate:"min=1"`   // по умолчанию — пользователь из токена
+	ReturnURL string             `json:"return_url,omitempty" validate:"url"`
+	FailURL   string             `json:"fail_url,omitempty" validate:"url"`
+	Language  string             `json:"language,omitempty"` // по умолчанию — язык запроса
+	PromoCode string             `json:"promo_code,omitempty"`
+}
+
+type OrderItemRequest struct {
+	CouponID int64 `json:"coupon_id" validate:"required,min=1"`
+	Quantity int   `json:"quantity" validate:"required,min=1,max=10"`
 }
 
 type CreateOrderResponse struct {
-	OrderID    int64  `json:"order_id"`
-	PaymentURL string `json:"payment_url"`
-	Success    bool   `json:"success"`
-	Message    string `json:"message,omitempty"`
+	OrderID     int64   `json:"order_id"`
+	OrderNumber string  `json:"order_number,omitempty"`
+	PaymentURL  string  `json:"payment_url"`
+	Amount      float64 `json:"amount,omitempty"`
+	Discount    float64 `json:"discount,omitempty"`
+	Success     bool    `json:"success"`
+	Message     string  `json:"message,omitempty"`
 }
 
 type OrderStatusResponse struct {
-	OrderID    int64   `json:"order_id"`
-	Status     string  `json:"status"`
-	CouponName string  `json:"coupon_name,omitempty"`
-	Amount     float64 `json:"amount"`
-	Currency   string  `json:"currency"`
-	Success    bool    `json:"success"`
-	Message    string  `json:"message,omitempty"`
+	OrderID    int64        `json:"order_id"`
+	MerchantID int64        `json:"merchant_id,omitempty"`
+	Status     string       `json:"status"`
+	CouponName string       `json:"coupon_name,omitempty"`
+	Items      []*OrderItem `json:"items,omitempty"`
+	Amount     float64      `json:"amount"`
+	Discount   float64      `json:"discount,omitempty"`
+	Refunded   float64      `json:"refunded,omitempty"`
+	Currency   string       `json:"currency"`
+	Success    bool         `json:"success"`
+	Message    string       `json:"message,omitempty"`
+}
+
+type CartItemRequest struct {
+	Quantity int `json:"quantity" validate:"required,min=0,max=10"` // 0 удаляет позицию
+}
+
+type CartItemResponse struct {
+	CouponID  int64   `json:"coupon_id"`
+	Name      string  `json:"name"`
+	ImageURL  string  `json:"image_url,omitempty"`
+	Quantity  int     `json:"quantity"`
+	UnitPrice float64 `json:"unit_price"`
+	Amount    float64 `json:"amount"`
+}
+
+type CartResponse struct {
+	Items    []CartItemResponse `json:"items"`
+	Quantity int                `json:"quantity"`
+	Total    float64            `json:"total"`
+	Currency string             `json:"currency"`
+}
+
+type CheckoutRequest struct {
+	ReturnURL string `json:"return_url,omitempty" validate:"url"`
+	FailURL   string `json:"fail_url,omitempty" validate:"url"`
+	Language  string `json:"language,omitempty"`
+	PromoCode string `json:"promo_code,omitempty"`
+}
+
+type OrderSearchRequest struct {
+	Status          string `query:"status"`
+	UserID          string `query:"user_id"`
+	OrderNumber     string `query:"order_number"`
+	AlfaBankOrderID string `query:"alfabank_order_id"`
+	MerchantID      int64  `query:"merchant_id"`
+	Limit           int    `query:"limit"`
+	Offset          int    `query:"offset"`
+}
+
+type RefundRequest struct {
+	Items []RefundItemRequest `json:"items" validate:"required,min=1"`
+}
+
+type RefundItemRequest struct {
+	ItemID   int64 `json:"item_id" validate:"required,min=1"`
+	Quantity int   `json:"quantity" validate:"required,min=1"`
+}
+
+type RefundResponse struct {
+	OrderID        int64   `json:"order_id"`
+	Status         string  `json:"status"`
+	Amount         float64 `json:"amount"`          // сумма этого возврата
+	RefundedAmount float64 `json:"refunded_amount"` // всего возвращено по заказу
+	Success        bool    `json:"success"`
+}
+
+// Журнал заказа для разбора спорных платежей
+type OrderTimelineResponse struct {
+	OrderID     int64         `json:"order_id"`
+	OrderNumber string        `json:"order_number"`
+	Status      string        `json:"status"`
+	Events      []OrderChange `json:"events"`
+}
+
+type CouponTranslationRequest struct {
+	Name        string `json:"name" validate:"required,min=1"`
+	Description string `json:"description"`
+}
+
+type CouponCodeResponse struct {
+	Code        string     `json:"code"`
+	CouponName  string     `json:"coupon_name"`
+	Description string     `json:"description,omitempty"`
+	IsUsed      bool       `json:"is_used"`
+	ActivatedAt time.Time  `json:"activated_at"`
+	UsedAt      *time.Time `json:"used_at,omitempty"`
+}
+
+type RedeemCouponRequest struct {
+	Code       string `json:"code,omitempty"`
+	Payload    string `json:"payload,omitempty"`                 // подписанный код из QR или штрихкода
+	UserID     string `json:"user_id" validate:"required,min=1"` // покупатель, предъявивший купон
+	TerminalID string `json:"terminal_id,omitempty"`
+}
+
+type RedeemCouponResponse struct {
+	Code       string    `json:"code"`
+	CouponName string    `json:"coupon_name"`
+	UserID     string    `json:"user_id"`
+	MerchantID int64     `json:"merchant_id"`
+	TerminalID string    `json:"terminal_id,omitempty"`
+	RedeemedAt time.Time `json:"redeemed_at"`
+	Success    bool      `json:"success"`
+}
+
+// Данные вебхуков order.paid и order.refunded
+type OrderWebhookData struct {
+	Order        *Order  `json:"order"`
+	RefundAmount float64 `json:"refund_amount,omitempty"` // сумма этого возврата в рублях
+}
+
+// Данные вебхука coupon.redeemed
+type CouponRedeemedWebhookData struct {
+	RedeemCouponResponse
+	UserCouponID int64  `json:"user_coupon_id"`
+	CouponID     int64  `json:"coupon_id"`
+	OrderNumber  string `json:"order_number"`
+}
+
+// Данные события outbox coupon.activated
+type CouponActivatedEventData struct {
+	OrderNumber string `json:"order_number"`
+	UserID      string `json:"user_id"`
+	MerchantID  int64  `json:"merchant_id,omitempty"`
+	Activated   int    `json:"activated"` // число выданных купонов
+}
+
+// Создание и изменение партнера. Пароль шлюза в ответах не возвращается;
+// при изменении пустой пароль сохраняет прежний
+type MerchantRequest struct {
+	Name            string           `json:"name" validate:"required,min=1"`
+	GatewayURL      string           `json:"gateway_url,omitempty" validate:"url"`
+	GatewayUsername string           `json:"gateway_username,omitempty"`
+	GatewayPassword string           `json:"gateway_password,omitempty"`
+	ReturnURL       string           `json:"return_url,omitempty" validate:"url"`
+	FailURL         string           `json:"fail_url,omitempty" validate:"url"`
+	Branding        MerchantBranding `json:"branding"`
+}
+
+// Партнер с API-ключом: ключ показывается только при создании
+type CreateMerchantResponse struct {
+	Merchant
+	APIKey string `json:"api_key"`
+}
+
+type CouponMerchantRequest struct {
+	MerchantID int64 `json:"merchant_id" validate:"min=0"` // 0 — общий каталог
+}
+
+type CreateGiftRequest struct {
+	ToUserID string `json:"to_user_id,omitempty" validate:"min=1"`
+}
+
+type CreateGiftResponse struct {
+	GiftID    int64     `json:"gift_id"`
+	Token     string    `json:"token"`
+	GiftURL   string    `json:"gift_url"`
+	ToUserID  string    `json:"to_user_id,omitempty"`
+	ExpiresAt time.Time `json:"expires_at"`
+	Success   bool      `json:"success"`
+}
+
+type GiftResponse struct {
+	GiftID     int64     `json:"gift_id"`
+	CouponName string    `json:"coupon_name"`
+	FromUserID string    `json:"from_user_id"`
+	Status     string    `json:"status"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+type AcceptGiftRequest struct {
+	UserID string `json:"user_id,omitempty" validate:"min=1"` // по умолчанию — пользователь из токена
 }
 
 type AlfaBankRegisterRequest struct {
@@ -35,6 +214,7 @@ type AlfaBankRegisterRequest struct {
 	ClientId           string `json:"clientId,omitempty"`
 	JsonParams         string `json:"jsonParams,omitempty"`
 	SessionTimeoutSecs int    `json:"sessionTimeoutSecs,omitempty"`
+	OrderBundle        string `json:"orderBundle,omitempty"` // корзина для фискального чека
 }
 
 type AlfaBankRegisterResponse struct {
@@ -56,4 +236,74 @@ type AlfaBankStatusResponse struct {
 	Date                  int64  `json:"date"`
 	Ip                    string `json:"ip"`
 	OrderDescription      string `json:"orderDescription"`
+	Pan                   string `json:"Pan"` // маска номера карты
+	Expiration            string `json:"expiration"`
+	CardholderName        string `json:"cardholderName"`
+	ApprovalCode          string `json:"approvalCode"`
+}
+
+// Ответ getOrderStatusExtended.do; используются только суммы
+type AlfaBankExtendedStatusResponse struct {
+	ErrorCode         string                    `json:"errorCode"`
+	ErrorMessage      string                    `json:"errorMessage,omitempty"`
+	OrderStatus       int                       `json:"orderStatus"`
+	PaymentAmountInfo AlfaBankPaymentAmountInfo `json:"paymentAmountInfo"`
+}
+
+// Суммы по заказу в копейках
+type AlfaBankPaymentAmountInfo struct {
+	PaymentState    string `json:"paymentState"`
+	ApprovedAmount  int64  `json:"approvedAmount"`
+	DepositedAmount int64  `json:"depositedAmount"`
+	RefundedAmount  int64  `json:"refundedAmount"`
+}
+
+type AlfaBankRefundRequest struct {
+	OrderId     string `json:"orderId"`
+	Amount      int64  `json:"amount"`
+	RefundItems string `json:"refundItems,omitempty"` // возвращаемые позиции чека
+}
+
+type AlfaBankRefundResponse struct {
+	ErrorCode    string `json:"errorCode"`
+	ErrorMessage string `json:"errorMessage,omitempty"`
+}
+
+type AlfaBankReverseResponse struct {
+	ErrorCode    string `json:"errorCode"`
+	ErrorMessage string `json:"errorMessage,omitempty"`
+}
+
+// Корзина заказа для фискализации (orderBundle)
+type AlfaBankOrderBundle struct {
+	CartItems AlfaBankCartItems `json:"cartItems"`
+}
+
+type AlfaBankCartItems struct {
+	Items []AlfaBankCartItem `json:"items"`
+}
+
+type AlfaBankCartItem struct {
+	PositionID string            `json:"positionId"`
+	Name       string            `json:"name"`
+	Quantity   AlfaBankQuantity  `json:"quantity"`
+	ItemAmount int64             `json:"itemAmount"`
+	ItemCode   string            `json:"itemCode"`
+	ItemPrice  int64             `json:"itemPrice"`
+	Discount   *AlfaBankDiscount `json:"discount,omitempty"`
+	Tax        *AlfaBankTax      `json:"tax,omitempty"`
+}
+
+type AlfaBankQuantity struct {
+	Value   int    `json:"value"`
+	Measure string `json:"measure"`
+}
+
+type AlfaBankDiscount struct {
+	DiscountType  string `json:"discountType"`
+	DiscountValue int64  `json:"discountValue"`
+}
+
+type AlfaBankTax struct {
+	TaxType int `json:"taxType"`
 }
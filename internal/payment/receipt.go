@@ -0,0 +1,66 @@
+package payment
+
+import (
+	"encoding/json"
+	"strconv"
+)
+
+// Параметры позиций фискального чека
+const (
+	receiptMeasure      = "шт"
+	receiptTaxType      = 0 // без НДС
+	receiptDiscountType = "summ"
+)
+
+// Корзина orderBundle: по позиции чека на каждую позицию заказа
+func buildOrderBundle(items []*OrderItem) (string, error) {
+	bundle := AlfaBankOrderBundle{}
+	for _, item := range items {
+		bundle.CartItems.Items = append(bundle.CartItems.Items, receiptItem(item, item.Quantity, item.Amount))
+	}
+
+	data, err := json.Marshal(bundle)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// Позиции чека возврата; positionId совпадает с позицией в чеке оплаты
+func buildRefundItems(lines []RefundLine) (string, error) {
+	cart := AlfaBankCartItems{}
+	for _, line := range lines {
+		cart.Items = append(cart.Items, receiptItem(line.Item, line.Quantity, line.Amount))
+	}
+
+	data, err := json.Marshal(cart)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func receiptItem(item *OrderItem, quantity int, amount int64) AlfaBankCartItem {
+	cartItem := AlfaBankCartItem{
+		PositionID: strconv.Itoa(item.Position),
+		Name:       item.Name,
+		Quantity: AlfaBankQuantity{
+			Value:   quantity,
+			Measure: receiptMeasure,
+		},
+		ItemAmount: amount,
+		ItemCode:   "coupon-" + strconv.FormatInt(item.CouponID, 10),
+		ItemPrice:  item.UnitPrice,
+		Tax:        &AlfaBankTax{TaxType: receiptTaxType},
+	}
+
+	// Скидка по промокоду указывается суммой на позицию
+	if discount := item.UnitPrice*int64(quantity) - amount; discount > 0 {
+		cartItem.Discount = &AlfaBankDiscount{
+			DiscountType:  receiptDiscountType,
+			DiscountValue: discount,
+		}
+	}
+
+	return cartItem
+}
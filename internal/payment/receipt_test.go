@@ -0,0 +1,84 @@
+package payment
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestBuildOrderBundle(t *testing.T) {
+	items := []*OrderItem{
+		{Position: 1, CouponID: 10, Name: "Кофе", Quantity: 2, UnitPrice: 15000, Amount: 30000},
+		{Position: 2, CouponID: 20, Name: "Обед", Quantity: 3, UnitPrice: 50000, DiscountAmount: 1001, Amount: 148999},
+	}
+
+	data, err := buildOrderBundle(items)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var bundle AlfaBankOrderBundle
+	if err := json.Unmarshal([]byte(data), &bundle); err != nil {
+		t.Fatal(err)
+	}
+
+	cart := bundle.CartItems.Items
+	if len(cart) != len(items) {
+		t.Fatalf("позиций в чеке %d, ожидалось %d", len(cart), len(items))
+	}
+	if cart[0].PositionID != "1" || cart[0].ItemCode != "coupon-10" || cart[0].Quantity.Value != 2 || cart[0].ItemAmount != 30000 {
+		t.Fatalf("позиция без скидки: %+v", cart[0])
+	}
+	if cart[0].Discount != nil {
+		t.Fatalf("позиция без скидки получила скидку %+v", cart[0].Discount)
+	}
+	if cart[1].Discount == nil || cart[1].Discount.DiscountValue != 1001 || cart[1].ItemAmount != 148999 {
+		t.Fatalf("позиция со скидкой: %+v, скидка %+v", cart[1], cart[1].Discount)
+	}
+}
+
+// Чеки последовательных возвратов позиции в сумме дают ее чек оплаты:
+// суммы и скидки по позиции сходятся до копейки
+func TestBuildRefundItems(t *testing.T) {
+	item := &OrderItem{ID: 7, Position: 3, CouponID: 30, Name: "Ужин", Quantity: 7, UnitPrice: 10000, DiscountAmount: 1003, Amount: 68997}
+
+	var amount, discount int64
+	for _, quantity := range []int{3, 1, 2, 1} {
+		line := RefundLine{Item: item, Quantity: quantity, Amount: item.RefundAmount(quantity)}
+
+		data, err := buildRefundItems([]RefundLine{line})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var cart AlfaBankCartItems
+		if err := json.Unmarshal([]byte(data), &cart); err != nil {
+			t.Fatal(err)
+		}
+		if len(cart.Items) != 1 {
+			t.Fatalf("позиций в чеке возврата %d", len(cart.Items))
+		}
+
+		refunded := cart.Items[0]
+		if refunded.PositionID != "3" || refunded.Quantity.Value != quantity || refunded.ItemPrice != item.UnitPrice {
+			t.Fatalf("позиция чека возврата: %+v", refunded)
+		}
+		if refunded.ItemAmount != line.Amount {
+			t.Fatalf("сумма в чеке %d, сумма возврата %d", refunded.ItemAmount, line.Amount)
+		}
+		if refunded.Discount != nil {
+			if refunded.Discount.DiscountValue != item.UnitPrice*int64(quantity)-line.Amount {
+				t.Fatalf("скидка в чеке %d не сходится с суммой %d", refunded.Discount.DiscountValue, line.Amount)
+			}
+			discount += refunded.Discount.DiscountValue
+		}
+		amount += refunded.ItemAmount
+
+		item.RefundedQuantity += quantity
+		item.RefundedAmount += line.Amount
+	}
+
+	if amount != item.Amount {
+		t.Fatalf("сумма чеков возврата %d, сумма позиции %d", amount, item.Amount)
+	}
+	if discount != item.DiscountAmount {
+		t.Fatalf("скидка в чеках возврата %d, скидка позиции %d", discount, item.DiscountAmount)
+	}
+}
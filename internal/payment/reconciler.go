@@ -0,0 +1,73 @@
+package payment
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/skr1ms/PaymentAlphaBank.git/pkg/i18n"
+)
+
+// Параметры сверки зависших заказов
+const (
+	reconcileInterval = time.Minute
+	reconcileBatch    = 100
+	// Заказ моложе этого возраста еще может получить уведомление банка
+	reconcileMinAge = time.Minute
+	// Старше — сессия оплаты давно истекла, заказ больше не опрашивается
+	reconcileMaxAge = 24 * time.Hour
+	// Возврат моложе этого возраста еще может ждать ответа банка
+	reconcileRefundMinAge = 5 * time.Minute
+)
+
+// Сверка заказов в ожидании оплаты со статусом в банке на случай
+// потерянных уведомлений и незавершенных возвратов. Изменения публикуются
+// в шину событий заказов через CheckOrderStatus и ReconcileRefunds
+type Reconciler struct {
+	orderRepo     *OrderRepository
+	couponService *CouponService
+}
+
+func NewReconciler(orderRepo *OrderRepository, couponService *CouponService) *Reconciler {
+	return &Reconciler{
+		orderRepo:     orderRepo,
+		couponService: couponService,
+	}
+}
+
+// Периодическая сверка до отмены ctx
+func (r *Reconciler) Run(ctx context.Context) {
+	ticker := time.NewTicker(reconcileInterval)
+	defer ticker.Stop()
+
+	for {
+		r.reconcile(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (r *Reconciler) reconcile(ctx context.Context) {
+	ctx = WithChangeSource(ctx, ChangeSourceReconciler, ActorSystem)
+	now := time.Now()
+	orders, err := r.orderRepo.GetPending(ctx, now.Add(-reconcileMaxAge), now.Add(-reconcileMinAge), reconcileBatch)
+	if err != nil {
+		log.Printf("Ошибка получения заказов для сверки: %v", err)
+		return
+	}
+
+	for _, order := range orders {
+		if ctx.Err() != nil {
+			return
+		}
+		if _, err := r.couponService.CheckOrderStatus(ctx, order.OrderNumber, i18n.DefaultLocale); err != nil {
+			log.Printf("Ошибка сверки заказа %s: %v", order.OrderNumber, err)
+		}
+	}
+
+	r.couponService.ReconcileRefunds(ctx, now.Add(-reconcileRefundMinAge), reconcileBatch)
+}
@@ -5,9 +5,15 @@ import (
     "fmt"
     "time"
 
+    "github.com/skr1ms/PaymentAlphaBank.git/pkg/db"
+    "github.com/skr1ms/PaymentAlphaBank.git/pkg/i18n"
+    "github.com/skr1ms/PaymentAlphaBank.git/pkg/secret"
     "github.com/uptrace/bun"
 )
 
+// Количество попыток сгенерировать уникальный код купона
+const couponCodeAttempts = 5
+
 type CouponRepository struct {
     db *bun.DB
 }
@@ -16,19 +22,169 @@ func NewCouponRepository(db *bun.DB) *CouponRepository {
     return &CouponRepository{db: db}
 }
 
-func (r *CouponRepository) GetActiveCoupons(ctx context.Context) ([]Coupon, error) {
+// Выражение ранжирования для полнотекстового поиска с русской морфологией
+const couponRankExpr = "ts_rank(coupon.search_vector, websearch_to_tsquery('russian', ?))::float8"
+
+func (r *CouponRepository) GetActiveCoupons(ctx context.Context, filter *CouponFilter) ([]Coupon, error) {
     var coupons []Coupon
-    err := r.db.NewSelect().
+    q := db.Conn(ctx, r.db).NewSelect().
         Model(&coupons).
-        Where("is_active = ?", true).
-        Order("created_at DESC").
-        Scan(ctx)
+        ColumnExpr("?TableColumns").
+        Relation("Category").
+        Where("coupon.is_active = ?", true)
+
+    if filter.Query != "" {
+        q = q.ColumnExpr(couponRankExpr+" AS rank", filter.Query).
+            Where("coupon.search_vector @@ websearch_to_tsquery('russian', ?)", filter.Query)
+    }
+    if filter.Category != "" {
+        q = q.Where("category.slug = ?", filter.Category)
+    }
+    if filter.Tag != "" {
+        q = q.Where("? = ANY(coupon.tags)", filter.Tag)
+    }
+    if filter.MerchantID != 0 {
+        q = q.Where("coupon.merchant_id = ?", filter.MerchantID)
+    }
+    if filter.MinPrice != nil {
+        q = q.Where("coupon.price >= ?", *filter.MinPrice)
+    }
+    if filter.MaxPrice != nil {
+        q = q.Where("coupon.price <= ?", *filter.MaxPrice)
+    }
+
+    // Ключ сортировки, направление и условие курсора
+    var key string
+    var keyArgs []interface{}
+    desc := false
+    switch filter.Sort {
+    case SortPriceAsc:
+        key = "coupon.price"
+    case SortPriceDesc:
+        key, desc = "coupon.price", true
+    case SortNameAsc:
+        key = "coupon.name"
+    case SortRelevance:
+        key, keyArgs, desc = couponRankExpr, []interface{}{filter.Query}, true
+    default:
+        key, desc = "coupon.created_at", true
+    }
+
+    if filter.Cursor != nil {
+        value, err := filter.Cursor.sortValue()
+        if err != nil {
+            return nil, err
+        }
+        op := ">"
+        if desc {
+            op = "<"
+        }
+        args := append(append([]interface{}{}, keyArgs...), value, filter.Cursor.ID)
+        q = q.Where("("+key+", coupon.id) "+op+" (?, ?)", args...)
+    }
+
+    direction := " ASC"
+    if desc {
+        direction = " DESC"
+    }
+    q = q.OrderExpr(key+direction, keyArgs...).
+        OrderExpr("coupon.id" + direction).
+        Limit(filter.Limit)
+
+    err := q.Scan(ctx)
     return coupons, err
 }
 
+// Подстановка переводов названий и описаний купонов для языка
+func (r *CouponRepository) Localize(ctx context.Context, locale string, coupons ...*Coupon) error {
+    if locale == i18n.DefaultLocale || len(coupons) == 0 {
+        return nil
+    }
+
+    ids := make([]int64, 0, len(coupons))
+    for _, coupon := range coupons {
+        if coupon != nil {
+            ids = append(ids, coupon.ID)
+        }
+    }
+    if len(ids) == 0 {
+        return nil
+    }
+
+    var translations []CouponTranslation
+    err := db.Conn(ctx, r.db).NewSelect().
+        Model(&translations).
+        Where("coupon_id IN (?)", bun.In(ids)).
+        Where("locale = ?", locale).
+        Scan(ctx)
+    if err != nil {
+        return err
+    }
+
+    byCoupon := make(map[int64]*CouponTranslation, len(translations))
+    for i := range translations {
+        byCoupon[translations[i].CouponID] = &translations[i]
+    }
+
+    for _, coupon := range coupons {
+        if coupon == nil {
+            continue
+        }
+        if translation, ok := byCoupon[coupon.ID]; ok {
+            coupon.Name = translation.Name
+            coupon.Description = translation.Description
+        }
+    }
+
+    return nil
+}
+
+func (r *CouponRepository) SaveTranslation(ctx context.Context, translation *CouponTranslation) error {
+    translation.UpdatedAt = time.Now()
+    _, err := db.Conn(ctx, r.db).NewInsert().
+        Model(translation).
+        On("CONFLICT (coupon_id, locale) DO UPDATE").
+        Set("name = EXCLUDED.name").
+        Set("description = EXCLUDED.description").
+        Set("updated_at = EXCLUDED.updated_at").
+        Exec(ctx)
+    return err
+}
+
+func (r *CouponRepository) GetCategories(ctx context.Context) ([]Category, error) {
+    var categories []Category
+    err := db.Conn(ctx, r.db).NewSelect().
+        Model(&categories).
+        Order("name ASC").
+        Scan(ctx)
+    return categories, err
+}
+
+func (r *CouponRepository) UpdateImage(ctx context.Context, couponID int64, imageKey, imageURL string) error {
+    _, err := db.Conn(ctx, r.db).NewUpdate().
+        Model((*Coupon)(nil)).
+        Set("image_key = ?", imageKey).
+        Set("image_url = ?", imageURL).
+        Set("updated_at = ?", time.Now()).
+        Where("id = ?", couponID).
+        Exec(ctx)
+    return err
+}
+
+// Привязка купона к магазину; 0 возвращает купон в общий каталог
+func (r *CouponRepository) UpdateMerchant(ctx context.Context, couponID, merchantID int64) error {
+    _, err := db.Conn(ctx, r.db).NewUpdate().
+        Model((*Coupon)(nil)).
+        Set("merchant_id = NULLIF(?, 0)", merchantID).
+        Set("updated_at = ?", time.Now()).
+        Where("id = ?", couponID).
+        Exec(ctx)
+    return err
+}
+
 func (r *CouponRepository) GetByID(ctx context.Context, id int64) (*Coupon, error) {
     coupon := &Coupon{}
-    err := r.db.NewSelect().
+    err := db.Conn(ctx, r.db).NewSelect().
         Model(coupon).
         Where("id = ? AND is_active = ?", id, true).
         Scan(ctx)
@@ -46,35 +202,151 @@ func NewOrderRepository(db *bun.DB) *OrderRepository {
     return &OrderRepository{db: db}
 }
 
+// Создание заказа вместе с позициями
 func (r *OrderRepository) Create(ctx context.Context, order *Order) error {
     order.CreatedAt = time.Now()
     order.UpdatedAt = time.Now()
-    _, err := r.db.NewInsert().Model(order).Exec(ctx)
-    return err
+
+    return db.RunInTx(ctx, r.db, func(ctx context.Context, tx bun.IDB) error {
+        if _, err := tx.NewInsert().Model(order).Exec(ctx); err != nil {
+            return err
+        }
+        if len(order.Items) == 0 {
+            return nil
+        }
+
+        for _, item := range order.Items {
+            item.OrderID = order.ID
+            item.CreatedAt = order.CreatedAt
+        }
+        _, err := tx.NewInsert().Model(&order.Items).Exec(ctx)
+        return err
+    })
+}
+
+func (r *OrderRepository) NextOrderSerial(ctx context.Context) (int64, error) {
+    var serial int64
+    err := db.Conn(ctx, r.db).QueryRowContext(ctx, "SELECT nextval('order_number_seq')").Scan(&serial)
+    return serial, err
 }
 
 func (r *OrderRepository) GetByOrderNumber(ctx context.Context, orderNumber string) (*Order, error) {
     order := &Order{}
-    err := r.db.NewSelect().
+    err := db.Conn(ctx, r.db).NewSelect().
+        Model(order).
+        Relation("Coupon").
+        Relation("Items", orderItemsByPosition).
+        Relation("Items.Coupon").
+        Where("order_number = ?", orderNumber).
+        Scan(ctx)
+    return order, err
+}
+
+// Заказ под блокировкой строки (SELECT ... FOR UPDATE) до конца транзакции
+// из контекста. Блокируется только заказ: купоны и позиции подгружаются
+// отдельными запросами, а LEFT JOIN купона нельзя блокировать
+func (r *OrderRepository) LockByOrderNumber(ctx context.Context, orderNumber string) (*Order, error) {
+    order := &Order{}
+    err := db.Conn(ctx, r.db).NewSelect().
         Model(order).
         Relation("Coupon").
+        Relation("Items", orderItemsByPosition).
+        Relation("Items.Coupon").
         Where("order_number = ?", orderNumber).
+        For("UPDATE OF ?TableAlias").
         Scan(ctx)
     return order, err
 }
 
 func (r *OrderRepository) GetByAlfaBankOrderID(ctx context.Context, alfaBankOrderID string) (*Order, error) {
     order := &Order{}
-    err := r.db.NewSelect().
+    err := db.Conn(ctx, r.db).NewSelect().
         Model(order).
         Relation("Coupon").
+        Relation("Items", orderItemsByPosition).
+        Relation("Items.Coupon").
         Where("alfabank_order_id = ?", alfaBankOrderID).
         Scan(ctx)
     return order, err
 }
 
+// Поиск заказов для сотрудников: фильтры необязательны, новые заказы первыми
+func (r *OrderRepository) Search(ctx context.Context, filter *OrderSearchRequest) ([]Order, error) {
+    var orders []Order
+    q := db.Conn(ctx, r.db).NewSelect().
+        Model(&orders).
+        Relation("Items", orderItemsByPosition).
+        Order("order.created_at DESC", "order.id DESC").
+        Limit(filter.Limit).
+        Offset(filter.Offset)
+
+    if filter.Status != "" {
+        q = q.Where("order.status = ?", filter.Status)
+    }
+    if filter.UserID != "" {
+        q = q.Where("order.user_id = ?", filter.UserID)
+    }
+    if filter.OrderNumber != "" {
+        q = q.Where("order.order_number = ?", filter.OrderNumber)
+    }
+    if filter.AlfaBankOrderID != "" {
+        q = q.Where("order.alfabank_order_id = ?", filter.AlfaBankOrderID)
+    }
+    if filter.MerchantID != 0 {
+        q = q.Where("order.merchant_id = ?", filter.MerchantID)
+    }
+
+    err := q.Scan(ctx)
+    return orders, err
+}
+
+// Заказы в ожидании оплаты, зарегистрированные в банке в окне [createdAfter, createdBefore]
+func (r *OrderRepository) GetPending(ctx context.Context, createdAfter, createdBefore time.Time, limit int) ([]Order, error) {
+    var orders []Order
+    err := db.Conn(ctx, r.db).NewSelect().
+        Model(&orders).
+        Column("order.order_number").
+        Where("order.status = ?", OrderStatusPending).
+        Where("order.alfabank_order_id <> ''").
+        Where("order.created_at BETWEEN ? AND ?", createdAfter, createdBefore).
+        Order("order.created_at").
+        Limit(limit).
+        Scan(ctx)
+    return orders, err
+}
+
+func (r *OrderRepository) UpdatePaymentDetails(ctx context.Context, orderID int64, paymentDetails string) error {
+    _, err := db.Conn(ctx, r.db).NewUpdate().
+        Model((*Order)(nil)).
+        Set("payment_details = ?", paymentDetails).
+        Set("updated_at = ?", time.Now()).
+        Where("id = ?", orderID).
+        Exec(ctx)
+    return err
+}
+
+// Заказы после afterID, данные оплаты которых зашифрованы не ключом с префиксом prefix
+func (r *OrderRepository) ListStalePaymentDetails(ctx context.Context, prefix string, afterID int64, limit int) ([]secret.Record, error) {
+    var records []secret.Record
+    err := db.Conn(ctx, r.db).NewSelect().
+        Model((*Order)(nil)).
+        Column("id").
+        ColumnExpr("payment_details AS value").
+        Where("id > ?", afterID).
+        Where("payment_details <> ''").
+        Where("NOT starts_with(payment_details, ?)", prefix).
+        Order("id").
+        Limit(limit).
+        Scan(ctx, &records)
+    return records, err
+}
+
+func orderItemsByPosition(q *bun.SelectQuery) *bun.SelectQuery {
+    return q.Order("order_item.position")
+}
+
 func (r *OrderRepository) UpdateStatus(ctx context.Context, orderID int64, status string) error {
-    _, err := r.db.NewUpdate().
+    _, err := db.Conn(ctx, r.db).NewUpdate().
         Model((*Order)(nil)).
         Set("status = ?", status).
         Set("updated_at = ?", time.Now()).
@@ -84,7 +356,7 @@ func (r *OrderRepository) UpdateStatus(ctx context.Context, orderID int64, statu
 }
 
 func (r *OrderRepository) UpdateAlfaBankOrderID(ctx context.Context, orderID int64, alfaBankOrderID string) error {
-    _, err := r.db.NewUpdate().
+    _, err := db.Conn(ctx, r.db).NewUpdate().
         Model((*Order)(nil)).
         Set("alfabank_order_id = ?", alfaBankOrderID).
         Set("updated_at = ?", time.Now()).
@@ -93,11 +365,319 @@ func (r *OrderRepository) UpdateAlfaBankOrderID(ctx context.Context, orderID int
     return err
 }
 
+// Сохранение скидки заказа и ее распределения по позициям
+func (r *OrderRepository) UpdateDiscount(ctx context.Context, order *Order) error {
+    return db.RunInTx(ctx, r.db, func(ctx context.Context, tx bun.IDB) error {
+        _, err := tx.NewUpdate().
+            Model((*Order)(nil)).
+            Set("promo_code_id = ?", order.PromoCodeID).
+            Set("discount_amount = ?", order.DiscountAmount).
+            Set("amount = ?", order.Amount).
+            Set("updated_at = ?", time.Now()).
+            Where("id = ?", order.ID).
+            Exec(ctx)
+        if err != nil {
+            return err
+        }
+
+        for _, item := range order.Items {
+            _, err := tx.NewUpdate().
+                Model(item).
+                Column("discount_amount", "amount").
+                WherePK().
+                Exec(ctx)
+            if err != nil {
+                return err
+            }
+        }
+        return nil
+    })
+}
+
+// Возврат в статусе pending. Заказ и его купоны блокируются; plan получает ID
+// неиспользованных купонов покупателя по позициям и возвращает строки
+// возврата. Купоны возврата сразу помечаются возвращенными: пока банк
+// проводит возврат, их нельзя погасить, подарить или вернуть повторно
+func (r *OrderRepository) CreateRefund(
+    ctx context.Context,
+    orderID int64,
+    plan func(order *Order, available map[int64][]int64) ([]RefundLine, error),
+) (*Refund, error) {
+    var refund *Refund
+
+    err := db.RunInTx(ctx, r.db, func(ctx context.Context, tx bun.IDB) error {
+        order := &Order{}
+        err := tx.NewSelect().
+            Model(order).
+            Where("id = ?", orderID).
+            For("UPDATE").
+            Scan(ctx)
+        if err != nil {
+            return err
+        }
+
+        err = tx.NewSelect().
+            Model(&order.Items).
+            Where("order_id = ?", orderID).
+            Order("position").
+            Scan(ctx)
+        if err != nil {
+            return err
+        }
+
+        // Незавершенные возвраты учитываются в позициях как проведенные:
+        // иначе следующий возврат посчитал бы сумму тех же единиц
+        var pending []Refund
+        err = tx.NewSelect().
+            Model(&pending).
+            Where("order_id = ?", orderID).
+            Where("status = ?", RefundStatusPending).
+            Scan(ctx)
+        if err != nil {
+            return err
+        }
+        addPendingRefunds(order, pending)
+
+        // Подаренный купон принадлежит получателю, поэтому возвращаются
+        // только купоны, оставшиеся у покупателя
+        var userCoupons []UserCoupon
+        err = tx.NewSelect().
+            Model(&userCoupons).
+            Column("id", "order_item_id").
+            Where("order_id = ?", orderID).
+            Where("user_id = ?", order.UserID).
+            Where("is_used = ?", false).
+            Where("refunded_at IS NULL").
+            Order("id DESC").
+            For("UPDATE").
+            Scan(ctx)
+        if err != nil {
+            return err
+        }
+
+        available := make(map[int64][]int64)
+        for _, uc := range userCoupons {
+            available[uc.OrderItemID] = append(available[uc.OrderItemID], uc.ID)
+        }
+
+        lines, err := plan(order, available)
+        if err != nil {
+            return err
+        }
+
+        refund = NewRefund(orderID, lines)
+        if _, err := tx.NewInsert().Model(refund).Returning("*").Exec(ctx); err != nil {
+            return err
+        }
+
+        if ids := refund.UserCouponIDs(); len(ids) > 0 {
+            _, err = tx.NewUpdate().
+                Model((*UserCoupon)(nil)).
+                Set("refunded_at = ?", refund.CreatedAt).
+                Where("id IN (?)", bun.In(ids)).
+                Exec(ctx)
+            if err != nil {
+                return err
+            }
+        }
+        return nil
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    return refund, nil
+}
+
+// Возврат под блокировкой до конца транзакции; ErrRefundNotPending, если он
+// уже завершен
+func lockPendingRefund(ctx context.Context, tx bun.IDB, refundID int64) (*Refund, error) {
+    refund := &Refund{}
+    err := tx.NewSelect().
+        Model(refund).
+        Where("id = ?", refundID).
+        For("UPDATE").
+        Scan(ctx)
+    if err != nil {
+        return nil, err
+    }
+    if refund.Status != RefundStatusPending {
+        return nil, ErrRefundNotPending
+    }
+    return refund, nil
+}
+
+func (r *OrderRepository) CompleteRefund(ctx context.Context, refundID int64) (*Order, error) {
+    order := &Order{}
+
+    err := db.RunInTx(ctx, r.db, func(ctx context.Context, tx bun.IDB) error {
+        refund, err := lockPendingRefund(ctx, tx, refundID)
+        if err != nil {
+            return err
+        }
+
+        err = tx.NewSelect().
+            Model(order).
+            Where("id = ?", refund.OrderID).
+            For("UPDATE").
+            Scan(ctx)
+        if err != nil {
+            return err
+        }
+
+        err = tx.NewSelect().
+            Model(&order.Items).
+            Where("order_id = ?", order.ID).
+            Order("position").
+            Scan(ctx)
+        if err != nil {
+            return err
+        }
+
+        items := make(map[int64]*OrderItem, len(order.Items))
+        for _, item := range order.Items {
+            items[item.ID] = item
+        }
+
+        now := time.Now()
+        for _, refunded := range refund.Items {
+            item, ok := items[refunded.ItemID]
+            if !ok {
+                return fmt.Errorf("позиция %d возврата %d не найдена", refunded.ItemID, refund.ID)
+            }
+            item.RefundedQuantity += refunded.Quantity
+            item.RefundedAmount += refunded.Amount
+
+            _, err := tx.NewUpdate().
+                Model(item).
+                Column("refunded_quantity", "refunded_amount").
+                WherePK().
+                Exec(ctx)
+            if err != nil {
+                return err
+            }
+        }
+
+        if ids := refund.UserCouponIDs(); len(ids) > 0 {
+            // Подарки возвращенных купонов больше нельзя принять
+            _, err = tx.NewUpdate().
+                Model((*CouponGift)(nil)).
+                Set("status = ?", GiftStatusCancelled).
+                Set("updated_at = ?", now).
+                Where("user_coupon_id IN (?)", bun.In(ids)).
+                Where("status = ?", GiftStatusPending).
+                Exec(ctx)
+            if err != nil {
+                return err
+            }
+        }
+
+        order.RefundedAmount += refund.Amount
+        if order.RefundedAmount >= order.Amount {
+            order.Status = OrderStatusRefunded
+        }
+        order.UpdatedAt = now
+        _, err = tx.NewUpdate().
+            Model(order).
+            Column("refunded_amount", "status", "updated_at").
+            WherePK().
+            Exec(ctx)
+        if err != nil {
+            return err
+        }
+
+        _, err = tx.NewUpdate().
+            Model((*Refund)(nil)).
+            Set("status = ?", RefundStatusSucceeded).
+            Set("updated_at = ?", now).
+            Where("id = ?", refund.ID).
+            Exec(ctx)
+        return err
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    return order, nil
+}
+
+// Отклоненный банком возврат: купоны снова доступны
+func (r *OrderRepository) FailRefund(ctx context.Context, refundID int64, reason string) error {
+    return db.RunInTx(ctx, r.db, func(ctx context.Context, tx bun.IDB) error {
+        refund, err := lockPendingRefund(ctx, tx, refundID)
+        if err != nil {
+            return err
+        }
+
+        if ids := refund.UserCouponIDs(); len(ids) > 0 {
+            _, err = tx.NewUpdate().
+                Model((*UserCoupon)(nil)).
+                Set("refunded_at = NULL").
+                Where("id IN (?)", bun.In(ids)).
+                Exec(ctx)
+            if err != nil {
+                return err
+            }
+        }
+
+        _, err = tx.NewUpdate().
+            Model((*Refund)(nil)).
+            Set("status = ?", RefundStatusFailed).
+            Set("bank_error = ?", reason).
+            Set("updated_at = ?", time.Now()).
+            Where("id = ?", refund.ID).
+            Exec(ctx)
+        return err
+    })
+}
+
+// Возвраты в pending, созданные раньше createdBefore, с заказом; старые первыми
+func (r *OrderRepository) ListPendingRefunds(ctx context.Context, createdBefore time.Time, limit int) ([]Refund, error) {
+    var refunds []Refund
+    err := db.Conn(ctx, r.db).NewSelect().
+        Model(&refunds).
+        Relation("Order").
+        Where("refund.status = ?", RefundStatusPending).
+        Where("refund.created_at < ?", createdBefore).
+        Order("refund.created_at", "refund.id").
+        Limit(limit).
+        Scan(ctx)
+    return refunds, err
+}
+
+// Позиции для заказов, созданных до появления корзины: одна позиция на заказ
+func (r *OrderRepository) BackfillItems(ctx context.Context) (int, error) {
+    res, err := db.Conn(ctx, r.db).NewRaw(`
+        INSERT INTO order_items (order_id, position, coupon_id, name, quantity, unit_price, discount_amount, amount, created_at)
+        SELECT o.id, 1, o.coupon_id, coalesce(c.name, ''), 1, o.amount + o.discount_amount, o.discount_amount, o.amount, o.created_at
+        FROM orders AS o
+        LEFT JOIN coupons AS c ON c.id = o.coupon_id
+        WHERE o.coupon_id IS NOT NULL
+          AND NOT EXISTS (SELECT 1 FROM order_items AS oi WHERE oi.order_id = o.id)`).
+        Exec(ctx)
+    if err != nil {
+        return 0, err
+    }
+
+    _, err = db.Conn(ctx, r.db).NewRaw(`
+        UPDATE user_coupons AS uc SET order_item_id = oi.id
+        FROM order_items AS oi
+        WHERE oi.order_id = uc.order_id AND oi.coupon_id = uc.coupon_id AND uc.order_item_id IS NULL`).
+        Exec(ctx)
+    if err != nil {
+        return 0, err
+    }
+
+    inserted, err := res.RowsAffected()
+    return int(inserted), err
+}
+
 func (r *OrderRepository) GetUserOrders(ctx context.Context, userID string) ([]Order, error) {
     var orders []Order
-    err := r.db.NewSelect().
+    err := db.Conn(ctx, r.db).NewSelect().
         Model(&orders).
         Relation("Coupon").
+        Relation("Items", orderItemsByPosition).
         Where("user_id = ?", userID).
         Order("created_at DESC").
         Scan(ctx)
@@ -112,22 +692,151 @@ func NewUserCouponRepository(db *bun.DB) *UserCouponRepository {
     return &UserCouponRepository{db: db}
 }
 
-func (r *UserCouponRepository) ActivateCoupon(ctx context.Context, userID string, couponID, orderID int64) error {
-    userCoupon := &UserCoupon{
-        UserID:      userID,
-        CouponID:    couponID,
-        OrderID:     orderID,
-        ActivatedAt: time.Now(),
-        IsUsed:      false,
+// Выдача купонов по оплаченному заказу: по одному на каждую купленную единицу.
+// Повторный вызов выдает только недостающие купоны, поэтому безопасен
+// при нескольких проверках статуса и уведомлениях банка
+func (r *UserCouponRepository) ActivateOrder(ctx context.Context, order *Order, expiresAt func(item *OrderItem) time.Time) (int, error) {
+    activated := 0
+
+    err := db.RunInTx(ctx, r.db, func(ctx context.Context, tx bun.IDB) error {
+        // Блокировка заказа не дает параллельным проверкам выдать купоны дважды
+        _, err := tx.NewSelect().
+            Model((*Order)(nil)).
+            Column("id").
+            Where("id = ?", order.ID).
+            For("UPDATE").
+            Exec(ctx)
+        if err != nil {
+            return err
+        }
+
+        for _, item := range order.Items {
+            issued, err := tx.NewSelect().
+                Model((*UserCoupon)(nil)).
+                Where("order_item_id = ?", item.ID).
+                Count(ctx)
+            if err != nil {
+                return err
+            }
+
+            for i := issued; i < item.Quantity; i++ {
+                userCoupon := &UserCoupon{
+                    UserID:      order.UserID,
+                    CouponID:    item.CouponID,
+                    OrderID:     order.ID,
+                    OrderItemID: item.ID,
+                    ActivatedAt: time.Now(),
+                    ExpiresAt:   expiresAt(item),
+                    IsUsed:      false,
+                }
+                if err := insertWithCode(ctx, tx, userCoupon); err != nil {
+                    return err
+                }
+                activated++
+            }
+        }
+        return nil
+    })
+    if err != nil {
+        return 0, err
     }
-    
-    _, err := r.db.NewInsert().Model(userCoupon).Exec(ctx)
-    return err
+
+    return activated, nil
+}
+
+// Вставка купона со случайным кодом. Конфликт по коду не прерывает транзакцию,
+// поэтому при коллизии просто генерируем новый код
+func insertWithCode(ctx context.Context, idb bun.IDB, userCoupon *UserCoupon) error {
+    for attempt := 0; attempt < couponCodeAttempts; attempt++ {
+        code, err := GenerateCouponCode()
+        if err != nil {
+            return fmt.Errorf("ошибка генерации кода купона: %w", err)
+        }
+        userCoupon.Code = code
+
+        res, err := idb.NewInsert().
+            Model(userCoupon).
+            On("CONFLICT (code) DO NOTHING").
+            Exec(ctx)
+        if err != nil {
+            return err
+        }
+        if inserted, err := res.RowsAffected(); err != nil || inserted == 1 {
+            return err
+        }
+    }
+
+    return fmt.Errorf("не удалось сгенерировать уникальный код купона за %d попыток", couponCodeAttempts)
+}
+
+func (r *UserCouponRepository) GetByCode(ctx context.Context, code string) (*UserCoupon, error) {
+    userCoupon := &UserCoupon{}
+    err := db.Conn(ctx, r.db).NewSelect().
+        Model(userCoupon).
+        Relation("Coupon").
+        Relation("Order").
+        Where("user_coupon.code = ?", code).
+        Scan(ctx)
+    if err != nil {
+        return nil, err
+    }
+    return userCoupon, nil
+}
+
+func (r *UserCouponRepository) GetUserCoupon(ctx context.Context, userID string, userCouponID int64) (*UserCoupon, error) {
+    userCoupon := &UserCoupon{}
+    err := db.Conn(ctx, r.db).NewSelect().
+        Model(userCoupon).
+        Relation("Order").
+        Where("user_coupon.id = ? AND user_coupon.user_id = ?", userCouponID, userID).
+        Scan(ctx)
+    if err != nil {
+        return nil, err
+    }
+    return userCoupon, nil
+}
+
+// Выдача кодов купонам, активированным до появления кодов
+func (r *UserCouponRepository) BackfillCodes(ctx context.Context) (int, error) {
+    var ids []int64
+    err := db.Conn(ctx, r.db).NewSelect().
+        Model((*UserCoupon)(nil)).
+        Column("id").
+        Where("code IS NULL").
+        Scan(ctx, &ids)
+    if err != nil {
+        return 0, err
+    }
+
+    updated := 0
+    for _, id := range ids {
+        for attempt := 0; ; attempt++ {
+            code, err := GenerateCouponCode()
+            if err != nil {
+                return updated, fmt.Errorf("ошибка генерации кода купона: %w", err)
+            }
+
+            _, err = db.Conn(ctx, r.db).NewUpdate().
+                Model((*UserCoupon)(nil)).
+                Set("code = ?", code).
+                Where("id = ? AND code IS NULL", id).
+                Exec(ctx)
+            if err == nil {
+                updated++
+                break
+            }
+            if !db.IsUniqueViolation(err, "user_coupons_code_key") || attempt+1 >= couponCodeAttempts {
+                return updated, err
+            }
+        }
+    }
+
+    return updated, nil
 }
 
 func (r *UserCouponRepository) GetUserCoupons(ctx context.Context, userID string) ([]UserCoupon, error) {
     var userCoupons []UserCoupon
-    err := r.db.NewSelect().
+    err := db.Conn(ctx, r.db).NewSelect().
         Model(&userCoupons).
         Relation("Coupon").
         Relation("Order").
@@ -137,52 +846,349 @@ func (r *UserCouponRepository) GetUserCoupons(ctx context.Context, userID string
     return userCoupons, err
 }
 
-func (r *UserCouponRepository) UseCoupon(ctx context.Context, userCouponID int64) error {
-    _, err := r.db.NewUpdate().
+// Погашение купона одним запросом: если купон уже использован или истек,
+// ни одна строка не обновится и вернется false
+func (r *UserCouponRepository) UseCoupon(ctx context.Context, userCouponID, merchantID int64, terminalID string, usedAt time.Time) (bool, error) {
+    res, err := db.Conn(ctx, r.db).NewUpdate().
         Model((*UserCoupon)(nil)).
         Set("is_used = ?", true).
-        Set("used_at = ?", time.Now()).
+        Set("used_at = ?", usedAt).
+        Set("redeemed_merchant_id = ?", merchantID).
+        Set("redeemed_terminal_id = NULLIF(?, '')", terminalID).
         Where("id = ?", userCouponID).
+        Where("is_used = ?", false).
+        Where("refunded_at IS NULL").
+        Where("expires_at IS NULL OR expires_at > ?", usedAt).
+        Exec(ctx)
+    if err != nil {
+        return false, err
+    }
+
+    affected, err := res.RowsAffected()
+    if err != nil {
+        return false, err
+    }
+    return affected == 1, nil
+}
+
+type CartRepository struct {
+    db *bun.DB
+}
+
+func NewCartRepository(db *bun.DB) *CartRepository {
+    return &CartRepository{db: db}
+}
+
+func (r *CartRepository) GetItems(ctx context.Context, userID string) ([]CartItem, error) {
+    var items []CartItem
+    err := r.db.NewSelect().
+        Model(&items).
+        Relation("Coupon").
+        Where("cart_item.user_id = ?", userID).
+        Order("cart_item.created_at", "cart_item.id").
+        Scan(ctx)
+    return items, err
+}
+
+func (r *CartRepository) CountItems(ctx context.Context, userID string) (int, error) {
+    return r.db.NewSelect().
+        Model((*CartItem)(nil)).
+        Where("user_id = ?", userID).
+        Count(ctx)
+}
+
+// Установка количества купона в корзине: добавляет позицию или меняет существующую
+func (r *CartRepository) SetQuantity(ctx context.Context, userID string, couponID int64, quantity int) error {
+    item := &CartItem{
+        UserID:    userID,
+        CouponID:  couponID,
+        Quantity:  quantity,
+        CreatedAt: time.Now(),
+        UpdatedAt: time.Now(),
+    }
+    _, err := r.db.NewInsert().
+        Model(item).
+        On("CONFLICT (user_id, coupon_id) DO UPDATE").
+        Set("quantity = EXCLUDED.quantity").
+        Set("updated_at = EXCLUDED.updated_at").
+        Exec(ctx)
+    return err
+}
+
+func (r *CartRepository) RemoveItem(ctx context.Context, userID string, couponID int64) error {
+    _, err := r.db.NewDelete().
+        Model((*CartItem)(nil)).
+        Where("user_id = ? AND coupon_id = ?", userID, couponID).
+        Exec(ctx)
+    return err
+}
+
+func (r *CartRepository) Clear(ctx context.Context, userID string) error {
+    _, err := r.db.NewDelete().
+        Model((*CartItem)(nil)).
+        Where("user_id = ?", userID).
         Exec(ctx)
     return err
 }
 
-// Создание таблиц
-func CreateTables(ctx context.Context, db *bun.DB) error {
-    models := []interface{}{
-        (*Coupon)(nil),
-        (*Order)(nil),
-        (*UserCoupon)(nil),
+// Журнал изменений заказов. Запись идет в транзакции изменения
+type OrderChangeRepository struct {
+    db *bun.DB
+}
+
+func NewOrderChangeRepository(db *bun.DB) *OrderChangeRepository {
+    return &OrderChangeRepository{db: db}
+}
+
+func (r *OrderChangeRepository) Append(ctx context.Context, change *OrderChange) error {
+    _, err := db.Conn(ctx, r.db).NewInsert().Model(change).Returning("id, created_at").Exec(ctx)
+    return err
+}
+
+func (r *OrderChangeRepository) ListByOrder(ctx context.Context, orderID int64) ([]OrderChange, error) {
+    var changes []OrderChange
+    err := db.Conn(ctx, r.db).NewSelect().
+        Model(&changes).
+        Where("order_id = ?", orderID).
+        Order("id").
+        Scan(ctx)
+    return changes, err
+}
+
+type GiftRepository struct {
+    db *bun.DB
+}
+
+func NewGiftRepository(db *bun.DB) *GiftRepository {
+    return &GiftRepository{db: db}
+}
+
+func (r *GiftRepository) Create(ctx context.Context, gift *CouponGift) error {
+    gift.CreatedAt = time.Now()
+    gift.UpdatedAt = time.Now()
+    _, err := r.db.NewInsert().Model(gift).Exec(ctx)
+    return err
+}
+
+// Снятие просроченных подарков, чтобы купон можно было подарить заново
+func (r *GiftRepository) CancelExpired(ctx context.Context, userCouponID int64, now time.Time) error {
+    _, err := r.db.NewUpdate().
+        Model((*CouponGift)(nil)).
+        Set("status = ?", GiftStatusCancelled).
+        Set("updated_at = ?", now).
+        Where("user_coupon_id = ? AND status = ? AND expires_at <= ?", userCouponID, GiftStatusPending, now).
+        Exec(ctx)
+    return err
+}
+
+func (r *GiftRepository) GetByTokenHash(ctx context.Context, tokenHash string) (*CouponGift, error) {
+    gift := &CouponGift{}
+    err := r.db.NewSelect().
+        Model(gift).
+        Relation("UserCoupon").
+        Relation("UserCoupon.Coupon").
+        Where("coupon_gift.token_hash = ?", tokenHash).
+        Scan(ctx)
+    if err != nil {
+        return nil, err
+    }
+    return gift, nil
+}
+
+func (r *GiftRepository) Cancel(ctx context.Context, giftID int64, fromUserID string) (bool, error) {
+    res, err := r.db.NewUpdate().
+        Model((*CouponGift)(nil)).
+        Set("status = ?", GiftStatusCancelled).
+        Set("updated_at = ?", time.Now()).
+        Where("id = ? AND from_user_id = ? AND status = ?", giftID, fromUserID, GiftStatusPending).
+        Exec(ctx)
+    if err != nil {
+        return false, err
+    }
+
+    affected, err := res.RowsAffected()
+    if err != nil {
+        return false, err
     }
-    
-    for _, model := range models {
-        _, err := db.NewCreateTable().Model(model).IfNotExists().Exec(ctx)
+    return affected == 1, nil
+}
+
+// Принятие подарка в одной транзакции: подарок и купон блокируются,
+// validate проверяет бизнес-правила уже на заблокированных строках
+func (r *GiftRepository) Accept(ctx context.Context, tokenHash, toUserID, newCode string, validate func(gift *CouponGift) error) (*UserCoupon, error) {
+    var userCoupon *UserCoupon
+
+    err := db.RunInTx(ctx, r.db, func(ctx context.Context, tx bun.IDB) error {
+        gift := &CouponGift{}
+        err := tx.NewSelect().
+            Model(gift).
+            Where("token_hash = ?", tokenHash).
+            For("UPDATE").
+            Scan(ctx)
+        if err != nil {
+            return err
+        }
+
+        gift.UserCoupon = &UserCoupon{}
+        err = tx.NewSelect().
+            Model(gift.UserCoupon).
+            Relation("Order").
+            Where("user_coupon.id = ?", gift.UserCouponID).
+            For("UPDATE OF user_coupon").
+            Scan(ctx)
+        if err != nil {
+            return err
+        }
+
+        if err := validate(gift); err != nil {
+            return err
+        }
+
+        now := time.Now()
+        _, err = tx.NewUpdate().
+            Model((*UserCoupon)(nil)).
+            Set("user_id = ?", toUserID).
+            Set("code = ?", newCode).
+            Where("id = ?", gift.UserCouponID).
+            Exec(ctx)
+        if err != nil {
+            return err
+        }
+
+        _, err = tx.NewUpdate().
+            Model((*CouponGift)(nil)).
+            Set("status = ?", GiftStatusAccepted).
+            Set("accepted_by = ?", toUserID).
+            Set("accepted_at = ?", now).
+            Set("updated_at = ?", now).
+            Where("id = ?", gift.ID).
+            Exec(ctx)
+        if err != nil {
+            return err
+        }
+
+        transfer := &CouponTransfer{
+            UserCouponID:  gift.UserCouponID,
+            GiftID:        gift.ID,
+            FromUserID:    gift.FromUserID,
+            ToUserID:      toUserID,
+            TransferredAt: now,
+        }
+        _, err = tx.NewInsert().Model(transfer).Exec(ctx)
         if err != nil {
-            return fmt.Errorf("ошибка создания таблицы: %w", err)
+            return err
         }
+
+        userCoupon = gift.UserCoupon
+        userCoupon.UserID = toUserID
+        userCoupon.Code = newCode
+        return nil
+    })
+    if err != nil {
+        return nil, err
     }
-    
-    return nil
+
+    return userCoupon, nil
+}
+
+type MerchantRepository struct {
+    db *bun.DB
+}
+
+func NewMerchantRepository(db *bun.DB) *MerchantRepository {
+    return &MerchantRepository{db: db}
+}
+
+func (r *MerchantRepository) Create(ctx context.Context, merchant *Merchant) error {
+    merchant.CreatedAt = time.Now()
+    merchant.UpdatedAt = time.Now()
+    _, err := r.db.NewInsert().Model(merchant).Exec(ctx)
+    return err
 }
 
-// Создание индексов
-func CreateIndexes(ctx context.Context, db *bun.DB) error {
-    indexes := []string{
-        "CREATE INDEX IF NOT EXISTS idx_orders_order_number ON orders(order_number)",
-        "CREATE INDEX IF NOT EXISTS idx_orders_alfabank_order_id ON orders(alfabank_order_id)",
-        "CREATE INDEX IF NOT EXISTS idx_orders_user_id ON orders(user_id)",
-        "CREATE INDEX IF NOT EXISTS idx_orders_status ON orders(status)",
-        "CREATE INDEX IF NOT EXISTS idx_user_coupons_user_id ON user_coupons(user_id)",
-        "CREATE INDEX IF NOT EXISTS idx_user_coupons_coupon_id ON user_coupons(coupon_id)",
-        "CREATE INDEX IF NOT EXISTS idx_coupons_is_active ON coupons(is_active)",
+func (r *MerchantRepository) GetByID(ctx context.Context, id int64) (*Merchant, error) {
+    merchant := &Merchant{}
+    err := r.db.NewSelect().
+        Model(merchant).
+        Where("id = ? AND is_active = ?", id, true).
+        Scan(ctx)
+    if err != nil {
+        return nil, err
     }
-    
-    for _, indexSQL := range indexes {
-        _, err := db.ExecContext(ctx, indexSQL)
-        if err != nil {
-            return fmt.Errorf("ошибка создания индекса: %w", err)
-        }
+    return merchant, nil
+}
+
+func (r *MerchantRepository) GetByAPIKeyHash(ctx context.Context, apiKeyHash string) (*Merchant, error) {
+    merchant := &Merchant{}
+    err := r.db.NewSelect().
+        Model(merchant).
+        Where("api_key_hash = ? AND is_active = ?", apiKeyHash, true).
+        Scan(ctx)
+    if err != nil {
+        return nil, err
     }
-    
-    return nil
-}
\ No newline at end of file
+    return merchant, nil
+}
+
+// Партнер независимо от активности: нужен для операций по старым заказам
+func (r *MerchantRepository) GetWithInactive(ctx context.Context, id int64) (*Merchant, error) {
+    merchant := &Merchant{}
+    err := r.db.NewSelect().
+        Model(merchant).
+        Where("id = ?", id).
+        Scan(ctx)
+    if err != nil {
+        return nil, err
+    }
+    return merchant, nil
+}
+
+func (r *MerchantRepository) List(ctx context.Context) ([]Merchant, error) {
+    var merchants []Merchant
+    err := r.db.NewSelect().
+        Model(&merchants).
+        Order("id").
+        Scan(ctx)
+    return merchants, err
+}
+
+func (r *MerchantRepository) Update(ctx context.Context, merchant *Merchant) error {
+    merchant.UpdatedAt = time.Now()
+    _, err := r.db.NewUpdate().
+        Model(merchant).
+        Column("name", "gateway_url", "gateway_username", "gateway_password", "return_url", "fail_url", "branding", "updated_at").
+        WherePK().
+        Exec(ctx)
+    return err
+}
+
+func (r *MerchantRepository) UpdateGatewayPassword(ctx context.Context, merchantID int64, gatewayPassword string) error {
+    _, err := r.db.NewUpdate().
+        Model((*Merchant)(nil)).
+        Set("gateway_password = ?", gatewayPassword).
+        Set("updated_at = ?", time.Now()).
+        Where("id = ?", merchantID).
+        Exec(ctx)
+    return err
+}
+
+// Партнеры после afterID, включая отключенных, пароль шлюза которых
+// зашифрован не ключом с префиксом prefix
+func (r *MerchantRepository) ListStaleSecrets(ctx context.Context, prefix string, afterID int64, limit int) ([]secret.Record, error) {
+    var records []secret.Record
+    err := r.db.NewSelect().
+        Model((*Merchant)(nil)).
+        Column("id").
+        ColumnExpr("gateway_password AS value").
+        Where("id > ?", afterID).
+        Where("gateway_password <> ''").
+        Where("NOT starts_with(gateway_password, ?)", prefix).
+        Order("id").
+        Limit(limit).
+        Scan(ctx, &records)
+    return records, err
+}
+
+func (r *MerchantRepository) Count(ctx context.Context) (int, error) {
+    return r.db.NewSelect().Model((*Merchant)(nil)).Count(ctx)
+}
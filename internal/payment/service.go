@@ -1,37 +1,79 @@
 package payment
 
 import (
+	"bytes"
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"net/url"
+	"regexp"
 	"strconv"
 	"time"
 
 	"github.com/skr1ms/PaymentAlphaBank.git/config"
+	"github.com/skr1ms/PaymentAlphaBank.git/internal/outbox"
+	"github.com/skr1ms/PaymentAlphaBank.git/internal/promo"
+	"github.com/skr1ms/PaymentAlphaBank.git/internal/webhook"
+	"github.com/skr1ms/PaymentAlphaBank.git/pkg/db"
+	"github.com/skr1ms/PaymentAlphaBank.git/pkg/i18n"
+	"github.com/skr1ms/PaymentAlphaBank.git/pkg/secret"
+	"github.com/skr1ms/PaymentAlphaBank.git/pkg/storage"
 )
 
+// Учетная запись магазина в платежном шлюзе
+type GatewayCredentials struct {
+	BaseURL  string
+	Username string
+	Password string
+}
+
 type AlfaBankClient struct {
-	config *config.Config
-	client *http.Client
+	credentials GatewayCredentials
+	isTest      bool
+	client      *http.Client
 }
 
+// Клиент общей учетной записи из конфигурации
 func NewAlfaBankClient(config *config.Config) *AlfaBankClient {
 	return &AlfaBankClient{
-		config: config,
+		credentials: GatewayCredentials{
+			BaseURL:  config.BaseURL,
+			Username: config.Username,
+			Password: config.Password,
+		},
+		isTest: config.IsTest,
 		client: &http.Client{
-			Timeout: 30 * time.Second,
+			Timeout: config.GatewayTimeout,
 		},
 	}
 }
 
+// Клиент другой учетной записи на том же HTTP-клиенте; без адреса
+// используется адрес шлюза исходного клиента
+func (c *AlfaBankClient) WithCredentials(credentials GatewayCredentials) *AlfaBankClient {
+	if credentials.BaseURL == "" {
+		credentials.BaseURL = c.credentials.BaseURL
+	}
+	return &AlfaBankClient{
+		credentials: credentials,
+		isTest:      c.isTest,
+		client:      c.client,
+	}
+}
+
 func (c *AlfaBankClient) RegisterOrder(ctx context.Context, req *AlfaBankRegisterRequest) (*AlfaBankRegisterResponse, error) {
 	data := url.Values{}
-	data.Set("userName", c.config.Username)
-	data.Set("password", c.config.Password)
+	data.Set("userName", c.credentials.Username)
+	data.Set("password", c.credentials.Password)
 	data.Set("orderNumber", req.OrderNumber)
 	data.Set("amount", strconv.FormatInt(req.Amount, 10))
 	data.Set("returnUrl", req.ReturnUrl)
@@ -62,12 +104,20 @@ func (c *AlfaBankClient) RegisterOrder(ctx context.Context, req *AlfaBankRegiste
 	if req.SessionTimeoutSecs > 0 {
 		data.Set("sessionTimeoutSecs", strconv.Itoa(req.SessionTimeoutSecs))
 	}
+	if req.OrderBundle != "" {
+		data.Set("orderBundle", req.OrderBundle)
+	}
 
-	if c.config.IsTest {
-		log.Printf("Отправляем запрос в Альфа-Банк: %s", data.Encode())
+	if c.isTest {
+		logged := url.Values{}
+		for key, values := range data {
+			logged[key] = values
+		}
+		logged.Set("password", "***")
+		log.Printf("Отправляем запрос в Альфа-Банк: %s", logged.Encode())
 	}
 
-	resp, err := c.client.PostForm(c.config.BaseURL+"/payment/rest/register.do", data)
+	resp, err := c.client.PostForm(c.credentials.BaseURL+"/payment/rest/register.do", data)
 	if err != nil {
 		return nil, fmt.Errorf("ошибка запроса к API: %w", err)
 	}
@@ -78,7 +128,7 @@ func (c *AlfaBankClient) RegisterOrder(ctx context.Context, req *AlfaBankRegiste
 		return nil, fmt.Errorf("ошибка чтения ответа: %w", err)
 	}
 
-	if c.config.IsTest {
+	if c.isTest {
 		log.Printf("Ответ от Альфа-Банка: %s", string(body))
 	}
 
@@ -93,12 +143,12 @@ func (c *AlfaBankClient) RegisterOrder(ctx context.Context, req *AlfaBankRegiste
 
 func (c *AlfaBankClient) GetOrderStatus(ctx context.Context, orderID string) (*AlfaBankStatusResponse, error) {
 	data := url.Values{}
-	data.Set("userName", c.config.Username)
-	data.Set("password", c.config.Password)
+	data.Set("userName", c.credentials.Username)
+	data.Set("password", c.credentials.Password)
 	data.Set("orderId", orderID)
 	data.Set("language", "ru")
 
-	resp, err := c.client.PostForm(c.config.BaseURL+"/payment/rest/getOrderStatus.do", data)
+	resp, err := c.client.PostForm(c.credentials.BaseURL+"/payment/rest/getOrderStatus.do", data)
 	if err != nil {
 		return nil, fmt.Errorf("ошибка запроса статуса: %w", err)
 	}
@@ -109,7 +159,7 @@ func (c *AlfaBankClient) GetOrderStatus(ctx context.Context, orderID string) (*A
 		return nil, fmt.Errorf("ошибка чтения ответа статуса: %w", err)
 	}
 
-	if c.config.IsTest {
+	if c.isTest {
 		log.Printf("Статус заказа %s: %s", orderID, string(body))
 	}
 
@@ -122,84 +172,470 @@ func (c *AlfaBankClient) GetOrderStatus(ctx context.Context, orderID string) (*A
 	return &result, nil
 }
 
+// Расширенный статус заказа: суммы удержания, списания и возвратов
+func (c *AlfaBankClient) GetOrderStatusExtended(ctx context.Context, orderID string) (*AlfaBankExtendedStatusResponse, error) {
+	data := url.Values{}
+	data.Set("userName", c.credentials.Username)
+	data.Set("password", c.credentials.Password)
+	data.Set("orderId", orderID)
+	data.Set("language", "ru")
+
+	resp, err := c.client.PostForm(c.credentials.BaseURL+"/payment/rest/getOrderStatusExtended.do", data)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка запроса статуса: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения ответа статуса: %w", err)
+	}
+
+	if c.isTest {
+		log.Printf("Расширенный статус заказа %s: %s", orderID, string(body))
+	}
+
+	var result AlfaBankExtendedStatusResponse
+	err = json.Unmarshal(body, &result)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка парсинга статуса: %w", err)
+	}
+
+	return &result, nil
+}
+
+// Возврат средств по заказу; refundItems передаются для чека возврата
+func (c *AlfaBankClient) Refund(ctx context.Context, req *AlfaBankRefundRequest) (*AlfaBankRefundResponse, error) {
+	data := url.Values{}
+	data.Set("userName", c.credentials.Username)
+	data.Set("password", c.credentials.Password)
+	data.Set("orderId", req.OrderId)
+	data.Set("amount", strconv.FormatInt(req.Amount, 10))
+	if req.RefundItems != "" {
+		data.Set("refundItems", req.RefundItems)
+	}
+
+	resp, err := c.client.PostForm(c.credentials.BaseURL+"/payment/rest/refund.do", data)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка запроса возврата: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения ответа возврата: %w", err)
+	}
+
+	if c.isTest {
+		log.Printf("Возврат по заказу %s: %s", req.OrderId, string(body))
+	}
+
+	var result AlfaBankRefundResponse
+	err = json.Unmarshal(body, &result)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка парсинга ответа возврата: %w", err)
+	}
+
+	return &result, nil
+}
+
+// Отмена оплаты, по которой средства только удержаны (двухстадийный платеж);
+// удержание снимается полностью
+func (c *AlfaBankClient) Reverse(ctx context.Context, orderID string) (*AlfaBankReverseResponse, error) {
+	data := url.Values{}
+	data.Set("userName", c.credentials.Username)
+	data.Set("password", c.credentials.Password)
+	data.Set("orderId", orderID)
+	data.Set("language", "ru")
+
+	resp, err := c.client.PostForm(c.credentials.BaseURL+"/payment/rest/reverse.do", data)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка запроса отмены оплаты: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения ответа отмены оплаты: %w", err)
+	}
+
+	if c.isTest {
+		log.Printf("Отмена оплаты заказа %s: %s", orderID, string(body))
+	}
+
+	var result AlfaBankReverseResponse
+	err = json.Unmarshal(body, &result)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка парсинга ответа отмены оплаты: %w", err)
+	}
+
+	return &result, nil
+}
+
+// Ограничения состава заказа
+const (
+	maxOrderItems   = 20
+	maxItemQuantity = 10
+)
+
+var (
+	ErrInvalidOrderItems  = errors.New("неверный состав заказа")
+	ErrOrderNotFound      = errors.New("заказ не найден")
+	ErrOrderNotRefundable = errors.New("вернуть можно только оплаченный заказ")
+	ErrInvalidRefundItems = errors.New("неверные позиции возврата")
+	ErrRefundUnavailable  = errors.New("недостаточно купонов для возврата: использованные и уже возвращенные купоны не возвращаются")
+	ErrRefundRejected     = errors.New("банк отклонил возврат")
+	ErrRefundUnconfirmed  = errors.New("банк не подтвердил возврат, результат будет сверен автоматически")
+	ErrRefundNotPending   = errors.New("возврат уже завершен")
+	ErrMixedMerchants     = fmt.Errorf("%w: купоны разных магазинов оформляются отдельными заказами", ErrInvalidOrderItems)
+	ErrReturnURLRequired  = errors.New("не указан адрес возврата")
+
+	ErrOrderNotCancellable = errors.New("отменить можно только неоплаченный заказ")
+	ErrOrderAlreadyPaid    = errors.New("заказ уже оплачен")
+	ErrPaymentInProgress   = errors.New("покупатель подтверждает оплату, отмена пока невозможна")
+	ErrCancelRejected      = errors.New("банк отклонил отмену заказа")
+)
+
+// Строка возврата: позиция, количество единиц, сумма и возвращаемые купоны
+type RefundLine struct {
+	Item          *OrderItem
+	Quantity      int
+	Amount        int64
+	UserCouponIDs []int64
+}
+
+// Возврат в статусе pending по строкам возврата
+func NewRefund(orderID int64, lines []RefundLine) *Refund {
+	refund := &Refund{OrderID: orderID, Status: RefundStatusPending}
+	for _, line := range lines {
+		refund.Items = append(refund.Items, RefundItem{
+			ItemID:        line.Item.ID,
+			Quantity:      line.Quantity,
+			Amount:        line.Amount,
+			UserCouponIDs: line.UserCouponIDs,
+		})
+		refund.Amount += line.Amount
+	}
+	return refund
+}
+
 type CouponService struct {
-	couponRepo     *CouponRepository
-	orderRepo      *OrderRepository
-	userCouponRepo *UserCouponRepository
-	alfaClient     *AlfaBankClient
+	couponRepo      CouponStore
+	orderRepo       OrderStore
+	userCouponRepo  UserCouponStore
+	changeRepo      OrderChangeStore
+	tx              TxRunner
+	orderNumbers    *OrderNumberGenerator
+	merchantService Merchants
+	alfaClient      *AlfaBankClient // общая учетная запись шлюза
+	envelope        *secret.Envelope
+	codeSigner      *CodeSigner
+	promoService    PromoCodes
+	webhookService  WebhookPublisher
+	outboxService   OutboxWriter
+	events          *OrderEventBus
 }
 
 func NewCouponService(
-	couponRepo *CouponRepository,
-	orderRepo *OrderRepository,
-	userCouponRepo *UserCouponRepository,
+	couponRepo CouponStore,
+	orderRepo OrderStore,
+	userCouponRepo UserCouponStore,
+	changeRepo OrderChangeStore,
+	tx TxRunner,
+	orderNumbers *OrderNumberGenerator,
+	merchantService Merchants,
 	alfaClient *AlfaBankClient,
+	envelope *secret.Envelope,
+	codeSigner *CodeSigner,
+	promoService PromoCodes,
+	webhookService WebhookPublisher,
+	outboxService OutboxWriter,
 ) *CouponService {
 	return &CouponService{
-		couponRepo:     couponRepo,
-		orderRepo:      orderRepo,
-		userCouponRepo: userCouponRepo,
-		alfaClient:     alfaClient,
+		couponRepo:      couponRepo,
+		orderRepo:       orderRepo,
+		userCouponRepo:  userCouponRepo,
+		changeRepo:      changeRepo,
+		tx:              tx,
+		orderNumbers:    orderNumbers,
+		merchantService: merchantService,
+		alfaClient:      alfaClient,
+		envelope:        envelope,
+		codeSigner:      codeSigner,
+		promoService:    promoService,
+		webhookService:  webhookService,
+		outboxService:   outboxService,
+		events:          NewOrderEventBus(),
+	}
+}
+
+// Подписка на изменения заказов. Отписка — вызовом возвращенной функции
+func (s *CouponService) SubscribeOrderEvents(filter OrderEventFilter) (<-chan OrderEvent, func()) {
+	return s.events.Subscribe(filter)
+}
+
+// События заказов, отложенные до фиксации транзакции
+type pendingEvents struct {
+	events []OrderEvent
+}
+
+type pendingEventsKey struct{}
+
+// Бизнес-операция в одной транзакции: заказ, купоны, промокод и очередь
+// вебхуков фиксируются вместе или не меняются вовсе. Подписчики получают
+// события заказов только после фиксации. Вложенный вызов присоединяется
+// к внешней операции
+func (s *CouponService) inTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	if _, ok := ctx.Value(pendingEventsKey{}).(*pendingEvents); ok {
+		return fn(ctx)
 	}
+
+	pending := &pendingEvents{}
+	if err := s.tx.RunInTx(context.WithValue(ctx, pendingEventsKey{}, pending), fn); err != nil {
+		return err
+	}
+	for _, event := range pending.events {
+		s.events.Publish(event)
+	}
+	return nil
+}
+
+// Публикация события заказа; внутри inTx — после фиксации транзакции
+func (s *CouponService) publish(ctx context.Context, event OrderEvent) {
+	if pending, ok := ctx.Value(pendingEventsKey{}).(*pendingEvents); ok {
+		pending.events = append(pending.events, event)
+		return
+	}
+	s.events.Publish(event)
+}
+
+// Смена статуса заказа в базе с записью в журнал и публикацией события.
+// Вебхук ставится в очередь в той же транзакции, поэтому его ошибка отменяет
+// смену статуса. bank — ответ банка, по которому меняется статус, если есть
+func (s *CouponService) setOrderStatus(ctx context.Context, order *Order, status string, bank *AlfaBankStatusResponse) error {
+	if err := s.orderRepo.UpdateStatus(ctx, order.ID, status); err != nil {
+		return err
+	}
+	previous := order.Status
+	order.Status = status
+	if err := s.recordChange(ctx, order, statusChange(previous, status, bank)); err != nil {
+		return err
+	}
+	s.publish(ctx, newOrderEvent(OrderEventStatusChanged, order, previous))
+
+	switch status {
+	case OrderStatusPaid:
+		if err := s.outboxService.Add(ctx, outbox.EventOrderPaid, order.OrderNumber, &OrderWebhookData{Order: order}); err != nil {
+			return err
+		}
+		return s.webhookService.Publish(ctx, webhook.EventOrderPaid, &OrderWebhookData{Order: order})
+	case OrderStatusRefunded:
+		// Полный возврат на стороне банка, минуя RefundOrder
+		return s.webhookService.Publish(ctx, webhook.EventOrderRefunded, &OrderWebhookData{Order: order})
+	}
+	return nil
 }
 
-func (s *CouponService) GetCoupons(ctx context.Context) ([]Coupon, error) {
-	return s.couponRepo.GetActiveCoupons(ctx)
+// Постановка вебхука в очередь; ошибка не прерывает основную операцию
+func (s *CouponService) notify(ctx context.Context, eventType string, data any) {
+	if err := s.webhookService.Publish(ctx, eventType, data); err != nil {
+		log.Printf("Ошибка постановки вебхука %s: %v", eventType, err)
+	}
 }
 
 func (s *CouponService) CreateOrder(ctx context.Context, req *CreateOrderRequest) (*CreateOrderResponse, error) {
-	// Получаем купон
-	coupon, err := s.couponRepo.GetByID(ctx, req.CouponID)
+	requested, err := orderItemRequests(req)
+	if err != nil {
+		return &CreateOrderResponse{
+			Success: false,
+			Message: "Неверный состав заказа",
+		}, err
+	}
+
+	// Получаем купоны позиций
+	coupons := make([]*Coupon, len(requested))
+	for i, itemReq := range requested {
+		coupon, err := s.couponRepo.GetByID(ctx, itemReq.CouponID)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				err = ErrCouponNotFound
+			}
+			return &CreateOrderResponse{
+				Success: false,
+				Message: "Купон не найден",
+			}, err
+		}
+		coupons[i] = coupon
+	}
+
+	// Заказ регистрируется под учетной записью одного магазина
+	merchant, err := s.orderMerchant(ctx, coupons)
 	if err != nil {
 		return &CreateOrderResponse{
 			Success: false,
-			Message: "Купон не найден",
+			Message: "Неверный состав заказа",
 		}, err
 	}
 
-	// Генерируем уникальный номер заказа
-	orderNumber := fmt.Sprintf("COUPON_%d_%s_%d", req.CouponID, req.UserID, time.Now().Unix())
-	amountInKopecks := int64(coupon.Price * 100)
+	returnURL, failURL := req.ReturnURL, req.FailURL
+	if merchant != nil {
+		if returnURL == "" {
+			returnURL = merchant.ReturnURL
+		}
+		if failURL == "" {
+			failURL = merchant.FailURL
+		}
+	}
+	if returnURL == "" {
+		return &CreateOrderResponse{
+			Success: false,
+			Message: "Не указан адрес возврата",
+		}, ErrReturnURLRequired
+	}
+
+	// Язык платежной страницы и описания заказа
+	locale := req.Language
+	if !i18n.IsSupported(locale) {
+		locale = i18n.DefaultLocale
+	}
+	if err := s.couponRepo.Localize(ctx, locale, coupons...); err != nil {
+		log.Printf("Ошибка получения перевода купона: %v", err)
+	}
 
 	// Создаем заказ в базе данных
 	order := &Order{
-		OrderNumber: orderNumber,
-		CouponID:    req.CouponID,
-		UserID:      req.UserID,
-		Amount:      amountInKopecks,
-		Currency:    coupon.Currency,
-		Status:      OrderStatusCreated,
-		ReturnURL:   req.ReturnURL,
-		FailURL:     req.FailURL,
-		Description: fmt.Sprintf("Покупка купона: %s", coupon.Name),
+		UserID:    req.UserID,
+		Currency:  coupons[0].Currency,
+		Status:    OrderStatusCreated,
+		ReturnURL: returnURL,
+		FailURL:   failURL,
+	}
+	if merchant != nil {
+		order.MerchantID = merchant.ID
+	}
+	for i, coupon := range coupons {
+		unitPrice := int64(coupon.Price * 100)
+		quantity := requested[i].Quantity
+		order.Items = append(order.Items, &OrderItem{
+			Position:  i + 1,
+			CouponID:  coupon.ID,
+			Name:      coupon.Name,
+			Quantity:  quantity,
+			UnitPrice: unitPrice,
+			Amount:    unitPrice * int64(quantity),
+		})
+		order.Amount += unitPrice * int64(quantity)
+	}
+
+	if len(order.Items) == 1 && order.Items[0].Quantity == 1 {
+		order.CouponID = coupons[0].ID
+		order.Description = fmt.Sprintf(i18n.Translate(locale, "Покупка купона: %s"), coupons[0].Name)
+	} else {
+		order.Description = fmt.Sprintf(i18n.Translate(locale, "Покупка купонов: %d шт."), orderQuantity(order.Items))
+	}
+
+	// Предварительная проверка промокода, чтобы не создавать заказ с опечаткой в коде
+	if req.PromoCode != "" {
+		quote, err := s.promoService.Quote(ctx, req.PromoCode, req.UserID, promoLines(order.Items))
+		if err != nil {
+			return &CreateOrderResponse{
+				Success: false,
+				Message: err.Error(),
+			}, err
+		}
+		applyDiscount(order, quote)
+	}
+
+	// Заказ сохраняется вместе с резервом промокода: если резерв не удался,
+	// не остается ни заказа, ни занятого промокода. Занятый номер заказа
+	// отменяет транзакцию, поэтому попытка повторяется с новым номером
+	var reserveErr error
+	for attempt := 1; ; attempt++ {
+		if order.OrderNumber, err = s.orderNumbers.Next(ctx); err != nil {
+			break
+		}
+		err = s.inTx(ctx, func(ctx context.Context) error {
+			if err := s.orderRepo.Create(ctx, order); err != nil {
+				return err
+			}
+			if err := s.recordChange(ctx, order, statusChange("", order.Status, nil)); err != nil {
+				return err
+			}
+			if req.PromoCode == "" {
+				return nil
+			}
+
+			// Лимиты промокода проверяются повторно под блокировкой
+			quote, err := s.promoService.Reserve(ctx, req.PromoCode, req.UserID, promoLines(order.Items), order.ID)
+			if err != nil {
+				reserveErr = err
+				return err
+			}
+			if quote.Discount != order.DiscountAmount {
+				applyDiscount(order, quote)
+				return s.orderRepo.UpdateDiscount(ctx, order)
+			}
+			return nil
+		})
+		if err == nil || !db.IsUniqueViolation(err, "orders_order_number_key") || attempt == orderNumberAttempts {
+			break
+		}
+		log.Printf("Номер заказа %s уже занят, попытка %d", order.OrderNumber, attempt)
+	}
+	if err != nil {
+		message := "Ошибка создания заказа"
+		if reserveErr != nil {
+			message = reserveErr.Error()
+		}
+		return &CreateOrderResponse{
+			Success: false,
+			Message: message,
+		}, err
 	}
 
-	err = s.orderRepo.Create(ctx, order)
+	// Корзина для фискального чека: по позиции на каждый купон
+	orderBundle, err := buildOrderBundle(order.Items)
 	if err != nil {
+		s.failOrder(ctx, order)
 		return &CreateOrderResponse{
 			Success: false,
 			Message: "Ошибка создания заказа",
 		}, err
 	}
 
+	jsonParams, _ := json.Marshal(map[string]string{
+		"userId":  req.UserID,
+		"orderId": strconv.FormatInt(order.ID, 10),
+	})
+
 	// Регистрируем заказ в Альфа-Банке
 	alfaReq := &AlfaBankRegisterRequest{
-		OrderNumber:        orderNumber,
-		Amount:             amountInKopecks,
+		OrderNumber:        order.OrderNumber,
+		Amount:             order.Amount,
 		Currency:           "810", // Рубли
-		ReturnUrl:          req.ReturnURL,
-		FailUrl:            req.FailURL,
+		ReturnUrl:          order.ReturnURL,
+		FailUrl:            order.FailURL,
 		Description:        order.Description,
-		Language:           "ru",
+		Language:           locale,
 		ClientId:           req.UserID,
-		JsonParams:         fmt.Sprintf(`{"couponId":"%d","userId":"%s","orderId":"%d"}`, req.CouponID, req.UserID, order.ID),
+		JsonParams:         string(jsonParams),
 		SessionTimeoutSecs: 1200,
+		OrderBundle:        orderBundle,
+	}
+
+	alfaClient, err := s.gateway(ctx, order.MerchantID)
+	if err != nil {
+		s.failOrder(ctx, order)
+		return &CreateOrderResponse{
+			Success: false,
+			Message: "Ошибка регистрации платежа",
+		}, err
 	}
 
-	alfaResp, err := s.alfaClient.RegisterOrder(ctx, alfaReq)
+	alfaResp, err := alfaClient.RegisterOrder(ctx, alfaReq)
 	if err != nil {
 		// Обновляем статус заказа на failed
-		s.orderRepo.UpdateStatus(ctx, order.ID, OrderStatusFailed)
+		s.failOrder(ctx, order)
 		return &CreateOrderResponse{
 			Success: false,
 			Message: "Ошибка регистрации платежа",
@@ -208,41 +644,215 @@ func (s *CouponService) CreateOrder(ctx context.Context, req *CreateOrderRequest
 
 	if alfaResp.ErrorCode != "" && alfaResp.ErrorCode != "0" {
 		// Обновляем статус заказа на failed
-		s.orderRepo.UpdateStatus(ctx, order.ID, OrderStatusFailed)
+		s.failOrder(ctx, order)
 		return &CreateOrderResponse{
 			Success: false,
 			Message: alfaResp.ErrorMessage,
 		}, fmt.Errorf("ошибка API Альфа-Банка: %s", alfaResp.ErrorMessage)
 	}
 
-	// Обновляем заказ с данными от Альфа-Банка
-	err = s.orderRepo.UpdateAlfaBankOrderID(ctx, order.ID, alfaResp.OrderId)
+	// Номер заказа в банке и статус pending сохраняются вместе: без номера
+	// сверка с банком не найдет оплату, поэтому покупатель не получит ссылку
+	err = s.inTx(ctx, func(ctx context.Context) error {
+		if _, err := s.orderRepo.LockByOrderNumber(ctx, order.OrderNumber); err != nil {
+			return err
+		}
+		if err := s.orderRepo.UpdateAlfaBankOrderID(ctx, order.ID, alfaResp.OrderId); err != nil {
+			return err
+		}
+		order.AlfaBankOrderID = alfaResp.OrderId
+		return s.setOrderStatus(ctx, order, OrderStatusPending, nil)
+	})
 	if err != nil {
-		log.Printf("Ошибка обновления AlfaBankOrderID: %v", err)
+		s.failOrder(ctx, order)
+		return &CreateOrderResponse{
+			Success: false,
+			Message: "Ошибка создания заказа",
+		}, err
+	}
+
+	return &CreateOrderResponse{
+		OrderID:     order.ID,
+		OrderNumber: order.OrderNumber,
+		PaymentURL:  alfaResp.FormUrl,
+		Amount:      float64(order.Amount) / 100,
+		Discount:    float64(order.DiscountAmount) / 100,
+		Success:     true,
+		Message:     "Заказ успешно создан",
+	}, nil
+}
+
+// Магазин купонов заказа; nil — купоны общего каталога. Отключенный
+// магазин не продает купоны
+func (s *CouponService) orderMerchant(ctx context.Context, coupons []*Coupon) (*Merchant, error) {
+	merchantID := coupons[0].MerchantID
+	for _, coupon := range coupons[1:] {
+		if coupon.MerchantID != merchantID {
+			return nil, ErrMixedMerchants
+		}
+	}
+	if merchantID == 0 {
+		return nil, nil
 	}
 
-	// Обновляем статус на pending
-	err = s.orderRepo.UpdateStatus(ctx, order.ID, OrderStatusPending)
+	merchant, err := s.merchantService.GetMerchant(ctx, merchantID)
 	if err != nil {
-		log.Printf("Ошибка обновления статуса заказа: %v", err)
+		return nil, err
 	}
+	if merchant == nil {
+		return nil, ErrCouponNotFound
+	}
+	return merchant, nil
+}
 
-	return &CreateOrderResponse{
-		OrderID:    order.ID,
-		PaymentURL: alfaResp.FormUrl,
-		Success:    true,
-		Message:    "Заказ успешно создан",
-	}, nil
+// Позиции заказа из запроса: coupon_id — заказ из одного купона,
+// повторяющиеся купоны в items объединяются в одну позицию
+func orderItemRequests(req *CreateOrderRequest) ([]OrderItemRequest, error) {
+	if len(req.Items) == 0 {
+		if req.CouponID <= 0 {
+			return nil, ErrInvalidOrderItems
+		}
+		return []OrderItemRequest{{CouponID: req.CouponID, Quantity: 1}}, nil
+	}
+	if req.CouponID != 0 {
+		return nil, ErrInvalidOrderItems
+	}
+
+	var items []OrderItemRequest
+	positions := make(map[int64]int)
+	for _, item := range req.Items {
+		if item.CouponID <= 0 || item.Quantity <= 0 {
+			return nil, ErrInvalidOrderItems
+		}
+		if i, ok := positions[item.CouponID]; ok {
+			items[i].Quantity += item.Quantity
+		} else {
+			positions[item.CouponID] = len(items)
+			items = append(items, item)
+		}
+	}
+
+	if len(items) > maxOrderItems {
+		return nil, ErrInvalidOrderItems
+	}
+	for _, item := range items {
+		if item.Quantity > maxItemQuantity {
+			return nil, ErrInvalidOrderItems
+		}
+	}
+
+	return items, nil
+}
+
+func orderQuantity(items []*OrderItem) int {
+	quantity := 0
+	for _, item := range items {
+		quantity += item.Quantity
+	}
+	return quantity
+}
+
+func promoLines(items []*OrderItem) []promo.Line {
+	lines := make([]promo.Line, len(items))
+	for i, item := range items {
+		lines[i] = promo.Line{
+			CouponID: item.CouponID,
+			Amount:   item.UnitPrice * int64(item.Quantity),
+		}
+	}
+	return lines
+}
+
+// Применение скидки промокода к заказу и его позициям
+func applyDiscount(order *Order, quote *promo.Quote) {
+	order.PromoCodeID = quote.PromoCodeID
+	order.DiscountAmount = quote.Discount
+	order.Amount = quote.Amount
+	for i, item := range order.Items {
+		item.DiscountAmount = quote.LineDiscounts[i]
+		item.Amount = item.UnitPrice*int64(item.Quantity) - item.DiscountAmount
+	}
+}
+
+// Перевод заказа в failed с освобождением промокода одной транзакцией.
+// Вызывается, когда операция уже не удалась, поэтому ошибка только логируется
+func (s *CouponService) failOrder(ctx context.Context, order *Order) {
+	err := s.inTx(ctx, func(ctx context.Context) error {
+		if _, err := s.orderRepo.LockByOrderNumber(ctx, order.OrderNumber); err != nil {
+			return err
+		}
+		if err := s.setOrderStatus(ctx, order, OrderStatusFailed, nil); err != nil {
+			return err
+		}
+		return s.releasePromo(ctx, order)
+	})
+	if err != nil {
+		log.Printf("Ошибка перевода заказа %s в failed: %v", order.OrderNumber, err)
+	}
+}
+
+func (s *CouponService) releasePromo(ctx context.Context, order *Order) error {
+	if order.PromoCodeID == 0 {
+		return nil
+	}
+	if err := s.promoService.Release(ctx, order.ID); err != nil {
+		return fmt.Errorf("ошибка освобождения промокода заказа %d: %w", order.ID, err)
+	}
+	return nil
+}
+
+// Клиент шлюза для заказов партнера: под его учетной записью, если она
+// задана, иначе под общей
+func (s *CouponService) gateway(ctx context.Context, merchantID int64) (*AlfaBankClient, error) {
+	if merchantID == 0 {
+		return s.alfaClient, nil
+	}
+	credentials, ok, err := s.merchantService.GatewayCredentials(ctx, merchantID)
+	if err != nil || !ok {
+		return s.alfaClient, err
+	}
+	return s.alfaClient.WithCredentials(credentials), nil
+}
+
+// Расчет скидки по промокоду для купона без создания заказа
+func (s *CouponService) CheckPromoCode(ctx context.Context, req *promo.CheckPromoCodeRequest) (*promo.Quote, error) {
+	coupon, err := s.couponRepo.GetByID(ctx, req.CouponID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrCouponNotFound
+		}
+		return nil, err
+	}
+
+	return s.promoService.Quote(ctx, req.Code, req.UserID, []promo.Line{
+		{CouponID: coupon.ID, Amount: int64(coupon.Price * 100)},
+	})
+}
+
+// Проверка, что заказ принадлежит пользователю; пустой userID — доступ
+// без ограничения владельцем
+func (s *CouponService) CheckOrderAccess(ctx context.Context, orderNumber, userID string) error {
+	order, err := s.orderRepo.GetByOrderNumber(ctx, orderNumber)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrOrderNotFound
+		}
+		return err
+	}
+	if userID != "" && order.UserID != userID {
+		return ErrOrderNotFound
+	}
+	return nil
 }
 
-func (s *CouponService) CheckOrderStatus(ctx context.Context, orderNumber string) (*OrderStatusResponse, error) {
+func (s *CouponService) CheckOrderStatus(ctx context.Context, orderNumber, locale string) (*OrderStatusResponse, error) {
 	// Получаем заказ из базы данных
 	order, err := s.orderRepo.GetByOrderNumber(ctx, orderNumber)
 	if err != nil {
-		return &OrderStatusResponse{
-			Success: false,
-			Message: "Заказ не найден",
-		}, err
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrOrderNotFound
+		}
+		return nil, err
 	}
 
 	// Если у нас нет ID заказа от Альфа-Банка, возвращаем текущий статус
@@ -256,8 +866,12 @@ func (s *CouponService) CheckOrderStatus(ctx context.Context, orderNumber string
 		}, nil
 	}
 
-	// Проверяем статус в Альфа-Банке
-	alfaStatus, err := s.alfaClient.GetOrderStatus(ctx, order.AlfaBankOrderID)
+	// Проверяем статус в Альфа-Банке под учетной записью магазина заказа
+	alfaClient, err := s.gateway(ctx, order.MerchantID)
+	if err != nil {
+		return nil, err
+	}
+	alfaStatus, err := alfaClient.GetOrderStatus(ctx, order.AlfaBankOrderID)
 	if err != nil {
 		return &OrderStatusResponse{
 			OrderID:  order.ID,
@@ -269,51 +883,1381 @@ func (s *CouponService) CheckOrderStatus(ctx context.Context, orderNumber string
 		}, nil
 	}
 
-	// Обновляем статус заказа в зависимости от ответа банка
-	var newStatus string
-	switch alfaStatus.OrderStatus {
-	case 2: // Успешно оплачен
-		newStatus = OrderStatusPaid
-		// Активируем купон для пользователя
-		err = s.userCouponRepo.ActivateCoupon(ctx, order.UserID, order.CouponID, order.ID)
-		if err != nil {
-			log.Printf("Ошибка активации купона: %v", err)
-		}
-	case 1: // В процессе оплаты
-		newStatus = OrderStatusPending
-	case 6: // Отклонен
-		newStatus = OrderStatusFailed
-	default:
-		newStatus = order.Status
+	// Банк возвращает 4 после любого возврата, в том числе частичного,
+	// которые проводит RefundOrder: заказ считается возвращенным, только
+	// если возвращена вся сумма. Суммы запрашиваются до транзакции, чтобы
+	// не держать блокировку заказа на время запроса в банк
+	fullyRefunded := false
+	if alfaStatus.OrderStatus == 4 {
+		fullyRefunded = s.fullyRefunded(ctx, alfaClient, order)
 	}
 
-	// Обновляем статус в базе данных, если он изменился
-	if newStatus != order.Status {
-		err = s.orderRepo.UpdateStatus(ctx, order.ID, newStatus)
+	// Статус, выдача купонов, данные оплаты и промокод меняются одной
+	// транзакцией. Заказ перечитывается под блокировкой: параллельная проверка
+	// или сверка могли изменить его, пока шел запрос в банк
+	err = s.inTx(ctx, func(ctx context.Context) error {
+		locked, err := s.orderRepo.LockByOrderNumber(ctx, orderNumber)
 		if err != nil {
-			log.Printf("Ошибка обновления статуса заказа: %v", err)
+			return err
 		}
-	}
+		order = locked
 
-	couponName := ""
-	if order.Coupon != nil {
-		couponName = order.Coupon.Name
+		var newStatus string
+		switch alfaStatus.OrderStatus {
+		case 2: // Успешно оплачен
+			newStatus = OrderStatusPaid
+			if order.Status == OrderStatusRefunded {
+				newStatus = order.Status
+			}
+			// Активируем купоны для пользователя: по одному на каждую единицу позиций
+			activated, err := s.userCouponRepo.ActivateOrder(ctx, order, func(item *OrderItem) time.Time {
+				if item.Coupon != nil && item.Coupon.ValidDays > 0 {
+					return time.Now().AddDate(0, 0, item.Coupon.ValidDays)
+				}
+				return time.Time{}
+			})
+			if err != nil {
+				return fmt.Errorf("ошибка активации купонов: %w", err)
+			}
+			// Повторная проверка оплаченного заказа ничего не активирует
+			if activated > 0 {
+				err = s.recordChange(ctx, order, &OrderChange{
+					Kind:       OrderChangeCouponsActivated,
+					BankStatus: &alfaStatus.OrderStatus,
+					Details:    map[string]any{"activated": activated},
+				})
+				if err != nil {
+					return err
+				}
+				err = s.outboxService.Add(ctx, outbox.EventCouponActivated, order.OrderNumber, &CouponActivatedEventData{
+					OrderNumber: order.OrderNumber,
+					UserID:      order.UserID,
+					MerchantID:  order.MerchantID,
+					Activated:   activated,
+				})
+				if err != nil {
+					return err
+				}
+			}
+		case 1: // В процессе оплаты
+			newStatus = OrderStatusPending
+		case 4: // Возвращен
+			newStatus = order.Status
+			if fullyRefunded {
+				newStatus = OrderStatusRefunded
+			}
+		case 6: // Отклонен
+			newStatus = OrderStatusFailed
+		default:
+			newStatus = order.Status
+		}
+
+		// Отмененный заказ возвращается в работу, только если банк все же
+		// провел оплату брошенной платежной страницы
+		if order.Status == OrderStatusCancelled && newStatus != OrderStatusPaid {
+			newStatus = order.Status
+		}
+
+		// Обновляем статус в базе данных, если он изменился
+		if newStatus == order.Status {
+			return nil
+		}
+		if newStatus == OrderStatusPaid || newStatus == OrderStatusFailed {
+			if err := s.savePaymentDetails(ctx, order, alfaStatus); err != nil {
+				return err
+			}
+		}
+		if err := s.setOrderStatus(ctx, order, newStatus, alfaStatus); err != nil {
+			return err
+		}
+		if newStatus == OrderStatusFailed {
+			return s.releasePromo(ctx, order)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	return &OrderStatusResponse{
-		OrderID:    order.ID,
-		Status:     newStatus,
-		CouponName: couponName,
-		Amount:     float64(order.Amount) / 100,
-		Currency:   order.Currency,
-		Success:    true,
-	}, nil
+	return s.orderStatusResponse(ctx, order, locale), nil
 }
 
-func (s *CouponService) GetUserCoupons(ctx context.Context, userID string) ([]UserCoupon, error) {
-	return s.userCouponRepo.GetUserCoupons(ctx, userID)
+// Данные попытки оплаты сохраняются зашифрованными. Ошибка шифрования
+// не мешает обновлению статуса; ошибка записи возвращается, так как
+// прерывает транзакцию
+func (s *CouponService) savePaymentDetails(ctx context.Context, order *Order, alfaStatus *AlfaBankStatusResponse) error {
+	details := PaymentDetails{
+		Pan:            alfaStatus.Pan,
+		Expiration:     alfaStatus.Expiration,
+		CardholderName: alfaStatus.CardholderName,
+		ApprovalCode:   alfaStatus.ApprovalCode,
+		IP:             alfaStatus.Ip,
+	}
+	if details == (PaymentDetails{}) {
+		return nil
+	}
+
+	data, err := json.Marshal(details)
+	if err != nil {
+		log.Printf("Ошибка сохранения данных оплаты заказа %s: %v", order.OrderNumber, err)
+		return nil
+	}
+	encrypted, err := s.envelope.Encrypt(ctx, string(data))
+	if err != nil {
+		log.Printf("Ошибка шифрования данных оплаты заказа %s: %v", order.OrderNumber, err)
+		return nil
+	}
+	if err := s.orderRepo.UpdatePaymentDetails(ctx, order.ID, encrypted); err != nil {
+		return fmt.Errorf("ошибка сохранения данных оплаты заказа %s: %w", order.OrderNumber, err)
+	}
+	order.PaymentDetails = encrypted
+	return nil
+}
+
+// Расшифрованные данные попытки оплаты; nil, если банк их не передал
+func (s *CouponService) GetPaymentDetails(ctx context.Context, orderNumber string) (*PaymentDetails, error) {
+	order, err := s.orderRepo.GetByOrderNumber(ctx, orderNumber)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrOrderNotFound
+		}
+		return nil, err
+	}
+	if order.PaymentDetails == "" {
+		return nil, nil
+	}
+
+	data, err := s.envelope.Decrypt(ctx, order.PaymentDetails)
+	if err != nil {
+		return nil, fmt.Errorf("данные оплаты заказа %s: %w", orderNumber, err)
+	}
+	details := &PaymentDetails{}
+	if err := json.Unmarshal([]byte(data), details); err != nil {
+		return nil, err
+	}
+	return details, nil
+}
+
+// Все изменения заказа и его купонов по порядку
+func (s *CouponService) GetOrderTimeline(ctx context.Context, orderNumber string) (*OrderTimelineResponse, error) {
+	order, err := s.orderRepo.GetByOrderNumber(ctx, orderNumber)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrOrderNotFound
+		}
+		return nil, err
+	}
+
+	changes, err := s.changeRepo.ListByOrder(ctx, order.ID)
+	if err != nil {
+		return nil, err
+	}
+	if changes == nil {
+		changes = []OrderChange{}
+	}
+
+	return &OrderTimelineResponse{
+		OrderID:     order.ID,
+		OrderNumber: order.OrderNumber,
+		Status:      order.Status,
+		Events:      changes,
+	}, nil
+}
+
+// Перешифрование данных оплаты основным ключом после ротации
+func (s *CouponService) ReencryptPaymentDetails(ctx context.Context, batch int) (int, error) {
+	return s.envelope.ReencryptAll(ctx, batch, s.orderRepo.ListStalePaymentDetails, s.orderRepo.UpdatePaymentDetails)
+}
+
+// Статус заказа из базы без запроса в банк
+func (s *CouponService) GetOrderStatus(ctx context.Context, orderNumber, locale string) (*OrderStatusResponse, error) {
+	order, err := s.orderRepo.GetByOrderNumber(ctx, orderNumber)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrOrderNotFound
+		}
+		return nil, err
+	}
+	return s.orderStatusResponse(ctx, order, locale), nil
+}
+
+// Текущее состояние заказа в виде события для начала подписки
+func (s *CouponService) OrderSnapshot(ctx context.Context, orderNumber string) (*OrderEvent, error) {
+	order, err := s.orderRepo.GetByOrderNumber(ctx, orderNumber)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrOrderNotFound
+		}
+		return nil, err
+	}
+	event := newOrderEvent(OrderEventSnapshot, order, "")
+	return &event, nil
+}
+
+func (s *CouponService) orderStatusResponse(ctx context.Context, order *Order, locale string) *OrderStatusResponse {
+	couponName := ""
+	if order.Coupon != nil {
+		if err := s.couponRepo.Localize(ctx, locale, order.Coupon); err != nil {
+			log.Printf("Ошибка получения перевода купона: %v", err)
+		}
+		couponName = order.Coupon.Name
+	}
+
+	return &OrderStatusResponse{
+		OrderID:    order.ID,
+		MerchantID: order.MerchantID,
+		Status:     order.Status,
+		CouponName: couponName,
+		Items:      order.Items,
+		Amount:     float64(order.Amount) / 100,
+		Discount:   float64(order.DiscountAmount) / 100,
+		Refunded:   float64(order.RefundedAmount) / 100,
+		Currency:   order.Currency,
+		Success:    true,
+	}
+}
+
+// Возвращена ли в банке вся сумма заказа; при ошибке запроса статус
+// заказа не меняется
+func (s *CouponService) fullyRefunded(ctx context.Context, alfaClient *AlfaBankClient, order *Order) bool {
+	status, err := alfaClient.GetOrderStatusExtended(ctx, order.AlfaBankOrderID)
+	if err == nil && status.ErrorCode != "" && status.ErrorCode != "0" {
+		err = fmt.Errorf("банк вернул ошибку %s: %s", status.ErrorCode, status.ErrorMessage)
+	}
+	if err != nil {
+		log.Printf("Ошибка проверки суммы возвратов заказа %s: %v", order.OrderNumber, err)
+		return false
+	}
+	return status.PaymentAmountInfo.RefundedAmount >= order.Amount
+}
+
+// Ограничения поиска заказов
+const (
+	defaultOrderSearchLimit = 50
+	maxOrderSearchLimit     = 200
+)
+
+var ErrInvalidOrderSearch = errors.New("неверные параметры поиска заказов")
+
+func (s *CouponService) SearchOrders(ctx context.Context, filter *OrderSearchRequest) ([]Order, error) {
+	if filter.Limit < 0 || filter.Offset < 0 {
+		return nil, ErrInvalidOrderSearch
+	}
+	if filter.Limit == 0 {
+		filter.Limit = defaultOrderSearchLimit
+	}
+	if filter.Limit > maxOrderSearchLimit {
+		filter.Limit = maxOrderSearchLimit
+	}
+
+	return s.orderRepo.Search(ctx, filter)
+}
+
+// Возврат части единиц по позициям заказа. Возвращаются только
+// неиспользованные купоны покупателя; в банк уходит чек возврата по тем же
+// позициям. Возврат сохраняется до запроса в банк, а в заказе проводится
+// отдельной транзакцией после ответа: запрос не держит блокировку заказа,
+// а возврат, проведенный банком, не теряется при сбое — его завершит сверка
+func (s *CouponService) RefundOrder(ctx context.Context, orderNumber string, req *RefundRequest) (*RefundResponse, error) {
+	if len(req.Items) == 0 {
+		return nil, ErrInvalidRefundItems
+	}
+
+	found, err := s.orderRepo.GetByOrderNumber(ctx, orderNumber)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrOrderNotFound
+		}
+		return nil, err
+	}
+	alfaClient, err := s.gateway(ctx, found.MerchantID)
+	if err != nil {
+		return nil, err
+	}
+
+	var refundItems string
+	refund, err := s.orderRepo.CreateRefund(ctx, found.ID, func(order *Order, available map[int64][]int64) ([]RefundLine, error) {
+		if order.Status != OrderStatusPaid || order.AlfaBankOrderID == "" {
+			return nil, ErrOrderNotRefundable
+		}
+
+		items := make(map[int64]*OrderItem, len(order.Items))
+		for _, item := range order.Items {
+			items[item.ID] = item
+		}
+
+		var lines []RefundLine
+		seen := make(map[int64]bool)
+		for _, itemReq := range req.Items {
+			item, ok := items[itemReq.ItemID]
+			if !ok || seen[itemReq.ItemID] || itemReq.Quantity <= 0 {
+				return nil, ErrInvalidRefundItems
+			}
+			seen[itemReq.ItemID] = true
+
+			if itemReq.Quantity > len(available[item.ID]) ||
+				item.RefundedQuantity+itemReq.Quantity > item.Quantity {
+				return nil, ErrRefundUnavailable
+			}
+
+			lines = append(lines, RefundLine{
+				Item:          item,
+				Quantity:      itemReq.Quantity,
+				Amount:        item.RefundAmount(itemReq.Quantity),
+				UserCouponIDs: available[item.ID][:itemReq.Quantity],
+			})
+		}
+
+		var err error
+		refundItems, err = buildRefundItems(lines)
+		return lines, err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	alfaResp, err := alfaClient.Refund(ctx, &AlfaBankRefundRequest{
+		OrderId:     found.AlfaBankOrderID,
+		Amount:      refund.Amount,
+		RefundItems: refundItems,
+	})
+	if err != nil {
+		// Неизвестно, провел ли банк возврат: купоны остаются снятыми с
+		// погашения, пока сверка не узнает результат
+		log.Printf("Возврат %d по заказу %s не подтвержден банком: %v", refund.ID, orderNumber, err)
+		return nil, fmt.Errorf("%w: %v", ErrRefundUnconfirmed, err)
+	}
+	if alfaResp.ErrorCode != "" && alfaResp.ErrorCode != "0" {
+		if err := s.orderRepo.FailRefund(ctx, refund.ID, alfaResp.ErrorMessage); err != nil {
+			log.Printf("Ошибка отмены отклоненного возврата %d: %v", refund.ID, err)
+		}
+		return nil, fmt.Errorf("%w: %s", ErrRefundRejected, alfaResp.ErrorMessage)
+	}
+
+	order, err := s.completeRefund(ctx, orderNumber, refund)
+	if err != nil {
+		return nil, fmt.Errorf("возврат %d проведен банком, но не сохранен, его завершит сверка: %w", refund.ID, err)
+	}
+
+	return &RefundResponse{
+		OrderID:        order.ID,
+		Status:         order.Status,
+		Amount:         float64(refund.Amount) / 100,
+		RefundedAmount: float64(order.RefundedAmount) / 100,
+		Success:        true,
+	}, nil
+}
+
+// Проведение подтвержденного банком возврата в заказе под его блокировкой
+func (s *CouponService) completeRefund(ctx context.Context, orderNumber string, refund *Refund) (*Order, error) {
+	var (
+		order    *Order
+		previous string
+	)
+	err := s.inTx(ctx, func(ctx context.Context) error {
+		locked, err := s.orderRepo.LockByOrderNumber(ctx, orderNumber)
+		if err != nil {
+			return err
+		}
+		previous = locked.Status
+
+		order, err = s.orderRepo.CompleteRefund(ctx, refund.ID)
+		if err != nil {
+			return err
+		}
+		if err := s.recordChange(ctx, order, refundChange(previous, order.Status, refund)); err != nil {
+			return err
+		}
+		s.publish(ctx, newOrderEvent(OrderEventRefunded, order, previous))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Возврат по заказу %s на сумму %d коп.", order.OrderNumber, refund.Amount)
+	// Ошибка постановки вебхука не должна отменять проведенный возврат
+	s.notify(ctx, webhook.EventOrderRefunded, &OrderWebhookData{
+		Order:        order,
+		RefundAmount: float64(refund.Amount) / 100,
+	})
+	return order, nil
+}
+
+// Сверка возвратов, оставшихся в pending: банк не ответил на запрос или
+// возврат не удалось сохранить после ответа. Возврат считается проведенным,
+// если сумма возвратов в банке покрывает его сверх уже проведенных в заказе
+func (s *CouponService) ReconcileRefunds(ctx context.Context, createdBefore time.Time, limit int) {
+	refunds, err := s.orderRepo.ListPendingRefunds(ctx, createdBefore, limit)
+	if err != nil {
+		log.Printf("Ошибка получения возвратов для сверки: %v", err)
+		return
+	}
+
+	for i := range refunds {
+		if ctx.Err() != nil {
+			return
+		}
+		if err := s.reconcileRefund(ctx, &refunds[i]); err != nil {
+			log.Printf("Ошибка сверки возврата %d: %v", refunds[i].ID, err)
+		}
+	}
+}
+
+func (s *CouponService) reconcileRefund(ctx context.Context, refund *Refund) error {
+	// Заказ перечитывается: предыдущий возврат этого же заказа мог только
+	// что завершиться и увеличить сумму возвратов
+	order, err := s.orderRepo.GetByOrderNumber(ctx, refund.Order.OrderNumber)
+	if err != nil {
+		return err
+	}
+	alfaClient, err := s.gateway(ctx, order.MerchantID)
+	if err != nil {
+		return err
+	}
+	status, err := alfaClient.GetOrderStatusExtended(ctx, order.AlfaBankOrderID)
+	if err != nil {
+		return err
+	}
+	if status.ErrorCode != "" && status.ErrorCode != "0" {
+		return fmt.Errorf("банк вернул ошибку %s: %s", status.ErrorCode, status.ErrorMessage)
+	}
+
+	if status.PaymentAmountInfo.RefundedAmount >= order.RefundedAmount+refund.Amount {
+		_, err := s.completeRefund(ctx, order.OrderNumber, refund)
+		return err
+	}
+	log.Printf("Возврат %d по заказу %s не найден в банке, купоны снова доступны", refund.ID, order.OrderNumber)
+	return s.orderRepo.FailRefund(ctx, refund.ID, "возврат не найден в банке при сверке")
+}
+
+// Отмена неоплаченного заказа покупателем или сотрудником. Удержанные банком
+// средства возвращаются через reverse.do; заказ без оплаты просто
+// закрывается, платежная страница банка истечет сама. Промокод заказа
+// освобождается. userID ограничивает отмену заказами пользователя, пустой —
+// для сотрудников
+func (s *CouponService) CancelOrder(ctx context.Context, orderNumber, userID, locale string) (*OrderStatusResponse, error) {
+	order, err := s.orderRepo.GetByOrderNumber(ctx, orderNumber)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrOrderNotFound
+		}
+		return nil, err
+	}
+	if userID != "" && order.UserID != userID {
+		return nil, ErrOrderNotFound
+	}
+	if !isCancellable(order.Status) {
+		return nil, ErrOrderNotCancellable
+	}
+
+	// Статус в базе мог отстать от банка, поэтому решение принимается по
+	// ответу банка, а не по статусу заказа
+	var alfaStatus *AlfaBankStatusResponse
+	if order.AlfaBankOrderID != "" {
+		alfaClient, err := s.gateway(ctx, order.MerchantID)
+		if err != nil {
+			return nil, err
+		}
+		alfaStatus, err = alfaClient.GetOrderStatus(ctx, order.AlfaBankOrderID)
+		if err != nil {
+			return nil, err
+		}
+
+		switch alfaStatus.OrderStatus {
+		case 2, 4: // Оплачен или уже возвращен
+			// Заказ переводится в статус банка с выдачей купонов как при
+			// обычной проверке; отмена оплаченного заказа — это возврат
+			if _, err := s.CheckOrderStatus(ctx, orderNumber, locale); err != nil {
+				log.Printf("Ошибка обновления статуса оплаченного заказа %s: %v", orderNumber, err)
+			}
+			return nil, ErrOrderAlreadyPaid
+		case 5: // Идет проверка 3-D Secure
+			return nil, ErrPaymentInProgress
+		case 1: // Средства удержаны
+			reverse, err := alfaClient.Reverse(ctx, order.AlfaBankOrderID)
+			if err != nil {
+				return nil, err
+			}
+			if reverse.ErrorCode != "" && reverse.ErrorCode != "0" {
+				return nil, fmt.Errorf("%w: %s", ErrCancelRejected, reverse.ErrorMessage)
+			}
+			log.Printf("Удержание по заказу %s снято в банке", orderNumber)
+		}
+	}
+
+	err = s.inTx(ctx, func(ctx context.Context) error {
+		locked, err := s.orderRepo.LockByOrderNumber(ctx, orderNumber)
+		if err != nil {
+			return err
+		}
+		order = locked
+		// Параллельная проверка статуса могла успеть изменить заказ
+		if !isCancellable(order.Status) {
+			return ErrOrderNotCancellable
+		}
+		if err := s.setOrderStatus(ctx, order, OrderStatusCancelled, alfaStatus); err != nil {
+			return err
+		}
+		return s.releasePromo(ctx, order)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s.orderStatusResponse(ctx, order, locale), nil
+}
+
+func isCancellable(status string) bool {
+	return status == OrderStatusCreated || status == OrderStatusPending
+}
+
+func (s *CouponService) GetUserCoupons(ctx context.Context, userID, locale string) ([]UserCoupon, error) {
+	userCoupons, err := s.userCouponRepo.GetUserCoupons(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	coupons := make([]*Coupon, len(userCoupons))
+	for i := range userCoupons {
+		coupons[i] = userCoupons[i].Coupon
+	}
+	if err := s.couponRepo.Localize(ctx, locale, coupons...); err != nil {
+		return nil, err
+	}
+
+	return userCoupons, nil
+}
+
+func (s *CouponService) GetUserCouponByCode(ctx context.Context, code, locale string) (*CouponCodeResponse, error) {
+	normalized, err := NormalizeCouponCode(code)
+	if err != nil {
+		return nil, err
+	}
+
+	userCoupon, err := s.userCouponRepo.GetByCode(ctx, normalized)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrCouponCodeNotFound
+		}
+		return nil, err
+	}
+
+	response := &CouponCodeResponse{
+		Code:        userCoupon.Code,
+		IsUsed:      userCoupon.IsUsed,
+		ActivatedAt: userCoupon.ActivatedAt,
+	}
+	if userCoupon.Coupon != nil {
+		if err := s.couponRepo.Localize(ctx, locale, userCoupon.Coupon); err != nil {
+			return nil, err
+		}
+		response.CouponName = userCoupon.Coupon.Name
+		response.Description = userCoupon.Coupon.Description
+	}
+	if !userCoupon.UsedAt.IsZero() {
+		response.UsedAt = &userCoupon.UsedAt
+	}
+
+	return response, nil
+}
+
+// Изображение QR-кода или штрихкода с подписанным кодом купона
+func (s *CouponService) GetUserCouponBarcode(ctx context.Context, userID string, userCouponID int64, kind, format string) ([]byte, string, error) {
+	userCoupon, err := s.userCouponRepo.GetUserCoupon(ctx, userID, userCouponID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, "", ErrCouponCodeNotFound
+		}
+		return nil, "", err
+	}
+	if userCoupon.Code == "" {
+		return nil, "", ErrCouponCodeNotFound
+	}
+
+	return RenderBarcode(s.codeSigner.Sign(userCoupon.Code), kind, format)
+}
+
+func (s *CouponService) RedeemCoupon(ctx context.Context, merchant *Merchant, req *RedeemCouponRequest) (*RedeemCouponResponse, error) {
+	// Отсканированный код приходит с подписью, введенный вручную — без нее
+	var (
+		normalized string
+		err        error
+	)
+	if req.Payload != "" {
+		normalized, err = s.codeSigner.Verify(req.Payload)
+	} else {
+		normalized, err = NormalizeCouponCode(req.Code)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Одного кода недостаточно: кассир указывает покупателя, предъявившего купон
+	if req.UserID == "" {
+		return nil, ErrCouponOwnerMissing
+	}
+
+	userCoupon, err := s.userCouponRepo.GetByCode(ctx, normalized)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrCouponCodeNotFound
+		}
+		return nil, err
+	}
+
+	if req.UserID != userCoupon.UserID {
+		return nil, ErrCouponNotOwned
+	}
+	if userCoupon.Order == nil || userCoupon.Order.Status != OrderStatusPaid {
+		return nil, ErrCouponNotPaid
+	}
+
+	now := time.Now()
+	if err := redeemableError(userCoupon, now); err != nil {
+		return nil, err
+	}
+
+	couponName := ""
+	if userCoupon.Coupon != nil {
+		couponName = userCoupon.Coupon.Name
+	}
+	response := &RedeemCouponResponse{
+		Code:       userCoupon.Code,
+		CouponName: couponName,
+		UserID:     userCoupon.UserID,
+		MerchantID: merchant.ID,
+		TerminalID: req.TerminalID,
+		RedeemedAt: now,
+		Success:    true,
+	}
+
+	// Погашение и вебхук о нем фиксируются вместе
+	err = s.inTx(ctx, func(ctx context.Context) error {
+		// Повторная проверка выполняется в самом UPDATE, поэтому два кассира
+		// не смогут погасить один и тот же код
+		used, err := s.userCouponRepo.UseCoupon(ctx, userCoupon.ID, merchant.ID, req.TerminalID, now)
+		if err != nil {
+			return err
+		}
+		if !used {
+			current, err := s.userCouponRepo.GetByCode(ctx, normalized)
+			if err != nil {
+				return err
+			}
+			if err := redeemableError(current, now); err != nil {
+				return err
+			}
+			return ErrCouponAlreadyUsed
+		}
+
+		err = s.recordChange(ctx, userCoupon.Order, &OrderChange{
+			Kind:         OrderChangeCouponRedeemed,
+			UserCouponID: userCoupon.ID,
+			Details:      map[string]any{"merchant_id": merchant.ID, "terminal_id": req.TerminalID},
+		})
+		if err != nil {
+			return err
+		}
+
+		return s.webhookService.Publish(ctx, webhook.EventCouponRedeemed, &CouponRedeemedWebhookData{
+			RedeemCouponResponse: *response,
+			UserCouponID:         userCoupon.ID,
+			CouponID:             userCoupon.CouponID,
+			OrderNumber:          userCoupon.Order.OrderNumber,
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	log.Printf("Купон %s погашен партнером %d (терминал %q)", userCoupon.Code, merchant.ID, req.TerminalID)
+	return response, nil
+}
+
+func redeemableError(userCoupon *UserCoupon, now time.Time) error {
+	if !userCoupon.RefundedAt.IsZero() {
+		return ErrCouponRefunded
+	}
+	if userCoupon.IsUsed {
+		return ErrCouponAlreadyUsed
+	}
+	if userCoupon.IsExpired(now) {
+		return ErrCouponExpired
+	}
+	return nil
 }
 
-func (s *CouponService) GetUserOrders(ctx context.Context, userID string) ([]Order, error) {
-	return s.orderRepo.GetUserOrders(ctx, userID)
+func (s *CouponService) GetUserOrders(ctx context.Context, userID, locale string) ([]Order, error) {
+	orders, err := s.orderRepo.GetUserOrders(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	coupons := make([]*Coupon, len(orders))
+	for i := range orders {
+		coupons[i] = orders[i].Coupon
+	}
+	if err := s.couponRepo.Localize(ctx, locale, coupons...); err != nil {
+		return nil, err
+	}
+
+	return orders, nil
+}
+
+// Ограничения на изображения купонов
+const maxCouponImageSize = 5 << 20
+
+var couponImageTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/webp": ".webp",
+}
+
+var (
+	ErrCouponNotFound       = errors.New("купон не найден")
+	ErrUnsupportedImageType = errors.New("поддерживаются только изображения JPEG, PNG и WebP")
+	ErrImageTooLarge        = errors.New("изображение слишком большое")
+
+	ErrUnsupportedLocale       = errors.New("неподдерживаемый язык")
+	ErrTranslationNameRequired = errors.New("не указано название")
+)
+
+type CatalogService struct {
+	couponRepo *CouponRepository
+	storage    storage.Storage
+}
+
+func NewCatalogService(couponRepo *CouponRepository, storage storage.Storage) *CatalogService {
+	return &CatalogService{
+		couponRepo: couponRepo,
+		storage:    storage,
+	}
+}
+
+// Страница каталога и курсор следующей страницы (пустой, если страница последняя)
+func (s *CatalogService) GetCoupons(ctx context.Context, filter *CouponFilter) ([]Coupon, string, error) {
+	if err := filter.Normalize(); err != nil {
+		return nil, "", err
+	}
+
+	// Запрашиваем на один купон больше, чтобы понять, есть ли следующая страница
+	page := *filter
+	page.Limit = filter.Limit + 1
+
+	coupons, err := s.couponRepo.GetActiveCoupons(ctx, &page)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Курсор строится до перевода: сортировка по названию идет по русскому
+	// тексту в базе
+	nextCursor := ""
+	if len(coupons) > filter.Limit {
+		coupons = coupons[:filter.Limit]
+		nextCursor = filter.NextCursor(&coupons[len(coupons)-1])
+	}
+
+	localized := make([]*Coupon, len(coupons))
+	for i := range coupons {
+		localized[i] = &coupons[i]
+	}
+	if err := s.couponRepo.Localize(ctx, filter.Locale, localized...); err != nil {
+		return nil, "", err
+	}
+
+	return coupons, nextCursor, nil
+}
+
+// Сохранение перевода купона; русский текст хранится в самом купоне
+func (s *CatalogService) SaveTranslation(ctx context.Context, couponID int64, locale string, req *CouponTranslationRequest) (*CouponTranslation, error) {
+	if locale == i18n.DefaultLocale || !i18n.IsSupported(locale) {
+		return nil, ErrUnsupportedLocale
+	}
+	if req.Name == "" {
+		return nil, ErrTranslationNameRequired
+	}
+
+	if _, err := s.couponRepo.GetByID(ctx, couponID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrCouponNotFound
+		}
+		return nil, err
+	}
+
+	translation := &CouponTranslation{
+		CouponID:    couponID,
+		Locale:      locale,
+		Name:        req.Name,
+		Description: req.Description,
+	}
+	if err := s.couponRepo.SaveTranslation(ctx, translation); err != nil {
+		return nil, err
+	}
+
+	return translation, nil
+}
+
+// Передача купона магазину: заказы на купон регистрируются под его учетной записью
+func (s *CatalogService) SetCouponMerchant(ctx context.Context, couponID, merchantID int64) (*Coupon, error) {
+	coupon, err := s.couponRepo.GetByID(ctx, couponID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrCouponNotFound
+		}
+		return nil, err
+	}
+
+	if err := s.couponRepo.UpdateMerchant(ctx, couponID, merchantID); err != nil {
+		return nil, err
+	}
+	coupon.MerchantID = merchantID
+	return coupon, nil
+}
+
+func (s *CatalogService) GetCategories(ctx context.Context) ([]Category, error) {
+	return s.couponRepo.GetCategories(ctx)
+}
+
+// Загрузка изображения купона; тип определяется по содержимому, а не по заголовку
+func (s *CatalogService) UploadCouponImage(ctx context.Context, couponID int64, r io.Reader) (*Coupon, error) {
+	coupon, err := s.couponRepo.GetByID(ctx, couponID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrCouponNotFound
+		}
+		return nil, err
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r, maxCouponImageSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения изображения: %w", err)
+	}
+	if len(data) > maxCouponImageSize {
+		return nil, ErrImageTooLarge
+	}
+
+	ext, ok := couponImageTypes[http.DetectContentType(data)]
+	if !ok {
+		return nil, ErrUnsupportedImageType
+	}
+
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return nil, fmt.Errorf("ошибка генерации имени файла: %w", err)
+	}
+	key := fmt.Sprintf("coupons/%d/%s%s", coupon.ID, hex.EncodeToString(suffix), ext)
+
+	if err := s.storage.Save(ctx, key, bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+
+	imageURL := s.storage.URL(key)
+	if err := s.couponRepo.UpdateImage(ctx, coupon.ID, key, imageURL); err != nil {
+		s.storage.Delete(ctx, key)
+		return nil, err
+	}
+
+	// Старое изображение больше не нужно
+	if coupon.ImageKey != "" {
+		if err := s.storage.Delete(ctx, coupon.ImageKey); err != nil {
+			log.Printf("Ошибка удаления старого изображения купона %d: %v", coupon.ID, err)
+		}
+	}
+
+	coupon.ImageKey = key
+	coupon.ImageURL = imageURL
+	return coupon, nil
+}
+
+var (
+	ErrInvalidMerchant  = errors.New("неверные параметры партнера")
+	ErrMerchantNotFound = errors.New("партнер не найден")
+)
+
+var brandColorPattern = regexp.MustCompile(`^#[0-9a-fA-F]{6}$`)
+
+type MerchantService struct {
+	merchantRepo *MerchantRepository
+	envelope     *secret.Envelope
+}
+
+func NewMerchantService(merchantRepo *MerchantRepository, envelope *secret.Envelope) *MerchantService {
+	return &MerchantService{
+		merchantRepo: merchantRepo,
+		envelope:     envelope,
+	}
+}
+
+// Создание партнера; ключ возвращается один раз, в базе хранится только хеш
+func (s *MerchantService) CreateMerchant(ctx context.Context, req *MerchantRequest) (*Merchant, string, error) {
+	merchant := &Merchant{IsActive: true}
+	if err := s.apply(ctx, merchant, req); err != nil {
+		return nil, "", err
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, "", fmt.Errorf("ошибка генерации API-ключа: %w", err)
+	}
+	apiKey := "mk_" + hex.EncodeToString(key)
+	merchant.APIKeyHash = hashSecret(apiKey)
+
+	if err := s.merchantRepo.Create(ctx, merchant); err != nil {
+		return nil, "", err
+	}
+
+	return merchant, apiKey, nil
+}
+
+func (s *MerchantService) UpdateMerchant(ctx context.Context, id int64, req *MerchantRequest) (*Merchant, error) {
+	merchant, err := s.merchantRepo.GetWithInactive(ctx, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrMerchantNotFound
+		}
+		return nil, err
+	}
+
+	if err := s.apply(ctx, merchant, req); err != nil {
+		return nil, err
+	}
+	if err := s.merchantRepo.Update(ctx, merchant); err != nil {
+		return nil, err
+	}
+	return merchant, nil
+}
+
+func (s *MerchantService) ListMerchants(ctx context.Context) ([]Merchant, error) {
+	return s.merchantRepo.List(ctx)
+}
+
+// Перенос полей запроса в партнера; пароль шлюза шифруется
+func (s *MerchantService) apply(ctx context.Context, merchant *Merchant, req *MerchantRequest) error {
+	if req.Name == "" {
+		return ErrInvalidMerchant
+	}
+	if req.Branding.PrimaryColor != "" && !brandColorPattern.MatchString(req.Branding.PrimaryColor) {
+		return fmt.Errorf("%w: цвет задается в формате #rrggbb", ErrInvalidMerchant)
+	}
+
+	merchant.Name = req.Name
+	merchant.GatewayURL = req.GatewayURL
+	merchant.ReturnURL = req.ReturnURL
+	merchant.FailURL = req.FailURL
+	merchant.Branding = req.Branding
+
+	// Без логина магазин работает через общую учетную запись
+	merchant.GatewayUsername = req.GatewayUsername
+	if req.GatewayUsername == "" {
+		merchant.GatewayURL = ""
+		merchant.GatewayPassword = ""
+		return nil
+	}
+	if req.GatewayPassword == "" {
+		if merchant.GatewayPassword == "" {
+			return fmt.Errorf("%w: не указан пароль шлюза", ErrInvalidMerchant)
+		}
+		return nil
+	}
+
+	encrypted, err := s.envelope.Encrypt(ctx, req.GatewayPassword)
+	if err != nil {
+		return err
+	}
+	merchant.GatewayPassword = encrypted
+	return nil
+}
+
+// Учетная запись партнера в шлюзе; false — заказы партнера идут через
+// общую учетную запись. Отключенный партнер учитывается: по его старым
+// заказам возможны проверки статуса и возвраты
+func (s *MerchantService) GatewayCredentials(ctx context.Context, id int64) (GatewayCredentials, bool, error) {
+	merchant, err := s.merchantRepo.GetWithInactive(ctx, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return GatewayCredentials{}, false, nil
+		}
+		return GatewayCredentials{}, false, err
+	}
+	if !merchant.HasGatewayAccount() {
+		return GatewayCredentials{}, false, nil
+	}
+
+	password, err := s.envelope.Decrypt(ctx, merchant.GatewayPassword)
+	if err != nil {
+		return GatewayCredentials{}, false, fmt.Errorf("пароль шлюза партнера %d: %w", id, err)
+	}
+	return GatewayCredentials{
+		BaseURL:  merchant.GatewayURL,
+		Username: merchant.GatewayUsername,
+		Password: password,
+	}, true, nil
+}
+
+// Перешифрование паролей шлюза основным ключом после ротации
+func (s *MerchantService) ReencryptSecrets(ctx context.Context, batch int) (int, error) {
+	return s.envelope.ReencryptAll(ctx, batch, s.merchantRepo.ListStaleSecrets, s.merchantRepo.UpdateGatewayPassword)
+}
+
+func (s *MerchantService) GetMerchant(ctx context.Context, id int64) (*Merchant, error) {
+	merchant, err := s.merchantRepo.GetByID(ctx, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return merchant, nil
+}
+
+func (s *MerchantService) Authenticate(ctx context.Context, apiKey string) (*Merchant, error) {
+	merchant, err := s.merchantRepo.GetByAPIKeyHash(ctx, hashSecret(apiKey))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return merchant, nil
+}
+
+// API-ключи и токены храним только в виде SHA-256
+func hashSecret(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(sum[:])
+}
+
+// Срок действия ссылки на подарок
+const giftLinkTTL = 7 * 24 * time.Hour
+
+var (
+	ErrGiftNotFound          = errors.New("подарок не найден")
+	ErrGiftNotPending        = errors.New("подарок уже принят или отменен")
+	ErrGiftExpired           = errors.New("срок действия подарка истек")
+	ErrGiftWrongRecipient    = errors.New("подарок предназначен другому пользователю")
+	ErrGiftToSelf            = errors.New("нельзя подарить купон самому себе")
+	ErrGiftAlreadyPending    = errors.New("для купона уже создан подарок")
+	ErrCouponNotTransferable = errors.New("купон нельзя передать: он использован, истек или не оплачен")
+)
+
+type GiftService struct {
+	userCouponRepo *UserCouponRepository
+	giftRepo       *GiftRepository
+	changeRepo     OrderChangeStore
+	tx             TxRunner
+}
+
+func NewGiftService(userCouponRepo *UserCouponRepository, giftRepo *GiftRepository, changeRepo OrderChangeStore, tx TxRunner) *GiftService {
+	return &GiftService{
+		userCouponRepo: userCouponRepo,
+		giftRepo:       giftRepo,
+		changeRepo:     changeRepo,
+		tx:             tx,
+	}
+}
+
+// Создание подарка: адресного (to_user_id) или по ссылке с токеном
+func (s *GiftService) CreateGift(ctx context.Context, fromUserID string, userCouponID int64, req *CreateGiftRequest) (*CreateGiftResponse, error) {
+	if req.ToUserID != "" && req.ToUserID == fromUserID {
+		return nil, ErrGiftToSelf
+	}
+
+	userCoupon, err := s.userCouponRepo.GetUserCoupon(ctx, fromUserID, userCouponID)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrCouponCodeNotFound
+		}
+		return nil, err
+	}
+
+	now := time.Now()
+	if !isTransferable(userCoupon, now) {
+		return nil, ErrCouponNotTransferable
+	}
+
+	if err := s.giftRepo.CancelExpired(ctx, userCoupon.ID, now); err != nil {
+		return nil, err
+	}
+
+	token, err := generateGiftToken()
+	if err != nil {
+		return nil, err
+	}
+
+	gift := &CouponGift{
+		UserCouponID: userCoupon.ID,
+		FromUserID:   fromUserID,
+		ToUserID:     req.ToUserID,
+		TokenHash:    hashSecret(token),
+		Status:       GiftStatusPending,
+		ExpiresAt:    now.Add(giftLinkTTL),
+	}
+	if err := s.giftRepo.Create(ctx, gift); err != nil {
+		if db.IsUniqueViolation(err, "idx_coupon_gifts_pending") {
+			return nil, ErrGiftAlreadyPending
+		}
+		return nil, err
+	}
+
+	return &CreateGiftResponse{
+		GiftID:    gift.ID,
+		Token:     token,
+		GiftURL:   "/api/v1/gifts/" + token,
+		ToUserID:  gift.ToUserID,
+		ExpiresAt: gift.ExpiresAt,
+		Success:   true,
+	}, nil
+}
+
+func (s *GiftService) GetGift(ctx context.Context, token string) (*GiftResponse, error) {
+	gift, err := s.giftRepo.GetByTokenHash(ctx, hashSecret(token))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrGiftNotFound
+		}
+		return nil, err
+	}
+
+	couponName := ""
+	if gift.UserCoupon != nil && gift.UserCoupon.Coupon != nil {
+		couponName = gift.UserCoupon.Coupon.Name
+	}
+
+	return &GiftResponse{
+		GiftID:     gift.ID,
+		CouponName: couponName,
+		FromUserID: gift.FromUserID,
+		Status:     gift.Status,
+		ExpiresAt:  gift.ExpiresAt,
+	}, nil
+}
+
+// Принятие подарка: купон получает нового владельца и новый код,
+// чтобы даритель не мог воспользоваться старым. Передача записывается
+// в журнал заказа купона в той же транзакции
+func (s *GiftService) AcceptGift(ctx context.Context, token, userID string) (*UserCoupon, error) {
+	newCode, err := GenerateCouponCode()
+	if err != nil {
+		return nil, fmt.Errorf("ошибка генерации кода купона: %w", err)
+	}
+
+	now := time.Now()
+	var userCoupon *UserCoupon
+	err = s.tx.RunInTx(ctx, func(ctx context.Context) error {
+		var fromUserID string
+		accepted, err := s.giftRepo.Accept(ctx, hashSecret(token), userID, newCode, func(gift *CouponGift) error {
+			switch {
+			case gift.Status != GiftStatusPending:
+				return ErrGiftNotPending
+			case !now.Before(gift.ExpiresAt):
+				return ErrGiftExpired
+			case gift.ToUserID != "" && gift.ToUserID != userID:
+				return ErrGiftWrongRecipient
+			case gift.FromUserID == userID:
+				return ErrGiftToSelf
+			case gift.UserCoupon.UserID != gift.FromUserID || !isTransferable(gift.UserCoupon, now):
+				return ErrCouponNotTransferable
+			}
+			fromUserID = gift.FromUserID
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		userCoupon = accepted
+		return s.changeRepo.Append(ctx, couponTransferChange(ctx, userCoupon, fromUserID, userID))
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrGiftNotFound
+		}
+		return nil, err
+	}
+
+	log.Printf("Купон %d передан в подарок пользователю %s", userCoupon.ID, userID)
+	return userCoupon, nil
+}
+
+func (s *GiftService) CancelGift(ctx context.Context, fromUserID string, giftID int64) error {
+	cancelled, err := s.giftRepo.Cancel(ctx, giftID, fromUserID)
+	if err != nil {
+		return err
+	}
+	if !cancelled {
+		return ErrGiftNotFound
+	}
+	return nil
+}
+
+// Передавать можно только оплаченный, неиспользованный, невозвращенный и действующий купон
+func isTransferable(userCoupon *UserCoupon, now time.Time) bool {
+	return userCoupon.Order != nil &&
+		userCoupon.Order.Status == OrderStatusPaid &&
+		!userCoupon.IsUsed &&
+		userCoupon.RefundedAt.IsZero() &&
+		!userCoupon.IsExpired(now)
+}
+
+func generateGiftToken() (string, error) {
+	token := make([]byte, 24)
+	if _, err := rand.Read(token); err != nil {
+		return "", fmt.Errorf("ошибка генерации токена подарка: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(token), nil
+}
+
+// Ограничения корзины
+const maxCartItems = maxOrderItems
+
+var (
+	ErrCartEmpty           = errors.New("корзина пуста")
+	ErrCartFull            = errors.New("в корзине слишком много позиций")
+	ErrInvalidCartQuantity = errors.New("неверное количество купонов")
+)
+
+type CartService struct {
+	cartRepo      *CartRepository
+	couponRepo    *CouponRepository
+	couponService *CouponService
+}
+
+func NewCartService(cartRepo *CartRepository, couponRepo *CouponRepository, couponService *CouponService) *CartService {
+	return &CartService{
+		cartRepo:      cartRepo,
+		couponRepo:    couponRepo,
+		couponService: couponService,
+	}
+}
+
+// Содержимое корзины; снятые с продажи купоны в ней не показываются
+func (s *CartService) GetCart(ctx context.Context, userID, locale string) (*CartResponse, error) {
+	items, err := s.cartRepo.GetItems(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	var coupons []*Coupon
+	for _, item := range items {
+		if item.Coupon != nil && item.Coupon.IsActive {
+			coupons = append(coupons, item.Coupon)
+		}
+	}
+	if err := s.couponRepo.Localize(ctx, locale, coupons...); err != nil {
+		return nil, err
+	}
+
+	cart := &CartResponse{
+		Items:    []CartItemResponse{},
+		Currency: "RUB",
+	}
+	var total int64
+	for _, item := range items {
+		if item.Coupon == nil || !item.Coupon.IsActive {
+			continue
+		}
+		unitPrice := int64(item.Coupon.Price * 100)
+		cart.Items = append(cart.Items, CartItemResponse{
+			CouponID:  item.CouponID,
+			Name:      item.Coupon.Name,
+			ImageURL:  item.Coupon.ImageURL,
+			Quantity:  item.Quantity,
+			UnitPrice: item.Coupon.Price,
+			Amount:    float64(unitPrice*int64(item.Quantity)) / 100,
+		})
+		cart.Quantity += item.Quantity
+		cart.Currency = item.Coupon.Currency
+		total += unitPrice * int64(item.Quantity)
+	}
+	cart.Total = float64(total) / 100
+
+	return cart, nil
+}
+
+// Установка количества купона в корзине; 0 удаляет позицию
+func (s *CartService) SetItem(ctx context.Context, userID string, couponID int64, quantity int, locale string) (*CartResponse, error) {
+	if quantity < 0 || quantity > maxItemQuantity {
+		return nil, ErrInvalidCartQuantity
+	}
+	if quantity == 0 {
+		return s.RemoveItem(ctx, userID, couponID, locale)
+	}
+
+	if _, err := s.couponRepo.GetByID(ctx, couponID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrCouponNotFound
+		}
+		return nil, err
+	}
+
+	items, err := s.cartRepo.GetItems(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	inCart := false
+	for _, item := range items {
+		if item.CouponID == couponID {
+			inCart = true
+			break
+		}
+	}
+	if !inCart && len(items) >= maxCartItems {
+		return nil, ErrCartFull
+	}
+
+	if err := s.cartRepo.SetQuantity(ctx, userID, couponID, quantity); err != nil {
+		return nil, err
+	}
+
+	return s.GetCart(ctx, userID, locale)
+}
+
+func (s *CartService) RemoveItem(ctx context.Context, userID string, couponID int64, locale string) (*CartResponse, error) {
+	if err := s.cartRepo.RemoveItem(ctx, userID, couponID); err != nil {
+		return nil, err
+	}
+	return s.GetCart(ctx, userID, locale)
+}
+
+func (s *CartService) Clear(ctx context.Context, userID string) error {
+	return s.cartRepo.Clear(ctx, userID)
+}
+
+// Оформление корзины одним заказом с одной оплатой в банке.
+// Корзина очищается только после успешной регистрации заказа
+func (s *CartService) Checkout(ctx context.Context, userID string, req *CheckoutRequest) (*CreateOrderResponse, error) {
+	items, err := s.cartRepo.GetItems(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	orderReq := &CreateOrderRequest{
+		UserID:    userID,
+		ReturnURL: req.ReturnURL,
+		FailURL:   req.FailURL,
+		Language:  req.Language,
+		PromoCode: req.PromoCode,
+	}
+	for _, item := range items {
+		if item.Coupon == nil || !item.Coupon.IsActive {
+			continue
+		}
+		orderReq.Items = append(orderReq.Items, OrderItemRequest{
+			CouponID: item.CouponID,
+			Quantity: item.Quantity,
+		})
+	}
+	if len(orderReq.Items) == 0 {
+		return nil, ErrCartEmpty
+	}
+
+	response, err := s.couponService.CreateOrder(ctx, orderReq)
+	if err != nil {
+		return response, err
+	}
+
+	if err := s.cartRepo.Clear(ctx, userID); err != nil {
+		log.Printf("Ошибка очистки корзины пользователя %s: %v", userID, err)
+	}
+
+	return response, nil
 }
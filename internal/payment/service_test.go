@@ -0,0 +1,323 @@
+package payment_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/skr1ms/PaymentAlphaBank.git/config"
+	"github.com/skr1ms/PaymentAlphaBank.git/internal/outbox"
+	"github.com/skr1ms/PaymentAlphaBank.git/internal/payment"
+	"github.com/skr1ms/PaymentAlphaBank.git/internal/payment/memstore"
+	"github.com/skr1ms/PaymentAlphaBank.git/internal/promo"
+	"github.com/skr1ms/PaymentAlphaBank.git/internal/webhook"
+	"github.com/skr1ms/PaymentAlphaBank.git/pkg/secret"
+)
+
+// Шлюз банка: регистрирует заказы, считает их оплаченными и проводит возвраты
+type fakeBank struct {
+	mu       sync.Mutex
+	refunded int64
+	rejected bool // отклонять возвраты
+}
+
+func (b *fakeBank) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var resp any
+	switch r.URL.Path {
+	case "/payment/rest/register.do":
+		resp = payment.AlfaBankRegisterResponse{OrderId: "alfa-" + r.FormValue("orderNumber"), FormUrl: "https://pay.test/form"}
+	case "/payment/rest/getOrderStatus.do":
+		resp = payment.AlfaBankStatusResponse{ErrorCode: "0", OrderStatus: 2}
+	case "/payment/rest/getOrderStatusExtended.do":
+		status := payment.AlfaBankExtendedStatusResponse{ErrorCode: "0", OrderStatus: 2}
+		status.PaymentAmountInfo.RefundedAmount = b.refunded
+		resp = status
+	case "/payment/rest/refund.do":
+		if b.rejected {
+			resp = payment.AlfaBankRefundResponse{ErrorCode: "7", ErrorMessage: "возврат запрещен"}
+			break
+		}
+		amount, _ := strconv.ParseInt(r.FormValue("amount"), 10, 64)
+		b.refunded += amount
+		resp = payment.AlfaBankRefundResponse{ErrorCode: "0"}
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+type stubPromoCodes struct{}
+
+func (stubPromoCodes) Quote(context.Context, string, string, []promo.Line) (*promo.Quote, error) {
+	return nil, promo.ErrPromoNotFound
+}
+
+func (stubPromoCodes) Reserve(context.Context, string, string, []promo.Line, int64) (*promo.Quote, error) {
+	return nil, promo.ErrPromoNotFound
+}
+
+func (stubPromoCodes) Release(context.Context, int64) error {
+	return nil
+}
+
+// Очередь вебхуков или outbox, запоминающая типы событий
+type recordedEvents struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (w *recordedEvents) Publish(ctx context.Context, eventType string, data any) error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.events = append(w.events, eventType)
+	return nil
+}
+
+func (w *recordedEvents) Add(ctx context.Context, eventType, key string, data any) error {
+	return w.Publish(ctx, eventType, data)
+}
+
+func (w *recordedEvents) count(eventType string) int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	n := 0
+	for _, event := range w.events {
+		if event == eventType {
+			n++
+		}
+	}
+	return n
+}
+
+// Купоны общего каталога, поэтому партнеры не запрашиваются
+type noMerchants struct{}
+
+func (noMerchants) GetMerchant(context.Context, int64) (*payment.Merchant, error) {
+	return nil, nil
+}
+
+func (noMerchants) GatewayCredentials(context.Context, int64) (payment.GatewayCredentials, bool, error) {
+	return payment.GatewayCredentials{}, false, nil
+}
+
+type serviceFixture struct {
+	service  *payment.CouponService
+	store    *memstore.Store
+	bank     *fakeBank
+	webhooks *recordedEvents
+	outbox   *recordedEvents
+	coupon   *payment.Coupon
+}
+
+func newServiceFixture(t *testing.T) *serviceFixture {
+	t.Helper()
+
+	bank := &fakeBank{}
+	server := httptest.NewServer(bank)
+	t.Cleanup(server.Close)
+
+	keys, err := secret.NewKeyring("test", map[string][]byte{"test": make([]byte, secret.KeySize)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{BaseURL: server.URL, GatewayTimeout: 5 * time.Second, CouponSigningKey: "test"}
+
+	store := memstore.New()
+	coupon := &payment.Coupon{Name: "Кофе", Price: 150, IsActive: true, ValidDays: 30}
+	if err := store.Coupons().Create(context.Background(), coupon); err != nil {
+		t.Fatal(err)
+	}
+
+	orderNumbers, err := payment.NewOrderNumberGenerator(config.OrderNumberConfig{Prefix: "CP", DateFormat: "YYMMDD", CheckDigit: true}, store.Orders())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	webhooks, outboxEvents := &recordedEvents{}, &recordedEvents{}
+	service := payment.NewCouponService(
+		store.Coupons(), store.Orders(), store.UserCoupons(), store.OrderChanges(), store,
+		orderNumbers, noMerchants{}, payment.NewAlfaBankClient(cfg), secret.NewEnvelope(keys),
+		payment.NewCodeSigner(cfg), stubPromoCodes{}, webhooks, outboxEvents,
+	)
+	return &serviceFixture{service: service, store: store, bank: bank, webhooks: webhooks, outbox: outboxEvents, coupon: coupon}
+}
+
+// Оплаченный заказ из quantity купонов с выданными покупателю купонами
+func (f *serviceFixture) paidOrder(t *testing.T, userID string, quantity int) (*payment.OrderStatusResponse, []payment.UserCoupon) {
+	t.Helper()
+	ctx := context.Background()
+
+	created, err := f.service.CreateOrder(ctx, &payment.CreateOrderRequest{
+		UserID:    userID,
+		Items:     []payment.OrderItemRequest{{CouponID: f.coupon.ID, Quantity: quantity}},
+		ReturnURL: "https://shop.test/return",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, err := f.service.CheckOrderStatus(ctx, created.OrderNumber, "ru")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Status != payment.OrderStatusPaid {
+		t.Fatalf("заказ не оплачен: %s", status.Status)
+	}
+
+	userCoupons, err := f.service.GetUserCoupons(ctx, userID, "ru")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(userCoupons) != quantity {
+		t.Fatalf("выдано купонов %d, ожидалось %d", len(userCoupons), quantity)
+	}
+	return status, userCoupons
+}
+
+func orderNumberOf(t *testing.T, f *serviceFixture, userID string) string {
+	t.Helper()
+	orders, err := f.service.GetUserOrders(context.Background(), userID, "ru")
+	if err != nil || len(orders) == 0 {
+		t.Fatalf("у пользователя нет заказов: %v", err)
+	}
+	return orders[0].OrderNumber
+}
+
+func TestCouponServicePurchaseRedeemRefund(t *testing.T) {
+	ctx := context.Background()
+	f := newServiceFixture(t)
+
+	status, userCoupons := f.paidOrder(t, "user-1", 3)
+	if status.Amount != 450 || len(status.Items) != 1 {
+		t.Fatalf("неверный заказ: %+v", status)
+	}
+	if f.webhooks.count(webhook.EventOrderPaid) != 1 {
+		t.Fatalf("вебхук оплаты: %v", f.webhooks.events)
+	}
+	if f.outbox.count(outbox.EventOrderPaid) != 1 || f.outbox.count(outbox.EventCouponActivated) != 1 {
+		t.Fatalf("события outbox: %v", f.outbox.events)
+	}
+	orderNumber := orderNumberOf(t, f, "user-1")
+	itemID := status.Items[0].ID
+
+	// Повторная проверка статуса не выдает купоны второй раз
+	if _, err := f.service.CheckOrderStatus(ctx, orderNumber, "ru"); err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := f.service.GetUserCoupons(ctx, "user-1", "ru"); len(again) != 3 {
+		t.Fatalf("купоны выданы повторно: %d", len(again))
+	}
+	if f.outbox.count(outbox.EventCouponActivated) != 1 {
+		t.Fatalf("повторное событие активации: %v", f.outbox.events)
+	}
+
+	merchant := &payment.Merchant{ID: 77, Name: "Кофейня"}
+	redeem := &payment.RedeemCouponRequest{Code: userCoupons[0].Code, UserID: "user-2"}
+	if _, err := f.service.RedeemCoupon(ctx, merchant, redeem); !errors.Is(err, payment.ErrCouponNotOwned) {
+		t.Fatalf("чужой купон погашен: %v", err)
+	}
+	redeem.UserID = "user-1"
+	if _, err := f.service.RedeemCoupon(ctx, merchant, redeem); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.service.RedeemCoupon(ctx, merchant, redeem); !errors.Is(err, payment.ErrCouponAlreadyUsed) {
+		t.Fatalf("купон погашен дважды: %v", err)
+	}
+
+	// Погашенный купон не возвращается
+	refundAll := &payment.RefundRequest{Items: []payment.RefundItemRequest{{ItemID: itemID, Quantity: 3}}}
+	if _, err := f.service.RefundOrder(ctx, orderNumber, refundAll); !errors.Is(err, payment.ErrRefundUnavailable) {
+		t.Fatalf("ожидалось ErrRefundUnavailable, получено %v", err)
+	}
+
+	refunded, err := f.service.RefundOrder(ctx, orderNumber, &payment.RefundRequest{
+		Items: []payment.RefundItemRequest{{ItemID: itemID, Quantity: 2}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refunded.Amount != 300 || refunded.RefundedAmount != 300 || refunded.Status != payment.OrderStatusPaid {
+		t.Fatalf("неверный возврат: %+v", refunded)
+	}
+	if f.bank.refunded != 30000 || f.webhooks.count(webhook.EventOrderRefunded) != 1 {
+		t.Fatalf("возврат в банке %d коп., вебхуки %v", f.bank.refunded, f.webhooks.events)
+	}
+
+	// Частичный возврат не делает заказ возвращенным при сверке статуса
+	status, err = f.service.CheckOrderStatus(ctx, orderNumber, "ru")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Status != payment.OrderStatusPaid || status.Refunded != 300 {
+		t.Fatalf("статус после частичного возврата: %+v", status)
+	}
+
+	for _, uc := range userCoupons[1:] {
+		_, err := f.service.RedeemCoupon(ctx, merchant, &payment.RedeemCouponRequest{Code: uc.Code, UserID: "user-1"})
+		if !errors.Is(err, payment.ErrCouponRefunded) {
+			t.Fatalf("возвращенный купон %s погашен: %v", uc.Code, err)
+		}
+	}
+}
+
+func TestCouponServiceRejectedRefund(t *testing.T) {
+	ctx := context.Background()
+	f := newServiceFixture(t)
+
+	status, userCoupons := f.paidOrder(t, "user-1", 1)
+	orderNumber := orderNumberOf(t, f, "user-1")
+	refund := &payment.RefundRequest{Items: []payment.RefundItemRequest{{ItemID: status.Items[0].ID, Quantity: 1}}}
+
+	f.bank.rejected = true
+	if _, err := f.service.RefundOrder(ctx, orderNumber, refund); !errors.Is(err, payment.ErrRefundRejected) {
+		t.Fatalf("ожидалось ErrRefundRejected, получено %v", err)
+	}
+	if f.webhooks.count(webhook.EventOrderRefunded) != 0 {
+		t.Fatalf("вебхук возврата отправлен для отклоненного возврата: %v", f.webhooks.events)
+	}
+
+	// Купон отклоненного возврата снова доступен для возврата
+	f.bank.rejected = false
+	refunded, err := f.service.RefundOrder(ctx, orderNumber, refund)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refunded.Status != payment.OrderStatusRefunded {
+		t.Fatalf("полный возврат не перевел заказ в refunded: %+v", refunded)
+	}
+	// Купоны возвращенного заказа не гасятся
+	_, err = f.service.RedeemCoupon(ctx, &payment.Merchant{ID: 77}, &payment.RedeemCouponRequest{Code: userCoupons[0].Code, UserID: "user-1"})
+	if !errors.Is(err, payment.ErrCouponNotPaid) {
+		t.Fatalf("купон возвращенного заказа погашен: %v", err)
+	}
+}
+
+func TestCouponServiceOrderAccess(t *testing.T) {
+	ctx := context.Background()
+	f := newServiceFixture(t)
+
+	f.paidOrder(t, "user-1", 1)
+	orderNumber := orderNumberOf(t, f, "user-1")
+
+	if err := f.service.CheckOrderAccess(ctx, orderNumber, "user-1"); err != nil {
+		t.Fatalf("владелец не получил доступ: %v", err)
+	}
+	if err := f.service.CheckOrderAccess(ctx, orderNumber, ""); err != nil {
+		t.Fatalf("доступ без ограничения владельцем: %v", err)
+	}
+	if err := f.service.CheckOrderAccess(ctx, orderNumber, "user-2"); !errors.Is(err, payment.ErrOrderNotFound) {
+		t.Fatalf("чужой заказ должен быть не найден: %v", err)
+	}
+	if err := f.service.CheckOrderAccess(ctx, "missing", ""); !errors.Is(err, payment.ErrOrderNotFound) {
+		t.Fatalf("ожидалось ErrOrderNotFound, получено %v", err)
+	}
+}
@@ -0,0 +1,58 @@
+package payment
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+
+	"github.com/skr1ms/PaymentAlphaBank.git/config"
+)
+
+// Префикс версии формата подписанного кода
+const signedCodePrefix = "PAB1"
+
+// Длина подписи в байтах: 96 бит достаточно и QR остается компактным
+const signatureLength = 12
+
+var ErrInvalidCodeSignature = errors.New("подпись кода купона недействительна")
+
+// Подпись кодов купонов, чтобы касса могла проверить отсканированный код
+type CodeSigner struct {
+	key []byte
+}
+
+func NewCodeSigner(config *config.Config) *CodeSigner {
+	return &CodeSigner{key: []byte(config.CouponSigningKey)}
+}
+
+// Формирование полезной нагрузки вида PAB1.XXXX-XXXX-XXXX.<подпись>
+func (s *CodeSigner) Sign(code string) string {
+	return signedCodePrefix + "." + code + "." + s.signature(code)
+}
+
+// Проверка подписи и извлечение кода купона из полезной нагрузки
+func (s *CodeSigner) Verify(payload string) (string, error) {
+	parts := strings.Split(strings.TrimSpace(payload), ".")
+	if len(parts) != 3 || parts[0] != signedCodePrefix {
+		return "", ErrInvalidCodeSignature
+	}
+
+	code, err := NormalizeCouponCode(parts[1])
+	if err != nil {
+		return "", err
+	}
+
+	if !hmac.Equal([]byte(parts[2]), []byte(s.signature(code))) {
+		return "", ErrInvalidCodeSignature
+	}
+
+	return code, nil
+}
+
+func (s *CodeSigner) signature(code string) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(signedCodePrefix + "." + code))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:signatureLength])
+}
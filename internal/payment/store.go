@@ -0,0 +1,128 @@
+package payment
+
+import (
+	"context"
+	"time"
+
+	"github.com/skr1ms/PaymentAlphaBank.git/internal/outbox"
+	"github.com/skr1ms/PaymentAlphaBank.git/internal/promo"
+	"github.com/skr1ms/PaymentAlphaBank.git/internal/webhook"
+	"github.com/skr1ms/PaymentAlphaBank.git/pkg/secret"
+)
+
+// Хранилища, с которыми работает CouponService. Основная реализация —
+// репозитории на Postgres, вторая — memstore в памяти. Обе обязаны проходить
+// общий набор проверок storetest.Run
+
+type CouponStore interface {
+	// Активный купон; sql.ErrNoRows, если купона нет или он отключен
+	GetByID(ctx context.Context, id int64) (*Coupon, error)
+	// Подстановка переводов названий и описаний для языка
+	Localize(ctx context.Context, locale string, coupons ...*Coupon) error
+}
+
+type OrderStore interface {
+	// Создание заказа с позициями; номер заказа уникален, при повторе —
+	// нарушение ограничения orders_order_number_key
+	Create(ctx context.Context, order *Order) error
+	// Следующий номер последовательности номеров заказов; не откатывается
+	// вместе с транзакцией
+	NextOrderSerial(ctx context.Context) (int64, error)
+	// Заказ с купоном и позициями по порядку; sql.ErrNoRows, если не найден
+	GetByOrderNumber(ctx context.Context, orderNumber string) (*Order, error)
+	// То же под блокировкой заказа до конца транзакции
+	LockByOrderNumber(ctx context.Context, orderNumber string) (*Order, error)
+	// Заказы пользователя, новые первыми
+	GetUserOrders(ctx context.Context, userID string) ([]Order, error)
+	// Заказы по фильтру, новые первыми
+	Search(ctx context.Context, filter *OrderSearchRequest) ([]Order, error)
+	UpdateStatus(ctx context.Context, orderID int64, status string) error
+	UpdateAlfaBankOrderID(ctx context.Context, orderID int64, alfaBankOrderID string) error
+	UpdateDiscount(ctx context.Context, order *Order) error
+	UpdatePaymentDetails(ctx context.Context, orderID int64, paymentDetails string) error
+	ListStalePaymentDetails(ctx context.Context, prefix string, afterID int64, limit int) ([]secret.Record, error)
+	// Возврат в статусе pending под блокировкой заказа. plan получает ID
+	// неиспользованных купонов по позициям и возвращает строки возврата; их
+	// купоны сразу снимаются с погашения. Ошибка plan отменяет изменения
+	CreateRefund(
+		ctx context.Context,
+		orderID int64,
+		plan func(order *Order, available map[int64][]int64) ([]RefundLine, error),
+	) (*Refund, error)
+	// Проведение подтвержденного банком возврата: суммы позиций и заказа,
+	// статус refunded при полном возврате, отмена подарков его купонов.
+	// Заказ возвращается с позициями; ErrRefundNotPending, если возврат
+	// уже завершен
+	CompleteRefund(ctx context.Context, refundID int64) (*Order, error)
+	// Отклоненный банком возврат: купоны снова доступны. ErrRefundNotPending,
+	// если возврат уже завершен
+	FailRefund(ctx context.Context, refundID int64, reason string) error
+	// Возвраты в pending, созданные раньше createdBefore, с заказом; старые первыми
+	ListPendingRefunds(ctx context.Context, createdBefore time.Time, limit int) ([]Refund, error)
+}
+
+type UserCouponStore interface {
+	// Выдача недостающих купонов по оплаченному заказу; возвращает число выданных
+	ActivateOrder(ctx context.Context, order *Order, expiresAt func(item *OrderItem) time.Time) (int, error)
+	GetByCode(ctx context.Context, code string) (*UserCoupon, error)
+	GetUserCoupon(ctx context.Context, userID string, userCouponID int64) (*UserCoupon, error)
+	// Купоны пользователя, последние активированные первыми
+	GetUserCoupons(ctx context.Context, userID string) ([]UserCoupon, error)
+	// Погашение купона; false, если купон использован, возвращен или истек
+	UseCoupon(ctx context.Context, userCouponID, merchantID int64, terminalID string, usedAt time.Time) (bool, error)
+}
+
+// Журнал изменений заказов: только добавление и чтение
+type OrderChangeStore interface {
+	Append(ctx context.Context, change *OrderChange) error
+	// Записи заказа в порядке добавления
+	ListByOrder(ctx context.Context, orderID int64) ([]OrderChange, error)
+}
+
+// Выполнение fn в одной транзакции: при ошибке все изменения хранилищ,
+// сделанные с переданным контекстом, отменяются. Вложенный вызов
+// присоединяется к внешней транзакции
+type TxRunner interface {
+	RunInTx(ctx context.Context, fn func(ctx context.Context) error) error
+}
+
+// Сервисы других модулей, от которых зависит CouponService. В приложении
+// это promo.PromoService, webhook.WebhookService, outbox.OutboxService
+// и MerchantService; в тестах их заменяют заглушками
+
+type PromoCodes interface {
+	// Расчет скидки без резервирования
+	Quote(ctx context.Context, code, userID string, lines []promo.Line) (*promo.Quote, error)
+	// Резервирование промокода за заказом
+	Reserve(ctx context.Context, code, userID string, lines []promo.Line, orderID int64) (*promo.Quote, error)
+	// Освобождение промокода неоплаченного заказа
+	Release(ctx context.Context, orderID int64) error
+}
+
+type WebhookPublisher interface {
+	// Постановка события в очередь доставки подписчикам
+	Publish(ctx context.Context, eventType string, data any) error
+}
+
+type OutboxWriter interface {
+	// Запись события в outbox в транзакции изменения
+	Add(ctx context.Context, eventType, key string, data any) error
+}
+
+type Merchants interface {
+	GetMerchant(ctx context.Context, id int64) (*Merchant, error)
+	// Учетная запись партнера в шлюзе; false — общая учетная запись
+	GatewayCredentials(ctx context.Context, id int64) (GatewayCredentials, bool, error)
+}
+
+var (
+	_ CouponStore      = (*CouponRepository)(nil)
+	_ OrderStore       = (*OrderRepository)(nil)
+	_ UserCouponStore  = (*UserCouponRepository)(nil)
+	_ OrderChangeStore = (*OrderChangeRepository)(nil)
+
+	_ PromoCodes       = (*promo.PromoService)(nil)
+	_ WebhookPublisher = (*webhook.WebhookService)(nil)
+	_ OutboxWriter     = (*outbox.OutboxService)(nil)
+	_ Merchants        = (*MerchantService)(nil)
+)
@@ -0,0 +1,84 @@
+package storetest
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/stdlib"
+	"github.com/skr1ms/PaymentAlphaBank.git/internal/payment"
+	"github.com/skr1ms/PaymentAlphaBank.git/migration"
+	"github.com/skr1ms/PaymentAlphaBank.git/pkg/db"
+	"github.com/uptrace/bun"
+	"github.com/uptrace/bun/dialect/pgdialect"
+)
+
+// Переменная окружения с адресом тестовой базы
+const databaseURLEnv = "TEST_DATABASE_URL"
+
+// Тестовая база из TEST_DATABASE_URL с примененными миграциями. Без переменной
+// проверка пропускается
+func OpenPostgres(t *testing.T) *bun.DB {
+	t.Helper()
+
+	url := os.Getenv(databaseURLEnv)
+	if url == "" {
+		t.Skipf("%s не задан", databaseURLEnv)
+	}
+	config, err := pgx.ParseConfig(url)
+	if err != nil {
+		t.Fatalf("%s: %v", databaseURLEnv, err)
+	}
+	config.DefaultQueryExecMode = pgx.QueryExecModeSimpleProtocol
+	bunDB := bun.NewDB(stdlib.OpenDB(*config), pgdialect.New())
+	t.Cleanup(func() { bunDB.Close() })
+
+	migrator, err := migration.NewMigrator(bunDB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := migrator.Up(context.Background()); err != nil {
+		t.Fatalf("миграции: %v", err)
+	}
+	return bunDB
+}
+
+// Репозитории на Postgres. Перед каждой проверкой таблицы очищаются,
+// поэтому база должна быть отдельной, тестовой
+func Postgres(bunDB *bun.DB) Factory {
+	return func(t *testing.T) Stores {
+		t.Helper()
+		ctx := context.Background()
+
+		_, err := bunDB.ExecContext(ctx, `TRUNCATE
+			order_events, refunds, coupon_gifts, coupon_transfers, user_coupons, promo_code_usages, order_items,
+			orders, cart_items, coupon_translations, coupons, merchants
+			RESTART IDENTITY CASCADE`)
+		if err != nil {
+			t.Fatalf("очистка таблиц: %v", err)
+		}
+
+		merchant := &payment.Merchant{Name: "storetest", APIKeyHash: "storetest"}
+		if _, err := bunDB.NewInsert().Model(merchant).Exec(ctx); err != nil {
+			t.Fatalf("создание магазина: %v", err)
+		}
+
+		return Stores{
+			Coupons:      payment.NewCouponRepository(bunDB),
+			Orders:       payment.NewOrderRepository(bunDB),
+			UserCoupons:  payment.NewUserCouponRepository(bunDB),
+			OrderChanges: payment.NewOrderChangeRepository(bunDB),
+			Tx:           db.NewTxRunner(bunDB),
+			CreateCoupon: func(ctx context.Context, coupon *payment.Coupon) error {
+				// is_active задается явно: false иначе заменится значением по умолчанию
+				_, err := bunDB.NewInsert().
+					Model(coupon).
+					Value("is_active", "?", coupon.IsActive).
+					Exec(ctx)
+				return err
+			},
+			MerchantID: merchant.ID,
+		}
+	}
+}
@@ -0,0 +1,734 @@
+// Общий набор проверок хранилищ payment. Любая реализация CouponStore,
+// OrderStore, UserCouponStore, OrderChangeStore и TxRunner должна его
+// проходить; storetest_test.go проверяет memstore и репозитории на Postgres
+// (с TEST_DATABASE_URL):
+//
+//	func TestPostgres(t *testing.T) {
+//		storetest.Run(t, storetest.Postgres(storetest.OpenPostgres(t)))
+//	}
+package storetest
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/skr1ms/PaymentAlphaBank.git/internal/payment"
+	"github.com/skr1ms/PaymentAlphaBank.git/internal/payment/memstore"
+	"github.com/skr1ms/PaymentAlphaBank.git/pkg/db"
+)
+
+// Проверяемые хранилища
+type Stores struct {
+	Coupons      payment.CouponStore
+	Orders       payment.OrderStore
+	UserCoupons  payment.UserCouponStore
+	OrderChanges payment.OrderChangeStore
+	Tx           payment.TxRunner
+
+	// Добавление купона в каталог: у CouponStore нет операции создания
+	CreateCoupon func(ctx context.Context, coupon *payment.Coupon) error
+	// Существующий магазин для погашения купонов
+	MerchantID int64
+}
+
+// Пустые хранилища для одной проверки
+type Factory func(t *testing.T) Stores
+
+func Memory() Factory {
+	return func(t *testing.T) Stores {
+		store := memstore.New()
+		return Stores{
+			Coupons:      store.Coupons(),
+			Orders:       store.Orders(),
+			UserCoupons:  store.UserCoupons(),
+			OrderChanges: store.OrderChanges(),
+			Tx:           store,
+			CreateCoupon: store.Coupons().Create,
+			MerchantID:   1,
+		}
+	}
+}
+
+func Run(t *testing.T, newStores Factory) {
+	tests := []struct {
+		name string
+		fn   func(t *testing.T, s Stores)
+	}{
+		{"CreateAndGetOrder", testCreateAndGetOrder},
+		{"OrderNumberUnique", testOrderNumberUnique},
+		{"OrderNotFound", testOrderNotFound},
+		{"OrderSerial", testOrderSerial},
+		{"LockOrder", testLockOrder},
+		{"UserOrdersNewestFirst", testUserOrdersNewestFirst},
+		{"SearchOrders", testSearchOrders},
+		{"UpdateOrder", testUpdateOrder},
+		{"StalePaymentDetails", testStalePaymentDetails},
+		{"InactiveCoupon", testInactiveCoupon},
+		{"ActivateOrderIdempotent", testActivateOrderIdempotent},
+		{"UseCouponOnce", testUseCouponOnce},
+		{"RefundLifecycle", testRefundLifecycle},
+		{"RefundFailAndRollback", testRefundFailAndRollback},
+		{"TxCommitAndRollback", testTxCommitAndRollback},
+		{"OrderChanges", testOrderChanges},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.fn(t, newStores(t))
+		})
+	}
+}
+
+// Время создания хранится с точностью до микросекунд, поэтому заказы,
+// порядок которых проверяется, создаются с паузой
+const createPause = 2 * time.Millisecond
+
+func createCoupon(t *testing.T, s Stores, name string) *payment.Coupon {
+	t.Helper()
+	coupon := &payment.Coupon{Name: name, Price: 100, Currency: "RUB", IsActive: true, ValidDays: 30}
+	if err := s.CreateCoupon(context.Background(), coupon); err != nil {
+		t.Fatalf("создание купона: %v", err)
+	}
+	return coupon
+}
+
+// Заказ на quantities единиц купона coupon, по позиции на каждое количество
+func createOrder(t *testing.T, s Stores, number, userID string, coupon *payment.Coupon, quantities ...int) *payment.Order {
+	t.Helper()
+	order := &payment.Order{
+		OrderNumber: number,
+		UserID:      userID,
+		Currency:    "RUB",
+		Status:      payment.OrderStatusCreated,
+	}
+	for i, quantity := range quantities {
+		amount := int64(quantity) * 10000
+		order.Items = append(order.Items, &payment.OrderItem{
+			Position:  i + 1,
+			CouponID:  coupon.ID,
+			Name:      coupon.Name,
+			Quantity:  quantity,
+			UnitPrice: 10000,
+			Amount:    amount,
+		})
+		order.Amount += amount
+	}
+	if len(order.Items) == 1 {
+		order.CouponID = coupon.ID
+	}
+	if err := s.Orders.Create(context.Background(), order); err != nil {
+		t.Fatalf("создание заказа %s: %v", number, err)
+	}
+	return order
+}
+
+func getOrder(t *testing.T, s Stores, number string) *payment.Order {
+	t.Helper()
+	order, err := s.Orders.GetByOrderNumber(context.Background(), number)
+	if err != nil {
+		t.Fatalf("заказ %s: %v", number, err)
+	}
+	return order
+}
+
+func orderNumbers(orders []payment.Order) []string {
+	numbers := make([]string, len(orders))
+	for i, order := range orders {
+		numbers[i] = order.OrderNumber
+	}
+	return numbers
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func noExpiry(item *payment.OrderItem) time.Time {
+	return time.Time{}
+}
+
+func testCreateAndGetOrder(t *testing.T, s Stores) {
+	coupon := createCoupon(t, s, "Кофе")
+	created := createOrder(t, s, "ORD-1", "user-1", coupon, 2, 1)
+	if created.ID == 0 || created.Items[0].ID == 0 || created.Items[1].OrderID != created.ID {
+		t.Fatalf("ID заказа и позиций не заполнены: %+v", created)
+	}
+	if created.CreatedAt.IsZero() {
+		t.Fatal("время создания не заполнено")
+	}
+
+	order := getOrder(t, s, "ORD-1")
+	if order.ID != created.ID || order.UserID != "user-1" || order.Amount != 30000 {
+		t.Fatalf("неверный заказ: %+v", order)
+	}
+	if len(order.Items) != 2 || order.Items[0].Position != 1 || order.Items[1].Position != 2 {
+		t.Fatalf("позиции должны идти по порядку: %+v", order.Items)
+	}
+	if order.Items[0].Coupon == nil || order.Items[0].Coupon.ID != coupon.ID {
+		t.Fatal("у позиций должен быть купон")
+	}
+}
+
+func testOrderNumberUnique(t *testing.T, s Stores) {
+	coupon := createCoupon(t, s, "Кофе")
+	createOrder(t, s, "ORD-1", "user-1", coupon, 1)
+
+	duplicate := &payment.Order{OrderNumber: "ORD-1", UserID: "user-2", Amount: 100, Currency: "RUB"}
+	err := s.Orders.Create(context.Background(), duplicate)
+	if !db.IsUniqueViolation(err, "orders_order_number_key") {
+		t.Fatalf("ожидалось нарушение уникальности номера, получено %v", err)
+	}
+
+	orders, err := s.Orders.GetUserOrders(context.Background(), "user-2")
+	if err != nil || len(orders) != 0 {
+		t.Fatalf("повторный заказ не должен сохраниться: %v, %d", err, len(orders))
+	}
+}
+
+func testOrderNotFound(t *testing.T, s Stores) {
+	_, err := s.Orders.GetByOrderNumber(context.Background(), "missing")
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("ожидалось sql.ErrNoRows, получено %v", err)
+	}
+	_, err = s.UserCoupons.GetByCode(context.Background(), "missing")
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("ожидалось sql.ErrNoRows для кода, получено %v", err)
+	}
+}
+
+// Номера последовательности растут и не возвращаются при откате транзакции
+func testOrderSerial(t *testing.T, s Stores) {
+	ctx := context.Background()
+	first, err := s.Orders.NextOrderSerial(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var second int64
+	rollback := errors.New("откат")
+	err = s.Tx.RunInTx(ctx, func(ctx context.Context) error {
+		var err error
+		if second, err = s.Orders.NextOrderSerial(ctx); err != nil {
+			return err
+		}
+		return rollback
+	})
+	if !errors.Is(err, rollback) {
+		t.Fatalf("ожидалась ошибка fn, получено %v", err)
+	}
+
+	third, err := s.Orders.NextOrderSerial(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !(first < second && second < third) {
+		t.Fatalf("номера должны расти и не повторяться после отката: %d, %d, %d", first, second, third)
+	}
+}
+
+func testLockOrder(t *testing.T, s Stores) {
+	ctx := context.Background()
+	coupon := createCoupon(t, s, "Кофе")
+	createOrder(t, s, "ORD-1", "user-1", coupon, 2, 1)
+
+	err := s.Tx.RunInTx(ctx, func(ctx context.Context) error {
+		order, err := s.Orders.LockByOrderNumber(ctx, "ORD-1")
+		if err != nil {
+			return err
+		}
+		if len(order.Items) != 2 || order.Items[0].Coupon == nil {
+			t.Errorf("заказ под блокировкой должен быть с позициями и купонами: %+v", order)
+		}
+		return s.Orders.UpdateStatus(ctx, order.ID, payment.OrderStatusPending)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored := getOrder(t, s, "ORD-1"); stored.Status != payment.OrderStatusPending {
+		t.Fatalf("статус не сохранен: %s", stored.Status)
+	}
+
+	err = s.Tx.RunInTx(ctx, func(ctx context.Context) error {
+		_, err := s.Orders.LockByOrderNumber(ctx, "missing")
+		return err
+	})
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("ожидалось sql.ErrNoRows, получено %v", err)
+	}
+}
+
+func testUserOrdersNewestFirst(t *testing.T, s Stores) {
+	coupon := createCoupon(t, s, "Кофе")
+	for _, number := range []string{"ORD-1", "ORD-2", "ORD-3"} {
+		createOrder(t, s, number, "user-1", coupon, 1)
+		time.Sleep(createPause)
+	}
+	createOrder(t, s, "ORD-OTHER", "user-2", coupon, 1)
+
+	orders, err := s.Orders.GetUserOrders(context.Background(), "user-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := orderNumbers(orders); !equalStrings(got, []string{"ORD-3", "ORD-2", "ORD-1"}) {
+		t.Fatalf("заказы должны идти от новых к старым: %v", got)
+	}
+	if orders[0].Coupon == nil || len(orders[0].Items) != 1 {
+		t.Fatal("у заказа должны быть купон и позиции")
+	}
+}
+
+func testSearchOrders(t *testing.T, s Stores) {
+	ctx := context.Background()
+	coupon := createCoupon(t, s, "Кофе")
+	for _, number := range []string{"ORD-1", "ORD-2", "ORD-3", "ORD-4"} {
+		createOrder(t, s, number, "user-1", coupon, 1)
+		time.Sleep(createPause)
+	}
+	paid := getOrder(t, s, "ORD-2")
+	if err := s.Orders.UpdateStatus(ctx, paid.ID, payment.OrderStatusPaid); err != nil {
+		t.Fatal(err)
+	}
+
+	orders, err := s.Orders.Search(ctx, &payment.OrderSearchRequest{Limit: 2, Offset: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := orderNumbers(orders); !equalStrings(got, []string{"ORD-3", "ORD-2"}) {
+		t.Fatalf("неверная страница поиска: %v", got)
+	}
+
+	orders, err = s.Orders.Search(ctx, &payment.OrderSearchRequest{Status: payment.OrderStatusPaid, Limit: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := orderNumbers(orders); !equalStrings(got, []string{"ORD-2"}) {
+		t.Fatalf("неверный поиск по статусу: %v", got)
+	}
+}
+
+func testUpdateOrder(t *testing.T, s Stores) {
+	ctx := context.Background()
+	coupon := createCoupon(t, s, "Кофе")
+	order := createOrder(t, s, "ORD-1", "user-1", coupon, 2)
+
+	if err := s.Orders.UpdateAlfaBankOrderID(ctx, order.ID, "alfa-1"); err != nil {
+		t.Fatal(err)
+	}
+	order.DiscountAmount = 500
+	order.Amount -= 500
+	order.Items[0].DiscountAmount = 500
+	order.Items[0].Amount -= 500
+	if err := s.Orders.UpdateDiscount(ctx, order); err != nil {
+		t.Fatal(err)
+	}
+
+	stored := getOrder(t, s, "ORD-1")
+	if stored.AlfaBankOrderID != "alfa-1" || stored.DiscountAmount != 500 || stored.Amount != 19500 {
+		t.Fatalf("изменения заказа не сохранены: %+v", stored)
+	}
+	if stored.Items[0].DiscountAmount != 500 || stored.Items[0].Amount != 19500 {
+		t.Fatalf("изменения позиции не сохранены: %+v", stored.Items[0])
+	}
+}
+
+func testStalePaymentDetails(t *testing.T, s Stores) {
+	ctx := context.Background()
+	coupon := createCoupon(t, s, "Кофе")
+	var ids []int64
+	for i, value := range []string{"v1:old:a:b", "v1:new:a:b", "", "legacy"} {
+		order := createOrder(t, s, "ORD-"+string(rune('1'+i)), "user-1", coupon, 1)
+		if err := s.Orders.UpdatePaymentDetails(ctx, order.ID, value); err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, order.ID)
+	}
+
+	records, err := s.Orders.ListStalePaymentDetails(ctx, "v1:new:", 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || records[0].ID != ids[0] || records[1].ID != ids[3] {
+		t.Fatalf("неверный список для перешифрования: %+v", records)
+	}
+
+	records, err = s.Orders.ListStalePaymentDetails(ctx, "v1:new:", ids[0], 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || records[0].Value != "legacy" {
+		t.Fatalf("записи должны идти после afterID: %+v", records)
+	}
+}
+
+func testInactiveCoupon(t *testing.T, s Stores) {
+	coupon := &payment.Coupon{Name: "Архив", Price: 100, Currency: "RUB", IsActive: false}
+	if err := s.CreateCoupon(context.Background(), coupon); err != nil {
+		t.Fatal(err)
+	}
+	_, err := s.Coupons.GetByID(context.Background(), coupon.ID)
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("отключенный купон не должен находиться: %v", err)
+	}
+
+	active := createCoupon(t, s, "Кофе")
+	found, err := s.Coupons.GetByID(context.Background(), active.ID)
+	if err != nil || found.Name != "Кофе" {
+		t.Fatalf("активный купон не найден: %v", err)
+	}
+}
+
+func testActivateOrderIdempotent(t *testing.T, s Stores) {
+	ctx := context.Background()
+	coupon := createCoupon(t, s, "Кофе")
+	order := createOrder(t, s, "ORD-1", "user-1", coupon, 2, 1)
+
+	activated, err := s.UserCoupons.ActivateOrder(ctx, order, noExpiry)
+	if err != nil || activated != 3 {
+		t.Fatalf("ожидалось 3 купона: %d, %v", activated, err)
+	}
+	activated, err = s.UserCoupons.ActivateOrder(ctx, order, noExpiry)
+	if err != nil || activated != 0 {
+		t.Fatalf("повторная активация не должна выдавать купоны: %d, %v", activated, err)
+	}
+
+	userCoupons, err := s.UserCoupons.GetUserCoupons(ctx, "user-1")
+	if err != nil || len(userCoupons) != 3 {
+		t.Fatalf("ожидалось 3 купона пользователя: %d, %v", len(userCoupons), err)
+	}
+	codes := make(map[string]bool)
+	for i, uc := range userCoupons {
+		if uc.Code == "" || codes[uc.Code] {
+			t.Fatalf("коды купонов должны быть уникальны: %q", uc.Code)
+		}
+		codes[uc.Code] = true
+		if i > 0 && uc.ActivatedAt.After(userCoupons[i-1].ActivatedAt) {
+			t.Fatal("купоны должны идти от последних активированных")
+		}
+	}
+
+	byCode, err := s.UserCoupons.GetByCode(ctx, userCoupons[0].Code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if byCode.Coupon == nil || byCode.Order == nil || byCode.Order.OrderNumber != "ORD-1" {
+		t.Fatalf("у купона должны быть купон каталога и заказ: %+v", byCode)
+	}
+
+	if _, err := s.UserCoupons.GetUserCoupon(ctx, "user-2", byCode.ID); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("чужой купон не должен находиться: %v", err)
+	}
+}
+
+func testUseCouponOnce(t *testing.T, s Stores) {
+	ctx := context.Background()
+	coupon := createCoupon(t, s, "Кофе")
+	order := createOrder(t, s, "ORD-1", "user-1", coupon, 2)
+	expiresAt := time.Now().Add(time.Hour)
+	if _, err := s.UserCoupons.ActivateOrder(ctx, order, func(*payment.OrderItem) time.Time { return expiresAt }); err != nil {
+		t.Fatal(err)
+	}
+	userCoupons, err := s.UserCoupons.GetUserCoupons(ctx, "user-1")
+	if err != nil || len(userCoupons) != 2 {
+		t.Fatalf("ожидалось 2 купона: %v", err)
+	}
+
+	now := time.Now()
+	used, err := s.UserCoupons.UseCoupon(ctx, userCoupons[0].ID, s.MerchantID, "T-1", now)
+	if err != nil || !used {
+		t.Fatalf("первое погашение должно пройти: %v", err)
+	}
+	used, err = s.UserCoupons.UseCoupon(ctx, userCoupons[0].ID, s.MerchantID, "T-1", now)
+	if err != nil || used {
+		t.Fatalf("повторное погашение не должно пройти: %v", err)
+	}
+	used, err = s.UserCoupons.UseCoupon(ctx, userCoupons[1].ID, s.MerchantID, "", expiresAt)
+	if err != nil || used {
+		t.Fatalf("истекший купон не должен гаситься: %v", err)
+	}
+
+	stored, err := s.UserCoupons.GetUserCoupon(ctx, "user-1", userCoupons[0].ID)
+	if err != nil || !stored.IsUsed || stored.RedeemedTerminalID != "T-1" {
+		t.Fatalf("погашение не сохранено: %+v, %v", stored, err)
+	}
+}
+
+// Строки возврата первых quantity доступных единиц первой позиции
+func refundFirstItem(quantity int) func(*payment.Order, map[int64][]int64) ([]payment.RefundLine, error) {
+	return func(order *payment.Order, available map[int64][]int64) ([]payment.RefundLine, error) {
+		item := order.Items[0]
+		ids := available[item.ID]
+		if len(ids) < quantity {
+			return nil, fmt.Errorf("доступно купонов: %d", len(ids))
+		}
+		return []payment.RefundLine{{
+			Item:          item,
+			Quantity:      quantity,
+			Amount:        item.RefundAmount(quantity),
+			UserCouponIDs: ids[:quantity],
+		}}, nil
+	}
+}
+
+func testRefundLifecycle(t *testing.T, s Stores) {
+	ctx := context.Background()
+	coupon := createCoupon(t, s, "Кофе")
+	order := createOrder(t, s, "ORD-1", "user-1", coupon, 2)
+	if err := s.Orders.UpdateStatus(ctx, order.ID, payment.OrderStatusPaid); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.UserCoupons.ActivateOrder(ctx, order, noExpiry); err != nil {
+		t.Fatal(err)
+	}
+
+	refund, err := s.Orders.CreateRefund(ctx, order.ID, func(locked *payment.Order, available map[int64][]int64) ([]payment.RefundLine, error) {
+		if locked.Status != payment.OrderStatusPaid {
+			t.Errorf("заказ передан без статуса: %+v", locked)
+		}
+		if ids := available[locked.Items[0].ID]; len(ids) != 2 || ids[0] < ids[1] {
+			t.Errorf("купоны должны идти от новых к старым: %v", ids)
+		}
+		return refundFirstItem(1)(locked, available)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refund.ID == 0 || refund.Status != payment.RefundStatusPending || refund.Amount != 10000 || len(refund.UserCouponIDs()) != 1 {
+		t.Fatalf("неверный возврат: %+v", refund)
+	}
+
+	// До подтверждения банка заказ не меняется, но купон уже не гасится
+	if stored := getOrder(t, s, "ORD-1"); stored.RefundedAmount != 0 || stored.Items[0].RefundedQuantity != 0 {
+		t.Fatalf("возврат проведен до подтверждения: %+v", stored)
+	}
+	reserved := refund.UserCouponIDs()[0]
+	if used, err := s.UserCoupons.UseCoupon(ctx, reserved, s.MerchantID, "", time.Now()); err != nil || used {
+		t.Fatalf("купон незавершенного возврата не должен гаситься: %v", err)
+	}
+
+	// Незавершенный возврат учитывается в позициях следующего как проведенный
+	skip := errors.New("без возврата")
+	_, err = s.Orders.CreateRefund(ctx, order.ID, func(locked *payment.Order, available map[int64][]int64) ([]payment.RefundLine, error) {
+		if item := locked.Items[0]; item.RefundedQuantity != 1 || item.RefundedAmount != 10000 {
+			t.Errorf("незавершенный возврат не учтен в позиции: %+v", item)
+		}
+		if ids := available[locked.Items[0].ID]; len(ids) != 1 || ids[0] == reserved {
+			t.Errorf("неверные доступные купоны: %v", ids)
+		}
+		return nil, skip
+	})
+	if !errors.Is(err, skip) {
+		t.Fatalf("ожидалась ошибка plan, получено %v", err)
+	}
+
+	pending, err := s.Orders.ListPendingRefunds(ctx, time.Now().Add(time.Minute), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 1 || pending[0].ID != refund.ID || pending[0].Order == nil || pending[0].Order.OrderNumber != "ORD-1" {
+		t.Fatalf("неверные незавершенные возвраты: %+v", pending)
+	}
+	if pending, err := s.Orders.ListPendingRefunds(ctx, time.Now().Add(-time.Minute), 10); err != nil || len(pending) != 0 {
+		t.Fatalf("возврат моложе границы не должен попадать в сверку: %+v, %v", pending, err)
+	}
+
+	completed, err := s.Orders.CompleteRefund(ctx, refund.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if completed.RefundedAmount != 10000 || completed.Status != payment.OrderStatusPaid || len(completed.Items) != 1 {
+		t.Fatalf("неверный заказ после возврата: %+v", completed)
+	}
+	stored := getOrder(t, s, "ORD-1")
+	if stored.RefundedAmount != 10000 || stored.Items[0].RefundedQuantity != 1 || stored.Items[0].RefundedAmount != 10000 {
+		t.Fatalf("возврат не сохранен: %+v", stored)
+	}
+	if _, err := s.Orders.CompleteRefund(ctx, refund.ID); !errors.Is(err, payment.ErrRefundNotPending) {
+		t.Fatalf("ожидалось ErrRefundNotPending, получено %v", err)
+	}
+	if pending, err := s.Orders.ListPendingRefunds(ctx, time.Now().Add(time.Minute), 10); err != nil || len(pending) != 0 {
+		t.Fatalf("завершенный возврат не должен попадать в сверку: %+v, %v", pending, err)
+	}
+
+	// Остаток заказа: доступен только второй купон, полный возврат меняет статус
+	second, err := s.Orders.CreateRefund(ctx, order.ID, refundFirstItem(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.UserCouponIDs()[0] == reserved {
+		t.Fatal("купон повторно попал в возврат")
+	}
+	if _, err := s.Orders.CreateRefund(ctx, order.ID, refundFirstItem(1)); err == nil {
+		t.Fatal("купонов для возврата не должно остаться")
+	}
+	completed, err = s.Orders.CompleteRefund(ctx, second.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if completed.RefundedAmount != 20000 || completed.Status != payment.OrderStatusRefunded {
+		t.Fatalf("полный возврат должен перевести заказ в refunded: %+v", completed)
+	}
+}
+
+func testRefundFailAndRollback(t *testing.T, s Stores) {
+	ctx := context.Background()
+	coupon := createCoupon(t, s, "Кофе")
+	order := createOrder(t, s, "ORD-1", "user-1", coupon, 1)
+	if _, err := s.UserCoupons.ActivateOrder(ctx, order, noExpiry); err != nil {
+		t.Fatal(err)
+	}
+
+	planErr := errors.New("неверные позиции")
+	_, err := s.Orders.CreateRefund(ctx, order.ID, func(*payment.Order, map[int64][]int64) ([]payment.RefundLine, error) {
+		return nil, planErr
+	})
+	if !errors.Is(err, planErr) {
+		t.Fatalf("ожидалась ошибка plan, получено %v", err)
+	}
+
+	refund, err := s.Orders.CreateRefund(ctx, order.ID, refundFirstItem(1))
+	if err != nil {
+		t.Fatalf("ошибка plan не должна занимать купоны: %v", err)
+	}
+	if err := s.Orders.FailRefund(ctx, refund.ID, "отклонен"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Orders.FailRefund(ctx, refund.ID, "отклонен"); !errors.Is(err, payment.ErrRefundNotPending) {
+		t.Fatalf("ожидалось ErrRefundNotPending, получено %v", err)
+	}
+	if _, err := s.Orders.CompleteRefund(ctx, refund.ID); !errors.Is(err, payment.ErrRefundNotPending) {
+		t.Fatalf("отклоненный возврат не должен проводиться: %v", err)
+	}
+	if stored := getOrder(t, s, "ORD-1"); stored.RefundedAmount != 0 || stored.Items[0].RefundedQuantity != 0 {
+		t.Fatalf("отклоненный возврат изменил заказ: %+v", stored)
+	}
+	if used, err := s.UserCoupons.UseCoupon(ctx, refund.UserCouponIDs()[0], s.MerchantID, "", time.Now()); err != nil || !used {
+		t.Fatalf("купон отклоненного возврата должен снова гаситься: %v", err)
+	}
+
+	_, err = s.Orders.CreateRefund(ctx, order.ID+1000, func(*payment.Order, map[int64][]int64) ([]payment.RefundLine, error) {
+		t.Error("plan не должен вызываться для несуществующего заказа")
+		return nil, nil
+	})
+	if !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("ожидалось sql.ErrNoRows, получено %v", err)
+	}
+	if _, err := s.Orders.CompleteRefund(ctx, refund.ID+1000); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("ожидалось sql.ErrNoRows, получено %v", err)
+	}
+}
+
+func testTxCommitAndRollback(t *testing.T, s Stores) {
+	ctx := context.Background()
+	coupon := createCoupon(t, s, "Кофе")
+
+	rollback := errors.New("откат")
+	err := s.Tx.RunInTx(ctx, func(ctx context.Context) error {
+		order := &payment.Order{OrderNumber: "ORD-ROLLBACK", UserID: "user-1", Amount: 100, Currency: "RUB", CouponID: coupon.ID}
+		if err := s.Orders.Create(ctx, order); err != nil {
+			return err
+		}
+		// Внутри транзакции изменения видны
+		if _, err := s.Orders.GetByOrderNumber(ctx, "ORD-ROLLBACK"); err != nil {
+			return err
+		}
+		return rollback
+	})
+	if !errors.Is(err, rollback) {
+		t.Fatalf("ожидалась ошибка fn, получено %v", err)
+	}
+	if _, err := s.Orders.GetByOrderNumber(ctx, "ORD-ROLLBACK"); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("заказ из отмененной транзакции не должен сохраниться: %v", err)
+	}
+
+	err = s.Tx.RunInTx(ctx, func(ctx context.Context) error {
+		order := &payment.Order{OrderNumber: "ORD-COMMIT", UserID: "user-1", Amount: 100, Currency: "RUB", CouponID: coupon.ID}
+		if err := s.Orders.Create(ctx, order); err != nil {
+			return err
+		}
+		// Вложенный вызов присоединяется к внешней транзакции
+		return s.Tx.RunInTx(ctx, func(ctx context.Context) error {
+			return s.Orders.UpdateStatus(ctx, order.ID, payment.OrderStatusPending)
+		})
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stored := getOrder(t, s, "ORD-COMMIT"); stored.Status != payment.OrderStatusPending {
+		t.Fatalf("изменения транзакции не сохранены: %+v", stored)
+	}
+}
+
+func testOrderChanges(t *testing.T, s Stores) {
+	ctx := context.Background()
+	coupon := createCoupon(t, s, "Кофе")
+	first := createOrder(t, s, "ORD-1", "user-1", coupon, 1)
+	second := createOrder(t, s, "ORD-2", "user-1", coupon, 1)
+
+	bankStatus := 2
+	changes := []*payment.OrderChange{
+		{OrderID: first.ID, Kind: payment.OrderChangeStatus, NewStatus: payment.OrderStatusCreated, Source: payment.ChangeSourceAPI, Actor: "user:user-1"},
+		{OrderID: second.ID, Kind: payment.OrderChangeStatus, NewStatus: payment.OrderStatusCreated, Source: payment.ChangeSourceAPI},
+		{
+			OrderID: first.ID, Kind: payment.OrderChangeStatus,
+			OldStatus: payment.OrderStatusCreated, NewStatus: payment.OrderStatusPaid,
+			Source: payment.ChangeSourceWebhook, Actor: payment.ActorBank,
+			BankOrderID: "alfa-1", BankStatus: &bankStatus,
+			Details: map[string]any{"activated": 1},
+		},
+	}
+	for _, change := range changes {
+		if err := s.OrderChanges.Append(ctx, change); err != nil {
+			t.Fatal(err)
+		}
+		if change.ID == 0 || change.CreatedAt.IsZero() {
+			t.Fatalf("у записи журнала нет ID или времени: %+v", change)
+		}
+	}
+
+	// Запись из отмененной транзакции не сохраняется
+	rollback := errors.New("откат")
+	err := s.Tx.RunInTx(ctx, func(ctx context.Context) error {
+		err := s.OrderChanges.Append(ctx, &payment.OrderChange{OrderID: first.ID, Kind: payment.OrderChangeRefunded, Source: payment.ChangeSourceAdmin})
+		if err != nil {
+			return err
+		}
+		return rollback
+	})
+	if !errors.Is(err, rollback) {
+		t.Fatalf("ожидалась ошибка fn, получено %v", err)
+	}
+
+	got, err := s.OrderChanges.ListByOrder(ctx, first.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].ID != changes[0].ID || got[1].ID != changes[2].ID {
+		t.Fatalf("журнал заказа должен содержать его записи по порядку: %+v", got)
+	}
+	paid := got[1]
+	if paid.OldStatus != payment.OrderStatusCreated || paid.NewStatus != payment.OrderStatusPaid ||
+		paid.Source != payment.ChangeSourceWebhook || paid.Actor != payment.ActorBank ||
+		paid.BankOrderID != "alfa-1" || paid.BankStatus == nil || *paid.BankStatus != 2 || paid.BankActionCode != nil {
+		t.Fatalf("запись журнала сохранена неверно: %+v", paid)
+	}
+	// Детали хранятся как JSON: числа читаются как float64
+	if paid.Details["activated"] != float64(1) {
+		t.Fatalf("детали записи сохранены неверно: %#v", paid.Details)
+	}
+
+	none, err := s.OrderChanges.ListByOrder(ctx, 0)
+	if err != nil || len(none) != 0 {
+		t.Fatalf("у несуществующего заказа нет журнала: %v, %v", none, err)
+	}
+}
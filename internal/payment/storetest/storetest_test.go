@@ -0,0 +1,12 @@
+package storetest
+
+import "testing"
+
+func TestMemory(t *testing.T) {
+	Run(t, Memory())
+}
+
+// Пропускается без TEST_DATABASE_URL
+func TestPostgres(t *testing.T) {
+	Run(t, Postgres(OpenPostgres(t)))
+}
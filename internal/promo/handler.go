@@ -0,0 +1,87 @@
+package promo
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/skr1ms/PaymentAlphaBank.git/pkg/auth"
+	"github.com/skr1ms/PaymentAlphaBank.git/pkg/httpapi"
+	"github.com/skr1ms/PaymentAlphaBank.git/pkg/openapi"
+)
+
+type PromoHandlerDeps struct {
+	PromoService *PromoService
+	Guard        *auth.Guard
+	OpenAPI      *openapi.Spec
+}
+
+type PromoHandler struct {
+	fiber.Router
+	deps *PromoHandlerDeps
+}
+
+func NewPromoHandler(router fiber.Router, deps *PromoHandlerDeps) {
+	handler := &PromoHandler{
+		Router: router,
+		deps:   deps,
+	}
+
+	describeRoutes(deps.OpenAPI)
+
+	// Управление промокодами: API-ключ или JWT с ролью, дающей область доступа
+	router.Get("/promo-codes", deps.Guard.RequireScope(auth.ScopePromoRead), handler.ListPromoCodes)
+	router.Post("/promo-codes", deps.Guard.RequireScope(auth.ScopePromoWrite),
+		deps.OpenAPI.ValidateBody(CreatePromoCodeRequest{}), handler.CreatePromoCode)
+}
+
+func describeRoutes(spec *openapi.Spec) {
+	security := []string{openapi.SecurityAPIKey, openapi.SecurityBearer}
+
+	spec.Add(openapi.Operation{
+		Method: http.MethodGet, Path: "/promo-codes", Tags: []string{"promo"},
+		Summary:  "Список промокодов",
+		Security: security,
+		Response: []PromoCode{},
+	})
+	spec.Add(openapi.Operation{
+		Method: http.MethodPost, Path: "/promo-codes", Tags: []string{"promo"},
+		Summary:  "Создание промокода",
+		Security: security,
+		Request:  CreatePromoCodeRequest{},
+		Response: PromoCode{},
+		Status:   http.StatusCreated,
+	})
+}
+
+func (h *PromoHandler) ListPromoCodes(c *fiber.Ctx) error {
+	promos, err := h.deps.PromoService.ListPromoCodes(c.Context())
+	if err != nil {
+		log.Printf("Ошибка получения промокодов: %v", err)
+		return httpapi.Respond(c, fiber.StatusInternalServerError, httpapi.CodeInternal, "Ошибка получения промокодов")
+	}
+
+	return c.JSON(promos)
+}
+
+func (h *PromoHandler) CreatePromoCode(c *fiber.Ctx) error {
+	var req CreatePromoCodeRequest
+	if err := c.BodyParser(&req); err != nil {
+		return httpapi.Respond(c, fiber.StatusBadRequest, httpapi.CodeBadRequest, "Неверный формат запроса")
+	}
+
+	promo, err := h.deps.PromoService.CreatePromoCode(c.Context(), &req)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrInvalidPromoParams):
+			return httpapi.Respond(c, fiber.StatusBadRequest, httpapi.CodeBadRequest, err.Error())
+		case errors.Is(err, ErrPromoCodeExists):
+			return httpapi.Respond(c, fiber.StatusConflict, httpapi.CodeConflict, err.Error())
+		}
+		log.Printf("Ошибка создания промокода: %v", err)
+		return httpapi.Respond(c, fiber.StatusInternalServerError, httpapi.CodeInternal, "Ошибка создания промокода")
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(promo)
+}
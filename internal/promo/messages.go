@@ -0,0 +1,20 @@
+package promo
+
+import "github.com/skr1ms/PaymentAlphaBank.git/pkg/i18n"
+
+func init() {
+	i18n.Register(i18n.LocaleEN, map[string]string{
+		"Ошибка получения промокодов": "Failed to get promo codes",
+		"Ошибка создания промокода":   "Failed to create promo code",
+		"Ошибка проверки промокода":   "Failed to check promo code",
+
+		"промокод недействителен: промокод не найден":                              "promo code is invalid: not found",
+		"промокод недействителен: промокод не активен":                             "promo code is invalid: not active",
+		"промокод недействителен: промокод не действует для этого купона":          "promo code is invalid: not applicable to this coupon",
+		"промокод недействителен: цена ниже минимальной для промокода":             "promo code is invalid: price is below the promo code minimum",
+		"промокод недействителен: промокод больше не доступен":                     "promo code is invalid: no longer available",
+		"промокод недействителен: промокод уже использован максимальное число раз": "promo code is invalid: usage limit reached",
+		"неверные параметры промокода":                                             "invalid promo code parameters",
+		"промокод с таким кодом уже существует":                                    "a promo code with this code already exists",
+	})
+}
@@ -0,0 +1,53 @@
+package promo
+
+import (
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+// Типы скидок
+const (
+	DiscountPercent = "percent"
+	DiscountFixed   = "fixed"
+)
+
+// Статусы использования промокода
+const (
+	UsageStatusActive   = "active"
+	UsageStatusReleased = "released"
+)
+
+// Модель промокода
+type PromoCode struct {
+	bun.BaseModel `bun:"table:promo_codes"`
+
+	ID            int64     `bun:"id,pk,autoincrement" json:"id"`
+	Code          string    `bun:"code,notnull,unique" json:"code"`
+	DiscountType  string    `bun:"discount_type,notnull" json:"discount_type"`
+	DiscountValue float64   `bun:"discount_value,notnull" json:"discount_value"` // проценты или рубли
+	MinPrice      float64   `bun:"min_price,notnull,default:0" json:"min_price"`
+	MaxUses       int       `bun:"max_uses,notnull,default:0" json:"max_uses"`             // 0 — без ограничений
+	PerUserLimit  int       `bun:"per_user_limit,notnull,default:0" json:"per_user_limit"` // 0 — без ограничений
+	UsedCount     int       `bun:"used_count,notnull,default:0" json:"used_count"`
+	CouponIDs     []int64   `bun:"coupon_ids,array" json:"coupon_ids,omitempty"` // пусто — любые купоны
+	ValidFrom     time.Time `bun:"valid_from,nullzero" json:"valid_from,omitempty"`
+	ValidUntil    time.Time `bun:"valid_until,nullzero" json:"valid_until,omitempty"`
+	IsActive      bool      `bun:"is_active,notnull,default:true" json:"is_active"`
+	CreatedAt     time.Time `bun:"created_at,nullzero,notnull,default:current_timestamp" json:"created_at"`
+	UpdatedAt     time.Time `bun:"updated_at,nullzero,notnull,default:current_timestamp" json:"updated_at"`
+}
+
+// Модель использования промокода в заказе
+type PromoUsage struct {
+	bun.BaseModel `bun:"table:promo_code_usages"`
+
+	ID          int64     `bun:"id,pk,autoincrement" json:"id"`
+	PromoCodeID int64     `bun:"promo_code_id,notnull" json:"promo_code_id"`
+	OrderID     int64     `bun:"order_id,notnull,unique" json:"order_id"`
+	UserID      string    `bun:"user_id,notnull" json:"user_id"`
+	Discount    int64     `bun:"discount,notnull" json:"discount"` // в копейках
+	Status      string    `bun:"status,notnull,default:'active'" json:"status"`
+	CreatedAt   time.Time `bun:"created_at,nullzero,notnull,default:current_timestamp" json:"created_at"`
+	ReleasedAt  time.Time `bun:"released_at,nullzero" json:"released_at,omitempty"`
+}
@@ -0,0 +1,38 @@
+package promo
+
+import "time"
+
+type CreatePromoCodeRequest struct {
+	Code          string    `json:"code" validate:"required,min=1"`
+	DiscountType  string    `json:"discount_type" validate:"required"`
+	DiscountValue float64   `json:"discount_value" validate:"required"`
+	MinPrice      float64   `json:"min_price,omitempty"`
+	MaxUses       int       `json:"max_uses,omitempty"`
+	PerUserLimit  int       `json:"per_user_limit,omitempty"`
+	CouponIDs     []int64   `json:"coupon_ids,omitempty"`
+	ValidFrom     time.Time `json:"valid_from,omitempty"`
+	ValidUntil    time.Time `json:"valid_until,omitempty"`
+}
+
+type CheckPromoCodeRequest struct {
+	Code     string `json:"code" validate:"required,min=1"`
+	CouponID int64  `json:"coupon_id" validate:"required,min=1"`
+	UserID   string `json:"user_id,omitempty" validate:"min=1"` // по умолчанию — пользователь из токена
+}
+
+// Позиция заказа для расчета скидки, сумма в копейках
+type Line struct {
+	CouponID int64
+	Amount   int64
+}
+
+// Расчет скидки по промокоду, суммы в копейках
+type Quote struct {
+	PromoCodeID int64  `json:"promo_code_id"`
+	Code        string `json:"code"`
+	Discount    int64  `json:"discount"`
+	Amount      int64  `json:"amount"`
+
+	// Скидка по каждой позиции в порядке переданных позиций
+	LineDiscounts []int64 `json:"-"`
+}
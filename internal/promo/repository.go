@@ -0,0 +1,152 @@
+package promo
+
+import (
+	"context"
+	"time"
+
+	"github.com/skr1ms/PaymentAlphaBank.git/pkg/db"
+	"github.com/uptrace/bun"
+)
+
+type PromoRepository struct {
+	db *bun.DB
+}
+
+func NewPromoRepository(db *bun.DB) *PromoRepository {
+	return &PromoRepository{db: db}
+}
+
+func (r *PromoRepository) Create(ctx context.Context, promo *PromoCode) error {
+	promo.CreatedAt = time.Now()
+	promo.UpdatedAt = time.Now()
+	_, err := r.db.NewInsert().Model(promo).Exec(ctx)
+	return err
+}
+
+func (r *PromoRepository) List(ctx context.Context) ([]PromoCode, error) {
+	var promos []PromoCode
+	err := r.db.NewSelect().
+		Model(&promos).
+		Order("created_at DESC").
+		Scan(ctx)
+	return promos, err
+}
+
+func (r *PromoRepository) GetByCode(ctx context.Context, code string) (*PromoCode, error) {
+	promo := &PromoCode{}
+	err := db.Conn(ctx, r.db).NewSelect().
+		Model(promo).
+		Where("code = ?", code).
+		Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return promo, nil
+}
+
+func (r *PromoRepository) CountUserUsages(ctx context.Context, promoCodeID int64, userID string) (int, error) {
+	return countUserUsages(ctx, db.Conn(ctx, r.db), promoCodeID, userID)
+}
+
+// Резервирование промокода за заказом. Строка промокода блокируется,
+// поэтому лимиты не превысить параллельными заказами
+func (r *PromoRepository) Reserve(
+	ctx context.Context,
+	code, userID string,
+	orderID int64,
+	discountFor func(promo *PromoCode, userUses int) (int64, error),
+) (*PromoUsage, error) {
+	var usage *PromoUsage
+
+	err := db.RunInTx(ctx, r.db, func(ctx context.Context, tx bun.IDB) error {
+		promo := &PromoCode{}
+		err := tx.NewSelect().
+			Model(promo).
+			Where("code = ?", code).
+			For("UPDATE").
+			Scan(ctx)
+		if err != nil {
+			return err
+		}
+
+		userUses, err := countUserUsages(ctx, tx, promo.ID, userID)
+		if err != nil {
+			return err
+		}
+
+		discount, err := discountFor(promo, userUses)
+		if err != nil {
+			return err
+		}
+
+		usage = &PromoUsage{
+			PromoCodeID: promo.ID,
+			OrderID:     orderID,
+			UserID:      userID,
+			Discount:    discount,
+			Status:      UsageStatusActive,
+			CreatedAt:   time.Now(),
+		}
+		if _, err := tx.NewInsert().Model(usage).Exec(ctx); err != nil {
+			return err
+		}
+
+		_, err = tx.NewUpdate().
+			Model((*PromoCode)(nil)).
+			Set("used_count = used_count + 1").
+			Set("updated_at = ?", time.Now()).
+			Where("id = ?", promo.ID).
+			Exec(ctx)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return usage, nil
+}
+
+// Возврат использования промокода, если заказ не был оплачен.
+// Повторный вызов для того же заказа ничего не меняет
+func (r *PromoRepository) Release(ctx context.Context, orderID int64) (bool, error) {
+	released := false
+
+	err := db.RunInTx(ctx, r.db, func(ctx context.Context, tx bun.IDB) error {
+		var promoCodeIDs []int64
+		err := tx.NewUpdate().
+			Model((*PromoUsage)(nil)).
+			Set("status = ?", UsageStatusReleased).
+			Set("released_at = ?", time.Now()).
+			Where("order_id = ? AND status = ?", orderID, UsageStatusActive).
+			Returning("promo_code_id").
+			Scan(ctx, &promoCodeIDs)
+		if err != nil {
+			return err
+		}
+		if len(promoCodeIDs) == 0 {
+			return nil
+		}
+
+		_, err = tx.NewUpdate().
+			Model((*PromoCode)(nil)).
+			Set("used_count = GREATEST(used_count - 1, 0)").
+			Set("updated_at = ?", time.Now()).
+			Where("id = ?", promoCodeIDs[0]).
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+
+		released = true
+		return nil
+	})
+
+	return released, err
+}
+
+func countUserUsages(ctx context.Context, idb bun.IDB, promoCodeID int64, userID string) (int, error) {
+	return idb.NewSelect().
+		Model((*PromoUsage)(nil)).
+		Where("promo_code_id = ? AND user_id = ? AND status = ?", promoCodeID, userID, UsageStatusActive).
+		Count(ctx)
+}
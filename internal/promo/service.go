@@ -0,0 +1,237 @@
+package promo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/skr1ms/PaymentAlphaBank.git/pkg/db"
+)
+
+// Минимальная сумма к оплате после скидки, в копейках: банк не принимает нулевые заказы
+const minChargeAmount = 100
+
+var (
+	ErrInvalidPromoCode   = errors.New("промокод недействителен")
+	ErrPromoNotFound      = fmt.Errorf("%w: промокод не найден", ErrInvalidPromoCode)
+	ErrPromoInactive      = fmt.Errorf("%w: промокод не активен", ErrInvalidPromoCode)
+	ErrPromoNotEligible   = fmt.Errorf("%w: промокод не действует для этого купона", ErrInvalidPromoCode)
+	ErrPromoMinPrice      = fmt.Errorf("%w: цена ниже минимальной для промокода", ErrInvalidPromoCode)
+	ErrPromoExhausted     = fmt.Errorf("%w: промокод больше не доступен", ErrInvalidPromoCode)
+	ErrPromoUserLimit     = fmt.Errorf("%w: промокод уже использован максимальное число раз", ErrInvalidPromoCode)
+	ErrInvalidPromoParams = errors.New("неверные параметры промокода")
+	ErrPromoCodeExists    = errors.New("промокод с таким кодом уже существует")
+)
+
+type PromoService struct {
+	promoRepo *PromoRepository
+}
+
+func NewPromoService(promoRepo *PromoRepository) *PromoService {
+	return &PromoService{promoRepo: promoRepo}
+}
+
+func (s *PromoService) CreatePromoCode(ctx context.Context, req *CreatePromoCodeRequest) (*PromoCode, error) {
+	code := NormalizeCode(req.Code)
+	if code == "" || req.DiscountValue <= 0 || req.MinPrice < 0 || req.MaxUses < 0 || req.PerUserLimit < 0 {
+		return nil, ErrInvalidPromoParams
+	}
+	switch req.DiscountType {
+	case DiscountPercent:
+		if req.DiscountValue > 100 {
+			return nil, ErrInvalidPromoParams
+		}
+	case DiscountFixed:
+	default:
+		return nil, ErrInvalidPromoParams
+	}
+	if !req.ValidFrom.IsZero() && !req.ValidUntil.IsZero() && !req.ValidFrom.Before(req.ValidUntil) {
+		return nil, ErrInvalidPromoParams
+	}
+
+	promo := &PromoCode{
+		Code:          code,
+		DiscountType:  req.DiscountType,
+		DiscountValue: req.DiscountValue,
+		MinPrice:      req.MinPrice,
+		MaxUses:       req.MaxUses,
+		PerUserLimit:  req.PerUserLimit,
+		CouponIDs:     req.CouponIDs,
+		ValidFrom:     req.ValidFrom,
+		ValidUntil:    req.ValidUntil,
+		IsActive:      true,
+	}
+	if err := s.promoRepo.Create(ctx, promo); err != nil {
+		if db.IsUniqueViolation(err, "") {
+			return nil, ErrPromoCodeExists
+		}
+		return nil, err
+	}
+
+	return promo, nil
+}
+
+func (s *PromoService) ListPromoCodes(ctx context.Context) ([]PromoCode, error) {
+	return s.promoRepo.List(ctx)
+}
+
+// Предварительный расчет скидки без резервирования
+func (s *PromoService) Quote(ctx context.Context, code, userID string, lines []Line) (*Quote, error) {
+	promo, err := s.promoRepo.GetByCode(ctx, NormalizeCode(code))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrPromoNotFound
+		}
+		return nil, err
+	}
+
+	userUses, err := s.promoRepo.CountUserUsages(ctx, promo.ID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	lineDiscounts, err := promo.discount(time.Now(), lines, userUses)
+	if err != nil {
+		return nil, err
+	}
+
+	return newQuote(promo, lines, lineDiscounts), nil
+}
+
+// Резервирование промокода за заказом с повторной проверкой всех ограничений
+func (s *PromoService) Reserve(ctx context.Context, code, userID string, lines []Line, orderID int64) (*Quote, error) {
+	now := time.Now()
+
+	var quote *Quote
+	_, err := s.promoRepo.Reserve(ctx, NormalizeCode(code), userID, orderID, func(promo *PromoCode, userUses int) (int64, error) {
+		lineDiscounts, err := promo.discount(now, lines, userUses)
+		if err != nil {
+			return 0, err
+		}
+		quote = newQuote(promo, lines, lineDiscounts)
+		return quote.Discount, nil
+	})
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrPromoNotFound
+		}
+		return nil, err
+	}
+
+	return quote, nil
+}
+
+func newQuote(promo *PromoCode, lines []Line, lineDiscounts []int64) *Quote {
+	quote := &Quote{
+		PromoCodeID:   promo.ID,
+		Code:          promo.Code,
+		LineDiscounts: lineDiscounts,
+	}
+	for i, line := range lines {
+		quote.Discount += lineDiscounts[i]
+		quote.Amount += line.Amount
+	}
+	quote.Amount -= quote.Discount
+	return quote
+}
+
+// Освобождение промокода неоплаченного заказа
+func (s *PromoService) Release(ctx context.Context, orderID int64) error {
+	_, err := s.promoRepo.Release(ctx, orderID)
+	return err
+}
+
+// Проверка ограничений промокода и расчет скидки в копейках по каждой позиции.
+// Скидка считается от суммы позиций, на которые действует промокод
+func (p *PromoCode) discount(now time.Time, lines []Line, userUses int) ([]int64, error) {
+	if !p.IsActive ||
+		(!p.ValidFrom.IsZero() && now.Before(p.ValidFrom)) ||
+		(!p.ValidUntil.IsZero() && !now.Before(p.ValidUntil)) {
+		return nil, ErrPromoInactive
+	}
+
+	var total, eligibleAmount int64
+	eligible := make([]bool, len(lines))
+	for i, line := range lines {
+		total += line.Amount
+		eligible[i] = p.appliesTo(line.CouponID)
+		if eligible[i] {
+			eligibleAmount += line.Amount
+		}
+	}
+	if eligibleAmount == 0 {
+		return nil, ErrPromoNotEligible
+	}
+
+	if eligibleAmount < toKopecks(p.MinPrice) {
+		return nil, ErrPromoMinPrice
+	}
+	if p.MaxUses > 0 && p.UsedCount >= p.MaxUses {
+		return nil, ErrPromoExhausted
+	}
+	if p.PerUserLimit > 0 && userUses >= p.PerUserLimit {
+		return nil, ErrPromoUserLimit
+	}
+
+	var discount int64
+	switch p.DiscountType {
+	case DiscountPercent:
+		discount = int64(math.Floor(float64(eligibleAmount) * p.DiscountValue / 100))
+	case DiscountFixed:
+		discount = toKopecks(p.DiscountValue)
+	}
+
+	if discount > eligibleAmount {
+		discount = eligibleAmount
+	}
+	if total-discount < minChargeAmount {
+		discount = total - minChargeAmount
+	}
+	if discount < 0 {
+		discount = 0
+	}
+
+	// Распределяем скидку пропорционально суммам позиций,
+	// остаток от округления — по копейке на позиции по порядку
+	lineDiscounts := make([]int64, len(lines))
+	rest := discount
+	for i, line := range lines {
+		if eligible[i] {
+			lineDiscounts[i] = discount * line.Amount / eligibleAmount
+			rest -= lineDiscounts[i]
+		}
+	}
+	for i := 0; rest > 0; i = (i + 1) % len(lines) {
+		if eligible[i] && lineDiscounts[i] < lines[i].Amount {
+			lineDiscounts[i]++
+			rest--
+		}
+	}
+
+	return lineDiscounts, nil
+}
+
+func (p *PromoCode) appliesTo(couponID int64) bool {
+	if len(p.CouponIDs) == 0 {
+		return true
+	}
+	for _, id := range p.CouponIDs {
+		if id == couponID {
+			return true
+		}
+	}
+	return false
+}
+
+// Промокоды не зависят от регистра и пробелов по краям
+func NormalizeCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+func toKopecks(rubles float64) int64 {
+	return int64(math.Round(rubles * 100))
+}
@@ -0,0 +1,167 @@
+package promo
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestPromoCodeDiscount(t *testing.T) {
+	now := time.Date(2026, 10, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		promo    PromoCode
+		lines    []Line
+		userUses int
+		want     []int64
+		wantErr  error
+	}{
+		{
+			name:  "процент",
+			promo: PromoCode{IsActive: true, DiscountType: DiscountPercent, DiscountValue: 15},
+			lines: []Line{{CouponID: 1, Amount: 100000}},
+			want:  []int64{15000},
+		},
+		{
+			name:  "процент округляется вниз",
+			promo: PromoCode{IsActive: true, DiscountType: DiscountPercent, DiscountValue: 33},
+			lines: []Line{{CouponID: 1, Amount: 999}},
+			want:  []int64{329},
+		},
+		{
+			name:  "фиксированная делится пропорционально суммам",
+			promo: PromoCode{IsActive: true, DiscountType: DiscountFixed, DiscountValue: 300},
+			lines: []Line{{CouponID: 1, Amount: 100000}, {CouponID: 2, Amount: 200000}},
+			want:  []int64{10000, 20000},
+		},
+		{
+			name:  "фиксированная больше суммы подходящих позиций",
+			promo: PromoCode{IsActive: true, DiscountType: DiscountFixed, DiscountValue: 5000, CouponIDs: []int64{1}},
+			lines: []Line{{CouponID: 1, Amount: 100000}, {CouponID: 2, Amount: 50000}},
+			want:  []int64{100000, 0},
+		},
+		{
+			name:  "фиксированная больше цены оставляет минимальный платеж",
+			promo: PromoCode{IsActive: true, DiscountType: DiscountFixed, DiscountValue: 5000},
+			lines: []Line{{CouponID: 1, Amount: 100000}},
+			want:  []int64{100000 - minChargeAmount},
+		},
+		{
+			name:  "100% на несколько позиций оставляет минимальный платеж",
+			promo: PromoCode{IsActive: true, DiscountType: DiscountPercent, DiscountValue: 100},
+			lines: []Line{{CouponID: 1, Amount: 30000}, {CouponID: 2, Amount: 20000}},
+			want:  []int64{29940, 19960},
+		},
+		{
+			name:  "сумма не выше минимального платежа",
+			promo: PromoCode{IsActive: true, DiscountType: DiscountFixed, DiscountValue: 10},
+			lines: []Line{{CouponID: 1, Amount: minChargeAmount - 1}},
+			want:  []int64{0},
+		},
+		{
+			name:  "неподходящие позиции без скидки",
+			promo: PromoCode{IsActive: true, DiscountType: DiscountPercent, DiscountValue: 10, CouponIDs: []int64{1, 3}},
+			lines: []Line{{CouponID: 1, Amount: 10000}, {CouponID: 2, Amount: 50000}, {CouponID: 3, Amount: 30000}},
+			want:  []int64{1000, 0, 3000},
+		},
+		{
+			name:  "остаток от округления по копейке по порядку",
+			promo: PromoCode{IsActive: true, DiscountType: DiscountFixed, DiscountValue: 1},
+			lines: []Line{{CouponID: 1, Amount: 10000}, {CouponID: 2, Amount: 10000}, {CouponID: 3, Amount: 10000}},
+			want:  []int64{34, 33, 33},
+		},
+		{
+			name:  "остаток пропускает неподходящие позиции",
+			promo: PromoCode{IsActive: true, DiscountType: DiscountFixed, DiscountValue: 0.05, CouponIDs: []int64{2, 3}},
+			lines: []Line{{CouponID: 1, Amount: 700}, {CouponID: 2, Amount: 700}, {CouponID: 3, Amount: 700}},
+			want:  []int64{0, 3, 2},
+		},
+		{
+			name:  "остаток не превышает сумму позиции",
+			promo: PromoCode{IsActive: true, DiscountType: DiscountFixed, DiscountValue: 1000},
+			lines: []Line{{CouponID: 1, Amount: 1}, {CouponID: 2, Amount: 1000}},
+			want:  []int64{1, 900},
+		},
+		{
+			name:    "нет подходящих позиций",
+			promo:   PromoCode{IsActive: true, DiscountType: DiscountFixed, DiscountValue: 100, CouponIDs: []int64{1, 2}},
+			lines:   []Line{{CouponID: 3, Amount: 100000}},
+			wantErr: ErrPromoNotEligible,
+		},
+		{
+			name:    "подходящие позиции дешевле минимальной цены",
+			promo:   PromoCode{IsActive: true, DiscountType: DiscountFixed, DiscountValue: 100, MinPrice: 1000, CouponIDs: []int64{1}},
+			lines:   []Line{{CouponID: 1, Amount: 99999}, {CouponID: 2, Amount: 100000}},
+			wantErr: ErrPromoMinPrice,
+		},
+		{
+			name:    "отключен",
+			promo:   PromoCode{DiscountType: DiscountFixed, DiscountValue: 100},
+			lines:   []Line{{CouponID: 1, Amount: 100000}},
+			wantErr: ErrPromoInactive,
+		},
+		{
+			name:    "еще не действует",
+			promo:   PromoCode{IsActive: true, DiscountType: DiscountFixed, DiscountValue: 100, ValidFrom: now.Add(time.Hour)},
+			lines:   []Line{{CouponID: 1, Amount: 100000}},
+			wantErr: ErrPromoInactive,
+		},
+		{
+			name:    "истек",
+			promo:   PromoCode{IsActive: true, DiscountType: DiscountFixed, DiscountValue: 100, ValidUntil: now},
+			lines:   []Line{{CouponID: 1, Amount: 100000}},
+			wantErr: ErrPromoInactive,
+		},
+		{
+			name:    "исчерпан",
+			promo:   PromoCode{IsActive: true, DiscountType: DiscountFixed, DiscountValue: 100, MaxUses: 5, UsedCount: 5},
+			lines:   []Line{{CouponID: 1, Amount: 100000}},
+			wantErr: ErrPromoExhausted,
+		},
+		{
+			name:     "лимит пользователя",
+			promo:    PromoCode{IsActive: true, DiscountType: DiscountFixed, DiscountValue: 100, PerUserLimit: 1},
+			lines:    []Line{{CouponID: 1, Amount: 100000}},
+			userUses: 1,
+			wantErr:  ErrPromoUserLimit,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.promo.discount(now, tt.lines, tt.userUses)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("ошибка %v, ожидалась %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("неожиданная ошибка: %v", err)
+			}
+			if len(got) != len(tt.lines) {
+				t.Fatalf("скидок %d, позиций %d", len(got), len(tt.lines))
+			}
+
+			var total, gotTotal, wantTotal int64
+			for i, line := range tt.lines {
+				if got[i] != tt.want[i] {
+					t.Errorf("позиция %d: скидка %d, ожидалась %d", i, got[i], tt.want[i])
+				}
+				if got[i] < 0 || got[i] > line.Amount {
+					t.Errorf("позиция %d: скидка %d вне суммы позиции %d", i, got[i], line.Amount)
+				}
+				total += line.Amount
+				gotTotal += got[i]
+				wantTotal += tt.want[i]
+			}
+			if gotTotal != wantTotal {
+				t.Errorf("сумма скидок по позициям %d, ожидалась %d", gotTotal, wantTotal)
+			}
+			if gotTotal > 0 && total-gotTotal < minChargeAmount {
+				t.Errorf("к оплате %d, меньше минимального платежа", total-gotTotal)
+			}
+		})
+	}
+}
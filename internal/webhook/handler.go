@@ -0,0 +1,203 @@
+package webhook
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/gofiber/fiber/v2"
+	"github.com/skr1ms/PaymentAlphaBank.git/pkg/auth"
+	"github.com/skr1ms/PaymentAlphaBank.git/pkg/httpapi"
+	"github.com/skr1ms/PaymentAlphaBank.git/pkg/openapi"
+)
+
+type WebhookHandlerDeps struct {
+	WebhookService *WebhookService
+	Guard          *auth.Guard
+	OpenAPI        *openapi.Spec
+}
+
+type WebhookHandler struct {
+	fiber.Router
+	deps *WebhookHandlerDeps
+}
+
+func NewWebhookHandler(router fiber.Router, deps *WebhookHandlerDeps) {
+	handler := &WebhookHandler{
+		Router: router,
+		deps:   deps,
+	}
+
+	describeRoutes(deps.OpenAPI)
+
+	// Управление подписками и журнал доставок: API-ключ или JWT с ролью, дающей область доступа
+	read := deps.Guard.RequireScope(auth.ScopeWebhooksRead)
+	write := deps.Guard.RequireScope(auth.ScopeWebhooksWrite)
+
+	router.Get("/admin/webhooks", read, handler.ListSubscriptions)
+	router.Post("/admin/webhooks", write, deps.OpenAPI.ValidateBody(CreateSubscriptionRequest{}), handler.CreateSubscription)
+	router.Delete("/admin/webhooks/:subscriptionID", write, handler.DeleteSubscription)
+	router.Get("/admin/webhook-deliveries", read, handler.ListDeliveries)
+	router.Get("/admin/webhook-deliveries/:deliveryID", read, handler.GetDelivery)
+	router.Post("/admin/webhook-deliveries/:deliveryID/redeliver", write, handler.Redeliver)
+}
+
+func describeRoutes(spec *openapi.Spec) {
+	security := []string{openapi.SecurityAPIKey, openapi.SecurityBearer}
+	subscriptionID := map[string]string{"subscriptionID": "integer"}
+	deliveryID := map[string]string{"deliveryID": "integer"}
+
+	spec.Add(openapi.Operation{
+		Method: http.MethodGet, Path: "/admin/webhooks", Tags: []string{"webhooks"},
+		Summary:  "Подписки на вебхуки",
+		Security: security,
+		Response: []Subscription{},
+	})
+	spec.Add(openapi.Operation{
+		Method: http.MethodPost, Path: "/admin/webhooks", Tags: []string{"webhooks"},
+		Summary:  "Создание подписки; секрет для проверки подписи возвращается один раз",
+		Security: security,
+		Request:  CreateSubscriptionRequest{},
+		Response: CreateSubscriptionResponse{},
+		Status:   http.StatusCreated,
+	})
+	spec.Add(openapi.Operation{
+		Method: http.MethodDelete, Path: "/admin/webhooks/:subscriptionID", Tags: []string{"webhooks"},
+		Summary:   "Отключение подписки",
+		Security:  security,
+		PathTypes: subscriptionID,
+		Status:    http.StatusNoContent,
+	})
+	spec.Add(openapi.Operation{
+		Method: http.MethodGet, Path: "/admin/webhook-deliveries", Tags: []string{"webhooks"},
+		Summary:  "Журнал доставок",
+		Security: security,
+		Query: []*openapi3.Parameter{
+			openapi3.NewQueryParameter("subscription_id").WithDescription("ID подписки").WithSchema(openapi3.NewInt64Schema()),
+			openapi3.NewQueryParameter("status").WithDescription("Статус доставки").
+				WithSchema(openapi3.NewStringSchema().WithEnum(DeliveryStatusPending, DeliveryStatusDelivered, DeliveryStatusDead)),
+			openapi3.NewQueryParameter("event_type").WithDescription("Тип события").
+				WithSchema(openapi3.NewStringSchema().WithEnum(EventOrderPaid, EventOrderRefunded, EventCouponRedeemed)),
+			openapi3.NewQueryParameter("limit").WithDescription("Размер страницы").
+				WithSchema(openapi3.NewIntegerSchema().WithMin(0).WithMax(maxDeliveryLimit)),
+			openapi3.NewQueryParameter("offset").WithDescription("Смещение").WithSchema(openapi3.NewIntegerSchema().WithMin(0)),
+		},
+		Response: []Delivery{},
+	})
+	spec.Add(openapi.Operation{
+		Method: http.MethodGet, Path: "/admin/webhook-deliveries/:deliveryID", Tags: []string{"webhooks"},
+		Summary:   "Доставка с журналом попыток",
+		Security:  security,
+		PathTypes: deliveryID,
+		Response:  Delivery{},
+	})
+	spec.Add(openapi.Operation{
+		Method: http.MethodPost, Path: "/admin/webhook-deliveries/:deliveryID/redeliver", Tags: []string{"webhooks"},
+		Summary:   "Повторная отправка доставки",
+		Security:  security,
+		PathTypes: deliveryID,
+		Response:  Delivery{},
+		Status:    http.StatusAccepted,
+	})
+}
+
+func (h *WebhookHandler) ListSubscriptions(c *fiber.Ctx) error {
+	subscriptions, err := h.deps.WebhookService.ListSubscriptions(c.Context())
+	if err != nil {
+		log.Printf("Ошибка получения подписок на вебхуки: %v", err)
+		return httpapi.Respond(c, fiber.StatusInternalServerError, httpapi.CodeInternal, "Ошибка получения подписок")
+	}
+
+	return c.JSON(subscriptions)
+}
+
+func (h *WebhookHandler) CreateSubscription(c *fiber.Ctx) error {
+	var req CreateSubscriptionRequest
+	if err := c.BodyParser(&req); err != nil {
+		return httpapi.Respond(c, fiber.StatusBadRequest, httpapi.CodeBadRequest, "Неверный формат запроса")
+	}
+
+	subscription, err := h.deps.WebhookService.CreateSubscription(c.Context(), &req)
+	if err != nil {
+		if errors.Is(err, ErrInvalidSubscription) {
+			return httpapi.Respond(c, fiber.StatusBadRequest, httpapi.CodeBadRequest, err.Error())
+		}
+		log.Printf("Ошибка создания подписки на вебхуки: %v", err)
+		return httpapi.Respond(c, fiber.StatusInternalServerError, httpapi.CodeInternal, "Ошибка создания подписки")
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(subscription)
+}
+
+func (h *WebhookHandler) DeleteSubscription(c *fiber.Ctx) error {
+	id, err := strconv.ParseInt(c.Params("subscriptionID"), 10, 64)
+	if err != nil || id <= 0 {
+		return httpapi.Respond(c, fiber.StatusBadRequest, httpapi.CodeBadRequest, "Неверный ID подписки")
+	}
+
+	if err := h.deps.WebhookService.DeleteSubscription(c.Context(), id); err != nil {
+		if errors.Is(err, ErrSubscriptionNotFound) {
+			return httpapi.Respond(c, fiber.StatusNotFound, httpapi.CodeNotFound, err.Error())
+		}
+		log.Printf("Ошибка отключения подписки на вебхуки: %v", err)
+		return httpapi.Respond(c, fiber.StatusInternalServerError, httpapi.CodeInternal, "Ошибка отключения подписки")
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+func (h *WebhookHandler) ListDeliveries(c *fiber.Ctx) error {
+	var filter DeliverySearchRequest
+	if err := c.QueryParser(&filter); err != nil {
+		return httpapi.Respond(c, fiber.StatusBadRequest, httpapi.CodeBadRequest, "Неверные параметры журнала доставок")
+	}
+
+	deliveries, err := h.deps.WebhookService.ListDeliveries(c.Context(), &filter)
+	if err != nil {
+		if errors.Is(err, ErrInvalidDeliverySearch) {
+			return httpapi.Respond(c, fiber.StatusBadRequest, httpapi.CodeBadRequest, "Неверные параметры журнала доставок")
+		}
+		log.Printf("Ошибка получения журнала доставок: %v", err)
+		return httpapi.Respond(c, fiber.StatusInternalServerError, httpapi.CodeInternal, "Ошибка получения журнала доставок")
+	}
+
+	return c.JSON(deliveries)
+}
+
+func (h *WebhookHandler) GetDelivery(c *fiber.Ctx) error {
+	id, err := strconv.ParseInt(c.Params("deliveryID"), 10, 64)
+	if err != nil || id <= 0 {
+		return httpapi.Respond(c, fiber.StatusBadRequest, httpapi.CodeBadRequest, "Неверный ID доставки")
+	}
+
+	delivery, err := h.deps.WebhookService.GetDelivery(c.Context(), id)
+	if err != nil {
+		return h.deliveryError(c, err)
+	}
+
+	return c.JSON(delivery)
+}
+
+func (h *WebhookHandler) Redeliver(c *fiber.Ctx) error {
+	id, err := strconv.ParseInt(c.Params("deliveryID"), 10, 64)
+	if err != nil || id <= 0 {
+		return httpapi.Respond(c, fiber.StatusBadRequest, httpapi.CodeBadRequest, "Неверный ID доставки")
+	}
+
+	delivery, err := h.deps.WebhookService.Redeliver(c.Context(), id)
+	if err != nil {
+		return h.deliveryError(c, err)
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(delivery)
+}
+
+func (h *WebhookHandler) deliveryError(c *fiber.Ctx, err error) error {
+	if errors.Is(err, ErrDeliveryNotFound) {
+		return httpapi.Respond(c, fiber.StatusNotFound, httpapi.CodeNotFound, err.Error())
+	}
+	log.Printf("Ошибка получения доставки вебхука: %v", err)
+	return httpapi.Respond(c, fiber.StatusInternalServerError, httpapi.CodeInternal, "Ошибка получения доставки")
+}
@@ -0,0 +1,19 @@
+package webhook
+
+import "github.com/skr1ms/PaymentAlphaBank.git/pkg/i18n"
+
+func init() {
+	i18n.Register(i18n.LocaleEN, map[string]string{
+		"Ошибка получения подписок":             "Failed to get subscriptions",
+		"Ошибка создания подписки":              "Failed to create subscription",
+		"Ошибка отключения подписки":            "Failed to disable subscription",
+		"Неверный ID подписки":                  "Invalid subscription ID",
+		"Неверный ID доставки":                  "Invalid delivery ID",
+		"Неверные параметры журнала доставок":   "Invalid delivery log parameters",
+		"Ошибка получения журнала доставок":     "Failed to get delivery log",
+		"Ошибка получения доставки":             "Failed to get delivery",
+		"неверные параметры подписки":           "invalid subscription parameters",
+		"подписка не найдена или уже отключена": "subscription not found or already disabled",
+		"доставка не найдена":                   "delivery not found",
+	})
+}
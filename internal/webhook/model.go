@@ -0,0 +1,94 @@
+package webhook
+
+import (
+	"encoding/json"
+	"slices"
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+// События, на которые можно подписаться
+const (
+	EventOrderPaid      = "order.paid"
+	EventOrderRefunded  = "order.refunded"
+	EventCouponRedeemed = "coupon.redeemed"
+)
+
+var eventTypes = []string{EventOrderPaid, EventOrderRefunded, EventCouponRedeemed}
+
+func IsEventType(eventType string) bool {
+	return slices.Contains(eventTypes, eventType)
+}
+
+// Статусы доставки
+const (
+	DeliveryStatusPending   = "pending"   // ждет первой или повторной попытки
+	DeliveryStatusDelivered = "delivered" // получатель ответил 2xx
+	DeliveryStatusDead      = "dead"      // попытки исчерпаны, нужна ручная повторная отправка
+)
+
+// Подписка внешней системы на события. Секретом подписываются тела запросов;
+// в базе он хранится в конверте secret.Envelope
+type Subscription struct {
+	bun.BaseModel `bun:"table:webhook_subscriptions"`
+
+	ID          int64     `bun:"id,pk,autoincrement" json:"id"`
+	URL         string    `bun:"url,notnull" json:"url"`
+	Secret      string    `bun:"secret,notnull" json:"-"`
+	EventTypes  []string  `bun:"event_types,array" json:"event_types"`
+	Description string    `bun:"description" json:"description,omitempty"`
+	IsActive    bool      `bun:"is_active,notnull,default:true" json:"is_active"`
+	CreatedAt   time.Time `bun:"created_at,nullzero,notnull,default:current_timestamp" json:"created_at"`
+	UpdatedAt   time.Time `bun:"updated_at,nullzero,notnull,default:current_timestamp" json:"updated_at"`
+}
+
+func (s *Subscription) Accepts(eventType string) bool {
+	return s.IsActive && slices.Contains(s.EventTypes, eventType)
+}
+
+// Доставка одного события одной подписке. Тело фиксируется при создании,
+// повторные попытки отправляют его без изменений
+type Delivery struct {
+	bun.BaseModel `bun:"table:webhook_deliveries"`
+
+	ID             int64           `bun:"id,pk,autoincrement" json:"id"`
+	SubscriptionID int64           `bun:"subscription_id,notnull" json:"subscription_id"`
+	EventID        string          `bun:"event_id,notnull" json:"event_id"`
+	EventType      string          `bun:"event_type,notnull" json:"event_type"`
+	Payload        json.RawMessage `bun:"payload,type:jsonb,notnull" json:"payload"`
+	Status         string          `bun:"status,notnull,default:'pending'" json:"status"`
+	Attempts       int             `bun:"attempts,notnull,default:0" json:"attempts"`
+	NextAttemptAt  time.Time       `bun:"next_attempt_at,nullzero" json:"next_attempt_at,omitempty"`
+	LastAttemptAt  time.Time       `bun:"last_attempt_at,nullzero" json:"last_attempt_at,omitempty"`
+	ResponseStatus int             `bun:"response_status,nullzero" json:"response_status,omitempty"`
+	LastError      string          `bun:"last_error" json:"last_error,omitempty"`
+	DeliveredAt    time.Time       `bun:"delivered_at,nullzero" json:"delivered_at,omitempty"`
+	CreatedAt      time.Time       `bun:"created_at,nullzero,notnull,default:current_timestamp" json:"created_at"`
+
+	// Связи
+	Subscription *Subscription      `bun:"rel:belongs-to,join:subscription_id=id" json:"-"`
+	Log          []*DeliveryAttempt `bun:"rel:has-many,join:id=delivery_id" json:"log,omitempty"`
+}
+
+// Запись журнала: результат одной попытки доставки
+type DeliveryAttempt struct {
+	bun.BaseModel `bun:"table:webhook_delivery_attempts"`
+
+	ID             int64     `bun:"id,pk,autoincrement" json:"id"`
+	DeliveryID     int64     `bun:"delivery_id,notnull" json:"delivery_id"`
+	Attempt        int       `bun:"attempt,notnull" json:"attempt"`
+	ResponseStatus int       `bun:"response_status,nullzero" json:"response_status,omitempty"`
+	ResponseBody   string    `bun:"response_body" json:"response_body,omitempty"` // начало ответа
+	Error          string    `bun:"error" json:"error,omitempty"`
+	DurationMs     int64     `bun:"duration_ms,notnull" json:"duration_ms"`
+	CreatedAt      time.Time `bun:"created_at,nullzero,notnull,default:current_timestamp" json:"created_at"`
+}
+
+// Тело запроса получателю
+type Event struct {
+	ID        string    `json:"id"`
+	Type      string    `json:"type"`
+	CreatedAt time.Time `json:"created_at"`
+	Data      any       `json:"data"`
+}
@@ -0,0 +1,22 @@
+package webhook
+
+type CreateSubscriptionRequest struct {
+	URL         string   `json:"url" validate:"required,url"`
+	EventTypes  []string `json:"event_types" validate:"required,min=1"`
+	Secret      string   `json:"secret,omitempty" validate:"min=16"` // по умолчанию генерируется
+	Description string   `json:"description,omitempty"`
+}
+
+// Подписка с секретом: секрет показывается только при создании
+type CreateSubscriptionResponse struct {
+	Subscription
+	Secret string `json:"secret"`
+}
+
+type DeliverySearchRequest struct {
+	SubscriptionID int64  `query:"subscription_id"`
+	Status         string `query:"status"`
+	EventType      string `query:"event_type"`
+	Limit          int    `query:"limit"`
+	Offset         int    `query:"offset"`
+}
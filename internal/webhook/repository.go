@@ -0,0 +1,215 @@
+package webhook
+
+import (
+	"context"
+	"time"
+
+	"github.com/skr1ms/PaymentAlphaBank.git/pkg/db"
+	"github.com/skr1ms/PaymentAlphaBank.git/pkg/secret"
+	"github.com/uptrace/bun"
+)
+
+type WebhookRepository struct {
+	db *bun.DB
+}
+
+func NewWebhookRepository(db *bun.DB) *WebhookRepository {
+	return &WebhookRepository{db: db}
+}
+
+func (r *WebhookRepository) CreateSubscription(ctx context.Context, subscription *Subscription) error {
+	subscription.CreatedAt = time.Now()
+	subscription.UpdatedAt = subscription.CreatedAt
+	_, err := r.db.NewInsert().Model(subscription).Exec(ctx)
+	return err
+}
+
+func (r *WebhookRepository) ListSubscriptions(ctx context.Context) ([]Subscription, error) {
+	var subscriptions []Subscription
+	err := r.db.NewSelect().
+		Model(&subscriptions).
+		Order("created_at DESC").
+		Scan(ctx)
+	return subscriptions, err
+}
+
+func (r *WebhookRepository) UpdateSecret(ctx context.Context, subscriptionID int64, encrypted string) error {
+	_, err := r.db.NewUpdate().
+		Model((*Subscription)(nil)).
+		Set("secret = ?", encrypted).
+		Set("updated_at = ?", time.Now()).
+		Where("id = ?", subscriptionID).
+		Exec(ctx)
+	return err
+}
+
+// Подписки после afterID, включая отключенные, секрет которых зашифрован
+// не ключом с префиксом prefix
+func (r *WebhookRepository) ListStaleSecrets(ctx context.Context, prefix string, afterID int64, limit int) ([]secret.Record, error) {
+	var records []secret.Record
+	err := r.db.NewSelect().
+		Model((*Subscription)(nil)).
+		Column("id").
+		ColumnExpr("secret AS value").
+		Where("id > ?", afterID).
+		Where("NOT starts_with(secret, ?)", prefix).
+		Order("id").
+		Limit(limit).
+		Scan(ctx, &records)
+	return records, err
+}
+
+// Отключение подписки; false, если подписка не найдена или уже отключена.
+// Ожидающие доставки по ней завершаются воркером как недоставленные
+func (r *WebhookRepository) DeactivateSubscription(ctx context.Context, id int64) (bool, error) {
+	res, err := r.db.NewUpdate().
+		Model((*Subscription)(nil)).
+		Set("is_active = FALSE").
+		Set("updated_at = ?", time.Now()).
+		Where("id = ? AND is_active", id).
+		Exec(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected == 1, nil
+}
+
+// Активные подписки на тип события
+func (r *WebhookRepository) GetSubscribers(ctx context.Context, eventType string) ([]Subscription, error) {
+	var subscriptions []Subscription
+	err := db.Conn(ctx, r.db).NewSelect().
+		Model(&subscriptions).
+		Where("is_active").
+		Where("? = ANY(event_types)", eventType).
+		Scan(ctx)
+	return subscriptions, err
+}
+
+func (r *WebhookRepository) CreateDeliveries(ctx context.Context, deliveries []Delivery) error {
+	if len(deliveries) == 0 {
+		return nil
+	}
+	// Доставки ставятся в очередь в транзакции операции, если она открыта
+	_, err := db.Conn(ctx, r.db).NewInsert().Model(&deliveries).Exec(ctx)
+	return err
+}
+
+// Захват доставок, время которых пришло. Срок следующей попытки сдвигается
+// на lease, чтобы другой экземпляр не взял те же доставки, пока идет отправка
+func (r *WebhookRepository) ClaimDue(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]Delivery, error) {
+	var deliveries []Delivery
+	err := r.db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		var ids []int64
+		err := tx.NewSelect().
+			Model((*Delivery)(nil)).
+			Column("id").
+			Where("status = ?", DeliveryStatusPending).
+			Where("next_attempt_at <= ?", now).
+			Order("next_attempt_at").
+			Limit(limit).
+			For("UPDATE SKIP LOCKED").
+			Scan(ctx, &ids)
+		if err != nil || len(ids) == 0 {
+			return err
+		}
+
+		_, err = tx.NewUpdate().
+			Model((*Delivery)(nil)).
+			Set("next_attempt_at = ?", now.Add(lease)).
+			Where("id IN (?)", bun.In(ids)).
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+
+		return tx.NewSelect().
+			Model(&deliveries).
+			Relation("Subscription").
+			Where("delivery.id IN (?)", bun.In(ids)).
+			Order("delivery.id").
+			Scan(ctx)
+	})
+	return deliveries, err
+}
+
+// Сохранение результата попытки вместе с записью журнала
+func (r *WebhookRepository) SaveAttempt(ctx context.Context, delivery *Delivery, attempt *DeliveryAttempt) error {
+	return r.db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		_, err := tx.NewUpdate().
+			Model(delivery).
+			Column("status", "attempts", "next_attempt_at", "last_attempt_at", "response_status", "last_error", "delivered_at").
+			WherePK().
+			Exec(ctx)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.NewInsert().Model(attempt).Exec(ctx)
+		return err
+	})
+}
+
+func (r *WebhookRepository) GetDelivery(ctx context.Context, id int64) (*Delivery, error) {
+	delivery := &Delivery{}
+	err := r.db.NewSelect().
+		Model(delivery).
+		Relation("Log", func(q *bun.SelectQuery) *bun.SelectQuery {
+			return q.Order("delivery_attempt.attempt")
+		}).
+		Where("delivery.id = ?", id).
+		Scan(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return delivery, nil
+}
+
+// Журнал доставок, новые первыми
+func (r *WebhookRepository) ListDeliveries(ctx context.Context, filter *DeliverySearchRequest) ([]Delivery, error) {
+	var deliveries []Delivery
+	q := r.db.NewSelect().
+		Model(&deliveries).
+		Order("delivery.created_at DESC", "delivery.id DESC").
+		Limit(filter.Limit).
+		Offset(filter.Offset)
+
+	if filter.SubscriptionID > 0 {
+		q = q.Where("delivery.subscription_id = ?", filter.SubscriptionID)
+	}
+	if filter.Status != "" {
+		q = q.Where("delivery.status = ?", filter.Status)
+	}
+	if filter.EventType != "" {
+		q = q.Where("delivery.event_type = ?", filter.EventType)
+	}
+
+	err := q.Scan(ctx)
+	return deliveries, err
+}
+
+// Постановка доставки в очередь заново с новым счетчиком попыток.
+// false, если доставка не найдена
+func (r *WebhookRepository) Requeue(ctx context.Context, id int64, now time.Time) (bool, error) {
+	res, err := r.db.NewUpdate().
+		Model((*Delivery)(nil)).
+		Set("status = ?", DeliveryStatusPending).
+		Set("attempts = 0").
+		Set("next_attempt_at = ?", now).
+		Set("delivered_at = NULL").
+		Where("id = ?", id).
+		Exec(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected == 1, nil
+}
@@ -0,0 +1,212 @@
+package webhook
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/skr1ms/PaymentAlphaBank.git/pkg/secret"
+)
+
+const secretPrefix = "whsec_"
+
+// Ограничения журнала доставок
+const (
+	defaultDeliveryLimit = 50
+	maxDeliveryLimit     = 200
+)
+
+var (
+	ErrInvalidSubscription   = errors.New("неверные параметры подписки")
+	ErrSubscriptionNotFound  = errors.New("подписка не найдена или уже отключена")
+	ErrDeliveryNotFound      = errors.New("доставка не найдена")
+	ErrInvalidDeliverySearch = errors.New("неверные параметры журнала доставок")
+)
+
+type WebhookService struct {
+	webhookRepo *WebhookRepository
+	envelope    *secret.Envelope
+}
+
+func NewWebhookService(webhookRepo *WebhookRepository, envelope *secret.Envelope) *WebhookService {
+	return &WebhookService{
+		webhookRepo: webhookRepo,
+		envelope:    envelope,
+	}
+}
+
+// Создание подписки; без секрета в запросе он генерируется. В базе секрет
+// хранится зашифрованным, открытым текстом он отдается только в ответе
+func (s *WebhookService) CreateSubscription(ctx context.Context, req *CreateSubscriptionRequest) (*CreateSubscriptionResponse, error) {
+	u, err := url.Parse(req.URL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return nil, ErrInvalidSubscription
+	}
+	if len(req.EventTypes) == 0 {
+		return nil, ErrInvalidSubscription
+	}
+	for _, eventType := range req.EventTypes {
+		if !IsEventType(eventType) {
+			return nil, ErrInvalidSubscription
+		}
+	}
+
+	signingSecret := req.Secret
+	if signingSecret == "" {
+		raw := make([]byte, 32)
+		if _, err := rand.Read(raw); err != nil {
+			return nil, fmt.Errorf("ошибка генерации секрета подписки: %w", err)
+		}
+		signingSecret = secretPrefix + hex.EncodeToString(raw)
+	}
+	encrypted, err := s.envelope.Encrypt(ctx, signingSecret)
+	if err != nil {
+		return nil, err
+	}
+
+	subscription := &Subscription{
+		URL:         req.URL,
+		Secret:      encrypted,
+		EventTypes:  req.EventTypes,
+		Description: req.Description,
+		IsActive:    true,
+	}
+	if err := s.webhookRepo.CreateSubscription(ctx, subscription); err != nil {
+		return nil, err
+	}
+
+	return &CreateSubscriptionResponse{Subscription: *subscription, Secret: signingSecret}, nil
+}
+
+// Шифрование секретов подписок, созданных до шифрования и хранящихся
+// открытым текстом. Возвращает число зашифрованных
+func (s *WebhookService) EncryptPlaintextSecrets(ctx context.Context) (int, error) {
+	subscriptions, err := s.webhookRepo.ListSubscriptions(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	total := 0
+	for _, subscription := range subscriptions {
+		if secret.KeyID(subscription.Secret) != "" {
+			continue
+		}
+		encrypted, err := s.envelope.Encrypt(ctx, subscription.Secret)
+		if err != nil {
+			return total, err
+		}
+		if err := s.webhookRepo.UpdateSecret(ctx, subscription.ID, encrypted); err != nil {
+			return total, fmt.Errorf("подписка %d: %w", subscription.ID, err)
+		}
+		total++
+	}
+	return total, nil
+}
+
+// Перешифрование секретов подписок основным ключом после ротации
+func (s *WebhookService) ReencryptSecrets(ctx context.Context, batch int) (int, error) {
+	return s.envelope.ReencryptAll(ctx, batch, s.webhookRepo.ListStaleSecrets, s.webhookRepo.UpdateSecret)
+}
+
+func (s *WebhookService) ListSubscriptions(ctx context.Context) ([]Subscription, error) {
+	return s.webhookRepo.ListSubscriptions(ctx)
+}
+
+func (s *WebhookService) DeleteSubscription(ctx context.Context, id int64) error {
+	deactivated, err := s.webhookRepo.DeactivateSubscription(ctx, id)
+	if err != nil {
+		return err
+	}
+	if !deactivated {
+		return ErrSubscriptionNotFound
+	}
+	return nil
+}
+
+// Постановка события в очередь доставки всем подписчикам. Доставку
+// выполняет DeliveryWorker, поэтому медленный получатель не задерживает
+// вызывающий код
+func (s *WebhookService) Publish(ctx context.Context, eventType string, data any) error {
+	subscriptions, err := s.webhookRepo.GetSubscribers(ctx, eventType)
+	if err != nil || len(subscriptions) == 0 {
+		return err
+	}
+
+	eventID, err := newEventID()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	payload, err := json.Marshal(&Event{
+		ID:        eventID,
+		Type:      eventType,
+		CreatedAt: now,
+		Data:      data,
+	})
+	if err != nil {
+		return fmt.Errorf("ошибка сериализации события: %w", err)
+	}
+
+	deliveries := make([]Delivery, 0, len(subscriptions))
+	for _, subscription := range subscriptions {
+		deliveries = append(deliveries, Delivery{
+			SubscriptionID: subscription.ID,
+			EventID:        eventID,
+			EventType:      eventType,
+			Payload:        payload,
+			Status:         DeliveryStatusPending,
+			NextAttemptAt:  now,
+			CreatedAt:      now,
+		})
+	}
+	return s.webhookRepo.CreateDeliveries(ctx, deliveries)
+}
+
+func (s *WebhookService) ListDeliveries(ctx context.Context, filter *DeliverySearchRequest) ([]Delivery, error) {
+	if filter.Limit < 0 || filter.Offset < 0 || filter.Limit > maxDeliveryLimit {
+		return nil, ErrInvalidDeliverySearch
+	}
+	if filter.Limit == 0 {
+		filter.Limit = defaultDeliveryLimit
+	}
+	return s.webhookRepo.ListDeliveries(ctx, filter)
+}
+
+// Доставка с журналом попыток
+func (s *WebhookService) GetDelivery(ctx context.Context, id int64) (*Delivery, error) {
+	delivery, err := s.webhookRepo.GetDelivery(ctx, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrDeliveryNotFound
+		}
+		return nil, err
+	}
+	return delivery, nil
+}
+
+// Ручная повторная отправка, в том числе недоставленных и уже доставленных
+// событий: доставка возвращается в очередь с тем же телом и ID события
+func (s *WebhookService) Redeliver(ctx context.Context, id int64) (*Delivery, error) {
+	requeued, err := s.webhookRepo.Requeue(ctx, id, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	if !requeued {
+		return nil, ErrDeliveryNotFound
+	}
+	return s.GetDelivery(ctx, id)
+}
+
+func newEventID() (string, error) {
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		return "", fmt.Errorf("ошибка генерации ID события: %w", err)
+	}
+	return "evt_" + hex.EncodeToString(raw), nil
+}
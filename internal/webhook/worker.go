@@ -0,0 +1,190 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/skr1ms/PaymentAlphaBank.git/pkg/secret"
+)
+
+// Заголовки запроса к получателю
+const (
+	HeaderSignature  = "X-Webhook-Signature" // t=<unix-время>,v1=<HMAC-SHA256 от "<t>.<тело>">
+	HeaderEvent      = "X-Webhook-Event"
+	HeaderEventID    = "X-Webhook-ID" // одинаков во всех попытках, по нему получатель отбрасывает повторы
+	HeaderDeliveryID = "X-Webhook-Delivery"
+)
+
+// Параметры доставки
+const (
+	deliveryInterval = 5 * time.Second
+	deliveryBatch    = 50
+	deliveryTimeout  = 10 * time.Second
+	// Пачка отправляется по одной доставке, поэтому аренда покрывает запросы
+	// всей пачки и запись их результатов; иначе последние доставки пачки
+	// заберет и отправит повторно другой экземпляр
+	deliveryLease       = deliveryBatch*deliveryTimeout + time.Minute
+	maxDeliveryAttempts = 10
+	retryBaseDelay      = 30 * time.Second
+	retryMaxDelay       = 6 * time.Hour
+	responseBodyLimit   = 1024
+)
+
+var errSubscriptionInactive = errors.New("подписка отключена")
+
+// Отправка событий из очереди доставок с повторами по экспоненциальной
+// задержке. После maxDeliveryAttempts неудач доставка переходит в dead
+type DeliveryWorker struct {
+	webhookRepo *WebhookRepository
+	envelope    *secret.Envelope
+	client      *http.Client
+}
+
+func NewDeliveryWorker(webhookRepo *WebhookRepository, envelope *secret.Envelope) *DeliveryWorker {
+	return &DeliveryWorker{
+		webhookRepo: webhookRepo,
+		envelope:    envelope,
+		client:      &http.Client{Timeout: deliveryTimeout},
+	}
+}
+
+// Обработка очереди до отмены ctx
+func (w *DeliveryWorker) Run(ctx context.Context) {
+	ticker := time.NewTicker(deliveryInterval)
+	defer ticker.Stop()
+
+	for {
+		// Полная пачка — вероятно, в очереди есть еще, берем сразу
+		for w.deliverDue(ctx) == deliveryBatch {
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (w *DeliveryWorker) deliverDue(ctx context.Context) int {
+	deliveries, err := w.webhookRepo.ClaimDue(ctx, time.Now(), deliveryLease, deliveryBatch)
+	if err != nil {
+		log.Printf("Ошибка получения доставок вебхуков: %v", err)
+		return 0
+	}
+
+	for i := range deliveries {
+		if ctx.Err() != nil {
+			return 0
+		}
+		w.deliver(ctx, &deliveries[i])
+	}
+	return len(deliveries)
+}
+
+func (w *DeliveryWorker) deliver(ctx context.Context, delivery *Delivery) {
+	started := time.Now()
+	attempt := &DeliveryAttempt{
+		DeliveryID: delivery.ID,
+		Attempt:    delivery.Attempts + 1,
+		CreatedAt:  started,
+	}
+
+	status, body, err := w.send(ctx, delivery, started)
+	attempt.DurationMs = time.Since(started).Milliseconds()
+	attempt.ResponseStatus = status
+	attempt.ResponseBody = body
+	if err == nil && (status < 200 || status > 299) {
+		err = fmt.Errorf("получатель ответил %d", status)
+	}
+
+	delivery.Attempts = attempt.Attempt
+	delivery.LastAttemptAt = started
+	delivery.ResponseStatus = status
+	switch {
+	case err == nil:
+		delivery.Status = DeliveryStatusDelivered
+		delivery.DeliveredAt = time.Now()
+		delivery.NextAttemptAt = time.Time{}
+		delivery.LastError = ""
+	case errors.Is(err, errSubscriptionInactive) || delivery.Attempts >= maxDeliveryAttempts:
+		delivery.Status = DeliveryStatusDead
+		delivery.NextAttemptAt = time.Time{}
+		delivery.LastError = err.Error()
+		log.Printf("Вебхук %d (%s) не доставлен: %v", delivery.ID, delivery.EventType, err)
+	default:
+		delivery.NextAttemptAt = time.Now().Add(retryDelay(delivery.Attempts))
+		delivery.LastError = err.Error()
+	}
+	if err != nil {
+		attempt.Error = err.Error()
+	}
+
+	if err := w.webhookRepo.SaveAttempt(ctx, delivery, attempt); err != nil {
+		log.Printf("Ошибка сохранения попытки доставки вебхука %d: %v", delivery.ID, err)
+	}
+}
+
+func (w *DeliveryWorker) send(ctx context.Context, delivery *Delivery, now time.Time) (int, string, error) {
+	subscription := delivery.Subscription
+	if subscription == nil || !subscription.IsActive {
+		return 0, "", errSubscriptionInactive
+	}
+	signingSecret, err := w.envelope.Decrypt(ctx, subscription.Secret)
+	if err != nil {
+		return 0, "", fmt.Errorf("секрет подписки %d: %w", subscription.ID, err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, subscription.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "PaymentAlphaBank-Webhooks/1.0")
+	req.Header.Set(HeaderEvent, delivery.EventType)
+	req.Header.Set(HeaderEventID, delivery.EventID)
+	req.Header.Set(HeaderDeliveryID, strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set(HeaderSignature, Sign(signingSecret, now, delivery.Payload))
+
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return 0, "", err
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(io.LimitReader(resp.Body, responseBodyLimit))
+	return resp.StatusCode, string(body), nil
+}
+
+// Подпись тела для заголовка X-Webhook-Signature. Время входит в подпись,
+// чтобы получатель мог отклонять старые повторно присланные запросы
+func Sign(secret string, timestamp time.Time, body []byte) string {
+	t := strconv.FormatInt(timestamp.Unix(), 10)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(t))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "t=" + t + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Задержка перед попыткой attempts+1: 30 с, 1 мин, 2 мин ... не более 6 ч
+func retryDelay(attempts int) time.Duration {
+	delay := retryBaseDelay
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if delay >= retryMaxDelay {
+			return retryMaxDelay
+		}
+	}
+	return delay
+}
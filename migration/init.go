@@ -8,21 +8,47 @@ import (
 	"github.com/skr1ms/PaymentAlphaBank.git/config"
 	"github.com/skr1ms/PaymentAlphaBank.git/internal/payment"
 	"github.com/skr1ms/PaymentAlphaBank.git/pkg/db"
+	"github.com/skr1ms/PaymentAlphaBank.git/pkg/i18n"
+	"github.com/skr1ms/PaymentAlphaBank.git/pkg/secret"
 	"github.com/uptrace/bun"
 )
 
 func Init(db *db.Db, config *config.Config) {
-	// Создаем таблицы и индексы
+	// Схема базы: версионированные миграции из sql/
 	ctx := context.Background()
-	if err := payment.CreateTables(ctx, db.DB); err != nil {
-		log.Fatalf("Ошибка создания таблиц: %v", err)
+	migrator, err := NewMigrator(db.DB)
+	if err != nil {
+		log.Fatalf("Ошибка загрузки миграций: %v", err)
+		return
+	}
+	applied, err := migrator.Up(ctx)
+	if err != nil {
+		log.Fatalf("Ошибка применения миграций: %v", err)
 		return
 	}
+	for _, migration := range applied {
+		log.Printf("Применена миграция %d_%s", migration.Version, migration.Name)
+	}
 
-	if err := payment.CreateIndexes(ctx, db.DB); err != nil {
-		log.Fatalf("Ошибка создания индексов: %v", err)
+	// Выдаем коды ранее активированным купонам
+	updated, err := payment.NewUserCouponRepository(db.DB).BackfillCodes(ctx)
+	if err != nil {
+		log.Fatalf("Ошибка выдачи кодов купонам: %v", err)
+		return
+	}
+	if updated > 0 {
+		log.Printf("Выданы коды %d купонам", updated)
+	}
+
+	// Позиции для заказов, созданных до появления корзины
+	backfilled, err := payment.NewOrderRepository(db.DB).BackfillItems(ctx)
+	if err != nil {
+		log.Fatalf("Ошибка создания позиций заказов: %v", err)
 		return
 	}
+	if backfilled > 0 {
+		log.Printf("Созданы позиции для %d заказов", backfilled)
+	}
 
 	// Создаем тестовые данные
 	if config.IsTest {
@@ -30,6 +56,15 @@ func Init(db *db.Db, config *config.Config) {
 			log.Printf("Ошибка создания тестовых данных: %v", err)
 			return
 		}
+		keys, err := secret.NewKeyProvider(config.SecretsKey, config.SecretsKeyFile)
+		if err != nil {
+			log.Printf("Ошибка инициализации шифрования: %v", err)
+			return
+		}
+		if err := createTestMerchant(ctx, db.DB, secret.NewEnvelope(keys)); err != nil {
+			log.Printf("Ошибка создания тестового партнера: %v", err)
+			return
+		}
 	}
 }
 
@@ -45,6 +80,22 @@ func createTestData(ctx context.Context, db *bun.DB) error {
 		return nil
 	}
 
+	// Создаем тестовые категории
+	testCategories := []payment.Category{
+		{Slug: "discounts", Name: "Скидки"},
+		{Slug: "delivery", Name: "Доставка"},
+	}
+
+	_, err = db.NewInsert().Model(&testCategories).On("CONFLICT (slug) DO UPDATE").Set("name = EXCLUDED.name").Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("ошибка создания тестовых категорий: %w", err)
+	}
+
+	categoryIDs := make(map[string]int64, len(testCategories))
+	for _, category := range testCategories {
+		categoryIDs[category.Slug] = category.ID
+	}
+
 	// Создаем тестовые купоны
 	testCoupons := []payment.Coupon{
 		{
@@ -53,6 +104,8 @@ func createTestData(ctx context.Context, db *bun.DB) error {
 			Price:       100.00,
 			Currency:    "RUB",
 			IsActive:    true,
+			CategoryID:  categoryIDs["discounts"],
+			Tags:        []string{"скидка"},
 		},
 		{
 			Name:        "Скидка 20%",
@@ -60,6 +113,8 @@ func createTestData(ctx context.Context, db *bun.DB) error {
 			Price:       200.00,
 			Currency:    "RUB",
 			IsActive:    true,
+			CategoryID:  categoryIDs["discounts"],
+			Tags:        []string{"скидка"},
 		},
 		{
 			Name:        "Бесплатная доставка",
@@ -67,6 +122,8 @@ func createTestData(ctx context.Context, db *bun.DB) error {
 			Price:       50.00,
 			Currency:    "RUB",
 			IsActive:    true,
+			CategoryID:  categoryIDs["delivery"],
+			Tags:        []string{"доставка"},
 		},
 		{
 			Name:        "Скидка 50%",
@@ -74,6 +131,8 @@ func createTestData(ctx context.Context, db *bun.DB) error {
 			Price:       500.00,
 			Currency:    "RUB",
 			IsActive:    true,
+			CategoryID:  categoryIDs["discounts"],
+			Tags:        []string{"скидка", "акция"},
 		},
 	}
 
@@ -82,6 +141,53 @@ func createTestData(ctx context.Context, db *bun.DB) error {
 		return fmt.Errorf("ошибка создания тестовых купонов: %w", err)
 	}
 
+	// Английские переводы тестовых купонов
+	englishNames := []payment.CouponTranslationRequest{
+		{Name: "10% discount", Description: "10% off any purchase in the store"},
+		{Name: "20% discount", Description: "20% off any purchase in the store"},
+		{Name: "Free delivery", Description: "Free delivery for orders over 500 rubles"},
+		{Name: "50% discount", Description: "50% off selected products"},
+	}
+
+	translations := make([]payment.CouponTranslation, 0, len(testCoupons))
+	for i, coupon := range testCoupons {
+		translations = append(translations, payment.CouponTranslation{
+			CouponID:    coupon.ID,
+			Locale:      i18n.LocaleEN,
+			Name:        englishNames[i].Name,
+			Description: englishNames[i].Description,
+		})
+	}
+
+	_, err = db.NewInsert().Model(&translations).Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("ошибка создания переводов тестовых купонов: %w", err)
+	}
+
 	log.Printf("Создано %d тестовых купонов", len(testCoupons))
 	return nil
 }
+
+func createTestMerchant(ctx context.Context, db *bun.DB, envelope *secret.Envelope) error {
+	merchantRepo := payment.NewMerchantRepository(db)
+
+	count, err := merchantRepo.Count(ctx)
+	if err != nil {
+		return fmt.Errorf("ошибка проверки количества партнеров: %w", err)
+	}
+
+	if count > 0 {
+		return nil
+	}
+
+	merchant, apiKey, err := payment.NewMerchantService(merchantRepo, envelope).CreateMerchant(ctx, &payment.MerchantRequest{
+		Name:     "Тестовый партнер",
+		Branding: payment.MerchantBranding{DisplayName: "Тестовый магазин"},
+	})
+	if err != nil {
+		return fmt.Errorf("ошибка создания тестового партнера: %w", err)
+	}
+
+	log.Printf("Создан тестовый партнер %d, API-ключ: %s", merchant.ID, apiKey)
+	return nil
+}
@@ -0,0 +1,280 @@
+package migration
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/uptrace/bun"
+)
+
+// Файлы миграций: NNNN_название.up.sql и NNNN_название.down.sql
+//
+//go:embed sql/*.sql
+var sqlFiles embed.FS
+
+// Ключ рекомендательной блокировки Postgres: миграции выполняет одна реплика,
+// остальные ждут ее завершения
+const migrationLockKey = 7_301_845_520_104
+
+var migrationFilePattern = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+var ErrNoDownMigration = errors.New("нет миграции отката")
+
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// Состояние миграции в базе
+type MigrationStatus struct {
+	Version   int64
+	Name      string
+	AppliedAt time.Time // нулевое, если миграция не применена
+}
+
+// Примененная миграция
+type SchemaMigration struct {
+	bun.BaseModel `bun:"table:schema_migrations"`
+
+	Version   int64     `bun:"version,pk"`
+	Name      string    `bun:"name,notnull"`
+	AppliedAt time.Time `bun:"applied_at,notnull,default:current_timestamp"`
+}
+
+type Migrator struct {
+	db         *bun.DB
+	migrations []Migration
+}
+
+func NewMigrator(db *bun.DB) (*Migrator, error) {
+	migrations, err := loadMigrations(sqlFiles)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, migrations: migrations}, nil
+}
+
+func loadMigrations(files fs.FS) ([]Migration, error) {
+	paths, err := fs.Glob(files, "sql/*.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, p := range paths {
+		match := migrationFilePattern.FindStringSubmatch(path.Base(p))
+		if match == nil {
+			continue
+		}
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("миграция %s: %w", p, err)
+		}
+		body, err := fs.ReadFile(files, p)
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		}
+		if migration.Name != match[2] {
+			return nil, fmt.Errorf("миграция %d: разные названия %s и %s", version, migration.Name, match[2])
+		}
+		if match[3] == "up" {
+			migration.Up = string(body)
+		} else {
+			migration.Down = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" {
+			return nil, fmt.Errorf("миграция %d_%s: нет файла up", migration.Version, migration.Name)
+		}
+		migrations = append(migrations, *migration)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Применение всех ожидающих миграций по порядку
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	var applied []Migration
+	err := m.withLock(ctx, func(conn bun.Conn, done map[int64]bool) error {
+		for _, migration := range m.migrations {
+			if done[migration.Version] {
+				continue
+			}
+			err := conn.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+				if _, err := tx.ExecContext(ctx, migration.Up); err != nil {
+					return err
+				}
+				_, err := tx.NewInsert().
+					Model(&SchemaMigration{Version: migration.Version, Name: migration.Name, AppliedAt: time.Now()}).
+					Exec(ctx)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("миграция %d_%s: %w", migration.Version, migration.Name, err)
+			}
+			applied = append(applied, migration)
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// Откат последних steps примененных миграций
+func (m *Migrator) Down(ctx context.Context, steps int) ([]Migration, error) {
+	var reverted []Migration
+	err := m.withLock(ctx, func(conn bun.Conn, done map[int64]bool) error {
+		for i := len(m.migrations) - 1; i >= 0 && len(reverted) < steps; i-- {
+			migration := m.migrations[i]
+			if !done[migration.Version] {
+				continue
+			}
+			if migration.Down == "" {
+				return fmt.Errorf("%w: %d_%s", ErrNoDownMigration, migration.Version, migration.Name)
+			}
+			err := conn.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+				if _, err := tx.ExecContext(ctx, migration.Down); err != nil {
+					return err
+				}
+				_, err := tx.NewDelete().
+					Model((*SchemaMigration)(nil)).
+					Where("version = ?", migration.Version).
+					Exec(ctx)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("откат миграции %d_%s: %w", migration.Version, migration.Name, err)
+			}
+			reverted = append(reverted, migration)
+		}
+		return nil
+	})
+	return reverted, err
+}
+
+// Все известные миграции с отметкой о применении
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	if err := m.createMigrationsTable(ctx, m.db); err != nil {
+		return nil, err
+	}
+
+	var applied []SchemaMigration
+	if err := m.db.NewSelect().Model(&applied).Scan(ctx); err != nil {
+		return nil, err
+	}
+	appliedAt := make(map[int64]time.Time, len(applied))
+	for _, migration := range applied {
+		appliedAt[migration.Version] = migration.AppliedAt
+	}
+
+	statuses := make([]MigrationStatus, len(m.migrations))
+	for i, migration := range m.migrations {
+		statuses[i] = MigrationStatus{
+			Version:   migration.Version,
+			Name:      migration.Name,
+			AppliedAt: appliedAt[migration.Version],
+		}
+	}
+	return statuses, nil
+}
+
+// Выполнение fn на одном соединении под рекомендательной блокировкой.
+// done — версии, примененные к моменту получения блокировки
+func (m *Migrator) withLock(ctx context.Context, fn func(conn bun.Conn, done map[int64]bool) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// Блокировка сессионная: снимается явно или при закрытии соединения
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock(?)", migrationLockKey); err != nil {
+		return fmt.Errorf("ошибка получения блокировки миграций: %w", err)
+	}
+	defer func() {
+		if _, err := conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock(?)", migrationLockKey); err != nil {
+			log.Printf("Ошибка снятия блокировки миграций: %v", err)
+		}
+	}()
+
+	if err := m.createMigrationsTable(ctx, conn); err != nil {
+		return err
+	}
+	if err := m.adoptLegacySchema(ctx, conn); err != nil {
+		return err
+	}
+
+	var versions []int64
+	if err := conn.NewSelect().Model((*SchemaMigration)(nil)).Column("version").Scan(ctx, &versions); err != nil {
+		return err
+	}
+	done := make(map[int64]bool, len(versions))
+	for _, version := range versions {
+		done[version] = true
+	}
+
+	return fn(conn, done)
+}
+
+func (m *Migrator) createMigrationsTable(ctx context.Context, db bun.IDB) error {
+	_, err := db.NewCreateTable().Model((*SchemaMigration)(nil)).IfNotExists().Exec(ctx)
+	if err != nil {
+		return fmt.Errorf("ошибка создания таблицы миграций: %w", err)
+	}
+	return nil
+}
+
+// База, созданная до версионированных миграций, доводится до состояния
+// первой миграции и отмечается как ее применившая
+func (m *Migrator) adoptLegacySchema(ctx context.Context, conn bun.Conn) error {
+	count, err := conn.NewSelect().Model((*SchemaMigration)(nil)).Count(ctx)
+	if err != nil || count > 0 {
+		return err
+	}
+	var legacy bool
+	if err := conn.QueryRowContext(ctx, "SELECT to_regclass('orders') IS NOT NULL").Scan(&legacy); err != nil {
+		return err
+	}
+	if !legacy || len(m.migrations) == 0 {
+		return nil
+	}
+
+	body, err := fs.ReadFile(sqlFiles, "sql/legacy.sql")
+	if err != nil {
+		return err
+	}
+	baseline := m.migrations[0]
+	err = conn.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		if _, err := tx.ExecContext(ctx, string(body)); err != nil {
+			return err
+		}
+		_, err := tx.NewInsert().
+			Model(&SchemaMigration{Version: baseline.Version, Name: baseline.Name, AppliedAt: time.Now()}).
+			Exec(ctx)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("ошибка перевода существующей схемы на миграции: %w", err)
+	}
+	log.Printf("Существующая схема отмечена как миграция %d_%s", baseline.Version, baseline.Name)
+	return nil
+}
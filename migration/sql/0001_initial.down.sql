@@ -0,0 +1,17 @@
+DROP TABLE IF EXISTS webhook_delivery_attempts;
+DROP TABLE IF EXISTS webhook_deliveries;
+DROP TABLE IF EXISTS webhook_subscriptions;
+DROP TABLE IF EXISTS api_keys;
+DROP TABLE IF EXISTS coupon_transfers;
+DROP TABLE IF EXISTS coupon_gifts;
+DROP TABLE IF EXISTS user_coupons;
+DROP TABLE IF EXISTS promo_code_usages;
+DROP TABLE IF EXISTS cart_items;
+DROP TABLE IF EXISTS refunds;
+DROP TABLE IF EXISTS order_items;
+DROP TABLE IF EXISTS orders;
+DROP TABLE IF EXISTS promo_codes;
+DROP TABLE IF EXISTS coupon_translations;
+DROP TABLE IF EXISTS coupons;
+DROP TABLE IF EXISTS coupon_categories;
+DROP TABLE IF EXISTS merchants;
@@ -0,0 +1,269 @@
+-- Схема на момент перехода на версионированные миграции, с внешними ключами
+
+-- Партнеры
+CREATE TABLE merchants (
+    id               BIGSERIAL PRIMARY KEY,
+    name             VARCHAR NOT NULL,
+    api_key_hash     VARCHAR NOT NULL UNIQUE,
+    is_active        BOOLEAN NOT NULL DEFAULT true,
+    gateway_url      VARCHAR,
+    gateway_username VARCHAR,
+    gateway_password VARCHAR,
+    return_url       VARCHAR,
+    fail_url         VARCHAR,
+    branding         JSONB,
+    created_at       TIMESTAMPTZ NOT NULL DEFAULT current_timestamp,
+    updated_at       TIMESTAMPTZ NOT NULL DEFAULT current_timestamp
+);
+
+-- Каталог
+CREATE TABLE coupon_categories (
+    id         BIGSERIAL PRIMARY KEY,
+    slug       VARCHAR NOT NULL UNIQUE,
+    name       VARCHAR NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT current_timestamp
+);
+
+CREATE TABLE coupons (
+    id          BIGSERIAL PRIMARY KEY,
+    name        VARCHAR NOT NULL,
+    description VARCHAR,
+    price       DOUBLE PRECISION NOT NULL,
+    currency    VARCHAR NOT NULL DEFAULT 'RUB',
+    is_active   BOOLEAN NOT NULL DEFAULT true,
+    valid_days  BIGINT NOT NULL DEFAULT 0,
+    category_id BIGINT CONSTRAINT coupons_category_id_fkey REFERENCES coupon_categories (id) ON DELETE SET NULL,
+    merchant_id BIGINT CONSTRAINT coupons_merchant_id_fkey REFERENCES merchants (id),
+    tags        VARCHAR[],
+    image_key   VARCHAR,
+    image_url   VARCHAR,
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT current_timestamp,
+    updated_at  TIMESTAMPTZ NOT NULL DEFAULT current_timestamp,
+    -- Вектор полнотекстового поиска: название важнее описания
+    search_vector tsvector GENERATED ALWAYS AS (
+        setweight(to_tsvector('russian', coalesce(name, '')), 'A') ||
+        setweight(to_tsvector('russian', coalesce(description, '')), 'B')
+    ) STORED
+);
+
+CREATE TABLE coupon_translations (
+    id          BIGSERIAL PRIMARY KEY,
+    coupon_id   BIGINT NOT NULL CONSTRAINT coupon_translations_coupon_id_fkey REFERENCES coupons (id) ON DELETE CASCADE,
+    locale      VARCHAR NOT NULL,
+    name        VARCHAR NOT NULL,
+    description VARCHAR,
+    updated_at  TIMESTAMPTZ NOT NULL DEFAULT current_timestamp,
+    CONSTRAINT coupon_translations_coupon_locale UNIQUE (coupon_id, locale)
+);
+
+-- Промокоды
+CREATE TABLE promo_codes (
+    id             BIGSERIAL PRIMARY KEY,
+    code           VARCHAR NOT NULL UNIQUE,
+    discount_type  VARCHAR NOT NULL,
+    discount_value DOUBLE PRECISION NOT NULL,
+    min_price      DOUBLE PRECISION NOT NULL DEFAULT 0,
+    max_uses       BIGINT NOT NULL DEFAULT 0,
+    per_user_limit BIGINT NOT NULL DEFAULT 0,
+    used_count     BIGINT NOT NULL DEFAULT 0,
+    coupon_ids     BIGINT[],
+    valid_from     TIMESTAMPTZ,
+    valid_until    TIMESTAMPTZ,
+    is_active      BOOLEAN NOT NULL DEFAULT true,
+    created_at     TIMESTAMPTZ NOT NULL DEFAULT current_timestamp,
+    updated_at     TIMESTAMPTZ NOT NULL DEFAULT current_timestamp
+);
+
+-- Заказы
+CREATE TABLE orders (
+    id                BIGSERIAL PRIMARY KEY,
+    order_number      VARCHAR NOT NULL UNIQUE,
+    alfabank_order_id VARCHAR,
+    coupon_id         BIGINT CONSTRAINT orders_coupon_id_fkey REFERENCES coupons (id),
+    merchant_id       BIGINT CONSTRAINT orders_merchant_id_fkey REFERENCES merchants (id),
+    user_id           VARCHAR NOT NULL,
+    amount            BIGINT NOT NULL,
+    discount_amount   BIGINT NOT NULL DEFAULT 0,
+    refunded_amount   BIGINT NOT NULL DEFAULT 0,
+    promo_code_id     BIGINT CONSTRAINT orders_promo_code_id_fkey REFERENCES promo_codes (id),
+    currency          VARCHAR NOT NULL DEFAULT 'RUB',
+    status            VARCHAR NOT NULL DEFAULT 'created',
+    payment_url       VARCHAR,
+    return_url        VARCHAR,
+    fail_url          VARCHAR,
+    description       VARCHAR,
+    payment_details   VARCHAR,
+    created_at        TIMESTAMPTZ NOT NULL DEFAULT current_timestamp,
+    updated_at        TIMESTAMPTZ NOT NULL DEFAULT current_timestamp
+);
+
+CREATE TABLE order_items (
+    id                BIGSERIAL PRIMARY KEY,
+    order_id          BIGINT NOT NULL CONSTRAINT order_items_order_id_fkey REFERENCES orders (id) ON DELETE CASCADE,
+    position          BIGINT NOT NULL,
+    coupon_id         BIGINT NOT NULL CONSTRAINT order_items_coupon_id_fkey REFERENCES coupons (id),
+    name              VARCHAR NOT NULL,
+    quantity          BIGINT NOT NULL,
+    unit_price        BIGINT NOT NULL,
+    discount_amount   BIGINT NOT NULL DEFAULT 0,
+    amount            BIGINT NOT NULL,
+    refunded_quantity BIGINT NOT NULL DEFAULT 0,
+    refunded_amount   BIGINT NOT NULL DEFAULT 0,
+    created_at        TIMESTAMPTZ NOT NULL DEFAULT current_timestamp,
+    CONSTRAINT order_items_order_position UNIQUE (order_id, position)
+);
+
+-- Возвраты сохраняются до запроса в банк и завершаются по его ответу или сверкой
+CREATE TABLE refunds (
+    id         BIGSERIAL PRIMARY KEY,
+    order_id   BIGINT NOT NULL CONSTRAINT refunds_order_id_fkey REFERENCES orders (id),
+    status     VARCHAR NOT NULL,
+    amount     BIGINT NOT NULL,
+    items      JSONB NOT NULL,
+    bank_error VARCHAR,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT current_timestamp,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT current_timestamp
+);
+
+CREATE TABLE cart_items (
+    id         BIGSERIAL PRIMARY KEY,
+    user_id    VARCHAR NOT NULL,
+    coupon_id  BIGINT NOT NULL CONSTRAINT cart_items_coupon_id_fkey REFERENCES coupons (id) ON DELETE CASCADE,
+    quantity   BIGINT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT current_timestamp,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT current_timestamp,
+    CONSTRAINT cart_items_user_coupon UNIQUE (user_id, coupon_id)
+);
+
+CREATE TABLE promo_code_usages (
+    id            BIGSERIAL PRIMARY KEY,
+    promo_code_id BIGINT NOT NULL CONSTRAINT promo_code_usages_promo_code_id_fkey REFERENCES promo_codes (id),
+    order_id      BIGINT NOT NULL UNIQUE CONSTRAINT promo_code_usages_order_id_fkey REFERENCES orders (id),
+    user_id       VARCHAR NOT NULL,
+    discount      BIGINT NOT NULL,
+    status        VARCHAR NOT NULL DEFAULT 'active',
+    created_at    TIMESTAMPTZ NOT NULL DEFAULT current_timestamp,
+    released_at   TIMESTAMPTZ
+);
+
+-- Купоны пользователей и подарки
+CREATE TABLE user_coupons (
+    id                   BIGSERIAL PRIMARY KEY,
+    user_id              VARCHAR NOT NULL,
+    coupon_id            BIGINT NOT NULL CONSTRAINT user_coupons_coupon_id_fkey REFERENCES coupons (id),
+    order_id             BIGINT NOT NULL CONSTRAINT user_coupons_order_id_fkey REFERENCES orders (id),
+    order_item_id        BIGINT CONSTRAINT user_coupons_order_item_id_fkey REFERENCES order_items (id),
+    code                 VARCHAR,
+    activated_at         TIMESTAMPTZ NOT NULL DEFAULT current_timestamp,
+    expires_at           TIMESTAMPTZ,
+    is_used              BOOLEAN NOT NULL DEFAULT false,
+    used_at              TIMESTAMPTZ,
+    refunded_at          TIMESTAMPTZ,
+    redeemed_merchant_id BIGINT CONSTRAINT user_coupons_redeemed_merchant_id_fkey REFERENCES merchants (id),
+    redeemed_terminal_id VARCHAR
+);
+
+CREATE TABLE coupon_gifts (
+    id             BIGSERIAL PRIMARY KEY,
+    user_coupon_id BIGINT NOT NULL CONSTRAINT coupon_gifts_user_coupon_id_fkey REFERENCES user_coupons (id),
+    from_user_id   VARCHAR NOT NULL,
+    to_user_id     VARCHAR,
+    token_hash     VARCHAR NOT NULL UNIQUE,
+    status         VARCHAR NOT NULL DEFAULT 'pending',
+    expires_at     TIMESTAMPTZ NOT NULL,
+    accepted_by    VARCHAR,
+    accepted_at    TIMESTAMPTZ,
+    created_at     TIMESTAMPTZ NOT NULL DEFAULT current_timestamp,
+    updated_at     TIMESTAMPTZ NOT NULL DEFAULT current_timestamp
+);
+
+CREATE TABLE coupon_transfers (
+    id             BIGSERIAL PRIMARY KEY,
+    user_coupon_id BIGINT NOT NULL CONSTRAINT coupon_transfers_user_coupon_id_fkey REFERENCES user_coupons (id),
+    gift_id        BIGINT NOT NULL CONSTRAINT coupon_transfers_gift_id_fkey REFERENCES coupon_gifts (id),
+    from_user_id   VARCHAR NOT NULL,
+    to_user_id     VARCHAR NOT NULL,
+    transferred_at TIMESTAMPTZ NOT NULL DEFAULT current_timestamp
+);
+
+-- API-ключи
+CREATE TABLE api_keys (
+    id           BIGSERIAL PRIMARY KEY,
+    name         VARCHAR NOT NULL,
+    prefix       VARCHAR NOT NULL,
+    key_hash     VARCHAR NOT NULL UNIQUE,
+    role         VARCHAR NOT NULL,
+    scopes       VARCHAR[],
+    merchant_id  BIGINT CONSTRAINT api_keys_merchant_id_fkey REFERENCES merchants (id),
+    expires_at   TIMESTAMPTZ,
+    last_used_at TIMESTAMPTZ,
+    revoked_at   TIMESTAMPTZ,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT current_timestamp
+);
+
+-- Вебхуки
+CREATE TABLE webhook_subscriptions (
+    id          BIGSERIAL PRIMARY KEY,
+    url         VARCHAR NOT NULL,
+    secret      VARCHAR NOT NULL,
+    event_types VARCHAR[],
+    description VARCHAR,
+    is_active   BOOLEAN NOT NULL DEFAULT true,
+    created_at  TIMESTAMPTZ NOT NULL DEFAULT current_timestamp,
+    updated_at  TIMESTAMPTZ NOT NULL DEFAULT current_timestamp
+);
+
+CREATE TABLE webhook_deliveries (
+    id              BIGSERIAL PRIMARY KEY,
+    subscription_id BIGINT NOT NULL CONSTRAINT webhook_deliveries_subscription_id_fkey REFERENCES webhook_subscriptions (id),
+    event_id        VARCHAR NOT NULL,
+    event_type      VARCHAR NOT NULL,
+    payload         JSONB NOT NULL,
+    status          VARCHAR NOT NULL DEFAULT 'pending',
+    attempts        BIGINT NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMPTZ,
+    last_attempt_at TIMESTAMPTZ,
+    response_status BIGINT,
+    last_error      VARCHAR,
+    delivered_at    TIMESTAMPTZ,
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT current_timestamp
+);
+
+CREATE TABLE webhook_delivery_attempts (
+    id              BIGSERIAL PRIMARY KEY,
+    delivery_id     BIGINT NOT NULL CONSTRAINT webhook_delivery_attempts_delivery_id_fkey REFERENCES webhook_deliveries (id) ON DELETE CASCADE,
+    attempt         BIGINT NOT NULL,
+    response_status BIGINT,
+    response_body   VARCHAR,
+    error           VARCHAR,
+    duration_ms     BIGINT NOT NULL,
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT current_timestamp
+);
+
+-- Индексы
+CREATE INDEX idx_orders_order_number ON orders (order_number);
+CREATE INDEX idx_orders_alfabank_order_id ON orders (alfabank_order_id);
+CREATE INDEX idx_orders_user_id ON orders (user_id);
+CREATE INDEX idx_orders_status ON orders (status);
+CREATE INDEX idx_orders_merchant_id ON orders (merchant_id);
+CREATE INDEX idx_order_items_order_id ON order_items (order_id);
+CREATE INDEX idx_refunds_order_id ON refunds (order_id);
+-- Очередь сверки незавершенных возвратов
+CREATE INDEX idx_refunds_pending ON refunds (created_at, id) WHERE status = 'pending';
+CREATE INDEX idx_user_coupons_user_id ON user_coupons (user_id);
+CREATE INDEX idx_user_coupons_coupon_id ON user_coupons (coupon_id);
+CREATE UNIQUE INDEX user_coupons_code_key ON user_coupons (code);
+CREATE INDEX idx_user_coupons_redeemed_merchant_id ON user_coupons (redeemed_merchant_id);
+CREATE INDEX idx_user_coupons_order_item_id ON user_coupons (order_item_id);
+CREATE INDEX idx_coupons_is_active ON coupons (is_active);
+CREATE INDEX idx_coupons_category_id ON coupons (category_id);
+CREATE INDEX idx_coupons_merchant_id ON coupons (merchant_id);
+CREATE INDEX idx_coupons_price ON coupons (price);
+CREATE INDEX idx_coupons_tags ON coupons USING GIN (tags);
+CREATE INDEX idx_coupons_search_vector ON coupons USING GIN (search_vector);
+CREATE UNIQUE INDEX idx_coupon_gifts_pending ON coupon_gifts (user_coupon_id) WHERE status = 'pending';
+CREATE INDEX idx_coupon_transfers_user_coupon_id ON coupon_transfers (user_coupon_id);
+CREATE INDEX idx_promo_code_usages_promo_user ON promo_code_usages (promo_code_id, user_id);
+CREATE INDEX idx_webhook_deliveries_due ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';
+CREATE INDEX idx_webhook_deliveries_subscription_id ON webhook_deliveries (subscription_id);
+CREATE INDEX idx_webhook_delivery_attempts_delivery_id ON webhook_delivery_attempts (delivery_id);
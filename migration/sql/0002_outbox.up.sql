@@ -0,0 +1,18 @@
+-- События для внешних потребителей, записываются в транзакции изменения
+CREATE TABLE outbox_events (
+    id              BIGSERIAL PRIMARY KEY,
+    event_id        VARCHAR NOT NULL CONSTRAINT outbox_events_event_id_key UNIQUE,
+    event_type      VARCHAR NOT NULL,
+    aggregate_key   VARCHAR NOT NULL,
+    payload         JSONB NOT NULL,
+    attempts        BIGINT NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT current_timestamp,
+    last_error      VARCHAR,
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT current_timestamp,
+    published_at    TIMESTAMPTZ
+);
+
+-- Очередь ретранслятора и проверка порядка внутри ключа
+CREATE INDEX idx_outbox_events_pending ON outbox_events (aggregate_key, id) WHERE published_at IS NULL;
+-- Очистка опубликованных
+CREATE INDEX idx_outbox_events_published_at ON outbox_events (published_at) WHERE published_at IS NOT NULL;
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS order_events;
+DROP FUNCTION IF EXISTS order_events_append_only();
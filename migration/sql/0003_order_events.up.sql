@@ -0,0 +1,29 @@
+-- Журнал изменений заказов и их купонов для разбора спорных платежей
+CREATE TABLE order_events (
+    id               BIGSERIAL PRIMARY KEY,
+    order_id         BIGINT NOT NULL CONSTRAINT order_events_order_id_fkey REFERENCES orders (id),
+    kind             VARCHAR NOT NULL,
+    old_status       VARCHAR,
+    new_status       VARCHAR,
+    user_coupon_id   BIGINT,
+    source           VARCHAR NOT NULL,
+    actor            VARCHAR,
+    bank_order_id    VARCHAR,
+    bank_status      BIGINT,
+    bank_action_code BIGINT,
+    details          JSONB,
+    created_at       TIMESTAMPTZ NOT NULL DEFAULT current_timestamp
+);
+
+CREATE INDEX idx_order_events_order_id ON order_events (order_id, id);
+
+-- Записи журнала не изменяются и не удаляются
+CREATE FUNCTION order_events_append_only() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'order_events: журнал только для добавления';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER order_events_append_only
+    BEFORE UPDATE OR DELETE ON order_events
+    FOR EACH ROW EXECUTE FUNCTION order_events_append_only();
@@ -0,0 +1 @@
+DROP SEQUENCE IF EXISTS order_number_seq;
@@ -0,0 +1,2 @@
+-- Порядковые номера заказов для генератора номеров
+CREATE SEQUENCE order_number_seq AS BIGINT START 1;
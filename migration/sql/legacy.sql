@@ -0,0 +1,105 @@
+-- Перевод базы, созданной прежним migration.Init (CreateTables и
+-- CreateIndexes), в состояние миграции 0001. Выполняется один раз, если
+-- таблица schema_migrations пуста, а таблица orders уже есть. Недостающие
+-- таблицы, колонки и индексы создаются, затем добавляются внешние ключи;
+-- если в данных есть висячие ссылки, перевод прерывается целиком
+
+-- Таблицы
+CREATE TABLE IF NOT EXISTS "coupon_categories" ("id" BIGSERIAL NOT NULL, "slug" VARCHAR NOT NULL, "name" VARCHAR NOT NULL, "created_at" TIMESTAMPTZ NOT NULL DEFAULT current_timestamp, PRIMARY KEY ("id"), UNIQUE ("slug"));
+CREATE TABLE IF NOT EXISTS "coupons" ("id" BIGSERIAL NOT NULL, "name" VARCHAR NOT NULL, "description" VARCHAR, "price" DOUBLE PRECISION NOT NULL, "currency" VARCHAR NOT NULL DEFAULT 'RUB', "is_active" BOOLEAN NOT NULL DEFAULT true, "valid_days" BIGINT NOT NULL DEFAULT 0, "category_id" BIGINT, "merchant_id" BIGINT, "tags" VARCHAR[], "image_key" VARCHAR, "image_url" VARCHAR, "created_at" TIMESTAMPTZ NOT NULL DEFAULT current_timestamp, "updated_at" TIMESTAMPTZ NOT NULL DEFAULT current_timestamp, PRIMARY KEY ("id"));
+CREATE TABLE IF NOT EXISTS "coupon_translations" ("id" BIGSERIAL NOT NULL, "coupon_id" BIGINT NOT NULL, "locale" VARCHAR NOT NULL, "name" VARCHAR NOT NULL, "description" VARCHAR, "updated_at" TIMESTAMPTZ NOT NULL DEFAULT current_timestamp, PRIMARY KEY ("id"), CONSTRAINT "coupon_translations_coupon_locale" UNIQUE ("coupon_id", "locale"));
+CREATE TABLE IF NOT EXISTS "orders" ("id" BIGSERIAL NOT NULL, "order_number" VARCHAR NOT NULL, "alfabank_order_id" VARCHAR, "coupon_id" BIGINT, "merchant_id" BIGINT, "user_id" VARCHAR NOT NULL, "amount" BIGINT NOT NULL, "discount_amount" BIGINT NOT NULL DEFAULT 0, "refunded_amount" BIGINT NOT NULL DEFAULT 0, "promo_code_id" BIGINT, "currency" VARCHAR NOT NULL DEFAULT 'RUB', "status" VARCHAR NOT NULL DEFAULT 'created', "payment_url" VARCHAR, "return_url" VARCHAR, "fail_url" VARCHAR, "description" VARCHAR, "payment_details" VARCHAR, "created_at" TIMESTAMPTZ NOT NULL DEFAULT current_timestamp, "updated_at" TIMESTAMPTZ NOT NULL DEFAULT current_timestamp, PRIMARY KEY ("id"), UNIQUE ("order_number"));
+CREATE TABLE IF NOT EXISTS "order_items" ("id" BIGSERIAL NOT NULL, "order_id" BIGINT NOT NULL, "position" BIGINT NOT NULL, "coupon_id" BIGINT NOT NULL, "name" VARCHAR NOT NULL, "quantity" BIGINT NOT NULL, "unit_price" BIGINT NOT NULL, "discount_amount" BIGINT NOT NULL DEFAULT 0, "amount" BIGINT NOT NULL, "refunded_quantity" BIGINT NOT NULL DEFAULT 0, "refunded_amount" BIGINT NOT NULL DEFAULT 0, "created_at" TIMESTAMPTZ NOT NULL DEFAULT current_timestamp, PRIMARY KEY ("id"), CONSTRAINT "order_items_order_position" UNIQUE ("order_id", "position"));
+CREATE TABLE IF NOT EXISTS "refunds" ("id" BIGSERIAL NOT NULL, "order_id" BIGINT NOT NULL, "status" VARCHAR NOT NULL, "amount" BIGINT NOT NULL, "items" JSONB NOT NULL, "bank_error" VARCHAR, "created_at" TIMESTAMPTZ NOT NULL DEFAULT current_timestamp, "updated_at" TIMESTAMPTZ NOT NULL DEFAULT current_timestamp, PRIMARY KEY ("id"));
+CREATE TABLE IF NOT EXISTS "cart_items" ("id" BIGSERIAL NOT NULL, "user_id" VARCHAR NOT NULL, "coupon_id" BIGINT NOT NULL, "quantity" BIGINT NOT NULL, "created_at" TIMESTAMPTZ NOT NULL DEFAULT current_timestamp, "updated_at" TIMESTAMPTZ NOT NULL DEFAULT current_timestamp, PRIMARY KEY ("id"), CONSTRAINT "cart_items_user_coupon" UNIQUE ("user_id", "coupon_id"));
+CREATE TABLE IF NOT EXISTS "user_coupons" ("id" BIGSERIAL NOT NULL, "user_id" VARCHAR NOT NULL, "coupon_id" BIGINT NOT NULL, "order_id" BIGINT NOT NULL, "order_item_id" BIGINT, "code" VARCHAR, "activated_at" TIMESTAMPTZ NOT NULL DEFAULT current_timestamp, "expires_at" TIMESTAMPTZ, "is_used" BOOLEAN NOT NULL DEFAULT false, "used_at" TIMESTAMPTZ, "refunded_at" TIMESTAMPTZ, "redeemed_merchant_id" BIGINT, "redeemed_terminal_id" VARCHAR, PRIMARY KEY ("id"), UNIQUE ("code"));
+CREATE TABLE IF NOT EXISTS "merchants" ("id" BIGSERIAL NOT NULL, "name" VARCHAR NOT NULL, "api_key_hash" VARCHAR NOT NULL, "is_active" BOOLEAN NOT NULL DEFAULT true, "gateway_url" VARCHAR, "gateway_username" VARCHAR, "gateway_password" VARCHAR, "return_url" VARCHAR, "fail_url" VARCHAR, "branding" jsonb, "created_at" TIMESTAMPTZ NOT NULL DEFAULT current_timestamp, "updated_at" TIMESTAMPTZ NOT NULL DEFAULT current_timestamp, PRIMARY KEY ("id"), UNIQUE ("api_key_hash"));
+CREATE TABLE IF NOT EXISTS "coupon_gifts" ("id" BIGSERIAL NOT NULL, "user_coupon_id" BIGINT NOT NULL, "from_user_id" VARCHAR NOT NULL, "to_user_id" VARCHAR, "token_hash" VARCHAR NOT NULL, "status" VARCHAR NOT NULL DEFAULT 'pending', "expires_at" TIMESTAMPTZ NOT NULL, "accepted_by" VARCHAR, "accepted_at" TIMESTAMPTZ, "created_at" TIMESTAMPTZ NOT NULL DEFAULT current_timestamp, "updated_at" TIMESTAMPTZ NOT NULL DEFAULT current_timestamp, PRIMARY KEY ("id"), UNIQUE ("token_hash"));
+CREATE TABLE IF NOT EXISTS "coupon_transfers" ("id" BIGSERIAL NOT NULL, "user_coupon_id" BIGINT NOT NULL, "gift_id" BIGINT NOT NULL, "from_user_id" VARCHAR NOT NULL, "to_user_id" VARCHAR NOT NULL, "transferred_at" TIMESTAMPTZ NOT NULL DEFAULT current_timestamp, PRIMARY KEY ("id"));
+CREATE TABLE IF NOT EXISTS "promo_codes" ("id" BIGSERIAL NOT NULL, "code" VARCHAR NOT NULL, "discount_type" VARCHAR NOT NULL, "discount_value" DOUBLE PRECISION NOT NULL, "min_price" DOUBLE PRECISION NOT NULL DEFAULT 0, "max_uses" BIGINT NOT NULL DEFAULT 0, "per_user_limit" BIGINT NOT NULL DEFAULT 0, "used_count" BIGINT NOT NULL DEFAULT 0, "coupon_ids" BIGINT[], "valid_from" TIMESTAMPTZ, "valid_until" TIMESTAMPTZ, "is_active" BOOLEAN NOT NULL DEFAULT true, "created_at" TIMESTAMPTZ NOT NULL DEFAULT current_timestamp, "updated_at" TIMESTAMPTZ NOT NULL DEFAULT current_timestamp, PRIMARY KEY ("id"), UNIQUE ("code"));
+CREATE TABLE IF NOT EXISTS "promo_code_usages" ("id" BIGSERIAL NOT NULL, "promo_code_id" BIGINT NOT NULL, "order_id" BIGINT NOT NULL, "user_id" VARCHAR NOT NULL, "discount" BIGINT NOT NULL, "status" VARCHAR NOT NULL DEFAULT 'active', "created_at" TIMESTAMPTZ NOT NULL DEFAULT current_timestamp, "released_at" TIMESTAMPTZ, PRIMARY KEY ("id"), UNIQUE ("order_id"));
+CREATE TABLE IF NOT EXISTS "api_keys" ("id" BIGSERIAL NOT NULL, "name" VARCHAR NOT NULL, "prefix" VARCHAR NOT NULL, "key_hash" VARCHAR NOT NULL, "role" VARCHAR NOT NULL, "scopes" VARCHAR[], "merchant_id" BIGINT, "expires_at" TIMESTAMPTZ, "last_used_at" TIMESTAMPTZ, "revoked_at" TIMESTAMPTZ, "created_at" TIMESTAMPTZ NOT NULL DEFAULT current_timestamp, PRIMARY KEY ("id"), UNIQUE ("key_hash"));
+CREATE TABLE IF NOT EXISTS "webhook_subscriptions" ("id" BIGSERIAL NOT NULL, "url" VARCHAR NOT NULL, "secret" VARCHAR NOT NULL, "event_types" VARCHAR[], "description" VARCHAR, "is_active" BOOLEAN NOT NULL DEFAULT true, "created_at" TIMESTAMPTZ NOT NULL DEFAULT current_timestamp, "updated_at" TIMESTAMPTZ NOT NULL DEFAULT current_timestamp, PRIMARY KEY ("id"));
+CREATE TABLE IF NOT EXISTS "webhook_deliveries" ("id" BIGSERIAL NOT NULL, "subscription_id" BIGINT NOT NULL, "event_id" VARCHAR NOT NULL, "event_type" VARCHAR NOT NULL, "payload" jsonb NOT NULL, "status" VARCHAR NOT NULL DEFAULT 'pending', "attempts" BIGINT NOT NULL DEFAULT 0, "next_attempt_at" TIMESTAMPTZ, "last_attempt_at" TIMESTAMPTZ, "response_status" BIGINT, "last_error" VARCHAR, "delivered_at" TIMESTAMPTZ, "created_at" TIMESTAMPTZ NOT NULL DEFAULT current_timestamp, PRIMARY KEY ("id"));
+CREATE TABLE IF NOT EXISTS "webhook_delivery_attempts" ("id" BIGSERIAL NOT NULL, "delivery_id" BIGINT NOT NULL, "attempt" BIGINT NOT NULL, "response_status" BIGINT, "response_body" VARCHAR, "error" VARCHAR, "duration_ms" BIGINT NOT NULL, "created_at" TIMESTAMPTZ NOT NULL DEFAULT current_timestamp, PRIMARY KEY ("id"));
+
+-- Колонки, добавлявшиеся после создания таблиц
+ALTER TABLE user_coupons ADD COLUMN IF NOT EXISTS code VARCHAR;
+ALTER TABLE user_coupons ADD COLUMN IF NOT EXISTS expires_at TIMESTAMPTZ;
+ALTER TABLE user_coupons ADD COLUMN IF NOT EXISTS redeemed_merchant_id BIGINT;
+ALTER TABLE user_coupons ADD COLUMN IF NOT EXISTS redeemed_terminal_id VARCHAR;
+ALTER TABLE coupons ADD COLUMN IF NOT EXISTS valid_days BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS discount_amount BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS promo_code_id BIGINT;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS refunded_amount BIGINT NOT NULL DEFAULT 0;
+ALTER TABLE orders ALTER COLUMN coupon_id DROP NOT NULL;
+ALTER TABLE user_coupons ADD COLUMN IF NOT EXISTS order_item_id BIGINT;
+ALTER TABLE user_coupons ADD COLUMN IF NOT EXISTS refunded_at TIMESTAMPTZ;
+ALTER TABLE coupons ADD COLUMN IF NOT EXISTS category_id BIGINT;
+ALTER TABLE coupons ADD COLUMN IF NOT EXISTS tags VARCHAR[];
+ALTER TABLE coupons ADD COLUMN IF NOT EXISTS image_key VARCHAR;
+ALTER TABLE coupons ADD COLUMN IF NOT EXISTS image_url VARCHAR;
+ALTER TABLE merchants ADD COLUMN IF NOT EXISTS gateway_url VARCHAR;
+ALTER TABLE merchants ADD COLUMN IF NOT EXISTS gateway_username VARCHAR;
+ALTER TABLE merchants ADD COLUMN IF NOT EXISTS gateway_password VARCHAR;
+ALTER TABLE merchants ADD COLUMN IF NOT EXISTS return_url VARCHAR;
+ALTER TABLE merchants ADD COLUMN IF NOT EXISTS fail_url VARCHAR;
+ALTER TABLE merchants ADD COLUMN IF NOT EXISTS branding JSONB;
+ALTER TABLE coupons ADD COLUMN IF NOT EXISTS merchant_id BIGINT;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS merchant_id BIGINT;
+ALTER TABLE orders ADD COLUMN IF NOT EXISTS payment_details VARCHAR;
+ALTER TABLE coupons ADD COLUMN IF NOT EXISTS search_vector tsvector GENERATED ALWAYS AS (
+    setweight(to_tsvector('russian', coalesce(name, '')), 'A') ||
+    setweight(to_tsvector('russian', coalesce(description, '')), 'B')
+) STORED;
+
+-- Индексы
+CREATE INDEX IF NOT EXISTS idx_orders_order_number ON orders (order_number);
+CREATE INDEX IF NOT EXISTS idx_orders_alfabank_order_id ON orders (alfabank_order_id);
+CREATE INDEX IF NOT EXISTS idx_orders_user_id ON orders (user_id);
+CREATE INDEX IF NOT EXISTS idx_orders_status ON orders (status);
+CREATE INDEX IF NOT EXISTS idx_orders_merchant_id ON orders (merchant_id);
+CREATE INDEX IF NOT EXISTS idx_order_items_order_id ON order_items (order_id);
+CREATE INDEX IF NOT EXISTS idx_refunds_order_id ON refunds (order_id);
+CREATE INDEX IF NOT EXISTS idx_refunds_pending ON refunds (created_at, id) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_user_coupons_user_id ON user_coupons (user_id);
+CREATE INDEX IF NOT EXISTS idx_user_coupons_coupon_id ON user_coupons (coupon_id);
+CREATE UNIQUE INDEX IF NOT EXISTS user_coupons_code_key ON user_coupons (code);
+CREATE INDEX IF NOT EXISTS idx_user_coupons_redeemed_merchant_id ON user_coupons (redeemed_merchant_id);
+CREATE INDEX IF NOT EXISTS idx_user_coupons_order_item_id ON user_coupons (order_item_id);
+CREATE INDEX IF NOT EXISTS idx_coupons_is_active ON coupons (is_active);
+CREATE INDEX IF NOT EXISTS idx_coupons_category_id ON coupons (category_id);
+CREATE INDEX IF NOT EXISTS idx_coupons_merchant_id ON coupons (merchant_id);
+CREATE INDEX IF NOT EXISTS idx_coupons_price ON coupons (price);
+CREATE INDEX IF NOT EXISTS idx_coupons_tags ON coupons USING GIN (tags);
+CREATE INDEX IF NOT EXISTS idx_coupons_search_vector ON coupons USING GIN (search_vector);
+CREATE UNIQUE INDEX IF NOT EXISTS idx_coupon_gifts_pending ON coupon_gifts (user_coupon_id) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_coupon_transfers_user_coupon_id ON coupon_transfers (user_coupon_id);
+CREATE INDEX IF NOT EXISTS idx_promo_code_usages_promo_user ON promo_code_usages (promo_code_id, user_id);
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_subscription_id ON webhook_deliveries (subscription_id);
+CREATE INDEX IF NOT EXISTS idx_webhook_delivery_attempts_delivery_id ON webhook_delivery_attempts (delivery_id);
+
+-- Внешние ключи
+ALTER TABLE coupons ADD CONSTRAINT coupons_category_id_fkey FOREIGN KEY (category_id) REFERENCES coupon_categories (id) ON DELETE SET NULL;
+ALTER TABLE coupons ADD CONSTRAINT coupons_merchant_id_fkey FOREIGN KEY (merchant_id) REFERENCES merchants (id);
+ALTER TABLE coupon_translations ADD CONSTRAINT coupon_translations_coupon_id_fkey FOREIGN KEY (coupon_id) REFERENCES coupons (id) ON DELETE CASCADE;
+ALTER TABLE orders ADD CONSTRAINT orders_coupon_id_fkey FOREIGN KEY (coupon_id) REFERENCES coupons (id);
+ALTER TABLE orders ADD CONSTRAINT orders_merchant_id_fkey FOREIGN KEY (merchant_id) REFERENCES merchants (id);
+ALTER TABLE orders ADD CONSTRAINT orders_promo_code_id_fkey FOREIGN KEY (promo_code_id) REFERENCES promo_codes (id);
+ALTER TABLE order_items ADD CONSTRAINT order_items_order_id_fkey FOREIGN KEY (order_id) REFERENCES orders (id) ON DELETE CASCADE;
+ALTER TABLE order_items ADD CONSTRAINT order_items_coupon_id_fkey FOREIGN KEY (coupon_id) REFERENCES coupons (id);
+ALTER TABLE refunds ADD CONSTRAINT refunds_order_id_fkey FOREIGN KEY (order_id) REFERENCES orders (id);
+ALTER TABLE cart_items ADD CONSTRAINT cart_items_coupon_id_fkey FOREIGN KEY (coupon_id) REFERENCES coupons (id) ON DELETE CASCADE;
+ALTER TABLE promo_code_usages ADD CONSTRAINT promo_code_usages_promo_code_id_fkey FOREIGN KEY (promo_code_id) REFERENCES promo_codes (id);
+ALTER TABLE promo_code_usages ADD CONSTRAINT promo_code_usages_order_id_fkey FOREIGN KEY (order_id) REFERENCES orders (id);
+ALTER TABLE user_coupons ADD CONSTRAINT user_coupons_coupon_id_fkey FOREIGN KEY (coupon_id) REFERENCES coupons (id);
+ALTER TABLE user_coupons ADD CONSTRAINT user_coupons_order_id_fkey FOREIGN KEY (order_id) REFERENCES orders (id);
+ALTER TABLE user_coupons ADD CONSTRAINT user_coupons_order_item_id_fkey FOREIGN KEY (order_item_id) REFERENCES order_items (id);
+ALTER TABLE user_coupons ADD CONSTRAINT user_coupons_redeemed_merchant_id_fkey FOREIGN KEY (redeemed_merchant_id) REFERENCES merchants (id);
+ALTER TABLE coupon_gifts ADD CONSTRAINT coupon_gifts_user_coupon_id_fkey FOREIGN KEY (user_coupon_id) REFERENCES user_coupons (id);
+ALTER TABLE coupon_transfers ADD CONSTRAINT coupon_transfers_user_coupon_id_fkey FOREIGN KEY (user_coupon_id) REFERENCES user_coupons (id);
+ALTER TABLE coupon_transfers ADD CONSTRAINT coupon_transfers_gift_id_fkey FOREIGN KEY (gift_id) REFERENCES coupon_gifts (id);
+ALTER TABLE api_keys ADD CONSTRAINT api_keys_merchant_id_fkey FOREIGN KEY (merchant_id) REFERENCES merchants (id);
+ALTER TABLE webhook_deliveries ADD CONSTRAINT webhook_deliveries_subscription_id_fkey FOREIGN KEY (subscription_id) REFERENCES webhook_subscriptions (id);
+ALTER TABLE webhook_delivery_attempts ADD CONSTRAINT webhook_delivery_attempts_delivery_id_fkey FOREIGN KEY (delivery_id) REFERENCES webhook_deliveries (id) ON DELETE CASCADE;
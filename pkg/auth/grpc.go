@@ -0,0 +1,118 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// Метаданные gRPC с учетными данными
+const (
+	APIKeyMetadata        = "x-api-key"
+	AuthorizationMetadata = "authorization"
+)
+
+type principalContextKey struct{}
+
+// Аутентификация вызовов gRPC: учетные данные из метаданных проверяются так же,
+// как в REST, вызывающий сохраняется в контексте. Вызов без учетных данных
+// пропускается, права проверяет сам метод
+func (g *Guard) UnaryServerInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+		ctx, err := g.authenticateContext(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+func (g *Guard) StreamServerInterceptor() grpc.StreamServerInterceptor {
+	return func(srv any, stream grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		ctx, err := g.authenticateContext(stream.Context())
+		if err != nil {
+			return err
+		}
+		return handler(srv, &authenticatedStream{ServerStream: stream, ctx: ctx})
+	}
+}
+
+type authenticatedStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *authenticatedStream) Context() context.Context {
+	return s.ctx
+}
+
+func (g *Guard) authenticateContext(ctx context.Context) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	token, _ := strings.CutPrefix(firstMetadata(md, AuthorizationMetadata), "Bearer ")
+
+	principal, _, err := g.authenticate(ctx, firstMetadata(md, APIKeyMetadata), strings.TrimSpace(token))
+	if err != nil {
+		if errors.Is(err, errInvalidCredentials) {
+			return nil, status.Error(codes.Unauthenticated, "неверные учетные данные")
+		}
+		log.Printf("Ошибка проверки API-ключа: %v", err)
+		return nil, status.Error(codes.Internal, "ошибка проверки доступа")
+	}
+
+	if principal != nil {
+		ctx = context.WithValue(ctx, principalContextKey{}, principal)
+	}
+	return ctx, nil
+}
+
+func firstMetadata(md metadata.MD, key string) string {
+	if values := md.Get(key); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// Вызывающий, определенный интерцептором; nil для вызова без учетных данных
+func PrincipalFromContext(ctx context.Context) *Principal {
+	principal, _ := ctx.Value(principalContextKey{}).(*Principal)
+	return principal
+}
+
+// Метод доступен только с указанной областью доступа
+func CheckScope(ctx context.Context, scope string) error {
+	principal := PrincipalFromContext(ctx)
+	if principal == nil {
+		return status.Error(codes.Unauthenticated, "требуется авторизация")
+	}
+	if !principal.HasScope(scope) {
+		return status.Error(codes.PermissionDenied, "недостаточно прав")
+	}
+	return nil
+}
+
+// Пользователь, от имени которого выполняется вызов. Пустой requested означает
+// пользователя из токена; чужой ID допускается для администратора и для
+// вызывающего с областью доступа scope
+func ActingUser(ctx context.Context, requested, scope string) (string, error) {
+	principal := PrincipalFromContext(ctx)
+	if principal == nil {
+		return "", status.Error(codes.Unauthenticated, "требуется авторизация")
+	}
+
+	if requested == "" || requested == principal.UserID {
+		if principal.UserID == "" {
+			return "", status.Error(codes.InvalidArgument, "не указан ID пользователя")
+		}
+		return principal.UserID, nil
+	}
+	if principal.HasRole(RoleAdmin) || principal.HasScope(scope) {
+		return requested, nil
+	}
+	return "", status.Error(codes.PermissionDenied, "недостаточно прав")
+}
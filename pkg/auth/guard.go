@@ -0,0 +1,109 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/skr1ms/PaymentAlphaBank.git/pkg/httpapi"
+)
+
+// Заголовок с API-ключом сотрудника или партнера
+const APIKeyHeader = "X-API-Key"
+
+const principalLocalsKey = "auth_principal"
+
+// Проверка API-ключа; для неизвестного, отозванного или просроченного ключа — nil, nil
+type KeyAuthenticator interface {
+	AuthenticateKey(ctx context.Context, key string) (*Principal, error)
+}
+
+// Доступ к административным маршрутам по API-ключу или JWT с ролью
+type Guard struct {
+	verifier *Verifier
+	keys     KeyAuthenticator
+}
+
+func NewGuard(verifier *Verifier, keys KeyAuthenticator) *Guard {
+	return &Guard{
+		verifier: verifier,
+		keys:     keys,
+	}
+}
+
+// Маршрут доступен только с указанной областью доступа
+func (g *Guard) RequireScope(scope string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		principal, err := g.Authenticate(c)
+		if err != nil {
+			log.Printf("Ошибка проверки API-ключа: %v", err)
+			return httpapi.Respond(c, fiber.StatusInternalServerError, httpapi.CodeInternal, "Ошибка проверки доступа")
+		}
+		if principal == nil {
+			return unauthorized(c)
+		}
+		if !principal.HasScope(scope) {
+			return forbidden(c)
+		}
+		return c.Next()
+	}
+}
+
+// Определение вызывающего по заголовку X-API-Key или Bearer-токену.
+// Результат сохраняется в контексте запроса
+func (g *Guard) Authenticate(c *fiber.Ctx) (*Principal, error) {
+	if principal := GetPrincipal(c); principal != nil {
+		return principal, nil
+	}
+
+	token, _ := strings.CutPrefix(c.Get(fiber.HeaderAuthorization), "Bearer ")
+	principal, claims, err := g.authenticate(c.Context(), c.Get(APIKeyHeader), strings.TrimSpace(token))
+	if err != nil {
+		if errors.Is(err, errInvalidCredentials) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	if claims != nil {
+		c.Locals(claimsLocalsKey, claims)
+	}
+	if principal != nil {
+		c.Locals(principalLocalsKey, principal)
+	}
+	return principal, nil
+}
+
+// Неверный токен или неизвестный API-ключ
+var errInvalidCredentials = errors.New("неверные учетные данные")
+
+// API-ключ важнее токена; без учетных данных — nil, nil, nil
+func (g *Guard) authenticate(ctx context.Context, apiKey, token string) (*Principal, *Claims, error) {
+	if apiKey != "" {
+		principal, err := g.keys.AuthenticateKey(ctx, apiKey)
+		if err != nil {
+			return nil, nil, err
+		}
+		if principal == nil {
+			return nil, nil, errInvalidCredentials
+		}
+		return principal, nil, nil
+	}
+
+	if token != "" {
+		claims, err := g.verifier.Verify(token)
+		if err != nil {
+			return nil, nil, errInvalidCredentials
+		}
+		return principalFromClaims(claims), claims, nil
+	}
+
+	return nil, nil, nil
+}
+
+func GetPrincipal(c *fiber.Ctx) *Principal {
+	principal, _ := c.Locals(principalLocalsKey).(*Principal)
+	return principal
+}
@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+)
+
+var ErrUnknownKey = errors.New("ключ подписи не найден в JWKS")
+
+type jwks struct {
+	Keys []jwk `json:"keys"`
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+// RSA-ключи из JWKS по kid
+type keySet struct {
+	keys map[string]*rsa.PublicKey
+}
+
+func loadJWKS(path string) (*keySet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("ошибка чтения JWKS: %w", err)
+	}
+
+	var set jwks
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("ошибка разбора JWKS: %w", err)
+	}
+
+	keys := &keySet{keys: make(map[string]*rsa.PublicKey)}
+	for _, k := range set.Keys {
+		// Ключи шифрования и других алгоритмов пропускаем
+		if k.Kty != "RSA" || (k.Use != "" && k.Use != "sig") || (k.Alg != "" && k.Alg != "RS256") {
+			continue
+		}
+		key, err := k.rsaPublicKey()
+		if err != nil {
+			return nil, fmt.Errorf("ошибка разбора ключа %q: %w", k.Kid, err)
+		}
+		keys.keys[k.Kid] = key
+	}
+	if len(keys.keys) == 0 {
+		return nil, fmt.Errorf("в JWKS %s нет RSA-ключей подписи", path)
+	}
+
+	return keys, nil
+}
+
+// Без kid в заголовке токена допускается только единственный ключ
+func (s *keySet) get(kid string) (*rsa.PublicKey, error) {
+	if key, ok := s.keys[kid]; ok {
+		return key, nil
+	}
+	if kid == "" && len(s.keys) == 1 {
+		for _, key := range s.keys {
+			return key, nil
+		}
+	}
+	return nil, ErrUnknownKey
+}
+
+func (k *jwk) rsaPublicKey() (*rsa.PublicKey, error) {
+	n, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, err
+	}
+	e, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, err
+	}
+
+	exponent := new(big.Int).SetBytes(e)
+	if !exponent.IsInt64() || exponent.Int64() < 3 || exponent.Int64() > 1<<31-1 {
+		return nil, errors.New("неверная экспонента")
+	}
+
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(n),
+		E: int(exponent.Int64()),
+	}, nil
+}
@@ -0,0 +1,115 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/skr1ms/PaymentAlphaBank.git/config"
+)
+
+var (
+	ErrInvalidToken = errors.New("недействительный токен")
+	ErrNoVerifyKeys = errors.New("не настроены ключи проверки JWT")
+)
+
+// Утверждения токена: ID пользователя в sub, роли в roles
+type Claims struct {
+	jwt.RegisteredClaims
+	Roles []string `json:"roles,omitempty"`
+}
+
+func (c *Claims) HasRole(role string) bool {
+	for _, r := range c.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// Проверка JWT: HS256 с общим секретом и/или RS256 с ключами из JWKS-файла
+type Verifier struct {
+	secret  []byte
+	rsaKeys *keySet
+	parser  *jwt.Parser
+}
+
+func NewVerifier(config *config.Config) (*Verifier, error) {
+	v := &Verifier{}
+	var methods []string
+
+	if config.JWTConfig.Secret != "" {
+		v.secret = []byte(config.JWTConfig.Secret)
+		methods = append(methods, jwt.SigningMethodHS256.Alg())
+	}
+	if config.JWTConfig.JWKSFile != "" {
+		keys, err := loadJWKS(config.JWTConfig.JWKSFile)
+		if err != nil {
+			return nil, err
+		}
+		v.rsaKeys = keys
+		methods = append(methods, jwt.SigningMethodRS256.Alg())
+	}
+	if len(methods) == 0 {
+		return nil, ErrNoVerifyKeys
+	}
+
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods(methods),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(30 * time.Second),
+	}
+	if config.JWTConfig.Issuer != "" {
+		options = append(options, jwt.WithIssuer(config.JWTConfig.Issuer))
+	}
+	if config.JWTConfig.Audience != "" {
+		options = append(options, jwt.WithAudience(config.JWTConfig.Audience))
+	}
+	v.parser = jwt.NewParser(options...)
+
+	return v, nil
+}
+
+// Проверка подписи и срока действия токена
+func (v *Verifier) Verify(tokenString string) (*Claims, error) {
+	claims := &Claims{}
+	_, err := v.parser.ParseWithClaims(tokenString, claims, v.key)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("%w: не указан sub", ErrInvalidToken)
+	}
+	return claims, nil
+}
+
+func (v *Verifier) key(token *jwt.Token) (interface{}, error) {
+	switch token.Method.Alg() {
+	case jwt.SigningMethodHS256.Alg():
+		return v.secret, nil
+	case jwt.SigningMethodRS256.Alg():
+		kid, _ := token.Header["kid"].(string)
+		return v.rsaKeys.get(kid)
+	}
+	return nil, fmt.Errorf("неподдерживаемый алгоритм %s", token.Method.Alg())
+}
+
+// Выпуск HS256-токена; используется только тестовой средой
+func (v *Verifier) Issue(userID string, roles []string, ttl time.Duration) (string, error) {
+	if v.secret == nil {
+		return "", ErrNoVerifyKeys
+	}
+
+	now := time.Now()
+	claims := &Claims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   userID,
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		},
+		Roles: roles,
+	}
+	return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(v.secret)
+}
@@ -0,0 +1,12 @@
+package auth
+
+import "github.com/skr1ms/PaymentAlphaBank.git/pkg/i18n"
+
+func init() {
+	i18n.Register(i18n.LocaleEN, map[string]string{
+		"Требуется авторизация":   "Authentication required",
+		"Недостаточно прав":       "Access denied",
+		"Ошибка выпуска токена":   "Failed to issue token",
+		"Ошибка проверки доступа": "Failed to check access",
+	})
+}
@@ -0,0 +1,142 @@
+package auth
+
+import (
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/skr1ms/PaymentAlphaBank.git/pkg/httpapi"
+)
+
+const claimsLocalsKey = "auth_claims"
+
+// Проверка Bearer-токена; утверждения сохраняются в контексте запроса
+func (v *Verifier) Middleware() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		header := c.Get(fiber.HeaderAuthorization)
+		token, ok := strings.CutPrefix(header, "Bearer ")
+		if !ok || token == "" {
+			return unauthorized(c)
+		}
+
+		claims, err := v.Verify(strings.TrimSpace(token))
+		if err != nil {
+			return unauthorized(c)
+		}
+
+		c.Locals(claimsLocalsKey, claims)
+		return c.Next()
+	}
+}
+
+// Токен из параметра запроса для клиентов, которые не могут передать
+// заголовок Authorization (EventSource в браузере). Заголовок имеет приоритет
+func TokenFromQuery(param string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if c.Get(fiber.HeaderAuthorization) == "" {
+			if token := c.Query(param); token != "" {
+				c.Request().Header.Set(fiber.HeaderAuthorization, "Bearer "+token)
+			}
+		}
+		return c.Next()
+	}
+}
+
+// Доступ только для указанных ролей
+func RequireRole(roles ...string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		claims := GetClaims(c)
+		if claims == nil {
+			return unauthorized(c)
+		}
+		for _, role := range roles {
+			if claims.HasRole(role) {
+				return c.Next()
+			}
+		}
+		return forbidden(c)
+	}
+}
+
+// Доступ к ресурсам пользователя из параметра пути: только сам пользователь или администратор
+func RequireUser(param string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		claims := GetClaims(c)
+		if claims == nil {
+			return unauthorized(c)
+		}
+		if claims.Subject != c.Params(param) && !claims.HasRole(RoleAdmin) {
+			return forbidden(c)
+		}
+		return c.Next()
+	}
+}
+
+func GetClaims(c *fiber.Ctx) *Claims {
+	claims, _ := c.Locals(claimsLocalsKey).(*Claims)
+	return claims
+}
+
+// ID пользователя из токена; пустая строка для неавторизованного запроса
+func UserID(c *fiber.Ctx) string {
+	if claims := GetClaims(c); claims != nil {
+		return claims.Subject
+	}
+	return ""
+}
+
+func IsAdmin(c *fiber.Ctx) bool {
+	claims := GetClaims(c)
+	return claims != nil && claims.HasRole(RoleAdmin)
+}
+
+// Дают ли роли пользователя JWT указанную область доступа
+func HasScope(c *fiber.Ctx, scope string) bool {
+	claims := GetClaims(c)
+	return claims != nil && principalFromClaims(claims).HasScope(scope)
+}
+
+// ID пользователя, от имени которого выполняется запрос. Пустой requested
+// означает самого вызывающего; чужой ID разрешен только администратору
+func ActingUserID(c *fiber.Ctx, requested string) (string, bool) {
+	caller := UserID(c)
+	if requested == "" || requested == caller {
+		return caller, caller != ""
+	}
+	return requested, IsAdmin(c)
+}
+
+func unauthorized(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderWWWAuthenticate, "Bearer")
+	return httpapi.Respond(c, fiber.StatusUnauthorized, httpapi.CodeUnauthorized, "Требуется авторизация")
+}
+
+func forbidden(c *fiber.Ctx) error {
+	return httpapi.Respond(c, fiber.StatusForbidden, httpapi.CodeForbidden, "Недостаточно прав")
+}
+
+// Срок действия токенов тестовой среды
+const testTokenTTL = 24 * time.Hour
+
+// Выдача токена для тестовых страниц: ?user_id=...&role=admin.
+// Регистрируется только в тестовом режиме
+func (v *Verifier) TestTokenHandler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userID := c.Query("user_id")
+		if userID == "" {
+			return httpapi.Respond(c, fiber.StatusBadRequest, httpapi.CodeBadRequest, "Не указан ID пользователя")
+		}
+
+		var roles []string
+		if role := c.Query("role"); role != "" {
+			roles = append(roles, role)
+		}
+
+		token, err := v.Issue(userID, roles, testTokenTTL)
+		if err != nil {
+			return httpapi.Respond(c, fiber.StatusInternalServerError, httpapi.CodeInternal, "Ошибка выпуска токена")
+		}
+
+		return c.JSON(fiber.Map{"token": token})
+	}
+}
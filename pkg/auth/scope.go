@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"errors"
+	"slices"
+)
+
+// Роли сотрудников и партнеров
+const (
+	RoleAdmin    = "admin" // может действовать от имени любого пользователя
+	RoleSupport  = "support"
+	RoleFinance  = "finance"
+	RoleMerchant = "merchant"
+)
+
+// Области доступа административных маршрутов
+const (
+	ScopeCouponsWrite     = "coupons:write"
+	ScopeOrdersRead       = "orders:read"
+	ScopeOrdersWrite      = "orders:write" // заказы от имени пользователей
+	ScopeRefundsWrite     = "refunds:write"
+	ScopePromoRead        = "promo:read"
+	ScopePromoWrite       = "promo:write"
+	ScopeRedemptionsWrite = "redemptions:write"
+	ScopeWebhooksRead     = "webhooks:read"
+	ScopeWebhooksWrite    = "webhooks:write"
+	ScopeMerchantsRead    = "merchants:read"
+	ScopeMerchantsWrite   = "merchants:write"
+)
+
+var ErrInvalidScope = errors.New("область доступа недоступна для роли")
+
+// Области доступа каждой роли; ключ не может получить больше, чем дает его роль
+var roleScopes = map[string][]string{
+	RoleAdmin: {
+		ScopeCouponsWrite, ScopeOrdersRead, ScopeOrdersWrite, ScopeRefundsWrite,
+		ScopePromoRead, ScopePromoWrite, ScopeRedemptionsWrite,
+		ScopeWebhooksRead, ScopeWebhooksWrite, ScopeMerchantsRead, ScopeMerchantsWrite,
+	},
+	RoleSupport:  {ScopeOrdersRead, ScopePromoRead, ScopeWebhooksRead, ScopeMerchantsRead},
+	RoleFinance:  {ScopeOrdersRead, ScopeRefundsWrite, ScopePromoRead, ScopePromoWrite},
+	RoleMerchant: {ScopeRedemptionsWrite},
+}
+
+func IsRole(role string) bool {
+	_, ok := roleScopes[role]
+	return ok
+}
+
+func RoleScopes(role string) []string {
+	return slices.Clone(roleScopes[role])
+}
+
+// Проверка, что все области доступа разрешены роли
+func ValidateScopes(role string, scopes []string) error {
+	for _, scope := range scopes {
+		if !slices.Contains(roleScopes[role], scope) {
+			return ErrInvalidScope
+		}
+	}
+	return nil
+}
+
+// Кто выполняет запрос: пользователь с JWT или владелец API-ключа
+type Principal struct {
+	UserID     string
+	KeyID      int64
+	Roles      []string
+	Scopes     []string
+	MerchantID int64
+}
+
+func (p *Principal) HasScope(scope string) bool {
+	return slices.Contains(p.Scopes, scope)
+}
+
+func (p *Principal) HasRole(role string) bool {
+	return slices.Contains(p.Roles, role)
+}
+
+// Области доступа пользователя JWT определяются его ролями
+func principalFromClaims(claims *Claims) *Principal {
+	principal := &Principal{
+		UserID: claims.Subject,
+		Roles:  claims.Roles,
+	}
+	for _, role := range claims.Roles {
+		for _, scope := range roleScopes[role] {
+			if !principal.HasScope(scope) {
+				principal.Scopes = append(principal.Scopes, scope)
+			}
+		}
+	}
+	return principal
+}
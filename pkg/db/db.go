@@ -22,5 +22,9 @@ func NewDb(config *config.Config) (*Db, error) {
 	}
 	db.DefaultQueryExecMode = pgx.QueryExecModeSimpleProtocol
 	psqlDB := stdlib.OpenDB(*db)
+	psqlDB.SetMaxOpenConns(config.DbConfig.MaxOpenConns)
+	psqlDB.SetMaxIdleConns(config.DbConfig.MaxIdleConns)
+	psqlDB.SetConnMaxLifetime(config.DbConfig.ConnMaxLifetime)
+	psqlDB.SetConnMaxIdleTime(config.DbConfig.ConnMaxIdleTime)
 	return &Db{bun.NewDB(psqlDB, pgdialect.New())}, nil
 }
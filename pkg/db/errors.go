@@ -0,0 +1,30 @@
+package db
+
+import (
+	"errors"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Код ошибки Postgres для нарушения уникальности
+const uniqueViolationCode = "23505"
+
+// Проверка нарушения уникального ограничения; пустое имя — любое ограничение
+func IsUniqueViolation(err error, constraint string) bool {
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) {
+		return false
+	}
+	return pgErr.Code == uniqueViolationCode && (constraint == "" || pgErr.ConstraintName == constraint)
+}
+
+// Ошибка нарушения уникальности в том виде, в каком ее возвращает Postgres.
+// Нужна хранилищам без базы, чтобы вызывающий код обрабатывал их одинаково
+func UniqueViolation(constraint string) error {
+	return &pgconn.PgError{
+		Severity:       "ERROR",
+		Code:           uniqueViolationCode,
+		Message:        "duplicate key value violates unique constraint \"" + constraint + "\"",
+		ConstraintName: constraint,
+	}
+}
@@ -0,0 +1,49 @@
+package db
+
+import (
+	"context"
+
+	"github.com/uptrace/bun"
+)
+
+type txKey struct{}
+
+// Транзакция из контекста, если вызов выполняется внутри RunInTx, иначе idb.
+// Репозитории берут соединение через Conn и так присоединяются к транзакции
+// вызывающего кода
+func Conn(ctx context.Context, idb bun.IDB) bun.IDB {
+	if tx, ok := ctx.Value(txKey{}).(bun.Tx); ok {
+		return tx
+	}
+	return idb
+}
+
+// Выполнение fn в транзакции, доступной через контекст. Если транзакция уже
+// открыта, fn присоединяется к ней: фиксацию и откат выполняет внешний вызов
+func RunInTx(ctx context.Context, db *bun.DB, fn func(ctx context.Context, tx bun.IDB) error) error {
+	if tx, ok := ctx.Value(txKey{}).(bun.Tx); ok {
+		return fn(ctx, tx)
+	}
+	return db.RunInTx(ctx, nil, func(ctx context.Context, tx bun.Tx) error {
+		return fn(context.WithValue(ctx, txKey{}, tx), tx)
+	})
+}
+
+// Запуск операций сервисов в одной транзакции Postgres
+type TxRunner struct {
+	db *bun.DB
+}
+
+func NewTxRunner(db *bun.DB) *TxRunner {
+	return &TxRunner{db: db}
+}
+
+func (r *TxRunner) RunInTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	return RunInTx(ctx, r.db, func(ctx context.Context, tx bun.IDB) error {
+		return fn(ctx)
+	})
+}
+
+func (d *Db) TxRunner() *TxRunner {
+	return NewTxRunner(d.DB)
+}
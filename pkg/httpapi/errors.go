@@ -0,0 +1,143 @@
+package httpapi
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/requestid"
+	"github.com/skr1ms/PaymentAlphaBank.git/pkg/i18n"
+)
+
+// Машиночитаемые коды ошибок API
+const (
+	CodeBadRequest           = "bad_request"
+	CodeValidationFailed     = "validation_failed"
+	CodeUnauthorized         = "unauthorized"
+	CodeForbidden            = "forbidden"
+	CodeNotFound             = "not_found"
+	CodeOrderNotFound        = "order_not_found"
+	CodeCouponNotFound       = "coupon_not_found"
+	CodeGiftNotFound         = "gift_not_found"
+	CodeMethodNotAllowed     = "method_not_allowed"
+	CodeConflict             = "conflict"
+	CodeUnsupportedMediaType = "unsupported_media_type"
+	CodeUnprocessable        = "unprocessable_entity"
+	CodeTooManyRequests      = "too_many_requests"
+	CodeInternal             = "internal_error"
+	CodeBadGateway           = "bad_gateway"
+	CodeServiceUnavailable   = "service_unavailable"
+)
+
+// Тело ответа с ошибкой: {"error": {"code": ..., "message": ..., "request_id": ...}}
+type ErrorResponse struct {
+	Error ErrorBody `json:"error"`
+}
+
+type ErrorBody struct {
+	Code      string       `json:"code"`
+	Message   string       `json:"message"`
+	RequestID string       `json:"request_id,omitempty"`
+	Fields    []FieldError `json:"fields,omitempty"`
+}
+
+// Ошибка в отдельном поле запроса; Field — путь через точку, например items.0.coupon_id
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// Ошибка, которую обработчик может вернуть вместо записи ответа
+type Error struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func NewError(status int, code, message string) *Error {
+	return &Error{Status: status, Code: code, Message: message}
+}
+
+// Ответ с ошибкой; сообщение переводится на язык запроса
+func Respond(c *fiber.Ctx, status int, code, message string) error {
+	return c.Status(status).JSON(ErrorResponse{
+		Error: ErrorBody{
+			Code:      code,
+			Message:   i18n.T(c, message),
+			RequestID: RequestID(c),
+		},
+	})
+}
+
+// Ответ об ошибках проверки с перечнем полей
+func RespondFields(c *fiber.Ctx, status int, code, message string, fields []FieldError) error {
+	for i := range fields {
+		fields[i].Message = i18n.T(c, fields[i].Message)
+	}
+	return c.Status(status).JSON(ErrorResponse{
+		Error: ErrorBody{
+			Code:      code,
+			Message:   i18n.T(c, message),
+			RequestID: RequestID(c),
+			Fields:    fields,
+		},
+	})
+}
+
+// ID запроса, выданный middleware requestid
+func RequestID(c *fiber.Ctx) string {
+	id, _ := c.Locals(requestid.ConfigDefault.ContextKey).(string)
+	return id
+}
+
+// Обработчик ошибок приложения: неизвестные маршруты, паники и ошибки,
+// возвращенные из обработчиков, приводятся к общему формату
+func ErrorHandler(c *fiber.Ctx, err error) error {
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return Respond(c, apiErr.Status, apiErr.Code, apiErr.Message)
+	}
+
+	var fiberErr *fiber.Error
+	if errors.As(err, &fiberErr) {
+		return Respond(c, fiberErr.Code, CodeForStatus(fiberErr.Code), fiberErr.Message)
+	}
+
+	return Respond(c, fiber.StatusInternalServerError, CodeInternal, "Внутренняя ошибка сервера")
+}
+
+// Код ошибки по HTTP-статусу для ответов без более точного кода
+func CodeForStatus(status int) string {
+	switch status {
+	case fiber.StatusBadRequest:
+		return CodeBadRequest
+	case fiber.StatusUnauthorized:
+		return CodeUnauthorized
+	case fiber.StatusForbidden:
+		return CodeForbidden
+	case fiber.StatusNotFound:
+		return CodeNotFound
+	case fiber.StatusMethodNotAllowed:
+		return CodeMethodNotAllowed
+	case fiber.StatusConflict:
+		return CodeConflict
+	case fiber.StatusUnsupportedMediaType:
+		return CodeUnsupportedMediaType
+	case fiber.StatusUnprocessableEntity:
+		return CodeUnprocessable
+	case fiber.StatusTooManyRequests:
+		return CodeTooManyRequests
+	case fiber.StatusBadGateway:
+		return CodeBadGateway
+	case fiber.StatusServiceUnavailable:
+		return CodeServiceUnavailable
+	}
+	if status >= fiber.StatusInternalServerError {
+		return CodeInternal
+	}
+	return CodeBadRequest
+}
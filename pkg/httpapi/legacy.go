@@ -0,0 +1,32 @@
+package httpapi
+
+import (
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Совместимость со старыми путями без версии: запрос к prefix/... повторно
+// маршрутизируется на prefix/version/... и помечается заголовком Deprecation
+func Legacy(prefix, version string) fiber.Handler {
+	versioned := prefix + "/" + version
+
+	return func(c *fiber.Ctx) error {
+		path := c.Path()
+		if path == versioned || strings.HasPrefix(path, versioned+"/") {
+			return c.Next()
+		}
+
+		target := versioned + strings.TrimPrefix(path, prefix)
+		c.Set("Deprecation", "true")
+		c.Set(fiber.HeaderLink, "<"+target+">; rel=\"successor-version\"")
+
+		// При повторной маршрутизации сохраняется уже выданный ID запроса
+		if id := RequestID(c); id != "" {
+			c.Request().Header.Set(fiber.HeaderXRequestID, id)
+		}
+
+		c.Path(target)
+		return c.RestartRouting()
+	}
+}
@@ -0,0 +1,9 @@
+package httpapi
+
+import "github.com/skr1ms/PaymentAlphaBank.git/pkg/i18n"
+
+func init() {
+	i18n.Register(i18n.LocaleEN, map[string]string{
+		"Внутренняя ошибка сервера": "Internal server error",
+	})
+}
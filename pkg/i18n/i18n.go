@@ -0,0 +1,86 @@
+package i18n
+
+import (
+	"sync"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Поддерживаемые языки; русский — язык исходных текстов
+const (
+	LocaleRU      = "ru"
+	LocaleEN      = "en"
+	DefaultLocale = LocaleRU
+)
+
+var supportedLocales = []string{LocaleRU, LocaleEN}
+
+const localeLocalsKey = "locale"
+
+var (
+	mu           sync.RWMutex
+	translations = map[string]map[string]string{}
+)
+
+func IsSupported(locale string) bool {
+	for _, supported := range supportedLocales {
+		if locale == supported {
+			return true
+		}
+	}
+	return false
+}
+
+// Регистрация переводов: ключ — исходный русский текст. Пакеты вызывают ее из init
+func Register(locale string, messages map[string]string) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	catalog, ok := translations[locale]
+	if !ok {
+		catalog = make(map[string]string, len(messages))
+		translations[locale] = catalog
+	}
+	for source, translated := range messages {
+		catalog[source] = translated
+	}
+}
+
+// Перевод сообщения; если перевода нет, возвращается исходный текст
+func Translate(locale, message string) string {
+	mu.RLock()
+	defer mu.RUnlock()
+
+	if translated, ok := translations[locale][message]; ok {
+		return translated
+	}
+	return message
+}
+
+// Выбор языка запроса: параметр ?lang важнее заголовка Accept-Language
+func Middleware(c *fiber.Ctx) error {
+	locale := c.Query("lang")
+	if !IsSupported(locale) {
+		locale = c.AcceptsLanguages(supportedLocales...)
+	}
+	if locale == "" {
+		locale = DefaultLocale
+	}
+
+	c.Locals(localeLocalsKey, locale)
+	c.Set(fiber.HeaderContentLanguage, locale)
+	return c.Next()
+}
+
+// Язык текущего запроса
+func Locale(c *fiber.Ctx) string {
+	if locale, ok := c.Locals(localeLocalsKey).(string); ok {
+		return locale
+	}
+	return DefaultLocale
+}
+
+// Перевод сообщения на язык текущего запроса
+func T(c *fiber.Ctx, message string) string {
+	return Translate(Locale(c), message)
+}
@@ -0,0 +1,24 @@
+package openapi
+
+import "github.com/skr1ms/PaymentAlphaBank.git/pkg/i18n"
+
+func init() {
+	i18n.Register(i18n.LocaleEN, map[string]string{
+		"Ошибка проверки запроса":                 "Request validation failed",
+		"Тело запроса должно быть в формате JSON": "Request body must be JSON",
+		"Обязательное поле":                       "Field is required",
+		"Неверный тип значения":                   "Invalid value type",
+		"Требуется абсолютный URL":                "Absolute URL is required",
+		"Неверный формат значения":                "Invalid value format",
+		"Значение должно быть положительным":      "Value must be positive",
+		"Значение меньше допустимого":             "Value is too small",
+		"Значение больше допустимого":             "Value is too large",
+		"Значение не должно быть пустым":          "Value must not be empty",
+		"Значение слишком короткое":               "Value is too short",
+		"Значение слишком длинное":                "Value is too long",
+		"Слишком мало элементов":                  "Too few items",
+		"Слишком много элементов":                 "Too many items",
+		"Недопустимое значение":                   "Value is not allowed",
+		"Неверное значение":                       "Invalid value",
+	})
+}
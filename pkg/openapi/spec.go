@@ -0,0 +1,280 @@
+package openapi
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3gen"
+	"github.com/gofiber/fiber/v2"
+	"github.com/skr1ms/PaymentAlphaBank.git/pkg/httpapi"
+)
+
+// Схемы безопасности, на которые ссылаются операции
+const (
+	SecurityBearer      = "bearerAuth"
+	SecurityAPIKey      = "apiKey"
+	SecurityMerchantKey = "merchantKey"
+)
+
+// Описание одного маршрута API
+type Operation struct {
+	Method      string
+	Path        string // путь в нотации Fiber: /orders/:orderNumber/status
+	Summary     string
+	Tags        []string
+	Security    []string          // альтернативные схемы безопасности
+	PathTypes   map[string]string // тип параметра пути, по умолчанию string
+	Query       []*openapi3.Parameter
+	Request     any    // тело запроса
+	Response    any    // тело успешного ответа
+	Status      int    // код успешного ответа, по умолчанию 200
+	ContentType string // тип успешного ответа, если это не JSON; Response тогда — схема его элементов
+}
+
+// Документ OpenAPI 3, собираемый из описаний маршрутов. Схемы тел строятся
+// по Go-типам, ограничения берутся из тега validate
+type Spec struct {
+	doc           *openapi3.T
+	schemas       map[reflect.Type]*openapi3.SchemaRef
+	errorResponse *openapi3.ResponseRef
+}
+
+func New(title, version, serverURL string) *Spec {
+	doc := &openapi3.T{
+		OpenAPI: "3.0.3",
+		Info: &openapi3.Info{
+			Title:   title,
+			Version: version,
+		},
+		Servers: openapi3.Servers{{URL: serverURL}},
+		Paths:   openapi3.NewPaths(),
+		Components: &openapi3.Components{
+			Schemas: openapi3.Schemas{},
+			SecuritySchemes: openapi3.SecuritySchemes{
+				SecurityBearer: &openapi3.SecuritySchemeRef{
+					Value: openapi3.NewJWTSecurityScheme(),
+				},
+				SecurityAPIKey: &openapi3.SecuritySchemeRef{
+					Value: openapi3.NewSecurityScheme().WithType("apiKey").WithIn("header").WithName("X-API-Key"),
+				},
+				SecurityMerchantKey: &openapi3.SecuritySchemeRef{
+					Value: openapi3.NewSecurityScheme().WithType("apiKey").WithIn("header").WithName("X-Merchant-Key"),
+				},
+			},
+		},
+	}
+
+	s := &Spec{
+		doc:     doc,
+		schemas: make(map[reflect.Type]*openapi3.SchemaRef),
+	}
+
+	// Общий ответ с ошибкой для всех операций
+	errorResponse := openapi3.NewResponse().
+		WithDescription("Ошибка").
+		WithJSONSchemaRef(s.schema(httpapi.ErrorResponse{}))
+	doc.Components.Responses = openapi3.ResponseBodies{
+		"Error": &openapi3.ResponseRef{Value: errorResponse},
+	}
+	s.errorResponse = &openapi3.ResponseRef{Ref: "#/components/responses/Error", Value: errorResponse}
+
+	return s
+}
+
+// Добавление операции в документ. Ошибка построения схемы — ошибка
+// в описании маршрутов, поэтому вызывает панику при старте
+func (s *Spec) Add(op Operation) {
+	operation := openapi3.NewOperation()
+	operation.Summary = op.Summary
+	operation.Tags = op.Tags
+	operation.OperationID = operationID(op.Method, op.Path)
+
+	path, params := convertPath(op.Path)
+	for _, name := range params {
+		schema := openapi3.NewStringSchema()
+		if op.PathTypes[name] == "integer" {
+			schema = openapi3.NewInt64Schema().WithMin(1)
+		}
+		operation.AddParameter(openapi3.NewPathParameter(name).WithSchema(schema))
+	}
+	for _, param := range op.Query {
+		operation.AddParameter(param)
+	}
+
+	if len(op.Security) > 0 {
+		requirements := openapi3.NewSecurityRequirements()
+		for _, name := range op.Security {
+			requirements.With(openapi3.NewSecurityRequirement().Authenticate(name))
+		}
+		operation.Security = requirements
+	}
+
+	if op.Request != nil {
+		operation.RequestBody = &openapi3.RequestBodyRef{
+			Value: openapi3.NewRequestBody().
+				WithRequired(true).
+				WithJSONSchemaRef(s.schema(op.Request)),
+		}
+	}
+
+	status := op.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	response := openapi3.NewResponse().WithDescription(http.StatusText(status))
+	switch {
+	case op.ContentType != "" && op.Response != nil:
+		response.WithContent(openapi3.NewContentWithSchemaRef(s.schema(op.Response), []string{op.ContentType}))
+	case op.ContentType != "":
+		response.WithContent(openapi3.NewContentWithSchema(openapi3.NewStringSchema(), []string{op.ContentType}))
+	case op.Response != nil:
+		response.WithJSONSchemaRef(s.schema(op.Response))
+	}
+	operation.AddResponse(status, response)
+	operation.Responses.Set("default", s.errorResponse)
+
+	s.doc.AddOperation(path, op.Method, operation)
+}
+
+// Документ для отдачи клиентам
+func (s *Spec) Document() *openapi3.T {
+	return s.doc
+}
+
+func (s *Spec) Handler() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		return c.JSON(s.doc)
+	}
+}
+
+// Схема типа тела. Именованные структуры верхнего уровня попадают
+// в components/schemas, вложенные описываются на месте. У ссылок сохраняется
+// значение схемы, по нему же проверяются запросы
+func (s *Spec) schema(value any) *openapi3.SchemaRef {
+	return s.schemaFor(reflect.TypeOf(value))
+}
+
+func (s *Spec) schemaFor(t reflect.Type) *openapi3.SchemaRef {
+	switch t.Kind() {
+	case reflect.Pointer:
+		return s.schemaFor(t.Elem())
+	case reflect.Slice:
+		array := openapi3.NewArraySchema()
+		array.Items = s.schemaFor(t.Elem())
+		return openapi3.NewSchemaRef("", array)
+	}
+
+	if ref, ok := s.schemas[t]; ok {
+		return ref
+	}
+
+	generator := openapi3gen.NewGenerator(openapi3gen.SchemaCustomizer(applyValidateTag))
+	ref, err := generator.NewSchemaRefForValue(reflect.New(t).Elem().Interface(), nil)
+	if err != nil {
+		panic(fmt.Sprintf("openapi: схема для %s: %v", t, err))
+	}
+
+	if t.Kind() == reflect.Struct && t.Name() != "" {
+		s.doc.Components.Schemas[t.Name()] = openapi3.NewSchemaRef("", ref.Value)
+		ref = openapi3.NewSchemaRef("#/components/schemas/"+t.Name(), ref.Value)
+	}
+
+	s.schemas[t] = ref
+	return ref
+}
+
+// Ограничения из тега validate: required, url, min=N, max=N
+func applyValidateTag(name string, t reflect.Type, tag reflect.StructTag, schema *openapi3.Schema) error {
+	if t.Kind() == reflect.Struct {
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if hasRule(field.Tag.Get("validate"), "required") {
+				schema.Required = append(schema.Required, jsonName(field))
+			}
+		}
+		return nil
+	}
+
+	for _, rule := range strings.Split(tag.Get("validate"), ",") {
+		key, value, _ := strings.Cut(rule, "=")
+		switch key {
+		case "url":
+			schema.Format = FormatAbsoluteURL
+		case "min", "max":
+			n, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return fmt.Errorf("поле %s: неверное ограничение %q", name, rule)
+			}
+			setBound(schema, key, n)
+		}
+	}
+	return nil
+}
+
+// min и max ограничивают число, длину строки или число элементов массива
+func setBound(schema *openapi3.Schema, key string, n float64) {
+	switch {
+	case schema.Type.Is("string"):
+		if key == "min" {
+			schema.MinLength = uint64(n)
+		} else {
+			schema.MaxLength = openapi3.Uint64Ptr(uint64(n))
+		}
+	case schema.Type.Is("array"):
+		if key == "min" {
+			schema.MinItems = uint64(n)
+		} else {
+			schema.MaxItems = openapi3.Uint64Ptr(uint64(n))
+		}
+	case schema.Type.Is("integer"), schema.Type.Is("number"):
+		if key == "min" {
+			schema.Min = openapi3.Float64Ptr(n)
+		} else {
+			schema.Max = openapi3.Float64Ptr(n)
+		}
+	}
+}
+
+func hasRule(tag, rule string) bool {
+	for _, r := range strings.Split(tag, ",") {
+		if r == rule {
+			return true
+		}
+	}
+	return false
+}
+
+func jsonName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "" {
+		return field.Name
+	}
+	return name
+}
+
+// Перевод пути Fiber в шаблон OpenAPI: /orders/:orderNumber -> /orders/{orderNumber}
+func convertPath(path string) (string, []string) {
+	var params []string
+	segments := strings.Split(path, "/")
+	for i, segment := range segments {
+		if name, ok := strings.CutPrefix(segment, ":"); ok {
+			params = append(params, name)
+			segments[i] = "{" + name + "}"
+		}
+	}
+	return strings.Join(segments, "/"), params
+}
+
+// operationId из метода и пути: GET /orders/:orderNumber/status -> getOrdersOrderNumberStatus
+func operationID(method, path string) string {
+	var b strings.Builder
+	b.WriteString(strings.ToLower(method))
+	for _, segment := range strings.FieldsFunc(path, func(r rune) bool { return r == '/' || r == '-' || r == ':' }) {
+		b.WriteString(strings.ToUpper(segment[:1]) + segment[1:])
+	}
+	return b.String()
+}
@@ -0,0 +1,36 @@
+package openapi
+
+import (
+	"html"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Версия Swagger UI, загружаемого с CDN
+const swaggerUIVersion = "5.17.14"
+
+// Страница Swagger UI для документа по адресу specURL
+func UIHandler(title, specURL string) fiber.Handler {
+	page := `<!DOCTYPE html>
+<html lang="ru">
+<head>
+    <meta charset="UTF-8">
+    <title>` + html.EscapeString(title) + `</title>
+    <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@` + swaggerUIVersion + `/swagger-ui.css">
+</head>
+<body>
+    <div id="swagger-ui"></div>
+    <script src="https://unpkg.com/swagger-ui-dist@` + swaggerUIVersion + `/swagger-ui-bundle.js"></script>
+    <script>
+        window.ui = SwaggerUIBundle({
+            url: '` + html.EscapeString(specURL) + `',
+            dom_id: '#swagger-ui'
+        });
+    </script>
+</body>
+</html>`
+
+	return func(c *fiber.Ctx) error {
+		return c.Type("html").SendString(page)
+	}
+}
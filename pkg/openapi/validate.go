@@ -0,0 +1,170 @@
+package openapi
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"strings"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+	"github.com/skr1ms/PaymentAlphaBank.git/pkg/httpapi"
+)
+
+// Формат строки с абсолютным URL (схема и хост обязательны)
+const FormatAbsoluteURL = "absolute-url"
+
+var errNotAbsoluteURL = errors.New("URL должен быть абсолютным")
+
+func init() {
+	openapi3.DefineStringFormatValidator(FormatAbsoluteURL, openapi3.NewCallbackValidator(func(value string) error {
+		u, err := url.Parse(value)
+		if err != nil || !u.IsAbs() || u.Host == "" {
+			return errNotAbsoluteURL
+		}
+		return nil
+	}))
+}
+
+// Проверка тела запроса по схеме типа value из документа. Тип тела
+// определяется так же, как в BodyParser fiber: JSON проверяется по схеме,
+// остальные тела (формы, XML) отклоняются, чтобы в обработчик не попали
+// непроверенные поля. Пустое тело проверяется как пустой объект
+func (s *Spec) ValidateBody(value any) fiber.Handler {
+	schema := s.schema(value).Value
+
+	return func(c *fiber.Ctx) error {
+		body := c.Body()
+		if len(body) > 0 && !isJSON(c.Get(fiber.HeaderContentType)) {
+			return httpapi.Respond(c, fiber.StatusUnsupportedMediaType, httpapi.CodeUnsupportedMediaType,
+				"Тело запроса должно быть в формате JSON")
+		}
+
+		var data any = map[string]any{}
+		if len(body) > 0 {
+			if err := json.Unmarshal(body, &data); err != nil {
+				return httpapi.Respond(c, fiber.StatusBadRequest, httpapi.CodeBadRequest, "Неверный формат запроса")
+			}
+		}
+		canonicalKeys(schema, data)
+
+		err := schema.VisitJSON(data, openapi3.MultiErrors(), openapi3.VisitAsRequest())
+		if err == nil {
+			return c.Next()
+		}
+
+		return httpapi.RespondFields(c, fiber.StatusBadRequest, httpapi.CodeValidationFailed,
+			"Ошибка проверки запроса", fieldErrors(err))
+	}
+}
+
+// JSON ли тело для BodyParser: регистр не важен, vendor-типы вида
+// application/merge-patch+json сводятся к подтипу после плюса
+func isJSON(contentType string) bool {
+	ctype := utils.ParseVendorSpecificContentType(utils.ToLower(contentType))
+	if end := strings.IndexByte(ctype, ';'); end != -1 {
+		ctype = ctype[:end]
+	}
+	return strings.HasSuffix(ctype, "json")
+}
+
+// Ключи объектов под имена свойств схемы. encoding/json в BodyParser
+// сопоставляет ключи без учета регистра, поэтому "Return_URL" попадет в
+// поле return_url и должен проверяться по его правилам
+func canonicalKeys(schema *openapi3.Schema, data any) {
+	if schema == nil {
+		return
+	}
+	switch value := data.(type) {
+	case map[string]any:
+		for key, field := range value {
+			if _, ok := schema.Properties[key]; ok {
+				continue
+			}
+			for name := range schema.Properties {
+				if strings.EqualFold(key, name) {
+					delete(value, key)
+					value[name] = field
+					break
+				}
+			}
+		}
+		for name, property := range schema.Properties {
+			if field, ok := value[name]; ok && property != nil {
+				canonicalKeys(property.Value, field)
+			}
+		}
+	case []any:
+		if schema.Items == nil {
+			return
+		}
+		for _, item := range value {
+			canonicalKeys(schema.Items.Value, item)
+		}
+	}
+}
+
+// Ошибки схемы в виде списка полей
+func fieldErrors(err error) []httpapi.FieldError {
+	var multi openapi3.MultiError
+	if !errors.As(err, &multi) {
+		multi = openapi3.MultiError{err}
+	}
+
+	fields := make([]httpapi.FieldError, 0, len(multi))
+	for _, e := range multi {
+		var nested openapi3.MultiError
+		if errors.As(e, &nested) && len(nested) > 0 {
+			fields = append(fields, fieldErrors(nested)...)
+			continue
+		}
+
+		var schemaErr *openapi3.SchemaError
+		if !errors.As(e, &schemaErr) {
+			fields = append(fields, httpapi.FieldError{Rule: "schema", Message: "Неверное значение"})
+			continue
+		}
+		fields = append(fields, httpapi.FieldError{
+			Field:   strings.Join(schemaErr.JSONPointer(), "."),
+			Rule:    schemaErr.SchemaField,
+			Message: ruleMessage(schemaErr),
+		})
+	}
+	return fields
+}
+
+func ruleMessage(err *openapi3.SchemaError) string {
+	switch err.SchemaField {
+	case "required":
+		return "Обязательное поле"
+	case "type":
+		return "Неверный тип значения"
+	case "format":
+		if err.Schema != nil && err.Schema.Format == FormatAbsoluteURL {
+			return "Требуется абсолютный URL"
+		}
+		return "Неверный формат значения"
+	case "minimum", "exclusiveMinimum":
+		if err.Schema != nil && err.Schema.Min != nil && *err.Schema.Min == 1 {
+			return "Значение должно быть положительным"
+		}
+		return "Значение меньше допустимого"
+	case "maximum", "exclusiveMaximum":
+		return "Значение больше допустимого"
+	case "minLength":
+		if err.Schema != nil && err.Schema.MinLength == 1 {
+			return "Значение не должно быть пустым"
+		}
+		return "Значение слишком короткое"
+	case "maxLength":
+		return "Значение слишком длинное"
+	case "minItems":
+		return "Слишком мало элементов"
+	case "maxItems":
+		return "Слишком много элементов"
+	case "enum":
+		return "Недопустимое значение"
+	}
+	return "Неверное значение"
+}
@@ -0,0 +1,96 @@
+package secret
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+// Длина ключа AES-256
+const KeySize = 32
+
+var ErrInvalidCiphertext = errors.New("неверный шифротекст")
+
+// Шифрование секретов для хранения в базе: AES-256-GCM, результат —
+// base64 от nonce и шифротекста
+type Cipher struct {
+	aead cipher.AEAD
+}
+
+func NewCipher(key []byte) (*Cipher, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("ключ шифрования должен быть %d байт, получено %d", KeySize, len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Cipher{aead: aead}, nil
+}
+
+// Ключ в base64, как он задается в конфигурации
+func ParseKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errors.New("ключ шифрования должен быть в base64")
+	}
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("ключ шифрования должен быть %d байт, получено %d", KeySize, len(key))
+	}
+	return key, nil
+}
+
+// Пустая строка не шифруется, чтобы незаданное значение оставалось пустым
+func (c *Cipher) Encrypt(plaintext string) (string, error) {
+	if plaintext == "" {
+		return "", nil
+	}
+	sealed, err := c.seal([]byte(plaintext), nil)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func (c *Cipher) Decrypt(ciphertext string) (string, error) {
+	if ciphertext == "" {
+		return "", nil
+	}
+	sealed, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return "", ErrInvalidCiphertext
+	}
+	plaintext, err := c.open(sealed, nil)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// nonce и шифротекст одним срезом; aad связывает шифротекст с контекстом
+func (c *Cipher) seal(plaintext, aad []byte) ([]byte, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("ошибка генерации nonce: %w", err)
+	}
+	return c.aead.Seal(nonce, nonce, plaintext, aad), nil
+}
+
+func (c *Cipher) open(sealed, aad []byte) ([]byte, error) {
+	if len(sealed) < c.aead.NonceSize() {
+		return nil, ErrInvalidCiphertext
+	}
+	nonce, data := sealed[:c.aead.NonceSize()], sealed[c.aead.NonceSize():]
+	plaintext, err := c.aead.Open(nil, nonce, data, aad)
+	if err != nil {
+		return nil, ErrInvalidCiphertext
+	}
+	return plaintext, nil
+}
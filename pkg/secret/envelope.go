@@ -0,0 +1,218 @@
+package secret
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"strings"
+)
+
+// Версия формата конверта
+const envelopeVersion = "v1"
+
+// Конвертное шифрование значений в базе. Каждое значение шифруется своим
+// случайным ключом данных, а ключ данных — ключом из KeyProvider:
+//
+//	v1:<ID ключа>:<base64 ключа данных>:<base64 значения>
+//
+// По ID ключа в шифротексте значение расшифровывается и после ротации,
+// пока старый ключ остается в наборе
+type Envelope struct {
+	keys KeyProvider
+}
+
+func NewEnvelope(keys KeyProvider) *Envelope {
+	return &Envelope{keys: keys}
+}
+
+// Пустая строка не шифруется, чтобы незаданное значение оставалось пустым
+func (e *Envelope) Encrypt(ctx context.Context, plaintext string) (string, error) {
+	if plaintext == "" {
+		return "", nil
+	}
+
+	keyID, err := e.keys.PrimaryKeyID(ctx)
+	if err != nil {
+		return "", err
+	}
+	kek, err := e.cipher(ctx, keyID)
+	if err != nil {
+		return "", err
+	}
+
+	dataKey := make([]byte, KeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", fmt.Errorf("ошибка генерации ключа данных: %w", err)
+	}
+	dek, err := NewCipher(dataKey)
+	if err != nil {
+		return "", err
+	}
+
+	// ID ключа входит в aad: подмена ID в шифротексте не пройдет проверку
+	wrapped, err := kek.seal(dataKey, []byte(keyID))
+	if err != nil {
+		return "", err
+	}
+	sealed, err := dek.seal([]byte(plaintext), nil)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.Join([]string{
+		envelopeVersion,
+		keyID,
+		base64.StdEncoding.EncodeToString(wrapped),
+		base64.StdEncoding.EncodeToString(sealed),
+	}, ":"), nil
+}
+
+func (e *Envelope) Decrypt(ctx context.Context, ciphertext string) (string, error) {
+	if ciphertext == "" {
+		return "", nil
+	}
+
+	parts := strings.Split(ciphertext, ":")
+	if len(parts) != 4 || parts[0] != envelopeVersion {
+		return e.decryptLegacy(ctx, ciphertext)
+	}
+	keyID := parts[1]
+
+	wrapped, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", ErrInvalidCiphertext
+	}
+	sealed, err := base64.StdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return "", ErrInvalidCiphertext
+	}
+
+	kek, err := e.cipher(ctx, keyID)
+	if err != nil {
+		return "", err
+	}
+	dataKey, err := kek.open(wrapped, []byte(keyID))
+	if err != nil {
+		return "", err
+	}
+	dek, err := NewCipher(dataKey)
+	if err != nil {
+		return "", ErrInvalidCiphertext
+	}
+	plaintext, err := dek.open(sealed, nil)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
+
+// Значения без конверта зашифрованы напрямую ключом SECRETS_KEY; он нужен
+// в наборе под ID legacy, пока значения не перешифрованы, каким бы ни был
+// основной ключ
+func (e *Envelope) decryptLegacy(ctx context.Context, ciphertext string) (string, error) {
+	kek, err := e.cipher(ctx, LegacyKeyID)
+	if err != nil {
+		return "", err
+	}
+	return kek.Decrypt(ciphertext)
+}
+
+// Перешифрование основным ключом; false — значение уже под основным ключом
+func (e *Envelope) Reencrypt(ctx context.Context, ciphertext string) (string, bool, error) {
+	if ciphertext == "" {
+		return "", false, nil
+	}
+
+	primary, err := e.keys.PrimaryKeyID(ctx)
+	if err != nil {
+		return "", false, err
+	}
+	if KeyID(ciphertext) == primary {
+		return ciphertext, false, nil
+	}
+
+	plaintext, err := e.Decrypt(ctx, ciphertext)
+	if err != nil {
+		return "", false, err
+	}
+	reencrypted, err := e.Encrypt(ctx, plaintext)
+	if err != nil {
+		return "", false, err
+	}
+	return reencrypted, true, nil
+}
+
+// Префикс значений под основным ключом; значения без него нужно перешифровать
+func (e *Envelope) PrimaryPrefix(ctx context.Context) (string, error) {
+	primary, err := e.keys.PrimaryKeyID(ctx)
+	if err != nil {
+		return "", err
+	}
+	return envelopeVersion + ":" + primary + ":", nil
+}
+
+func (e *Envelope) cipher(ctx context.Context, keyID string) (*Cipher, error) {
+	key, err := e.keys.Key(ctx, keyID)
+	if err != nil {
+		return nil, err
+	}
+	return NewCipher(key)
+}
+
+// ID ключа, которым зашифровано значение; пустой для значений без конверта
+func KeyID(ciphertext string) string {
+	parts := strings.SplitN(ciphertext, ":", 3)
+	if len(parts) != 3 || parts[0] != envelopeVersion {
+		return ""
+	}
+	return parts[1]
+}
+
+// Запись с зашифрованным значением для перешифрования
+type Record struct {
+	ID    int64
+	Value string
+}
+
+// Перешифрование основным ключом всех записей, которые он не покрывает.
+// list возвращает записи с ID больше afterID, значения которых не начинаются
+// с prefix; save сохраняет новое значение. Возвращает число перешифрованных
+func (e *Envelope) ReencryptAll(
+	ctx context.Context,
+	batch int,
+	list func(ctx context.Context, prefix string, afterID int64, limit int) ([]Record, error),
+	save func(ctx context.Context, id int64, value string) error,
+) (int, error) {
+	prefix, err := e.PrimaryPrefix(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	total := 0
+	var afterID int64
+	for {
+		records, err := list(ctx, prefix, afterID, batch)
+		if err != nil {
+			return total, err
+		}
+
+		for _, record := range records {
+			value, changed, err := e.Reencrypt(ctx, record.Value)
+			if err != nil {
+				return total, fmt.Errorf("запись %d: %w", record.ID, err)
+			}
+			if changed {
+				if err := save(ctx, record.ID, value); err != nil {
+					return total, fmt.Errorf("запись %d: %w", record.ID, err)
+				}
+				total++
+			}
+			afterID = record.ID
+		}
+
+		if len(records) == 0 || len(records) < batch {
+			return total, nil
+		}
+	}
+}
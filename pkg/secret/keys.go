@@ -0,0 +1,128 @@
+package secret
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+)
+
+var ErrUnknownKey = errors.New("неизвестный ключ шифрования")
+
+// ID ключа SECRETS_KEY. Им же расшифровываются значения без конверта,
+// поэтому в файле ключей прежний SECRETS_KEY задается под этим ID
+const LegacyKeyID = "legacy"
+
+// Источник ключей шифрования ключей данных (KEK). Локальный файл реализует
+// Keyring; внешнее хранилище ключей подключается своей реализацией
+type KeyProvider interface {
+	// ID ключа, которым шифруются новые значения
+	PrimaryKeyID(ctx context.Context) (string, error)
+	// Ключ по ID; ErrUnknownKey, если такого ключа нет
+	Key(ctx context.Context, id string) ([]byte, error)
+}
+
+// ID ключа хранится в шифротексте и разделяется двоеточием
+var keyIDPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// Набор ключей в памяти: основной шифрует, остальные только расшифровывают
+// значения, еще не перешифрованные после ротации
+type Keyring struct {
+	primary string
+	keys    map[string][]byte
+}
+
+func NewKeyring(primary string, keys map[string][]byte) (*Keyring, error) {
+	if _, ok := keys[primary]; !ok {
+		return nil, fmt.Errorf("основной ключ %q отсутствует в наборе", primary)
+	}
+	ring := &Keyring{primary: primary, keys: make(map[string][]byte, len(keys))}
+	for id, key := range keys {
+		if !keyIDPattern.MatchString(id) {
+			return nil, fmt.Errorf("неверный ID ключа %q: допустимы латинские буквы, цифры, точка, дефис и подчеркивание", id)
+		}
+		if len(key) != KeySize {
+			return nil, fmt.Errorf("ключ %q должен быть %d байт, получено %d", id, KeySize, len(key))
+		}
+		ring.keys[id] = key
+	}
+	return ring, nil
+}
+
+func (r *Keyring) PrimaryKeyID(ctx context.Context) (string, error) {
+	return r.primary, nil
+}
+
+func (r *Keyring) Key(ctx context.Context, id string) ([]byte, error) {
+	key, ok := r.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKey, id)
+	}
+	return key, nil
+}
+
+// Файл ключей:
+//
+//	{"primary": "2026-10", "keys": {"legacy": "<base64>", "2026-10": "<base64>"}}
+//
+// Ротация: добавить новый ключ, сделать его основным, перешифровать данные
+// командой secrets reencrypt и только после этого удалить старый ключ.
+// При переходе с SECRETS_KEY он остается в файле под ID legacy
+type keyFile struct {
+	Primary string            `json:"primary"`
+	Keys    map[string]string `json:"keys"`
+}
+
+func LoadKeyFile(path string) (*Keyring, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("файл ключей: %w", err)
+	}
+
+	var file keyFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("файл ключей %s: %w", path, err)
+	}
+
+	keys := make(map[string][]byte, len(file.Keys))
+	for id, encoded := range file.Keys {
+		key, err := ParseKey(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("файл ключей %s, ключ %q: %w", path, id, err)
+		}
+		keys[id] = key
+	}
+
+	ring, err := NewKeyring(file.Primary, keys)
+	if err != nil {
+		return nil, fmt.Errorf("файл ключей %s: %w", path, err)
+	}
+	return ring, nil
+}
+
+// Набор из одного ключа в base64 под ID legacy; для ротации без потери
+// данных нужен файл ключей
+func SingleKey(encoded string) (*Keyring, error) {
+	key, err := ParseKey(encoded)
+	if err != nil {
+		return nil, err
+	}
+	return NewKeyring(LegacyKeyID, map[string][]byte{LegacyKeyID: key})
+}
+
+// Ключи из конфигурации: файл ключей, если задан, иначе ключ в base64
+func NewKeyProvider(encodedKey, keyFilePath string) (KeyProvider, error) {
+	var ring *Keyring
+	var err error
+	if keyFilePath != "" {
+		ring, err = LoadKeyFile(keyFilePath)
+	} else {
+		ring, err = SingleKey(encodedKey)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ring, nil
+}
@@ -0,0 +1,84 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Хранение файлов в локальной директории, раздаваемой как статика
+type LocalStorage struct {
+	dir     string
+	baseURL string
+}
+
+func NewLocalStorage(dir, baseURL string) (*LocalStorage, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("ошибка создания директории хранилища: %w", err)
+	}
+	return &LocalStorage{
+		dir:     dir,
+		baseURL: strings.TrimRight(baseURL, "/"),
+	}, nil
+}
+
+func (s *LocalStorage) Save(ctx context.Context, key string, r io.Reader) error {
+	fullPath, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0o755); err != nil {
+		return fmt.Errorf("ошибка создания директории: %w", err)
+	}
+
+	// Пишем во временный файл и переименовываем, чтобы не отдавать недописанный
+	tmp, err := os.CreateTemp(filepath.Dir(fullPath), ".upload-*")
+	if err != nil {
+		return fmt.Errorf("ошибка создания файла: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return fmt.Errorf("ошибка записи файла: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("ошибка записи файла: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), fullPath); err != nil {
+		return fmt.Errorf("ошибка сохранения файла: %w", err)
+	}
+	return nil
+}
+
+func (s *LocalStorage) Delete(ctx context.Context, key string) error {
+	fullPath, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(fullPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("ошибка удаления файла: %w", err)
+	}
+	return nil
+}
+
+func (s *LocalStorage) URL(key string) string {
+	return s.baseURL + "/" + key
+}
+
+// Ключ не должен выходить за пределы директории хранилища
+func (s *LocalStorage) path(key string) (string, error) {
+	cleaned := path.Clean("/" + key)
+	if cleaned == "/" || strings.Contains(key, "\\") {
+		return "", fmt.Errorf("недопустимый ключ файла: %q", key)
+	}
+	return filepath.Join(s.dir, filepath.FromSlash(cleaned[1:])), nil
+}
@@ -0,0 +1,16 @@
+package storage
+
+import (
+	"context"
+	"io"
+)
+
+// Хранилище загружаемых файлов (изображения купонов и т.п.)
+type Storage interface {
+	// Сохраняет содержимое под ключом, перезаписывая существующий файл
+	Save(ctx context.Context, key string, r io.Reader) error
+	// Удаляет файл по ключу, отсутствие файла ошибкой не считается
+	Delete(ctx context.Context, key string) error
+	// Публичный URL файла
+	URL(key string) string
+}